@@ -9,28 +9,49 @@ import (
 
 // ChunkReader 用于读取字节码块
 type ChunkReader struct {
+	codes      []byte
 	codeBuffer *util.ByteBuffer
 	constPool  *ConstantPool
 	isVarConst *util.BitSet
+	variables  []string // GetVariables 的缓存
+	debugData  []byte
+	debug      *DebugInfo // GetDebugInfo 的缓存
 	tracer     *util.Tracer
 }
 
-// NewChunkReader 创建新的块读取器
+// NewChunkReader 创建新的块读取器。直接读取 chunk 中的数据，不复制，常量在读取时才解码
 func NewChunkReader(chunk *Chunk, tracer *util.Tracer) *ChunkReader {
-	codeBuffer := util.NewBufferFromBytes(chunk.Codes)
-	codeBuffer.SetPosition(0) // 重置位置到开始
+	codeBuffer := util.WrapBytes(chunk.Codes)
 	constPool, err := NewConstantPoolFromBytes(chunk.Constants, tracer)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create constant pool from chunk data: %v", err))
 	}
 	return &ChunkReader{
+		codes:      chunk.Codes,
 		codeBuffer: codeBuffer,
 		constPool:  constPool,
 		isVarConst: util.NewBitSetFromBytes(chunk.Vars),
+		debugData:  chunk.Debug,
 		tracer:     tracer,
 	}
 }
 
+// Preload 一次性解码全部常量、变量列表和调试信息。之后这些数据只读，
+// 可以由 Fork 得到的多个读取器在不同协程中共享
+func (cr *ChunkReader) Preload() {
+	cr.constPool.decodeAll()
+	cr.GetVariables()
+	cr.GetDebugInfo()
+}
+
+// Fork 返回从头读取同一字节码块的新读取器，共享常量池、变量列表和调试信息，不重新扫描常量池。
+// 在多个协程中使用前先调用 Preload
+func (cr *ChunkReader) Fork() *ChunkReader {
+	fork := *cr
+	fork.codeBuffer = util.WrapBytes(cr.codes)
+	return &fork
+}
+
 // ReadByte 读取一个字节
 func (cr *ChunkReader) ReadByte() (byte, error) {
 	b, err := cr.codeBuffer.Get()
@@ -63,24 +84,36 @@ func (cr *ChunkReader) ReadConst(index int) (values.Value, error) {
 	return cr.constPool.ReadConst(index)
 }
 
-// GetVariables 获取所有变量名
+// GetVariables 获取所有变量名，按槽位排序。结果会被缓存，调用方不要修改
 func (cr *ChunkReader) GetVariables() []string {
+	if cr.variables != nil {
+		return cr.variables
+	}
 	if cr.tracer != nil {
 		cr.tracer.StartTimer()
 		defer cr.tracer.EndTimer("构造变量列表")
 	}
-	allConsts := cr.constPool.GetAllConsts()
-	result := make([]string, 10)
-
-	for i, value := range allConsts {
+	result := make([]string, 0, 10)
+	for i := 0; i < cr.constPool.Size(); i++ {
 		if cr.isVarConst.Get(i) {
-			result = append(result, value.String())
+			value, _ := cr.constPool.ReadConst(i)
+			result = append(result, value.AsString())
 		}
 	}
 
+	cr.variables = result
 	return result
 }
 
+// GetDebugInfo 获取调试信息，首次调用时解码。没有调试信息或无法解码时返回 nil
+func (cr *ChunkReader) GetDebugInfo() *DebugInfo {
+	if cr.debug == nil && len(cr.debugData) > 0 {
+		cr.debug, _ = NewDebugInfoFromBytes(cr.debugData)
+		cr.debugData = nil
+	}
+	return cr.debug
+}
+
 // Position 获取当前读取位置
 func (cr *ChunkReader) Position() int {
 	return cr.codeBuffer.Position()
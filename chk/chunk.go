@@ -1,14 +1,11 @@
 package chk
 
-import (
-	"github.com/simonwater/gopression/util"
-)
-
 // Chunk 表示执行块的数据结构
 type Chunk struct {
 	Codes     []byte // 字节码
 	Constants []byte // 常量池
 	Vars      []byte // 变量信息
+	Debug     []byte // 调试信息，见 DebugInfo，没有时为空
 }
 
 // NewChunk 创建新的空 Chunk
@@ -29,33 +26,22 @@ func NewChunkWithData(codes, constants, vars []byte) *Chunk {
 	}
 }
 
-func NewChunkWithBytes(bytes []byte) *Chunk {
-	buf := util.NewBufferFromBytes(bytes)
-	buf.SetPosition(0)
-	codeSz, _ := buf.GetInt()
-	codes, _ := buf.GetBytes(int(codeSz))
-	constSz, _ := buf.GetInt()
-	constants, _ := buf.GetBytes(int(constSz))
-	varSz, _ := buf.GetInt()
-	vars, _ := buf.GetBytes(int(varSz))
-	return NewChunkWithData(codes, constants, vars)
+// GetByteSize 获取总字节大小
+func (c *Chunk) GetByteSize() int {
+	return len(c.Codes) + len(c.Constants) + len(c.Vars) + len(c.Debug)
 }
 
-func (c *Chunk) ToBytes() []byte {
-	sz := c.GetByteSize() + 3*4
-	buf := util.NewByteBuffer(sz)
-	buf.PutInt(int32(c.GetCodesSize()))
-	buf.PutBytes(c.Codes)
-	buf.PutInt(int32(c.GetConstsSize()))
-	buf.PutBytes(c.Constants)
-	buf.PutInt(int32(c.GetVarsSize()))
-	buf.PutBytes(c.Vars)
-	return buf.ToBytes()
+// HasDebugInfo 是否包含调试信息
+func (c *Chunk) HasDebugInfo() bool {
+	return len(c.Debug) > 0
 }
 
-// GetByteSize 获取总字节大小
-func (c *Chunk) GetByteSize() int {
-	return len(c.Codes) + len(c.Constants) + len(c.Vars)
+// GetDebugInfo 解码调试信息，没有调试信息时返回 nil
+func (c *Chunk) GetDebugInfo() (*DebugInfo, error) {
+	if !c.HasDebugInfo() {
+		return nil, nil
+	}
+	return NewDebugInfoFromBytes(c.Debug)
 }
 
 // GetCodesSize 获取字节码大小
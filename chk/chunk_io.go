@@ -0,0 +1,276 @@
+package chk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/simonwater/gopression/functions/funmgr"
+)
+
+// 字节码文件格式：
+//
+//	文件头：魔数 "GOPC"(4) | 格式版本(2) | 标志(1) | 操作码集版本(2) | 函数注册表指纹(4)
+//	内容：  字节码长度(4) | 字节码 | 常量池长度(4) | 常量池 | 变量信息长度(4) | 变量信息 | 调试信息长度(4) | 调试信息
+//	校验：  CRC32(4)，覆盖之前的全部字节（压缩时为压缩后的字节）
+//
+// 标志的第 0 位表示小端，作用于文件头、长度和校验和，字节码和常量池内部固定为大端；
+// 第 1 位表示内容部分经过 flate 压缩。格式版本 1 没有调试信息段，仍然可以读取。
+const (
+	CHUNK_MAGIC          = "GOPC"
+	CHUNK_FORMAT_VERSION = 2
+	// OPCODE_SET_VERSION 操作码集版本，增删操作码或改变其语义、操作数时递增
+	OPCODE_SET_VERSION = 2
+
+	chunkHeaderSize = 4 + 2 + 1 + 2 + 4
+
+	chunkFlagLittleEndian = 1 << 0
+	chunkFlagCompressed   = 1 << 1
+
+	// 超过此长度的数据段边读边分配，避免损坏的长度字段导致一次性分配过大的内存
+	blobAllocStep = 16 << 20
+)
+
+var (
+	// ErrCorruptChunk 字节码文件损坏：魔数不符、数据不完整或校验和不匹配
+	ErrCorruptChunk = errors.New("字节码文件损坏")
+	// ErrIncompatibleChunk 字节码文件与当前环境不兼容：格式版本、操作码集版本或函数注册表不同
+	ErrIncompatibleChunk = errors.New("字节码文件不兼容")
+)
+
+// ToBytes 序列化为未压缩的字节数组，格式见 CHUNK_MAGIC 处的说明
+func (c *Chunk) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.Grow(chunkHeaderSize + c.GetByteSize() + 4*4 + 4)
+	c.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo 以未压缩格式写入 w，实现 io.WriterTo
+func (c *Chunk) WriteTo(w io.Writer) (int64, error) {
+	return c.write(w, false, 0)
+}
+
+// WriteCompressed 以 flate 压缩格式写入 w，level 取值同 compress/flate，如 flate.BestSpeed
+func (c *Chunk) WriteCompressed(w io.Writer, level int) (int64, error) {
+	return c.write(w, true, level)
+}
+
+func (c *Chunk) write(w io.Writer, compressed bool, level int) (int64, error) {
+	out := &chunkWriter{w: w, crc: crc32.NewIEEE()}
+	var flags byte
+	if compressed {
+		flags |= chunkFlagCompressed
+	}
+	header := make([]byte, 0, chunkHeaderSize)
+	header = append(header, CHUNK_MAGIC...)
+	header = binary.BigEndian.AppendUint16(header, CHUNK_FORMAT_VERSION)
+	header = append(header, flags)
+	header = binary.BigEndian.AppendUint16(header, OPCODE_SET_VERSION)
+	header = binary.BigEndian.AppendUint32(header, funmgr.GetFunctionManager().Fingerprint())
+	out.Write(header)
+
+	var body io.Writer = out
+	var fw *flate.Writer
+	if compressed {
+		var err error
+		if fw, err = flate.NewWriter(out, level); err != nil {
+			return 0, err
+		}
+		body = fw
+	}
+	for _, blob := range [][]byte{c.Codes, c.Constants, c.Vars, c.Debug} {
+		if _, err := body.Write(binary.BigEndian.AppendUint32(nil, uint32(len(blob)))); err != nil {
+			return out.n, err
+		}
+		if _, err := body.Write(blob); err != nil {
+			return out.n, err
+		}
+	}
+	if fw != nil {
+		if err := fw.Close(); err != nil {
+			return out.n, err
+		}
+	}
+
+	sum := out.crc.Sum32()
+	out.crc = nil
+	out.Write(binary.BigEndian.AppendUint32(nil, sum))
+	return out.n, out.err
+}
+
+// NewChunkWithBytes 从 ToBytes 或 WriteCompressed 生成的字节数组还原 Chunk，不允许有多余数据。错误同 ReadChunk
+func NewChunkWithBytes(data []byte) (*Chunk, error) {
+	r := bytes.NewReader(data)
+	chunk, err := ReadChunk(r)
+	if err == nil && r.Len() > 0 {
+		return nil, fmt.Errorf("%w：存在多余数据", ErrCorruptChunk)
+	}
+	return chunk, err
+}
+
+// ReadChunk 从 r 中读取一个字节码块，自动识别是否压缩。各数据段按实际长度分配，不会整体复制。
+// r 没有实现 io.ByteReader 时会加一层缓冲，可能多读取字节码块之后的数据。
+// 数据损坏时返回包装了 ErrCorruptChunk 的错误，版本或函数注册表不一致时返回包装了 ErrIncompatibleChunk 的错误，
+// 读出的字节码不能通过 Verify 时返回 *VerifyError
+func ReadChunk(r io.Reader) (*Chunk, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	in := &chunkReader{r: br, crc: crc32.NewIEEE()}
+
+	header := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, corrupt(err)
+	}
+	if string(header[:4]) != CHUNK_MAGIC {
+		return nil, fmt.Errorf("%w：不是字节码文件", ErrCorruptChunk)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	flags := header[6]
+	if flags&^(chunkFlagLittleEndian|chunkFlagCompressed) != 0 {
+		return nil, fmt.Errorf("%w：未知的标志 %d", ErrCorruptChunk, flags)
+	}
+	if flags&chunkFlagLittleEndian != 0 {
+		order = binary.LittleEndian
+	}
+	formatVersion := order.Uint16(header[4:])
+	if formatVersion != CHUNK_FORMAT_VERSION && formatVersion != 1 {
+		return nil, fmt.Errorf("%w：格式版本 %d，当前支持 %d", ErrIncompatibleChunk, formatVersion, CHUNK_FORMAT_VERSION)
+	}
+
+	var body io.Reader = in
+	var fr io.ReadCloser
+	if flags&chunkFlagCompressed != 0 {
+		fr = flate.NewReader(in)
+		body = fr
+	}
+	blobs := make([][]byte, 4)
+	if formatVersion == 1 {
+		blobs = blobs[:3]
+	}
+	for i := range blobs {
+		var err error
+		if blobs[i], err = readBlob(body, order); err != nil {
+			return nil, corrupt(err)
+		}
+	}
+	if fr != nil {
+		// 压缩流应在最后一个数据段后结束
+		if n, err := fr.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+			return nil, fmt.Errorf("%w：存在多余数据", ErrCorruptChunk)
+		}
+		fr.Close()
+	}
+
+	// 先校验再检查其他字段，避免把损坏的数据误报为不兼容
+	sum := in.crc.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(br, trailer); err != nil {
+		return nil, corrupt(err)
+	}
+	if order.Uint32(trailer) != sum {
+		return nil, fmt.Errorf("%w：校验和不匹配", ErrCorruptChunk)
+	}
+
+	if opcodeVersion := order.Uint16(header[7:]); opcodeVersion != OPCODE_SET_VERSION {
+		return nil, fmt.Errorf("%w：操作码集版本 %d，当前为 %d", ErrIncompatibleChunk, opcodeVersion, OPCODE_SET_VERSION)
+	}
+	fingerprint := order.Uint32(header[9:])
+	if current := funmgr.GetFunctionManager().Fingerprint(); fingerprint != current {
+		return nil, fmt.Errorf("%w：函数注册表指纹 %08x，当前为 %08x", ErrIncompatibleChunk, fingerprint, current)
+	}
+
+	chunk := NewChunkWithData(blobs[0], blobs[1], blobs[2])
+	if len(blobs) > 3 && len(blobs[3]) > 0 {
+		chunk.Debug = blobs[3]
+	}
+	if err := Verify(chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// readBlob 读取长度和数据段
+func readBlob(r io.Reader, order binary.ByteOrder) ([]byte, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+	n := int(int32(order.Uint32(size)))
+	if n < 0 {
+		return nil, errors.New("长度越界")
+	}
+
+	blob := make([]byte, 0, min(n, blobAllocStep))
+	for len(blob) < n {
+		start := len(blob)
+		blob = append(blob, make([]byte, min(n-start, blobAllocStep))...)
+		if _, err := io.ReadFull(r, blob[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return blob, nil
+}
+
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w：数据不完整", ErrCorruptChunk)
+	}
+	return fmt.Errorf("%w：%v", ErrCorruptChunk, err)
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// chunkReader 读取时计算校验和。实现 io.ByteReader，flate 解压时不会多读压缩流之后的校验和
+type chunkReader struct {
+	r   byteReader
+	crc hash.Hash32
+	one [1]byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc.Write(p[:n])
+	return n, err
+}
+
+func (cr *chunkReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.one[0] = b
+		cr.crc.Write(cr.one[:])
+	}
+	return b, err
+}
+
+// chunkWriter 写入时计算校验和并统计字节数，出错后不再写入。crc 为 nil 时不再计算
+type chunkWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	if cw.crc != nil {
+		cw.crc.Write(p[:n])
+	}
+	return n, err
+}
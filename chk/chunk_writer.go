@@ -48,9 +48,10 @@ func (cw *ChunkWriter) Clear() {
 	cw.isVarConst = util.NewBitSet(0)
 }
 
-// WriteByte 写入一个字节
-func (cw *ChunkWriter) WriteByte(value byte) {
+// WriteByte 写入一个字节，实现 io.ByteWriter，总是返回 nil
+func (cw *ChunkWriter) WriteByte(value byte) error {
 	cw.codeBuffer.Put(value)
+	return nil
 }
 
 // WriteShort 写入一个短整型 (16位)
@@ -92,6 +93,17 @@ func (cw *ChunkWriter) SetVariables(vars []string) {
 	}
 }
 
+// GetVarSlots 获取变量的槽位：变量常量在常量池中按索引排序后的序号，需在 SetVariables 之后调用
+func (cw *ChunkWriter) GetVarSlots() map[string]int {
+	slots := make(map[string]int)
+	for i, value := range cw.constPool.GetAllConsts() {
+		if cw.isVarConst.Get(i) {
+			slots[value.String()] = len(slots)
+		}
+	}
+	return slots
+}
+
 // Position 获取当前写入位置
 func (cw *ChunkWriter) Position() int {
 	return cw.codeBuffer.Position()
@@ -7,11 +7,16 @@ import (
 	"github.com/simonwater/gopression/values"
 )
 
-// ConstantPool 常量池实现
+// ConstantPool 常量池实现。从字节数组创建时只记录各常量的位置，读取时才解码，
+// 需要按值查找索引时才一次性解码全部常量。不是并发安全的
 type ConstantPool struct {
 	constants []values.Value
-	indexMap  map[string]int
+	indexMap  map[string]int // 从字节数组创建时为 nil，见 ensureIndex
 	tracer    *util.Tracer
+
+	data    *util.ByteBuffer // 未解码的常量数据
+	offsets []int            // 各常量在 data 中的位置
+	decoded []bool           // 各常量是否已解码
 }
 
 // NewConstantPool 创建新的常量池
@@ -28,29 +33,75 @@ func NewConstantPool(capacity int, tracer *util.Tracer) *ConstantPool {
 	}
 }
 
-// NewConstantPoolFromBytes 从字节数组创建常量池
+// NewConstantPoolFromBytes 从字节数组创建常量池，不复制 data，调用方不能再修改它。
+// 创建时只校验数据格式并记录各常量的位置
 func NewConstantPoolFromBytes(data []byte, tracer *util.Tracer) (*ConstantPool, error) {
-	cp := &ConstantPool{
-		constants: make([]values.Value, 0),
-		indexMap:  make(map[string]int),
-		tracer:    tracer,
-	}
-
 	if tracer != nil {
 		tracer.StartTimer()
 		defer tracer.EndTimer("根据字节数组构造常量池。")
 	}
 
-	buffer := util.NewBufferFromBytes(data)
-	buffer.SetPosition(0) // 重置位置到开始
+	buffer := util.WrapBytes(data)
+	offsets := make([]int, 0)
 	for buffer.Remaining() > 0 {
-		val, err := values.GetFrom(buffer)
-		if err != nil {
+		offsets = append(offsets, buffer.Position())
+		if err := values.SkipFrom(buffer); err != nil {
 			return nil, err
 		}
-		cp.constants = append(cp.constants, val)
 	}
-	return cp, nil
+	return &ConstantPool{
+		constants: make([]values.Value, len(offsets)),
+		tracer:    tracer,
+		data:      buffer,
+		offsets:   offsets,
+		decoded:   make([]bool, len(offsets)),
+	}, nil
+}
+
+// decode 解码第 index 个常量，已解码时直接返回
+func (cp *ConstantPool) decode(index int) values.Value {
+	if cp.data == nil || cp.decoded[index] {
+		return cp.constants[index]
+	}
+	cp.data.SetPosition(cp.offsets[index])
+	val, err := values.GetFrom(cp.data)
+	if err != nil {
+		// 创建时已校验过格式
+		panic(err)
+	}
+	cp.constants[index] = val
+	cp.decoded[index] = true
+	return val
+}
+
+// decodeAll 解码全部常量，之后不再需要原始数据
+func (cp *ConstantPool) decodeAll() {
+	if cp.data == nil {
+		return
+	}
+	for i := range cp.constants {
+		cp.decode(i)
+	}
+	cp.data, cp.offsets, cp.decoded = nil, nil, nil
+}
+
+// ensureIndex 按值查找前建立索引
+func (cp *ConstantPool) ensureIndex() {
+	if cp.indexMap != nil {
+		return
+	}
+	cp.decodeAll()
+	cp.indexMap = make(map[string]int, len(cp.constants))
+	for i, val := range cp.constants {
+		if _, exists := cp.indexMap[val.String()]; !exists {
+			cp.indexMap[val.String()] = i
+		}
+	}
+}
+
+// Size 常量个数
+func (cp *ConstantPool) Size() int {
+	return len(cp.constants)
 }
 
 // ToBytes 将常量池转换为字节数组
@@ -60,6 +111,7 @@ func (cp *ConstantPool) ToBytes() ([]byte, error) {
 		defer cp.tracer.EndTimer("常量池生成字节数组。")
 	}
 
+	cp.decodeAll()
 	buffer := util.NewByteBuffer(0)
 	for _, val := range cp.constants {
 		if err := val.WriteTo(buffer); err != nil {
@@ -74,6 +126,7 @@ func (cp *ConstantPool) AddConst(value values.Value) (int, error) {
 	if err := cp.checkType(value); err != nil {
 		return -1, err
 	}
+	cp.ensureIndex()
 
 	key := value.String()
 	if idx, exists := cp.indexMap[key]; exists {
@@ -91,17 +144,19 @@ func (cp *ConstantPool) ReadConst(index int) (values.Value, error) {
 	if index < 0 || index >= len(cp.constants) {
 		return values.NewNullValue(), errors.New("常量索引越界")
 	}
-	return cp.constants[index], nil
+	return cp.decode(index), nil
 }
 
 // GetConstIndex 获取常量的索引
 func (cp *ConstantPool) GetConstIndex(constant string) (int, bool) {
+	cp.ensureIndex()
 	idx, exists := cp.indexMap[constant]
 	return idx, exists
 }
 
 // GetAllConsts 获取所有常量
 func (cp *ConstantPool) GetAllConsts() []values.Value {
+	cp.decodeAll()
 	return cp.constants
 }
 
@@ -109,6 +164,7 @@ func (cp *ConstantPool) GetAllConsts() []values.Value {
 func (cp *ConstantPool) Clear() {
 	cp.constants = make([]values.Value, 0)
 	cp.indexMap = make(map[string]int)
+	cp.data, cp.offsets, cp.decoded = nil, nil, nil
 }
 
 // checkType 检查常量类型是否支持
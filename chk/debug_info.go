@@ -0,0 +1,129 @@
+package chk
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/simonwater/gopression/util"
+)
+
+// DebugEntry 调试信息中的一条位置记录：从 Pos 开始直到下一条记录之前的指令，
+// 属于序号为 Index 的表达式，对应源码中 [Start, End) 范围内的子表达式
+type DebugEntry struct {
+	Pos    int // 指令位置
+	Index  int // 表达式序号
+	Start  int // 子表达式起始字符偏移
+	End    int // 子表达式结束字符偏移
+	Line   int // 子表达式起始行，从 1 开始
+	Column int // 子表达式起始列，从 1 开始
+}
+
+// DebugInfo 字节码的调试信息：指令位置到表达式和源码位置的映射，以及各表达式的源码
+type DebugInfo struct {
+	Sources map[int]string // 表达式序号 -> 源码
+	Entries []DebugEntry   // 按 Pos 升序排列
+}
+
+func NewDebugInfo() *DebugInfo {
+	return &DebugInfo{Sources: make(map[int]string)}
+}
+
+// AddEntry 添加位置记录，与上一条记录对应相同源码位置时忽略。记录需按指令位置升序添加
+func (d *DebugInfo) AddEntry(entry DebugEntry) {
+	if n := len(d.Entries); n > 0 {
+		last := &d.Entries[n-1]
+		if last.Pos == entry.Pos {
+			*last = entry
+			return
+		}
+		if last.sameSpan(entry) {
+			return
+		}
+	}
+	d.Entries = append(d.Entries, entry)
+}
+
+func (e DebugEntry) sameSpan(other DebugEntry) bool {
+	e.Pos = other.Pos
+	return e == other
+}
+
+// Lookup 查找 pos 处指令对应的位置记录
+func (d *DebugInfo) Lookup(pos int) (DebugEntry, bool) {
+	i := sort.Search(len(d.Entries), func(i int) bool { return d.Entries[i].Pos > pos })
+	if i == 0 {
+		return DebugEntry{}, false
+	}
+	return d.Entries[i-1], true
+}
+
+// Snippet 截取位置记录对应的子表达式源码
+func (d *DebugInfo) Snippet(entry DebugEntry) string {
+	runes := []rune(d.Sources[entry.Index])
+	if entry.Start < 0 || entry.End > len(runes) || entry.Start > entry.End {
+		return ""
+	}
+	return string(runes[entry.Start:entry.End])
+}
+
+// ToBytes 序列化：源码数量(4) | [序号(4) | 长度(4) | UTF-8 源码]... | 记录数量(4) | [6 个字段各 4 字节]...
+func (d *DebugInfo) ToBytes() []byte {
+	indexes := make([]int, 0, len(d.Sources))
+	for index := range d.Sources {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	buf := util.NewByteBuffer(64)
+	buf.PutInt(int32(len(indexes)))
+	for _, index := range indexes {
+		src := []byte(d.Sources[index])
+		buf.PutInt(int32(index))
+		buf.PutInt(int32(len(src)))
+		buf.PutBytes(src)
+	}
+	buf.PutInt(int32(len(d.Entries)))
+	for _, e := range d.Entries {
+		for _, v := range []int{e.Pos, e.Index, e.Start, e.End, e.Line, e.Column} {
+			buf.PutInt(int32(v))
+		}
+	}
+	return buf.ToBytes()
+}
+
+// NewDebugInfoFromBytes 从 ToBytes 生成的字节数组还原调试信息
+func NewDebugInfoFromBytes(data []byte) (*DebugInfo, error) {
+	errTruncated := errors.New("调试信息不完整")
+	buf := util.NewBufferFromBytes(data)
+	buf.SetPosition(0)
+	d := NewDebugInfo()
+
+	count, err := buf.GetInt()
+	if err != nil || count < 0 {
+		return nil, errTruncated
+	}
+	for i := 0; i < int(count); i++ {
+		index, err1 := buf.GetInt()
+		size, err2 := buf.GetInt()
+		if err1 != nil || err2 != nil || size < 0 || int(size) > buf.Remaining() {
+			return nil, errTruncated
+		}
+		src, _ := buf.GetBytes(int(size))
+		d.Sources[int(index)] = string(src)
+	}
+
+	count, err = buf.GetInt()
+	if err != nil || count < 0 || int(count)*6*4 != buf.Remaining() {
+		return nil, errTruncated
+	}
+	d.Entries = make([]DebugEntry, count)
+	for i := range d.Entries {
+		fields := make([]int, 6)
+		for j := range fields {
+			v, _ := buf.GetInt()
+			fields[j] = int(v)
+		}
+		d.Entries[i] = DebugEntry{Pos: fields[0], Index: fields[1], Start: fields[2], End: fields[3], Line: fields[4], Column: fields[5]}
+	}
+	return d, nil
+}
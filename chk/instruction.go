@@ -0,0 +1,143 @@
+package chk
+
+import (
+	"fmt"
+
+	"github.com/simonwater/gopression/util"
+)
+
+// 各操作码的操作数字节宽度，未列出的操作码没有操作数。操作数均为大端有符号整数
+var operandWidths = map[OpCode][]int{
+	OP_CONSTANT:             {4},
+	OP_GET_LOCAL:            {4},
+	OP_SET_LOCAL:            {4},
+	OP_GET_GLOBAL:           {4},
+	OP_DEFINE_GLOBAL:        {4},
+	OP_SET_GLOBAL:           {4},
+	OP_GET_PROPERTY:         {4},
+	OP_SET_PROPERTY:         {4},
+	OP_JUMP:                 {4},
+	OP_JUMP_IF_FALSE:        {4},
+	OP_CALL:                 {4},
+	OP_BEGIN:                {4},
+	OP_GET_GLOBAL_ADD_CONST: {4, 4},    // 变量名常量索引、常量索引
+	OP_CONST_COMPARE_JUMP:   {1, 4, 4}, // 比较操作码、常量索引、跳转偏移
+}
+
+// OperandWidths 获取操作数的字节宽度
+func (op OpCode) OperandWidths() []int {
+	return operandWidths[op]
+}
+
+// ConstOperands 常量索引操作数的序号
+func (op OpCode) ConstOperands() []int {
+	result := make([]int, 0, 2)
+	for _, c := range constOperands[op] {
+		result = append(result, c.index)
+	}
+	return result
+}
+
+// IsJump 是否为跳转指令。跳转指令的最后一个操作数是相对于指令末尾的偏移
+func (op OpCode) IsJump() bool {
+	return op == OP_JUMP || op == OP_JUMP_IF_FALSE || op == OP_CONST_COMPARE_JUMP
+}
+
+// Instruction 解码后的一条指令
+type Instruction struct {
+	Op       OpCode
+	Operands []int32
+	Pos      int          // 在字节码中的位置，编码后更新
+	Target   *Instruction // 跳转目标，非跳转指令为 nil
+}
+
+// Size 指令的字节大小
+func (ins *Instruction) Size() int {
+	size := 1
+	for _, w := range ins.Op.OperandWidths() {
+		size += w
+	}
+	return size
+}
+
+// DecodeInstructions 将字节码解码为指令列表，并解析跳转目标
+func DecodeInstructions(codes []byte) ([]*Instruction, error) {
+	buf := util.NewBufferFromBytes(codes)
+	buf.SetPosition(0)
+	result := make([]*Instruction, 0, len(codes)/3)
+	byPos := make(map[int]*Instruction)
+
+	for buf.Remaining() > 0 {
+		pos := buf.Position()
+		b, _ := buf.Get()
+		op, err := OpCodeFromValue(b)
+		if err != nil {
+			return nil, fmt.Errorf("位置 %d：%w", pos, err)
+		}
+		widths := op.OperandWidths()
+		ins := &Instruction{Op: op, Operands: make([]int32, len(widths)), Pos: pos}
+		for i, w := range widths {
+			if ins.Operands[i], err = readOperand(buf, w); err != nil {
+				return nil, fmt.Errorf("位置 %d：%s 的操作数不完整", pos, op.Title())
+			}
+		}
+		result = append(result, ins)
+		byPos[pos] = ins
+	}
+
+	for _, ins := range result {
+		if !ins.Op.IsJump() {
+			continue
+		}
+		target := ins.Pos + ins.Size() + int(ins.Operands[len(ins.Operands)-1])
+		if ins.Target = byPos[target]; ins.Target == nil {
+			return nil, fmt.Errorf("位置 %d：跳转目标 %d 不是指令起始位置", ins.Pos, target)
+		}
+	}
+	return result, nil
+}
+
+// EncodeInstructions 将指令列表编码为字节码，重新计算每条指令的位置和跳转偏移
+func EncodeInstructions(instructions []*Instruction) []byte {
+	size := 0
+	for _, ins := range instructions {
+		ins.Pos = size
+		size += ins.Size()
+	}
+
+	buf := util.NewByteBuffer(size)
+	for _, ins := range instructions {
+		if ins.Target != nil {
+			ins.Operands[len(ins.Operands)-1] = int32(ins.Target.Pos - ins.Pos - ins.Size())
+		}
+		buf.Put(byte(ins.Op))
+		for i, w := range ins.Op.OperandWidths() {
+			writeOperand(buf, w, ins.Operands[i])
+		}
+	}
+	return buf.ToBytes()
+}
+
+func readOperand(buf *util.ByteBuffer, width int) (int32, error) {
+	switch width {
+	case 1:
+		b, err := buf.Get()
+		return int32(b), err
+	case 2:
+		s, err := buf.GetShort()
+		return int32(s), err
+	default:
+		return buf.GetInt()
+	}
+}
+
+func writeOperand(buf *util.ByteBuffer, width int, value int32) {
+	switch width {
+	case 1:
+		buf.Put(byte(value))
+	case 2:
+		buf.PutShort(int16(value))
+	default:
+		buf.PutInt(value)
+	}
+}
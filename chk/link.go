@@ -0,0 +1,321 @@
+package chk
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/simonwater/gopression/values"
+)
+
+// unit 从字节码块中拆出的单个表达式（OP_BEGIN 到 OP_END 的指令），常量和槽位已解析为值和变量名
+type unit struct {
+	order        int
+	instructions []*Instruction
+	consts       map[*Instruction][]values.Value // 常量操作数的值，与 constOperands 中的顺序一致
+	slots        map[*Instruction]string         // 按槽位访问的变量名
+	entries      map[*Instruction]DebugEntry     // 调试位置
+	source       string
+	hasSource    bool
+	reads        []string
+	writes       []string
+	propReads    []string // 读取的属性路径，与 ExprInfo 相同为变量名加字段名，如 o.x。只用于排序
+	propWrites   []string // 赋值的属性路径
+}
+
+// Link 合并多个字节码块，不需要重新编译源码：
+//   - 合并常量池和变量集合，重新计算常量索引和变量槽位
+//   - 表达式序号依次重排：第 k 个块的表达式序号加上之前各块的表达式序号上限（最大序号 + 1）之和，
+//     即结果中的序号区间与各块按顺序一一对应
+//   - 按变量依赖重新排序表达式：读取变量的表达式排在所有对它赋值的表达式之后，没有依赖关系的表达式保持原有顺序。
+//     对象属性按属性路径（如 o.x）同样处理。存在循环依赖时返回错误
+//   - 合并调试信息，跳转偏移随指令位置重新计算
+//
+// 输入的字节码块需能通过 Verify
+func Link(chunks ...*Chunk) (*Chunk, error) {
+	var units []*unit
+	base := 0
+	for i, chunk := range chunks {
+		split, err := splitUnits(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 个字节码块：%w", i, err)
+		}
+		next := base
+		for _, u := range split {
+			next = max(next, base+u.order+1)
+			u.order += base
+		}
+		base = next
+		units = append(units, split...)
+	}
+
+	sorted, err := sortUnits(units)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(sorted), nil
+}
+
+// Extract 从字节码块中取出指定序号的表达式，组成新的字节码块。表达式保持原有顺序，
+// 按原序号从小到大重新编号为从 0 开始的连续序号；常量池和变量集合只保留用到的部分。
+// 不存在的序号返回错误。
+// 取出的表达式依赖的其他表达式不会被自动包含，需要时由调用方一并指定
+func Extract(chunk *Chunk, orders ...int) (*Chunk, error) {
+	units, err := splitUnits(chunk)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool, len(orders))
+	for _, order := range orders {
+		wanted[order] = true
+	}
+	selected := make([]*unit, 0, len(orders))
+	for _, u := range units {
+		if wanted[u.order] {
+			selected = append(selected, u)
+			delete(wanted, u.order)
+		}
+	}
+	for _, order := range orders {
+		if wanted[order] {
+			return nil, fmt.Errorf("字节码块中没有序号为 %d 的表达式", order)
+		}
+	}
+	renumber(selected)
+	return assemble(selected), nil
+}
+
+// renumber 保持相对大小，把表达式序号改为从 0 开始的连续序号
+func renumber(units []*unit) {
+	orders := make([]int, 0, len(units))
+	for _, u := range units {
+		orders = append(orders, u.order)
+	}
+	slices.Sort(orders)
+	orders = slices.Compact(orders)
+	for _, u := range units {
+		u.order, _ = slices.BinarySearch(orders, u.order)
+	}
+}
+
+// splitUnits 校验字节码块并按表达式拆分
+func splitUnits(chunk *Chunk) ([]*unit, error) {
+	if err := Verify(chunk); err != nil {
+		return nil, err
+	}
+	instructions, err := DecodeInstructions(chunk.Codes)
+	if err != nil {
+		return nil, err
+	}
+	reader := NewChunkReader(chunk, nil)
+	consts := reader.constPool.GetAllConsts()
+	variables := reader.GetVariables()
+	debug := reader.GetDebugInfo()
+
+	var units []*unit
+	var current *unit
+	path := "" // 栈顶对象的属性路径：读取变量或属性后为变量名或属性路径，其他指令后为空
+	for _, ins := range instructions {
+		switch ins.Op {
+		case OP_BEGIN:
+			current = &unit{
+				order:  int(ins.Operands[0]),
+				consts: make(map[*Instruction][]values.Value),
+				slots:  make(map[*Instruction]string),
+			}
+			if debug != nil {
+				current.entries = make(map[*Instruction]DebugEntry)
+				current.source, current.hasSource = debug.Sources[current.order]
+			}
+		case OP_EXIT:
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("位置 %d 的指令 %s 不在表达式内", ins.Pos, ins.Op)
+		}
+
+		current.instructions = append(current.instructions, ins)
+		for _, c := range constOperands[ins.Op] {
+			current.consts[ins] = append(current.consts[ins], consts[ins.Operands[c.index]])
+		}
+		if ins.Op == OP_GET_LOCAL || ins.Op == OP_SET_LOCAL {
+			current.slots[ins] = variables[ins.Operands[0]]
+		}
+		if debug != nil {
+			if e, ok := debug.Lookup(ins.Pos); ok {
+				current.entries[ins] = e
+			}
+		}
+
+		next := ""
+		switch ins.Op {
+		case OP_GET_GLOBAL:
+			next = current.consts[ins][0].AsString()
+			current.reads = append(current.reads, next)
+		case OP_GET_GLOBAL_ADD_CONST:
+			current.reads = append(current.reads, current.consts[ins][0].AsString())
+		case OP_GET_LOCAL:
+			next = current.slots[ins]
+			current.reads = append(current.reads, next)
+		case OP_GET_PROPERTY:
+			if path != "" {
+				next = path + "." + current.consts[ins][0].AsString()
+				current.propReads = append(current.propReads, next)
+			}
+		case OP_SET_PROPERTY:
+			if path != "" {
+				current.propWrites = append(current.propWrites, path+"."+current.consts[ins][0].AsString())
+			}
+		case OP_SET_GLOBAL:
+			current.writes = append(current.writes, current.consts[ins][0].AsString())
+		case OP_SET_LOCAL:
+			current.writes = append(current.writes, current.slots[ins])
+		case OP_END:
+			units = append(units, current)
+			current = nil
+		}
+		path = next
+	}
+	return units, nil
+}
+
+// sortUnits 按变量依赖稳定排序：每次取出依赖都已满足的表达式中位置最靠前的一个
+func sortUnits(units []*unit) ([]*unit, error) {
+	writers := make(map[string][]int)
+	for i, u := range units {
+		for _, name := range u.writes {
+			writers[name] = append(writers[name], i)
+		}
+		for _, name := range u.propWrites {
+			writers[name] = append(writers[name], i)
+		}
+	}
+
+	successors := make([][]int, len(units))
+	degrees := make([]int, len(units))
+	for i, u := range units {
+		seen := make(map[int]bool)
+		for _, name := range slices.Concat(u.reads, u.propReads) {
+			for _, w := range writers[name] {
+				if w != i && !seen[w] {
+					seen[w] = true
+					successors[w] = append(successors[w], i)
+					degrees[i]++
+				}
+			}
+		}
+	}
+
+	ready := &intHeap{}
+	for i, d := range degrees {
+		if d == 0 {
+			heap.Push(ready, i)
+		}
+	}
+	result := make([]*unit, 0, len(units))
+	for ready.Len() > 0 {
+		i := heap.Pop(ready).(int)
+		result = append(result, units[i])
+		for _, s := range successors[i] {
+			if degrees[s]--; degrees[s] == 0 {
+				heap.Push(ready, s)
+			}
+		}
+	}
+
+	if len(result) < len(units) {
+		orders := make([]int, 0)
+		for i, d := range degrees {
+			if d > 0 {
+				orders = append(orders, units[i].order)
+			}
+		}
+		return nil, fmt.Errorf("表达式之间存在循环依赖：%v", orders)
+	}
+	return result, nil
+}
+
+// assemble 将表达式重新组装为字节码块
+func assemble(units []*unit) *Chunk {
+	writer := NewChunkWriter(0, nil)
+	var debug *DebugInfo
+	varSet := make(map[string]bool)
+	for _, u := range units {
+		if u.entries != nil && debug == nil {
+			debug = NewDebugInfo()
+		}
+		for _, name := range u.reads {
+			varSet[name] = true
+		}
+		for _, name := range u.writes {
+			varSet[name] = true
+		}
+	}
+
+	all := make([]*Instruction, 0)
+	for _, u := range units {
+		for _, ins := range u.instructions {
+			for i, c := range constOperands[ins.Op] {
+				index, err := writer.AddConstant(u.consts[ins][i])
+				if err != nil {
+					// 常量来自已有的常量池，类型一定受支持
+					panic(err)
+				}
+				ins.Operands[c.index] = int32(index)
+			}
+			if ins.Op == OP_BEGIN {
+				ins.Operands[0] = int32(u.order)
+			}
+		}
+		all = append(all, u.instructions...)
+	}
+	exit := &Instruction{Op: OP_EXIT}
+	all = append(all, exit)
+
+	vars := make([]string, 0, len(varSet))
+	for name := range varSet {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+	writer.SetVariables(vars)
+	slots := writer.GetVarSlots()
+	for _, u := range units {
+		for ins, name := range u.slots {
+			ins.Operands[0] = int32(slots[name])
+		}
+	}
+
+	chunk := writer.Flush()
+	chunk.Codes = EncodeInstructions(all)
+	if debug != nil {
+		for _, u := range units {
+			if u.hasSource {
+				debug.Sources[u.order] = u.source
+			}
+			for _, ins := range u.instructions {
+				if e, ok := u.entries[ins]; ok {
+					e.Pos = ins.Pos
+					e.Index = u.order
+					debug.AddEntry(e)
+				}
+			}
+		}
+		chunk.Debug = debug.ToBytes()
+	}
+	return chunk
+}
+
+// intHeap 最小堆
+type intHeap []int
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x any)        { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
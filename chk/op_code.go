@@ -43,6 +43,20 @@ const (
 	OP_END                         // 30
 	OP_RETURN                      // 31
 	OP_EXIT                        // 32
+
+	// 融合指令，由窥孔优化生成（见 Optimize）
+	OP_GET_GLOBAL_ADD_CONST // 33 读取全局变量并加上常量
+	OP_CONST_COMPARE_JUMP   // 34 栈顶与常量比较，结果留在栈顶，为假时跳转
+
+	// 按类型特化的算术指令，由静态类型检查推断出操作数类型后生成（见 ir.TypeChecker），
+	// 执行时操作数类型与推断不符则按通用指令处理
+	OP_ADD_INT         // 35 两个整数相加
+	OP_SUBTRACT_INT    // 36 两个整数相减
+	OP_MULTIPLY_INT    // 37 两个整数相乘
+	OP_ADD_DOUBLE      // 38 两个数值相加，至少一个是浮点数
+	OP_SUBTRACT_DOUBLE // 39 两个数值相减，至少一个是浮点数
+	OP_MULTIPLY_DOUBLE // 40 两个数值相乘，至少一个是浮点数
+	OP_DIVIDE_DOUBLE   // 41 两个数值相除，至少一个是浮点数
 )
 
 var (
@@ -80,6 +94,17 @@ var (
 		OP_END:           "OP_END",
 		OP_RETURN:        "OP_RETURN",
 		OP_EXIT:          "OP_EXIT",
+
+		OP_GET_GLOBAL_ADD_CONST: "OP_GET_GLOBAL_ADD_CONST",
+		OP_CONST_COMPARE_JUMP:   "OP_CONST_COMPARE_JUMP",
+
+		OP_ADD_INT:         "OP_ADD_INT",
+		OP_SUBTRACT_INT:    "OP_SUBTRACT_INT",
+		OP_MULTIPLY_INT:    "OP_MULTIPLY_INT",
+		OP_ADD_DOUBLE:      "OP_ADD_DOUBLE",
+		OP_SUBTRACT_DOUBLE: "OP_SUBTRACT_DOUBLE",
+		OP_MULTIPLY_DOUBLE: "OP_MULTIPLY_DOUBLE",
+		OP_DIVIDE_DOUBLE:   "OP_DIVIDE_DOUBLE",
 	}
 
 	valueToOpCode map[byte]OpCode
@@ -90,7 +115,7 @@ var (
 func initOpCodeMap() {
 	opCodeMapOnce.Do(func() {
 		valueToOpCode = make(map[byte]OpCode)
-		for op := OP_CONSTANT; op <= OP_EXIT; op++ {
+		for op := range opCodeTitles {
 			valueToOpCode[byte(op)] = op
 		}
 	})
@@ -0,0 +1,233 @@
+package chk
+
+// Optimize 对字节码做窥孔优化，返回新的执行块，常量池和变量信息不变：
+//   - 跳转串联：跳转到无条件跳转、跳转到条件相同的条件跳转时直接跳到最终目标
+//   - 条件为 true/false/null 常量的条件跳转改为不跳转或无条件跳转，删除跳到下一条指令的跳转
+//   - 删除无副作用的入栈指令与紧随其后的 OP_POP
+//   - 融合 OP_GET_GLOBAL + OP_CONSTANT + OP_ADD 为 OP_GET_GLOBAL_ADD_CONST，
+//     OP_CONSTANT + 比较 + OP_JUMP_IF_FALSE 为 OP_CONST_COMPARE_JUMP
+//
+// 被跳转到的指令不会与前面的指令合并或删除。调试信息随指令一起调整，融合指令沿用最后一条运算指令的源码位置。
+func Optimize(chunk *Chunk) (*Chunk, error) {
+	instructions, err := DecodeInstructions(chunk.Codes)
+	if err != nil {
+		return nil, err
+	}
+	debug, err := chunk.GetDebugInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peephole{instructions: instructions}
+	if debug != nil {
+		p.entries = make(map[*Instruction]DebugEntry, len(instructions))
+		for _, ins := range instructions {
+			if e, ok := debug.Lookup(ins.Pos); ok {
+				p.entries[ins] = e
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = p.threadJumps()
+		changed = p.foldConstJumps() || changed
+		changed = p.removePushPop() || changed
+		changed = p.removeNopJumps() || changed
+	}
+	p.fuse()
+
+	result := NewChunkWithData(EncodeInstructions(p.instructions), chunk.Constants, chunk.Vars)
+	if debug != nil {
+		moved := &DebugInfo{Sources: debug.Sources}
+		for _, ins := range p.instructions {
+			if e, ok := p.entries[ins]; ok {
+				e.Pos = ins.Pos
+				moved.AddEntry(e)
+			}
+		}
+		result.Debug = moved.ToBytes()
+	}
+	return result, nil
+}
+
+type peephole struct {
+	instructions []*Instruction
+	removed      map[*Instruction]bool
+	entries      map[*Instruction]DebugEntry // 各指令的调试位置，没有调试信息时为 nil
+}
+
+// targets 被跳转到的指令
+func (p *peephole) targets() map[*Instruction]bool {
+	result := make(map[*Instruction]bool)
+	for _, ins := range p.instructions {
+		if ins.Target != nil {
+			result[ins.Target] = true
+		}
+	}
+	return result
+}
+
+func (p *peephole) remove(ins ...*Instruction) {
+	if p.removed == nil {
+		p.removed = make(map[*Instruction]bool)
+	}
+	for _, in := range ins {
+		p.removed[in] = true
+	}
+}
+
+// compact 删除标记的指令，指向被删除指令的跳转改为指向其后第一条保留的指令
+func (p *peephole) compact() bool {
+	if len(p.removed) == 0 {
+		return false
+	}
+	redirect := make(map[*Instruction]*Instruction)
+	var next *Instruction
+	for i := len(p.instructions) - 1; i >= 0; i-- {
+		ins := p.instructions[i]
+		if p.removed[ins] {
+			redirect[ins] = next
+		} else {
+			next = ins
+		}
+	}
+
+	kept := p.instructions[:0]
+	for _, ins := range p.instructions {
+		if !p.removed[ins] {
+			kept = append(kept, ins)
+		}
+	}
+	p.instructions = kept
+	for _, ins := range p.instructions {
+		if to, ok := redirect[ins.Target]; ok {
+			ins.Target = to
+		}
+	}
+	p.removed = nil
+	return true
+}
+
+func (p *peephole) threadJumps() bool {
+	index := make(map[*Instruction]int, len(p.instructions))
+	for i, ins := range p.instructions {
+		index[ins] = i
+	}
+	targets := p.targets()
+
+	changed := false
+	for i, ins := range p.instructions {
+		if ins.Op != OP_JUMP && ins.Op != OP_JUMP_IF_FALSE {
+			continue
+		}
+		// 紧跟在条件跳转之后且不是跳转目标的无条件跳转，执行时栈顶值一定为真
+		truthy := ins.Op == OP_JUMP && i > 0 && p.instructions[i-1].Op == OP_JUMP_IF_FALSE && !targets[ins]
+		for n := 0; n < len(p.instructions); n++ {
+			t := ins.Target
+			var to *Instruction
+			switch {
+			case t.Op == OP_JUMP:
+				to = t.Target
+			case t.Op == OP_JUMP_IF_FALSE && ins.Op == OP_JUMP_IF_FALSE:
+				to = t.Target
+			case t.Op == OP_JUMP_IF_FALSE && truthy && index[t]+1 < len(p.instructions):
+				to = p.instructions[index[t]+1]
+			}
+			if to == nil || to == ins.Target || to == ins {
+				break
+			}
+			ins.Target = to
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peephole) foldConstJumps() bool {
+	targets := p.targets()
+	for i := 1; i < len(p.instructions); i++ {
+		ins, prev := p.instructions[i], p.instructions[i-1]
+		if ins.Op != OP_JUMP_IF_FALSE || targets[ins] {
+			continue
+		}
+		switch prev.Op {
+		case OP_TRUE:
+			p.remove(ins)
+		case OP_FALSE, OP_NULL:
+			ins.Op = OP_JUMP
+		}
+	}
+	return p.compact()
+}
+
+func (p *peephole) removePushPop() bool {
+	targets := p.targets()
+	for i := 0; i+1 < len(p.instructions); i++ {
+		ins, next := p.instructions[i], p.instructions[i+1]
+		if next.Op == OP_POP && !targets[next] && isPurePush(ins.Op) {
+			p.remove(ins, next)
+			i++
+		}
+	}
+	return p.compact()
+}
+
+func (p *peephole) removeNopJumps() bool {
+	for i := 0; i+1 < len(p.instructions); i++ {
+		ins := p.instructions[i]
+		if (ins.Op == OP_JUMP || ins.Op == OP_JUMP_IF_FALSE) && ins.Target == p.instructions[i+1] {
+			p.remove(ins)
+		}
+	}
+	return p.compact()
+}
+
+func (p *peephole) fuse() {
+	targets := p.targets()
+	for i := 0; i+2 < len(p.instructions); i++ {
+		a, b, c := p.instructions[i], p.instructions[i+1], p.instructions[i+2]
+		if targets[b] || targets[c] {
+			continue
+		}
+		switch {
+		case a.Op == OP_GET_GLOBAL && b.Op == OP_CONSTANT && c.Op == OP_ADD:
+			// 原地修改第一条指令，指向它的跳转保持有效
+			a.Op = OP_GET_GLOBAL_ADD_CONST
+			a.Operands = []int32{a.Operands[0], b.Operands[0]}
+			p.moveEntry(c, a)
+		case a.Op == OP_CONSTANT && isCompare(b.Op) && c.Op == OP_JUMP_IF_FALSE:
+			a.Op = OP_CONST_COMPARE_JUMP
+			a.Operands = []int32{int32(b.Op), a.Operands[0], 0}
+			a.Target = c.Target
+			p.moveEntry(b, a)
+		default:
+			continue
+		}
+		p.remove(b, c)
+		i += 2
+	}
+	p.compact()
+}
+
+// moveEntry 融合指令使用被合并指令的调试位置，运行时错误发生在运算指令上
+func (p *peephole) moveEntry(from, to *Instruction) {
+	if e, ok := p.entries[from]; ok {
+		p.entries[to] = e
+	}
+}
+
+// isPurePush 没有副作用、只向栈中压入一个值的指令
+func isPurePush(op OpCode) bool {
+	switch op {
+	case OP_CONSTANT, OP_NULL, OP_TRUE, OP_FALSE, OP_GET_GLOBAL, OP_GET_LOCAL:
+		return true
+	}
+	return false
+}
+
+func isCompare(op OpCode) bool {
+	switch op {
+	case OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL, OP_EQUAL_EQUAL, OP_BANG_EQUAL:
+		return true
+	}
+	return false
+}
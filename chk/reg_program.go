@@ -0,0 +1,172 @@
+package chk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/simonwater/gopression/functions"
+	"github.com/simonwater/gopression/values"
+)
+
+// RegOp 寄存器虚拟机的操作码
+type RegOp byte
+
+const (
+	REG_BEGIN          RegOp = iota // 表达式开始：A 表达式序号，B 需要的临时寄存器数
+	REG_LOAD                        // 从执行环境读入变量：寄存器 A 到 A+B-1
+	REG_MOVE                        // R[A] = R[B]
+	REG_ADD                         // R[A] = R[B] + R[C]，以下二元运算相同
+	REG_SUBTRACT                    //
+	REG_MULTIPLY                    //
+	REG_DIVIDE                      //
+	REG_MODE                        //
+	REG_POWER                       //
+	REG_GREATER                     //
+	REG_GREATER_EQUAL               //
+	REG_LESS                        //
+	REG_LESS_EQUAL                  //
+	REG_EQUAL_EQUAL                 //
+	REG_BANG_EQUAL                  //
+	REG_NOT                         // R[A] = !R[B]
+	REG_NEGATE                      // R[A] = -R[B]
+	REG_JUMP                        // 跳转到指令 A
+	REG_JUMP_IF_FALSE               // R[A] 为假时跳转到指令 B
+	REG_JUMP_IF_TRUE                // R[A] 为真时跳转到指令 B
+	REG_CALL                        // R[A] = Funcs[B](R[C], R[C+1], ...)
+	REG_GET_PROPERTY                // R[A] = R[B].(R[C])
+	REG_SET_PROPERTY                // R[A].(R[B]) = R[C]
+	REG_CHECK_INSTANCE              // R[A] 不是实例时出错，R[B] 为要赋值的属性名
+	REG_END                         // 表达式结束，结果为 R[A]
+	REG_EXIT                        // 程序结束
+)
+
+var regOpNames = [...]string{
+	REG_BEGIN:          "BEGIN",
+	REG_LOAD:           "LOAD",
+	REG_MOVE:           "MOVE",
+	REG_ADD:            "ADD",
+	REG_SUBTRACT:       "SUBTRACT",
+	REG_MULTIPLY:       "MULTIPLY",
+	REG_DIVIDE:         "DIVIDE",
+	REG_MODE:           "MODE",
+	REG_POWER:          "POWER",
+	REG_GREATER:        "GREATER",
+	REG_GREATER_EQUAL:  "GREATER_EQUAL",
+	REG_LESS:           "LESS",
+	REG_LESS_EQUAL:     "LESS_EQUAL",
+	REG_EQUAL_EQUAL:    "EQUAL_EQUAL",
+	REG_BANG_EQUAL:     "BANG_EQUAL",
+	REG_NOT:            "NOT",
+	REG_NEGATE:         "NEGATE",
+	REG_JUMP:           "JUMP",
+	REG_JUMP_IF_FALSE:  "JUMP_IF_FALSE",
+	REG_JUMP_IF_TRUE:   "JUMP_IF_TRUE",
+	REG_CALL:           "CALL",
+	REG_GET_PROPERTY:   "GET_PROPERTY",
+	REG_SET_PROPERTY:   "SET_PROPERTY",
+	REG_CHECK_INSTANCE: "CHECK_INSTANCE",
+	REG_END:            "END",
+	REG_EXIT:           "EXIT",
+}
+
+func (op RegOp) String() string {
+	if int(op) < len(regOpNames) {
+		return regOpNames[op]
+	}
+	return fmt.Sprintf("RegOp(%d)", int(op))
+}
+
+// 操作数中哪些是寄存器：第 0、1、2 位分别对应 A、B、C
+var regOperands = [...]uint8{
+	REG_MOVE:           0b011,
+	REG_ADD:            0b111,
+	REG_SUBTRACT:       0b111,
+	REG_MULTIPLY:       0b111,
+	REG_DIVIDE:         0b111,
+	REG_MODE:           0b111,
+	REG_POWER:          0b111,
+	REG_GREATER:        0b111,
+	REG_GREATER_EQUAL:  0b111,
+	REG_LESS:           0b111,
+	REG_LESS_EQUAL:     0b111,
+	REG_EQUAL_EQUAL:    0b111,
+	REG_BANG_EQUAL:     0b111,
+	REG_NOT:            0b011,
+	REG_NEGATE:         0b011,
+	REG_JUMP_IF_FALSE:  0b001,
+	REG_JUMP_IF_TRUE:   0b001,
+	REG_CALL:           0b101,
+	REG_GET_PROPERTY:   0b111,
+	REG_SET_PROPERTY:   0b111,
+	REG_CHECK_INSTANCE: 0b011,
+	REG_END:            0b001,
+	REG_EXIT:           0,
+}
+
+// RegisterOperands 返回操作码中是寄存器的操作数：第 0、1、2 位分别对应 A、B、C
+func RegisterOperands(op RegOp) uint8 {
+	if int(op) < len(regOperands) {
+		return regOperands[op]
+	}
+	return 0
+}
+
+// RegInstruction 三地址指令
+type RegInstruction struct {
+	Op      RegOp
+	A, B, C int32
+}
+
+func (ins RegInstruction) String() string {
+	return fmt.Sprintf("%-14s %d %d %d", ins.Op, ins.A, ins.B, ins.C)
+}
+
+// RegProgram 寄存器虚拟机的程序，编译后不再修改，可由多个虚拟机同时执行。
+// 寄存器依次为：变量（0 到 len(Vars)-1）、常量（从 ConstBase 开始）、各表达式共用的临时寄存器（从 TempBase 开始）
+type RegProgram struct {
+	Code    []RegInstruction
+	Vars    []string
+	Consts  []values.Value
+	Funcs   []functions.CallableFunction
+	NumRegs int
+	Debug   *DebugInfo // 调试信息，记录中的 Pos 为指令序号。未生成时为 nil
+}
+
+// ConstBase 第一个常量寄存器
+func (p *RegProgram) ConstBase() int {
+	return len(p.Vars)
+}
+
+// TempBase 第一个临时寄存器
+func (p *RegProgram) TempBase() int {
+	return len(p.Vars) + len(p.Consts)
+}
+
+// String 列出全部指令，寄存器显示为变量名、常量值或临时寄存器编号
+func (p *RegProgram) String() string {
+	var sb strings.Builder
+	for pc, ins := range p.Code {
+		operands := []int32{ins.A, ins.B, ins.C}
+		args := make([]string, 0, 3)
+		for i, v := range operands {
+			if RegisterOperands(ins.Op)&(1<<i) != 0 {
+				args = append(args, p.regName(int(v)))
+			} else {
+				args = append(args, fmt.Sprintf("%d", v))
+			}
+		}
+		fmt.Fprintf(&sb, "%-6d %-14s %s\n", pc, ins.Op, strings.Join(args, " "))
+	}
+	return sb.String()
+}
+
+func (p *RegProgram) regName(r int) string {
+	switch {
+	case r < p.ConstBase():
+		return p.Vars[r]
+	case r < p.TempBase():
+		return "#" + p.Consts[r-p.ConstBase()].String()
+	default:
+		return fmt.Sprintf("r%d", r-p.TempBase())
+	}
+}
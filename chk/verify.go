@@ -0,0 +1,363 @@
+package chk
+
+import (
+	"fmt"
+
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// VerifyError 字节码校验错误，Pos 为出错指令在字节码中的位置，与指令无关的错误 Pos 为 -1
+type VerifyError struct {
+	Pos int
+	Op  OpCode
+	Msg string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Pos < 0 {
+		return "字节码校验失败：" + e.Msg
+	}
+	return fmt.Sprintf("字节码校验失败：位置 %d（%s）：%s", e.Pos, e.Op.Title(), e.Msg)
+}
+
+// 各操作码对栈的影响：执行前栈中至少需要的元素个数，以及执行后栈深度的变化。
+// OP_CALL 取决于函数的参数个数，单独处理
+var stackEffects = map[OpCode]struct{ need, delta int }{
+	OP_CONSTANT:             {0, 1},
+	OP_NULL:                 {0, 1},
+	OP_TRUE:                 {0, 1},
+	OP_FALSE:                {0, 1},
+	OP_POP:                  {1, -1},
+	OP_GET_LOCAL:            {0, 1},
+	OP_SET_LOCAL:            {1, 0},
+	OP_GET_GLOBAL:           {0, 1},
+	OP_SET_GLOBAL:           {1, 0},
+	OP_GET_PROPERTY:         {1, 0},
+	OP_SET_PROPERTY:         {2, -1},
+	OP_EQUAL_EQUAL:          {2, -1},
+	OP_BANG_EQUAL:           {2, -1},
+	OP_GREATER:              {2, -1},
+	OP_GREATER_EQUAL:        {2, -1},
+	OP_LESS:                 {2, -1},
+	OP_LESS_EQUAL:           {2, -1},
+	OP_ADD:                  {2, -1},
+	OP_SUBTRACT:             {2, -1},
+	OP_MULTIPLY:             {2, -1},
+	OP_DIVIDE:               {2, -1},
+	OP_MODE:                 {2, -1},
+	OP_POWER:                {2, -1},
+	OP_NOT:                  {1, 0},
+	OP_NEGATE:               {1, 0},
+	OP_JUMP:                 {0, 0},
+	OP_JUMP_IF_FALSE:        {1, 0},
+	OP_BEGIN:                {0, 0},
+	OP_END:                  {1, -1},
+	OP_RETURN:               {0, 0},
+	OP_EXIT:                 {0, 0},
+	OP_GET_GLOBAL_ADD_CONST: {0, 1},
+	OP_CONST_COMPARE_JUMP:   {1, 0},
+	OP_ADD_INT:              {2, -1},
+	OP_SUBTRACT_INT:         {2, -1},
+	OP_MULTIPLY_INT:         {2, -1},
+	OP_ADD_DOUBLE:           {2, -1},
+	OP_SUBTRACT_DOUBLE:      {2, -1},
+	OP_MULTIPLY_DOUBLE:      {2, -1},
+	OP_DIVIDE_DOUBLE:        {2, -1},
+}
+
+// constOperand 以常量池索引为值的操作数，isName 表示该常量必须是字符串（变量名、属性名、函数名）
+type constOperand struct {
+	index  int
+	isName bool
+}
+
+var constOperands = map[OpCode][]constOperand{
+	OP_CONSTANT:             {{0, false}},
+	OP_GET_GLOBAL:           {{0, true}},
+	OP_SET_GLOBAL:           {{0, true}},
+	OP_GET_PROPERTY:         {{0, true}},
+	OP_SET_PROPERTY:         {{0, true}},
+	OP_CALL:                 {{0, true}},
+	OP_GET_GLOBAL_ADD_CONST: {{0, true}, {1, false}},
+	OP_CONST_COMPARE_JUMP:   {{1, false}},
+}
+
+// compareOps OP_CONST_COMPARE_JUMP 允许的比较操作码
+var compareOps = map[OpCode]bool{
+	OP_GREATER: true, OP_GREATER_EQUAL: true, OP_LESS: true,
+	OP_LESS_EQUAL: true, OP_EQUAL_EQUAL: true, OP_BANG_EQUAL: true,
+}
+
+// Verify 在执行前校验字节码块，保证虚拟机执行时不会因字节码本身出错而越界或崩溃：
+//   - 常量池可以完整解码，操作码合法，操作数完整
+//   - 常量索引、变量槽位在范围内，名称类常量为字符串，调用的函数已注册
+//   - 跳转目标是同一表达式（OP_BEGIN 与 OP_END 之间）内的指令起始位置
+//   - 按所有执行路径模拟栈深度：不下溢，汇合处深度一致，OP_END 时栈中恰好一个结果
+//   - 表达式不嵌套，代码以 OP_EXIT 结尾
+//   - OP_BEGIN 的表达式序号不为负数，且小于表达式个数
+//   - 有调试信息时可以完整解码
+//
+// 校验失败返回 *VerifyError
+func Verify(chunk *Chunk) error {
+	consts, err := decodeConsts(chunk.Constants)
+	if err != nil {
+		return &VerifyError{Pos: -1, Msg: "常量池无法解码：" + err.Error()}
+	}
+	if _, err := chunk.GetDebugInfo(); err != nil {
+		return &VerifyError{Pos: -1, Msg: err.Error()}
+	}
+	v := &verifier{
+		consts: consts,
+		slots:  countVars(chunk.Vars, len(consts)),
+	}
+	if err := v.decode(chunk.Codes); err != nil {
+		return err
+	}
+	if err := v.checkRegions(); err != nil {
+		return err
+	}
+	return v.checkStack()
+}
+
+type verifier struct {
+	consts       []values.Value
+	slots        int
+	instructions []*Instruction
+	index        map[int]int // 指令位置 -> 指令下标
+	region       []int       // 每条指令所属表达式的 OP_BEGIN 下标，不属于任何表达式为 -1
+}
+
+// decodeConsts 解码常量池
+func decodeConsts(data []byte) ([]values.Value, error) {
+	constPool, err := NewConstantPoolFromBytes(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return constPool.GetAllConsts(), nil
+}
+
+func countVars(vars []byte, constCount int) int {
+	bits := util.NewBitSetFromBytes(vars)
+	n := 0
+	for i := 0; i < constCount; i++ {
+		if bits.Get(i) {
+			n++
+		}
+	}
+	return n
+}
+
+func errorAt(ins *Instruction, format string, args ...any) error {
+	return &VerifyError{Pos: ins.Pos, Op: ins.Op, Msg: fmt.Sprintf(format, args...)}
+}
+
+// decode 逐条解码指令并检查操作码和操作数
+func (v *verifier) decode(codes []byte) error {
+	buf := util.NewBufferFromBytes(codes)
+	buf.SetPosition(0)
+	v.index = make(map[int]int)
+	for buf.Remaining() > 0 {
+		pos := buf.Position()
+		b, _ := buf.Get()
+		op, err := OpCodeFromValue(b)
+		if err != nil {
+			return &VerifyError{Pos: pos, Op: OpCode(b), Msg: err.Error()}
+		}
+		ins := &Instruction{Op: op, Pos: pos}
+		widths := op.OperandWidths()
+		if buf.Remaining() < ins.Size()-1 {
+			return errorAt(ins, "操作数不完整")
+		}
+		ins.Operands = make([]int32, len(widths))
+		for i, w := range widths {
+			ins.Operands[i], _ = readOperand(buf, w)
+		}
+		if err := v.checkOperands(ins); err != nil {
+			return err
+		}
+		v.index[pos] = len(v.instructions)
+		v.instructions = append(v.instructions, ins)
+	}
+
+	if len(v.instructions) == 0 {
+		return &VerifyError{Pos: -1, Msg: "字节码为空"}
+	}
+	if last := v.instructions[len(v.instructions)-1]; last.Op != OP_EXIT {
+		return errorAt(last, "字节码未以 OP_EXIT 结尾")
+	}
+
+	for _, ins := range v.instructions {
+		if !ins.Op.IsJump() {
+			continue
+		}
+		target := ins.Pos + ins.Size() + int(ins.Operands[len(ins.Operands)-1])
+		i, ok := v.index[target]
+		if !ok {
+			return errorAt(ins, "跳转目标 %d 不是指令起始位置", target)
+		}
+		ins.Target = v.instructions[i]
+	}
+	return nil
+}
+
+func (v *verifier) checkOperands(ins *Instruction) error {
+	if _, ok := stackEffects[ins.Op]; !ok && ins.Op != OP_CALL {
+		return errorAt(ins, "虚拟机不支持该指令")
+	}
+	for _, c := range constOperands[ins.Op] {
+		if err := v.checkConst(ins, int(ins.Operands[c.index]), c.isName); err != nil {
+			return err
+		}
+	}
+
+	switch ins.Op {
+	case OP_GET_LOCAL, OP_SET_LOCAL:
+		if slot := int(ins.Operands[0]); slot < 0 || slot >= v.slots {
+			return errorAt(ins, "变量槽位 %d 越界，共 %d 个变量", slot, v.slots)
+		}
+	case OP_CALL:
+		name := v.consts[ins.Operands[0]].AsString()
+		if funmgr.GetFunctionManager().GetFunction(name) == nil {
+			return errorAt(ins, "函数未注册：%s", name)
+		}
+	case OP_CONST_COMPARE_JUMP:
+		if !compareOps[OpCode(ins.Operands[0])] {
+			return errorAt(ins, "不是比较操作码：%d", ins.Operands[0])
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkConst(ins *Instruction, index int, isName bool) error {
+	if index < 0 || index >= len(v.consts) {
+		return errorAt(ins, "常量索引 %d 越界，常量池大小为 %d", index, len(v.consts))
+	}
+	if isName && !v.consts[index].IsString() {
+		return errorAt(ins, "常量 %d 不是字符串", index)
+	}
+	return nil
+}
+
+// checkRegions 划分 OP_BEGIN/OP_END 区间，检查嵌套、表达式序号和跳转范围
+func (v *verifier) checkRegions() error {
+	v.region = make([]int, len(v.instructions))
+	current := -1
+	for i, ins := range v.instructions {
+		switch ins.Op {
+		case OP_BEGIN:
+			if current >= 0 {
+				return errorAt(ins, "表达式未结束，不能开始新的表达式")
+			}
+			current = i
+		case OP_EXIT:
+			if current >= 0 {
+				return errorAt(ins, "表达式未结束")
+			}
+		}
+		v.region[i] = current
+		if ins.Op == OP_END {
+			if current < 0 {
+				return errorAt(ins, "OP_END 没有对应的 OP_BEGIN")
+			}
+			current = -1
+		}
+	}
+
+	regions := 0
+	for _, ins := range v.instructions {
+		if ins.Op == OP_BEGIN {
+			regions++
+		}
+	}
+	for _, ins := range v.instructions {
+		if ins.Op != OP_BEGIN {
+			continue
+		}
+		if order := int(ins.Operands[0]); order < 0 || order >= regions {
+			return errorAt(ins, "表达式序号 %d 越界，共 %d 个表达式", order, regions)
+		}
+	}
+
+	for i, ins := range v.instructions {
+		if ins.Target == nil {
+			continue
+		}
+		j := v.index[ins.Target.Pos]
+		if v.region[i] < 0 || v.region[j] != v.region[i] || ins.Target.Op == OP_BEGIN {
+			return errorAt(ins, "跳转目标 %d 超出当前表达式", ins.Target.Pos)
+		}
+	}
+	return nil
+}
+
+// checkStack 沿所有执行路径模拟栈深度
+func (v *verifier) checkStack() error {
+	depths := make([]int, len(v.instructions))
+	for i := range depths {
+		depths[i] = -1
+	}
+	depths[0] = 0
+	work := []int{0}
+
+	flow := func(from *Instruction, to, depth int) error {
+		if to >= len(v.instructions) {
+			return errorAt(from, "执行越过字节码末尾")
+		}
+		if depths[to] < 0 {
+			depths[to] = depth
+			work = append(work, to)
+		} else if depths[to] != depth {
+			return errorAt(v.instructions[to], "栈深度不一致：%d 与 %d", depths[to], depth)
+		}
+		return nil
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		ins := v.instructions[i]
+		depth := depths[i]
+
+		need, delta := v.stackEffect(ins)
+		if depth < need {
+			return errorAt(ins, "栈下溢：需要 %d 个元素，栈深度为 %d", need, depth)
+		}
+		switch ins.Op {
+		case OP_BEGIN, OP_EXIT:
+			if depth != 0 {
+				return errorAt(ins, "栈深度应为 0，实际为 %d", depth)
+			}
+		case OP_END:
+			if depth != 1 {
+				return errorAt(ins, "表达式结束时栈深度应为 1，实际为 %d", depth)
+			}
+		}
+		depth += delta
+
+		if ins.Op == OP_EXIT {
+			continue
+		}
+		if ins.Target != nil {
+			if err := flow(ins, v.index[ins.Target.Pos], depth); err != nil {
+				return err
+			}
+		}
+		if ins.Op != OP_JUMP {
+			if err := flow(ins, i+1, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *verifier) stackEffect(ins *Instruction) (need, delta int) {
+	if ins.Op == OP_CALL {
+		arity := funmgr.GetFunctionManager().GetFunction(v.consts[ins.Operands[0]].AsString()).Arity()
+		return arity, 1 - arity
+	}
+	effect := stackEffects[ins.Op]
+	return effect.need, effect.delta
+}
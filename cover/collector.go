@@ -0,0 +1,78 @@
+package cover
+
+import (
+	"sync"
+)
+
+// Point 覆盖点：序号为 Index 的表达式中源码范围为 [Start, End) 的 if 或逻辑运算（字符偏移）
+type Point struct {
+	Index int
+	Start int
+	End   int
+}
+
+// Counts 覆盖点处判定值为真和为假的次数。if 的判定值为条件，&& 和 || 的判定值为左侧的值
+type Counts struct {
+	True  int64
+	False int64
+}
+
+// Collector 覆盖率收集器，记录各 if 和逻辑运算的判定结果，多次执行的结果累加。并发安全。
+// 语法树和闭包按表达式的源码位置记录；虚拟机按调试信息（见 chk.DebugInfo）中跳转指令的源码位置记录，
+// 字节码没有调试信息时不记录
+type Collector struct {
+	mu      sync.Mutex
+	points  map[Point]*Counts
+	sources map[int]string
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		points:  make(map[Point]*Counts),
+		sources: make(map[int]string),
+	}
+}
+
+// Reset 清空已记录的结果和源码
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.points = make(map[Point]*Counts)
+	c.sources = make(map[int]string)
+}
+
+// SetSources 记录各表达式的源码，生成报告时据此确定覆盖点的类型，expressions 按表达式序号排列
+func (c *Collector) SetSources(expressions []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, src := range expressions {
+		c.sources[i] = src
+	}
+}
+
+// Record 记录一次判定结果
+func (c *Collector) Record(index, start, end int, truthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	point := Point{Index: index, Start: start, End: end}
+	counts, ok := c.points[point]
+	if !ok {
+		counts = &Counts{}
+		c.points[point] = counts
+	}
+	if truthy {
+		counts.True++
+	} else {
+		counts.False++
+	}
+}
+
+// GetCounts 覆盖点的判定次数，没有记录时为零值
+func (c *Collector) GetCounts(point Point) Counts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counts, ok := c.points[point]; ok {
+		return *counts
+	}
+	return Counts{}
+}
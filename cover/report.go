@@ -0,0 +1,239 @@
+package cover
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/values"
+)
+
+// BranchKind 覆盖点的类型
+type BranchKind int
+
+const (
+	BranchIf      BranchKind = iota // if(...)
+	BranchAnd                       // &&
+	BranchOr                        // ||
+	BranchUnknown                   // 没有源码或源码中找不到对应的表达式
+)
+
+func (k BranchKind) String() string {
+	switch k {
+	case BranchIf:
+		return "if"
+	case BranchAnd:
+		return "&&"
+	case BranchOr:
+		return "||"
+	}
+	return "?"
+}
+
+// Arm 分支中的一路及其执行次数
+type Arm struct {
+	Name string
+	Hits int64
+}
+
+// Branch 一个 if 或逻辑运算的覆盖情况
+type Branch struct {
+	Point
+	Counts
+	Kind   BranchKind
+	Line   int    // 起始行，从 1 开始，没有源码时为 0
+	Column int    // 起始列，从 1 开始，没有源码时为 0
+	Text   string // 源码
+}
+
+// Arms 两路分支及其执行次数：if 为 then 和 else，&& 和 || 为右侧求值和短路
+func (b Branch) Arms() [2]Arm {
+	switch b.Kind {
+	case BranchIf:
+		return [2]Arm{{"then", b.True}, {"else", b.False}}
+	case BranchAnd:
+		return [2]Arm{{"右侧", b.True}, {"短路", b.False}}
+	case BranchOr:
+		return [2]Arm{{"右侧", b.False}, {"短路", b.True}}
+	}
+	return [2]Arm{{"真", b.True}, {"假", b.False}}
+}
+
+// IsCovered 两路分支是否都执行过
+func (b Branch) IsCovered() bool {
+	return b.True > 0 && b.False > 0
+}
+
+// Formula 一个表达式的覆盖情况，分支按源码位置排列，外层在前
+type Formula struct {
+	Index    int
+	Source   string
+	Branches []Branch
+	Err      error // 源码解析出错时不为 nil
+}
+
+// Report 覆盖率报告，表达式按序号排列
+type Report struct {
+	Formulas []Formula
+}
+
+// Report 生成报告。解析各表达式的源码找出全部 if 和逻辑运算，未执行过的也列出；
+// 开启优化时被常量折叠消除的分支不会执行，显示为未覆盖
+func (c *Collector) Report() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byIndex := make(map[int][]Point)
+	for point := range c.points {
+		byIndex[point.Index] = append(byIndex[point.Index], point)
+	}
+	indexes := make([]int, 0, len(c.sources))
+	for index := range c.sources {
+		indexes = append(indexes, index)
+	}
+	for index := range byIndex {
+		if _, ok := c.sources[index]; !ok {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+
+	report := &Report{}
+	for _, index := range indexes {
+		formula := Formula{Index: index, Source: c.sources[index]}
+		matched := make(map[Point]bool)
+		if formula.Source != "" {
+			expr, err := parser.NewParser(formula.Source).Parse()
+			if err != nil {
+				formula.Err = err
+			} else {
+				for _, n := range collectNodes(expr, nil) {
+					branch := Branch{
+						Point:  Point{Index: index, Start: n.span.Start, End: n.span.End},
+						Kind:   n.kind,
+						Line:   n.span.Line,
+						Column: n.span.Column,
+						Text:   n.span.Text(formula.Source),
+					}
+					// 优化后的比较跳转指令记录在条件（左侧）的位置上
+					for _, span := range []exprs.Span{n.span, n.cond} {
+						point := Point{Index: index, Start: span.Start, End: span.End}
+						if counts, ok := c.points[point]; ok && !matched[point] {
+							matched[point] = true
+							branch.True += counts.True
+							branch.False += counts.False
+						}
+					}
+					formula.Branches = append(formula.Branches, branch)
+				}
+			}
+		}
+		for _, point := range byIndex[index] {
+			if !matched[point] {
+				formula.Branches = append(formula.Branches, Branch{Point: point, Counts: *c.points[point], Kind: BranchUnknown})
+			}
+		}
+		slices.SortStableFunc(formula.Branches, func(a, b Branch) int {
+			if c := cmp.Compare(a.Start, b.Start); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.End, a.End)
+		})
+		report.Formulas = append(report.Formulas, formula)
+	}
+	return report
+}
+
+type node struct {
+	kind BranchKind
+	span exprs.Span
+	cond exprs.Span // if 的条件或逻辑运算的左侧
+}
+
+// collectNodes 按先序收集表达式中的 if 和逻辑运算
+func collectNodes(expr exprs.Expr, nodes []node) []node {
+	switch e := expr.(type) {
+	case *exprs.IfExpr:
+		nodes = append(nodes, node{kind: BranchIf, span: e.GetSpan(), cond: exprs.SpanOf(e.Condition)})
+		nodes = collectNodes(e.Condition, nodes)
+		nodes = collectNodes(e.ThenBranch, nodes)
+		if e.ElseBranch != nil {
+			nodes = collectNodes(e.ElseBranch, nodes)
+		}
+	case *exprs.LogicExpr:
+		kind := BranchAnd
+		if e.Operator.Type == values.OR {
+			kind = BranchOr
+		}
+		nodes = append(nodes, node{kind: kind, span: e.GetSpan(), cond: exprs.SpanOf(e.Left)})
+		nodes = collectNodes(e.Left, nodes)
+		nodes = collectNodes(e.Right, nodes)
+	case *exprs.BinaryExpr:
+		nodes = collectNodes(e.Left, nodes)
+		nodes = collectNodes(e.Right, nodes)
+	case *exprs.UnaryExpr:
+		nodes = collectNodes(e.Right, nodes)
+	case *exprs.AssignExpr:
+		nodes = collectNodes(e.Left, nodes)
+		nodes = collectNodes(e.Right, nodes)
+	case *exprs.CallExpr:
+		for _, arg := range e.Args {
+			nodes = collectNodes(arg, nodes)
+		}
+	case *exprs.GetExpr:
+		nodes = collectNodes(e.Object, nodes)
+	case *exprs.SetExpr:
+		nodes = collectNodes(e.Object, nodes)
+		nodes = collectNodes(e.Value, nodes)
+	}
+	return nodes
+}
+
+// Coverage 执行过的分支路数和全部分支路数，每个 if 或逻辑运算有两路
+func (r *Report) Coverage() (covered, total int) {
+	for _, f := range r.Formulas {
+		for _, b := range f.Branches {
+			for _, arm := range b.Arms() {
+				total++
+				if arm.Hits > 0 {
+					covered++
+				}
+			}
+		}
+	}
+	return covered, total
+}
+
+// WriteText 输出带执行次数注释的源码：每个表达式的源码之后逐行列出其中的分支，未执行过的一路以 ! 标出
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, f := range r.Formulas {
+		fmt.Fprintf(tw, "表达式 %d：%s\n", f.Index, f.Source)
+		if f.Err != nil {
+			fmt.Fprintf(tw, "    源码解析出错：%v\n", f.Err)
+		}
+		for _, b := range f.Branches {
+			arms := b.Arms()
+			fmt.Fprintf(tw, "    %d:%d\t%s\t%s\t%s\t%s\n", b.Line, b.Column, b.Kind,
+				formatArm(arms[0]), formatArm(arms[1]), b.Text)
+		}
+	}
+	covered, total := r.Coverage()
+	percent := 100.0
+	if total > 0 {
+		percent = float64(covered) * 100 / float64(total)
+	}
+	fmt.Fprintf(tw, "分支覆盖率：%d/%d（%.1f%%）\n", covered, total, percent)
+	return tw.Flush()
+}
+
+func formatArm(arm Arm) string {
+	if arm.Hits == 0 {
+		return fmt.Sprintf("%s 0 !", arm.Name)
+	}
+	return fmt.Sprintf("%s %d", arm.Name, arm.Hits)
+}
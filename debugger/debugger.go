@@ -0,0 +1,346 @@
+package debugger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/values"
+)
+
+var (
+	// ErrFinished 程序已执行结束，不能再单步或继续
+	ErrFinished = errors.New("程序已执行结束")
+	// ErrNotPaused 程序没有暂停，不能查看栈和变量
+	ErrNotPaused = errors.New("程序没有暂停")
+	// ErrAborted 调试被中止，作为程序的执行错误返回
+	ErrAborted = errors.New("调试已中止")
+)
+
+// BreakpointKind 断点类型
+type BreakpointKind int
+
+const (
+	// BreakExpr 在序号为 Value 的表达式开始（OP_BEGIN）时暂停
+	BreakExpr BreakpointKind = iota
+	// BreakPos 在字节码位置为 Value 的指令执行前暂停
+	BreakPos
+)
+
+// Breakpoint 断点
+type Breakpoint struct {
+	Kind  BreakpointKind
+	Value int
+}
+
+func (b Breakpoint) String() string {
+	if b.Kind == BreakExpr {
+		return fmt.Sprintf("表达式 %d", b.Value)
+	}
+	return fmt.Sprintf("位置 %d", b.Value)
+}
+
+// StopReason 暂停原因
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota // 遇到断点
+	StopStep                         // 单步执行
+	StopFinished                     // 程序执行结束（包括出错和中止）
+)
+
+// Stop 程序暂停或执行结束时的状态。暂停时描述即将执行的指令
+type Stop struct {
+	Reason      StopReason
+	Pos         int
+	Op          chk.OpCode
+	Order       int            // 当前表达式序号
+	Instruction string         // 即将执行的指令及操作数
+	Snippet     string         // 指令对应的子表达式源码，没有调试信息时为空
+	Stack       []values.Value // 操作数栈的副本，栈底在前
+
+	Results []*exec.ExResult // 执行结束时为已完成表达式的结果
+	Err     error            // 执行结束时为执行错误
+}
+
+type command int
+
+const (
+	cmdStep command = iota
+	cmdContinue
+	cmdAbort
+)
+
+// Debugger 字节码调试器，在 exec.VM 的调试钩子（见 exec.DebugHook）上实现断点、单步和继续执行，
+// 暂停时可以查看操作数栈和变量。虚拟机在单独的协程中执行，暂停时等待下一条命令。
+// 调试器不是并发安全的，应在一个协程中控制。暂停后不再调试时调用 Abort 结束虚拟机所在的协程
+type Debugger struct {
+	chunk        *chk.Chunk
+	ev           env.Environment
+	limits       limits.Limits
+	instructions map[int]*chk.Instruction
+	debug        *chk.DebugInfo
+	reader       *chk.ChunkReader
+	breakpoints  map[Breakpoint]bool
+
+	started  bool
+	finished bool
+	stepping bool
+	state    *exec.DebugState // 暂停时的虚拟机状态
+	last     *Stop
+	commands chan command
+	stops    chan *Stop
+}
+
+// NewDebugger 创建调试器，执行前校验字节码
+func NewDebugger(chunk *chk.Chunk, ev env.Environment) (*Debugger, error) {
+	if err := chk.Verify(chunk); err != nil {
+		return nil, err
+	}
+	decoded, err := chk.DecodeInstructions(chunk.Codes)
+	if err != nil {
+		return nil, err
+	}
+	debug, err := chunk.GetDebugInfo()
+	if err != nil {
+		return nil, err
+	}
+	instructions := make(map[int]*chk.Instruction, len(decoded))
+	for _, ins := range decoded {
+		instructions[ins.Pos] = ins
+	}
+	if ev == nil {
+		ev = env.NewDefaultEnvironment()
+	}
+	return &Debugger{
+		chunk:        chunk,
+		ev:           ev,
+		instructions: instructions,
+		debug:        debug,
+		reader:       chk.NewChunkReader(chunk, nil),
+		breakpoints:  make(map[Breakpoint]bool),
+	}, nil
+}
+
+func (d *Debugger) GetLimits() limits.Limits {
+	return d.limits
+}
+
+// SetLimits 设置执行资源限额，开始执行后设置不再生效
+func (d *Debugger) SetLimits(limits limits.Limits) {
+	d.limits = limits
+}
+
+// BreakAtExpr 在序号为 order 的表达式开始时暂停
+func (d *Debugger) BreakAtExpr(order int) {
+	d.breakpoints[Breakpoint{Kind: BreakExpr, Value: order}] = true
+}
+
+// BreakAtPos 在位置为 pos 的指令执行前暂停，pos 不是指令的起始位置时返回错误
+func (d *Debugger) BreakAtPos(pos int) error {
+	if _, ok := d.instructions[pos]; !ok {
+		return fmt.Errorf("位置 %d 不是指令的起始位置", pos)
+	}
+	d.breakpoints[Breakpoint{Kind: BreakPos, Value: pos}] = true
+	return nil
+}
+
+// RemoveBreakpoint 删除断点，断点不存在时返回 false
+func (d *Debugger) RemoveBreakpoint(bp Breakpoint) bool {
+	if !d.breakpoints[bp] {
+		return false
+	}
+	delete(d.breakpoints, bp)
+	return true
+}
+
+// ClearBreakpoints 删除全部断点
+func (d *Debugger) ClearBreakpoints() {
+	clear(d.breakpoints)
+}
+
+// GetBreakpoints 全部断点，表达式断点在前，同类按值排序
+func (d *Debugger) GetBreakpoints() []Breakpoint {
+	result := make([]Breakpoint, 0, len(d.breakpoints))
+	for bp := range d.breakpoints {
+		result = append(result, bp)
+	}
+	slices.SortFunc(result, func(a, b Breakpoint) int {
+		if a.Kind != b.Kind {
+			return int(a.Kind) - int(b.Kind)
+		}
+		return a.Value - b.Value
+	})
+	return result
+}
+
+// IsStarted 是否已开始执行
+func (d *Debugger) IsStarted() bool {
+	return d.started
+}
+
+// IsFinished 是否已执行结束
+func (d *Debugger) IsFinished() bool {
+	return d.finished
+}
+
+// GetLastStop 最近一次暂停或执行结束时的状态，未开始时为 nil
+func (d *Debugger) GetLastStop() *Stop {
+	return d.last
+}
+
+// Start 开始执行，运行到第一个断点或执行结束。ctx 取消时程序以取消错误结束
+func (d *Debugger) Start(ctx context.Context) (*Stop, error) {
+	if d.started {
+		return nil, errors.New("程序已开始执行")
+	}
+	d.started = true
+	d.commands = make(chan command)
+	d.stops = make(chan *Stop)
+
+	vm := exec.NewVM(nil)
+	vm.SetLimits(d.limits)
+	vm.SetDebugHook(d)
+	go func() {
+		results, err := vm.ExecuteContext(ctx, d.chunk, d.ev)
+		d.stops <- &Stop{Reason: StopFinished, Results: results, Err: err}
+	}()
+	return d.wait(), nil
+}
+
+// Step 执行一条指令后暂停。未开始时开始执行并在第一条指令前暂停
+func (d *Debugger) Step() (*Stop, error) {
+	if !d.started {
+		d.stepping = true
+		return d.Start(context.Background())
+	}
+	return d.resume(cmdStep)
+}
+
+// Continue 继续执行到下一个断点或执行结束。未开始时等同于 Start
+func (d *Debugger) Continue() (*Stop, error) {
+	if !d.started {
+		return d.Start(context.Background())
+	}
+	return d.resume(cmdContinue)
+}
+
+// Abort 中止执行，程序以 ErrAborted 结束。未开始或已结束时不做任何事
+func (d *Debugger) Abort() *Stop {
+	if !d.started || d.finished {
+		return d.last
+	}
+	stop, _ := d.resume(cmdAbort)
+	return stop
+}
+
+func (d *Debugger) resume(cmd command) (*Stop, error) {
+	if d.finished {
+		return nil, ErrFinished
+	}
+	d.state = nil
+	d.commands <- cmd
+	return d.wait(), nil
+}
+
+// wait 等待虚拟机暂停或执行结束
+func (d *Debugger) wait() *Stop {
+	stop := <-d.stops
+	if stop.Reason == StopFinished {
+		d.finished = true
+		d.state = nil
+	}
+	d.last = stop
+	return stop
+}
+
+// BeforeInstruction 实现 exec.DebugHook，在虚拟机的协程中调用，需要暂停时等待下一条命令
+func (d *Debugger) BeforeInstruction(state *exec.DebugState) error {
+	reason := StopStep
+	if !d.stepping {
+		if !d.breakpoints[Breakpoint{Kind: BreakPos, Value: state.Pos}] &&
+			!(state.Op == chk.OP_BEGIN && d.breakpoints[Breakpoint{Kind: BreakExpr, Value: state.Order}]) {
+			return nil
+		}
+		reason = StopBreakpoint
+	}
+
+	d.state = state
+	d.stops <- d.snapshot(state, reason)
+	switch <-d.commands {
+	case cmdStep:
+		d.stepping = true
+	case cmdContinue:
+		d.stepping = false
+	case cmdAbort:
+		return ErrAborted
+	}
+	return nil
+}
+
+func (d *Debugger) snapshot(state *exec.DebugState, reason StopReason) *Stop {
+	stop := &Stop{
+		Reason:      reason,
+		Pos:         state.Pos,
+		Op:          state.Op,
+		Order:       state.Order,
+		Instruction: d.FormatInstruction(state.Pos),
+		Stack:       slices.Clone(state.Stack),
+	}
+	if d.debug != nil {
+		if entry, ok := d.debug.Lookup(state.Pos); ok {
+			stop.Snippet = d.debug.Snippet(entry)
+		}
+	}
+	return stop
+}
+
+// FormatInstruction 格式化位置为 pos 的指令，常量操作数显示常量值
+func (d *Debugger) FormatInstruction(pos int) string {
+	ins, ok := d.instructions[pos]
+	if !ok {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(ins.Op.Title())
+	consts := ins.Op.ConstOperands()
+	for i, operand := range ins.Operands {
+		fmt.Fprintf(&sb, " %d", operand)
+		if slices.Contains(consts, i) {
+			if v, err := d.reader.ReadConst(int(operand)); err == nil {
+				fmt.Fprintf(&sb, " '%s'", v.String())
+			}
+		}
+	}
+	if ins.Target != nil {
+		fmt.Fprintf(&sb, " -> %d", ins.Target.Pos)
+	}
+	return sb.String()
+}
+
+// GetStack 暂停时操作数栈的副本，栈底在前
+func (d *Debugger) GetStack() ([]values.Value, error) {
+	if d.state == nil {
+		return nil, ErrNotPaused
+	}
+	return slices.Clone(d.state.Stack), nil
+}
+
+// GetVariable 暂停时变量的当前值，包括尚未写回执行环境的槽位变量
+func (d *Debugger) GetVariable(name string) (values.Value, error) {
+	if d.state == nil {
+		return values.NewNullValue(), ErrNotPaused
+	}
+	return d.state.GetVariable(name), nil
+}
+
+// GetVariables 字节码访问的全部变量名，按槽位排序
+func (d *Debugger) GetVariables() []string {
+	return slices.Clone(d.reader.GetVariables())
+}
@@ -0,0 +1,170 @@
+package debugger_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/debugger"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compile(t *testing.T, slotAccess bool, lines ...string) *chk.Chunk {
+	runner := gop.NewGopRunner()
+	runner.SetSlotAccess(slotAccess)
+	runner.SetDebugInfo(true)
+	chunk, err := runner.CompileSource(lines)
+	require.NoError(t, err)
+	return chunk
+}
+
+func TestDebugHook(t *testing.T) {
+	chunk := compile(t, false, "x = 1 + 2", "x * 10")
+	var ops []chk.OpCode
+	var orders []int
+	maxStack := 0
+	vm := exec.NewVM(nil)
+	vm.SetDebugHook(exec.DebugHookFunc(func(state *exec.DebugState) error {
+		ops = append(ops, state.Op)
+		if state.Op == chk.OP_BEGIN {
+			orders = append(orders, state.Order)
+		}
+		maxStack = max(maxStack, len(state.Stack))
+		return nil
+	}))
+	results, err := vm.Execute(chunk, env.NewDefaultEnvironment())
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, chk.OP_BEGIN, ops[0])
+	assert.Equal(t, chk.OP_EXIT, ops[len(ops)-1])
+	assert.Equal(t, []int{0, 1}, orders)
+	assert.Equal(t, 2, maxStack)
+
+	// 钩子返回错误时停止执行
+	stop := errors.New("stop")
+	vm.SetDebugHook(exec.DebugHookFunc(func(state *exec.DebugState) error {
+		if state.Order == 1 {
+			return stop
+		}
+		return nil
+	}))
+	results, err = vm.Execute(chunk, env.NewDefaultEnvironment())
+	assert.ErrorIs(t, err, stop)
+	assert.Len(t, results, 1)
+}
+
+func TestDebugger_BreakpointsAndStepping(t *testing.T) {
+	chunk := compile(t, false, "a = 2", "b = a * 3", "b + 1")
+	d, err := debugger.NewDebugger(chunk, nil)
+	require.NoError(t, err)
+	d.BreakAtExpr(1)
+	assert.Error(t, d.BreakAtPos(1), "不是指令的起始位置")
+
+	stop, err := d.Start(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, debugger.StopBreakpoint, stop.Reason)
+	assert.Equal(t, chk.OP_BEGIN, stop.Op)
+	assert.Equal(t, 1, stop.Order)
+	assert.Equal(t, "OP_BEGIN 1", stop.Instruction)
+	a, err := d.GetVariable("a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.GetValue())
+
+	// 单步执行到乘法
+	for stop.Op != chk.OP_MULTIPLY {
+		stop, err = d.Step()
+		require.NoError(t, err)
+		assert.Equal(t, debugger.StopStep, stop.Reason)
+	}
+	assert.Equal(t, "a * 3", stop.Snippet)
+	stack, err := d.GetStack()
+	require.NoError(t, err)
+	require.Len(t, stack, 2)
+	assert.Equal(t, 2, stack[0].GetValue())
+	assert.Equal(t, 3, stack[1].GetValue())
+
+	// 在下一个表达式的第一条指令处设置断点后继续
+	require.NoError(t, d.BreakAtPos(findBegin(t, chunk, 2)))
+	stop, err = d.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, debugger.StopBreakpoint, stop.Reason)
+	assert.Equal(t, 2, stop.Order)
+	assert.Len(t, d.GetBreakpoints(), 2)
+
+	d.ClearBreakpoints()
+	stop, err = d.Continue()
+	require.NoError(t, err)
+	assert.Equal(t, debugger.StopFinished, stop.Reason)
+	require.NoError(t, stop.Err)
+	require.Len(t, stop.Results, 3)
+	assert.Equal(t, 7, stop.Results[2].GetResult().GetValue())
+	assert.True(t, d.IsFinished())
+	_, err = d.Step()
+	assert.ErrorIs(t, err, debugger.ErrFinished)
+	_, err = d.GetStack()
+	assert.ErrorIs(t, err, debugger.ErrNotPaused)
+}
+
+// findBegin 查找表达式 order 的 OP_BEGIN 指令位置
+func findBegin(t *testing.T, chunk *chk.Chunk, order int32) int {
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	for _, ins := range instructions {
+		if ins.Op == chk.OP_BEGIN && ins.Operands[0] == order {
+			return ins.Pos
+		}
+	}
+	t.Fatalf("没有表达式 %d", order)
+	return 0
+}
+
+func TestDebugger_SlotVariablesAndAbort(t *testing.T) {
+	chunk := compile(t, true, "x = 5", "y = x + 1")
+	ev := env.NewDefaultEnvironment()
+	d, err := debugger.NewDebugger(chunk, ev)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"x", "y"}, d.GetVariables())
+	d.BreakAtExpr(1)
+	_, err = d.Start(context.Background())
+	require.NoError(t, err)
+
+	// 槽位变量在执行结束前还没有写回执行环境
+	x, err := d.GetVariable("x")
+	require.NoError(t, err)
+	assert.Equal(t, 5, x.GetValue())
+	assert.True(t, ev.Get("x").IsNull())
+
+	stop := d.Abort()
+	assert.Equal(t, debugger.StopFinished, stop.Reason)
+	assert.ErrorIs(t, stop.Err, debugger.ErrAborted)
+	assert.Len(t, stop.Results, 1)
+	assert.Equal(t, 5, ev.Get("x").GetValue(), "中止时写回已修改的变量")
+}
+
+func TestDebugger_REPL(t *testing.T) {
+	chunk := compile(t, false, "a = 2", "a * 3")
+	d, err := debugger.NewDebugger(chunk, nil)
+	require.NoError(t, err)
+	in := strings.NewReader(strings.Join([]string{
+		"b 1", "bl", "r", "p a", "s", "s", "stack", "l", "x", "c", "s",
+	}, "\n"))
+	var out bytes.Buffer
+	require.NoError(t, d.REPL(context.Background(), in, &out))
+
+	text := out.String()
+	assert.Contains(t, text, "表达式 1\n")
+	assert.Contains(t, text, "断点 表达式 1 位置")
+	assert.Contains(t, text, "a = 2\n")
+	assert.Contains(t, text, "[0] 2\n")
+	assert.Contains(t, text, "未知命令：x")
+	assert.Contains(t, text, "表达式 1 = 6\n")
+	assert.Contains(t, text, "执行结束\n")
+	assert.Contains(t, text, debugger.ErrFinished.Error())
+}
@@ -0,0 +1,166 @@
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `命令：
+  b <序号>      在表达式开始时暂停
+  bp <位置>     在指令执行前暂停
+  d <序号>      删除表达式断点
+  dp <位置>     删除指令断点
+  bl            列出断点
+  r             开始执行
+  s             单步执行
+  c             继续执行
+  stack         查看操作数栈
+  p <变量>      查看变量
+  vars          查看全部变量
+  l             查看当前指令
+  q             中止并退出
+  h             帮助`
+
+// REPL 从 in 逐行读取命令控制调试器，结果写入 out，直到输入结束或执行 q 命令。
+// 输入结束时如果程序仍在暂停则中止执行
+func (d *Debugger) REPL(ctx context.Context, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintln(out, "输入 h 查看帮助")
+	for {
+		fmt.Fprint(out, "(gop) ")
+		if !scanner.Scan() {
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "q" {
+			break
+		}
+		if err := d.execCommand(ctx, fields, out); err != nil {
+			fmt.Fprintf(out, "错误：%v\n", err)
+		}
+	}
+	if d.started && !d.finished {
+		d.printStop(out, d.Abort())
+	}
+	return scanner.Err()
+}
+
+func (d *Debugger) execCommand(ctx context.Context, fields []string, out io.Writer) error {
+	cmd := fields[0]
+	arg := func() (int, error) {
+		if len(fields) < 2 {
+			return 0, fmt.Errorf("命令 %s 缺少参数", cmd)
+		}
+		return strconv.Atoi(fields[1])
+	}
+
+	switch cmd {
+	case "h":
+		fmt.Fprintln(out, replHelp)
+
+	case "b", "bp", "d", "dp":
+		n, err := arg()
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "b":
+			d.BreakAtExpr(n)
+		case "bp":
+			return d.BreakAtPos(n)
+		case "d":
+			d.RemoveBreakpoint(Breakpoint{Kind: BreakExpr, Value: n})
+		case "dp":
+			d.RemoveBreakpoint(Breakpoint{Kind: BreakPos, Value: n})
+		}
+
+	case "bl":
+		for _, bp := range d.GetBreakpoints() {
+			fmt.Fprintln(out, bp)
+		}
+
+	case "r", "s", "c":
+		var stop *Stop
+		var err error
+		switch cmd {
+		case "r":
+			stop, err = d.Start(ctx)
+		case "s":
+			stop, err = d.Step()
+		case "c":
+			stop, err = d.Continue()
+		}
+		if err != nil {
+			return err
+		}
+		d.printStop(out, stop)
+
+	case "stack":
+		stack, err := d.GetStack()
+		if err != nil {
+			return err
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(out, "[%d] %s\n", i, stack[i].String())
+		}
+
+	case "p":
+		if len(fields) < 2 {
+			return fmt.Errorf("命令 %s 缺少参数", cmd)
+		}
+		v, err := d.GetVariable(fields[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s = %s\n", fields[1], v.String())
+
+	case "vars":
+		for _, name := range d.GetVariables() {
+			v, err := d.GetVariable(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s = %s\n", name, v.String())
+		}
+
+	case "l":
+		if d.last == nil || d.last.Reason == StopFinished {
+			return ErrNotPaused
+		}
+		d.printStop(out, d.last)
+
+	default:
+		return fmt.Errorf("未知命令：%s，输入 h 查看帮助", cmd)
+	}
+	return nil
+}
+
+// printStop 显示暂停位置或执行结果
+func (d *Debugger) printStop(out io.Writer, stop *Stop) {
+	if stop.Reason == StopFinished {
+		for _, res := range stop.Results {
+			fmt.Fprintf(out, "表达式 %d = %s\n", res.GetIndex(), res.GetResult().String())
+		}
+		if stop.Err != nil {
+			fmt.Fprintf(out, "执行出错：%v\n", stop.Err)
+		} else {
+			fmt.Fprintln(out, "执行结束")
+		}
+		return
+	}
+
+	if stop.Reason == StopBreakpoint {
+		fmt.Fprint(out, "断点 ")
+	}
+	fmt.Fprintf(out, "表达式 %d 位置 %d：%s\n", stop.Order, stop.Pos, stop.Instruction)
+	if stop.Snippet != "" {
+		fmt.Fprintf(out, "    %s\n", stop.Snippet)
+	}
+}
@@ -0,0 +1,33 @@
+package env
+
+import "github.com/simonwater/gopression/values"
+
+// BulkEnvironment 可选接口，一次读写多个变量，减少逐个访问的开销（如加锁、远程访问）
+type BulkEnvironment interface {
+	// GetValues 读取 ids 中各变量的值到 dest 的对应位置，变量不存在时为空值
+	GetValues(ids []string, dest []values.Value)
+	// PutValues 写入 ids 中各变量的值
+	PutValues(ids []string, vals []values.Value)
+}
+
+// GetValues 批量读取变量，ev 未实现 BulkEnvironment 时逐个读取
+func GetValues(ev Environment, ids []string, dest []values.Value) {
+	if bulk, ok := ev.(BulkEnvironment); ok {
+		bulk.GetValues(ids, dest)
+		return
+	}
+	for i, id := range ids {
+		dest[i] = ev.GetOrDefault(id, values.NewNullValue())
+	}
+}
+
+// PutValues 批量写入变量，ev 未实现 BulkEnvironment 时逐个写入
+func PutValues(ev Environment, ids []string, vals []values.Value) {
+	if bulk, ok := ev.(BulkEnvironment); ok {
+		bulk.PutValues(ids, vals)
+		return
+	}
+	for i, id := range ids {
+		ev.Put(id, vals[i])
+	}
+}
@@ -38,3 +38,19 @@ func (de *DefaultEnvironment) Put(id string, value values.Value) {
 func (de *DefaultEnvironment) Size() int {
 	return len(de.data)
 }
+
+func (de *DefaultEnvironment) GetValues(ids []string, dest []values.Value) {
+	for i, id := range ids {
+		if v, ok := de.data[id]; ok {
+			dest[i] = v
+		} else {
+			dest[i] = values.NewNullValue()
+		}
+	}
+}
+
+func (de *DefaultEnvironment) PutValues(ids []string, vals []values.Value) {
+	for i, id := range ids {
+		de.data[id] = vals[i]
+	}
+}
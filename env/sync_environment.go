@@ -0,0 +1,72 @@
+package env
+
+import (
+	"sync"
+
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// SyncEnvironment 并发安全的执行环境，对内部环境的访问加读写锁
+type SyncEnvironment struct {
+	*BaseEnvironment
+	inner Environment
+	mu    sync.RWMutex
+}
+
+// NewSyncEnvironment 包装执行环境，inner 本身已是 SyncEnvironment 时直接返回
+func NewSyncEnvironment(inner Environment) *SyncEnvironment {
+	if se, ok := inner.(*SyncEnvironment); ok {
+		return se
+	}
+	se := &SyncEnvironment{inner: inner}
+	se.BaseEnvironment = NewBaseEnvironment(se)
+	return se
+}
+
+// GetInner 获取被包装的环境
+func (se *SyncEnvironment) GetInner() Environment {
+	return se.inner
+}
+
+func (se *SyncEnvironment) BeforeExecute(vars []*util.Field) bool {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.inner.BeforeExecute(vars)
+}
+
+func (se *SyncEnvironment) Get(id string) values.Value {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.inner.Get(id)
+}
+
+func (se *SyncEnvironment) GetOrDefault(id string, defValue values.Value) values.Value {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.inner.GetOrDefault(id, defValue)
+}
+
+func (se *SyncEnvironment) Put(id string, value values.Value) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.inner.Put(id, value)
+}
+
+func (se *SyncEnvironment) Size() int {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.inner.Size()
+}
+
+func (se *SyncEnvironment) GetValues(ids []string, dest []values.Value) {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	GetValues(se.inner, ids, dest)
+}
+
+func (se *SyncEnvironment) PutValues(ids []string, vals []values.Value) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	PutValues(se.inner, ids, vals)
+}
@@ -0,0 +1,4 @@
+package exec
+
+// 虚拟机每执行 cancelCheckMask+1 条指令检查一次是否取消
+const cancelCheckMask = 1023
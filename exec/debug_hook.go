@@ -0,0 +1,74 @@
+package exec
+
+import (
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/values"
+)
+
+// DebugHook 调试钩子，设置后虚拟机在执行每条指令前调用。返回错误时虚拟机停止执行并返回该错误
+type DebugHook interface {
+	BeforeInstruction(state *DebugState) error
+}
+
+// DebugHookFunc 函数形式的调试钩子
+type DebugHookFunc func(state *DebugState) error
+
+func (f DebugHookFunc) BeforeInstruction(state *DebugState) error {
+	return f(state)
+}
+
+// DebugState 执行指令前的虚拟机状态，只在钩子调用期间有效，需要保留时复制
+type DebugState struct {
+	Pos   int            // 即将执行的指令在字节码中的位置
+	Op    chk.OpCode     // 即将执行的指令
+	Order int            // 当前表达式的序号，OP_BEGIN 指令时为即将开始的表达式
+	Stack []values.Value // 操作数栈，栈底在前，不要修改
+	Env   env.Environment
+
+	vm *VM
+}
+
+// GetVariable 读取变量的当前值。按槽位访问的变量在执行结束前只保存在虚拟机中，优先返回虚拟机中的值
+func (s *DebugState) GetVariable(name string) values.Value {
+	for i, slotName := range s.vm.slotNames {
+		if slotName == name {
+			return s.vm.slots[i]
+		}
+	}
+	return s.Env.GetOrDefault(name, values.NewNullValue())
+}
+
+// GetVariables 字节码访问的全部变量名，按槽位排序
+func (s *DebugState) GetVariables() []string {
+	return s.vm.chunkReader.GetVariables()
+}
+
+// GetDebugInfo 字节码的调试信息，没有时返回 nil
+func (s *DebugState) GetDebugInfo() *chk.DebugInfo {
+	return s.vm.chunkReader.GetDebugInfo()
+}
+
+// callHook 执行指令前调用调试钩子，op 已读出
+func (vm *VM) callHook(op chk.OpCode, order int, ev env.Environment) error {
+	if op == chk.OP_BEGIN {
+		// 预读表达式序号后回到操作数处
+		pos := vm.chunkReader.Position()
+		next, err := vm.chunkReader.ReadInt()
+		if err != nil {
+			return err
+		}
+		order = int(next)
+		if err := vm.chunkReader.NewPosition(pos); err != nil {
+			return err
+		}
+	}
+	return vm.hook.BeforeInstruction(&DebugState{
+		Pos:   vm.opPos,
+		Op:    op,
+		Order: order,
+		Stack: vm.stack[:vm.stackTop],
+		Env:   ev,
+		vm:    vm,
+	})
+}
@@ -11,6 +11,9 @@ import (
 type Disassembler struct {
 	printer     func(msg string)
 	chunkReader *chk.ChunkReader
+	variables   []string
+	debug       *chk.DebugInfo
+	lastIndex   int // 上一次显示源码的表达式序号
 }
 
 // NewDisassembler 创建新的反汇编器
@@ -24,14 +27,18 @@ func NewDisassembler(printer func(string)) *Disassembler {
 	return &Disassembler{printer: printer}
 }
 
-// Execute 执行反汇编过程
+// Execute 执行反汇编过程。字节码包含调试信息时，在源码位置变化处穿插显示对应的源码
 func (d *Disassembler) Execute(chunk *chk.Chunk) error {
 	d.chunkReader = chk.NewChunkReader(chunk, util.NewTracer())
+	d.variables = d.chunkReader.GetVariables()
+	d.debug = d.chunkReader.GetDebugInfo()
+	d.lastIndex = -1
 	var expOrder int32
 	d.println("POSITION", "CODE", "PARAMETER", "ORDER")
 
 	for {
 		pos := fmt.Sprintf("%d", d.chunkReader.Position())
+		d.printSource(d.chunkReader.Position())
 		op, err := d.readCode()
 		if err != nil {
 			return err
@@ -59,6 +66,16 @@ func (d *Disassembler) Execute(chunk *chk.Chunk) error {
 				return err
 			}
 
+		case chk.OP_GET_LOCAL, chk.OP_SET_LOCAL:
+			slot, err := d.chunkReader.ReadInt()
+			if err != nil {
+				return err
+			}
+			param = fmt.Sprintf("%d", slot)
+			if int(slot) < len(d.variables) {
+				param = fmt.Sprintf("%d(%s)", slot, d.variables[slot])
+			}
+
 		case chk.OP_JUMP_IF_FALSE, chk.OP_JUMP:
 			offset, err := d.chunkReader.ReadInt()
 			if err != nil {
@@ -66,6 +83,32 @@ func (d *Disassembler) Execute(chunk *chk.Chunk) error {
 			}
 			param = d.gotoOffset(int(offset))
 
+		case chk.OP_GET_GLOBAL_ADD_CONST:
+			name, err := d.readString()
+			if err != nil {
+				return err
+			}
+			v, err := d.readConstant()
+			if err != nil {
+				return err
+			}
+			param = fmt.Sprintf("%s + %s", name, v.String())
+
+		case chk.OP_CONST_COMPARE_JUMP:
+			cmp, err := d.chunkReader.ReadByte()
+			if err != nil {
+				return err
+			}
+			v, err := d.readConstant()
+			if err != nil {
+				return err
+			}
+			offset, err := d.chunkReader.ReadInt()
+			if err != nil {
+				return err
+			}
+			param = fmt.Sprintf("%s %s %s", compareSymbols[chk.OpCode(cmp)], v.String(), d.gotoOffset(int(offset)))
+
 		case chk.OP_EXIT:
 			d.println(pos, op.Title(), "", fmt.Sprintf("%d", expOrder))
 			return nil
@@ -78,6 +121,15 @@ func (d *Disassembler) Execute(chunk *chk.Chunk) error {
 	}
 }
 
+var compareSymbols = map[chk.OpCode]string{
+	chk.OP_GREATER:       ">",
+	chk.OP_GREATER_EQUAL: ">=",
+	chk.OP_LESS:          "<",
+	chk.OP_LESS_EQUAL:    "<=",
+	chk.OP_EQUAL_EQUAL:   "==",
+	chk.OP_BANG_EQUAL:    "!=",
+}
+
 func (d *Disassembler) readString() (string, error) {
 	value, err := d.readConstant()
 	return value.AsString(), err
@@ -102,11 +154,30 @@ func (d *Disassembler) gotoOffset(offset int) string {
 	return fmt.Sprintf(":%d->to:%d", offset, curPos+offset)
 }
 
+// printSource 指令的源码位置与上一条不同时显示源码：表达式开始时显示整个表达式，之后显示子表达式
+func (d *Disassembler) printSource(pos int) {
+	if d.debug == nil {
+		return
+	}
+	entry, ok := d.debug.Lookup(pos)
+	if !ok || entry.Pos != pos {
+		return
+	}
+	if entry.Index != d.lastIndex {
+		d.lastIndex = entry.Index
+		if src, ok := d.debug.Sources[entry.Index]; ok {
+			d.printer(fmt.Sprintf("; 表达式 %d：%s\n", entry.Index, src))
+		}
+		return
+	}
+	d.printer(fmt.Sprintf(";   %d:%d %s\n", entry.Line, entry.Column, d.debug.Snippet(entry)))
+}
+
 func (d *Disassembler) println(pos, op, param, order string) {
 	// 格式化输出行
-	line := fmt.Sprintf("%-10s %-20s %-20s %s\n",
+	line := fmt.Sprintf("%-10s %-26s %-20s %s\n",
 		truncate(pos, 10),
-		truncate(op, 18),
+		truncate(op, 24),
 		truncate(param, 18),
 		order)
 
@@ -0,0 +1,86 @@
+package exec_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/visitors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func disassemble(t *testing.T, chunk *chk.Chunk) []string {
+	t.Helper()
+	var output []string
+	require.NoError(t, exec.NewDisassembler(func(msg string) {
+		output = append(output, strings.Join(strings.Fields(msg), " "))
+	}).Execute(chunk))
+	return output
+}
+
+func TestPeephole_FuseAndThreadJumps(t *testing.T) {
+	lines := []string{
+		"a + 1 > 3",
+		"a > 1 || b > 1 || c > 1",
+		"aa > 11 && bb > 11 && cc > 11",
+	}
+	plain, err := gop.NewGopRunner().CompileSource(lines)
+	require.NoError(t, err)
+	runner := gop.NewGopRunner()
+	runner.SetOptimize(true)
+	chunk, err := runner.CompileSource(lines)
+	require.NoError(t, err)
+	assert.Less(t, chunk.GetCodesSize(), plain.GetCodesSize())
+
+	output := disassemble(t, chunk)
+	assert.Contains(t, output, "5 OP_GET_GLOBAL_ADD_CONST a + 1 0")
+	assert.Contains(t, output, "90 OP_CONST_COMPARE_JUMP > 11 :28->to:128 2")
+
+	// 跳转链直接跳到最终目标
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	for _, ins := range instructions {
+		if ins.Target != nil {
+			assert.NotEqual(t, chk.OP_JUMP, ins.Target.Op, "位置 %d", ins.Pos)
+			assert.NotEqual(t, chk.OP_JUMP_IF_FALSE, ins.Target.Op, "位置 %d", ins.Pos)
+		}
+	}
+}
+
+func TestPeephole_ConstCondition(t *testing.T) {
+	expr, err := parser.NewParser("if(true, a, b)").Parse()
+	require.NoError(t, err)
+	compiler := visitors.NewOpCodeCompiler(nil)
+	compiler.BeginCompile()
+	compiler.CompileExpr(expr, 0)
+	chunk, err := chk.Optimize(compiler.EndCompile())
+	require.NoError(t, err)
+
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	ops := make([]chk.OpCode, len(instructions))
+	for i, ins := range instructions {
+		ops[i] = ins.Op
+	}
+	assert.NotContains(t, ops, chk.OP_JUMP_IF_FALSE)
+	assert.NotContains(t, ops, chk.OP_TRUE)
+	assert.Equal(t, chk.OP_GET_GLOBAL, ops[1])
+}
+
+func TestInstructions_RoundTrip(t *testing.T) {
+	chunk, err := gop.NewGopRunner().CompileSource([]string{
+		"aa > 11 && bb > 11 && cc > 11 && dd > 11",
+		"if(a > 1, x = 1, y = 2)",
+	})
+	require.NoError(t, err)
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	assert.Equal(t, chunk.Codes, chk.EncodeInstructions(instructions))
+
+	_, err = chk.DecodeInstructions(chunk.Codes[:len(chunk.Codes)-3])
+	assert.Error(t, err, "截断的字节码应报错")
+}
@@ -0,0 +1,283 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/cover"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/profile"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// RegisterVM 寄存器虚拟机，执行 RegProgram。
+// 变量在表达式开始时读入寄存器，赋值只修改寄存器，执行结束（包括出错和取消）时写回修改过的变量
+type RegisterVM struct {
+	limits   limits.Limits
+	tracer   *util.Tracer
+	profiler *profile.Profiler
+	coverage *cover.Collector
+}
+
+func NewRegisterVM(tracer *util.Tracer) *RegisterVM {
+	return &RegisterVM{tracer: tracer}
+}
+
+func (vm *RegisterVM) GetLimits() limits.Limits {
+	return vm.limits
+}
+
+// SetLimits 设置资源限额，超出时返回 *QuotaError。MaxStackDepth 限制单个表达式的临时寄存器数
+func (vm *RegisterVM) SetLimits(limits limits.Limits) {
+	vm.limits = limits
+}
+
+func (vm *RegisterVM) GetProfiler() *profile.Profiler {
+	return vm.profiler
+}
+
+// SetProfiler 设置剖析器，记录表达式和函数调用的时间，为 nil 时不记录（默认）
+func (vm *RegisterVM) SetProfiler(profiler *profile.Profiler) {
+	vm.profiler = profiler
+}
+
+func (vm *RegisterVM) GetCoverage() *cover.Collector {
+	return vm.coverage
+}
+
+// SetCoverage 设置覆盖率收集器，在条件跳转指令处记录 if 和逻辑运算的判定结果。
+// 按调试信息中的源码位置记录，程序没有调试信息时不记录。为 nil 时不记录（默认）
+func (vm *RegisterVM) SetCoverage(coverage *cover.Collector) {
+	vm.coverage = coverage
+}
+
+// recordBranch 记录第 pc 条指令处的判定结果
+func (vm *RegisterVM) recordBranch(program *chk.RegProgram, pc int, truthy bool) {
+	if program.Debug == nil {
+		return
+	}
+	if entry, ok := program.Debug.Lookup(pc); ok {
+		vm.coverage.Record(entry.Index, entry.Start, entry.End, truthy)
+	}
+}
+
+func (vm *RegisterVM) Execute(program *chk.RegProgram, ev env.Environment) ([]*ExResult, error) {
+	return vm.ExecuteContext(context.Background(), program, ev)
+}
+
+// ExecuteContext 执行程序，在表达式之间以及每执行一定数量的指令后检查 ctx 是否取消。
+// 返回已完成表达式的结果，程序包含调试信息时执行错误为 *RuntimeError
+func (vm *RegisterVM) ExecuteContext(ctx context.Context, program *chk.RegProgram, ev env.Environment) ([]*ExResult, error) {
+	if vm.tracer != nil {
+		vm.tracer.StartTimerWithMsg("运行寄存器虚拟机")
+		defer vm.tracer.EndTimer("寄存器虚拟机运行结束")
+	}
+
+	regs := make([]values.Value, program.NumRegs)
+	copy(regs[program.ConstBase():], program.Consts)
+	dirty := make([]bool, len(program.Vars))
+	defer storeRegisters(ev, program.Vars, regs, dirty)
+
+	results, pc, err := vm.run(ctx, program, ev, regs, dirty)
+	if err != nil {
+		err = locate(err, program.Debug, pc)
+	}
+	return results, err
+}
+
+// run 虚拟机主循环，出错时同时返回出错指令的序号，执行中的 panic 转换为错误返回
+func (vm *RegisterVM) run(ctx context.Context, program *chk.RegProgram, ev env.Environment,
+	regs []values.Value, dirty []bool) (results []*ExResult, pc int, err error) {
+	code := program.Code
+	tempBase := int32(program.TempBase())
+	results = []*ExResult{}
+	expOrder := 0
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(r, expOrder, pc)
+		}
+	}()
+	cancelable := ctx.Done() != nil
+	steps := 0
+	exprSteps := 0
+	var exprStart time.Time
+
+	// set 写入寄存器，写入变量时标记为已修改
+	set := func(r int32, v values.Value) {
+		regs[r] = v
+		if r < tempBase {
+			dirty[r] = true
+		}
+	}
+
+	for pc = 0; ; pc++ {
+		steps++
+		if cancelable && steps&cancelCheckMask == 0 {
+			if err := limits.CheckCanceled(ctx); err != nil {
+				return results, pc, err
+			}
+		}
+		exprSteps++
+		if vm.limits.MaxInstructions > 0 && exprSteps > vm.limits.MaxInstructions {
+			return results, pc, &limits.QuotaError{Kind: limits.QuotaInstructions, Limit: vm.limits.MaxInstructions}
+		}
+
+		ins := &code[pc]
+		switch ins.Op {
+		case chk.REG_BEGIN:
+			if cancelable {
+				if err := limits.CheckCanceled(ctx); err != nil {
+					return results, pc, err
+				}
+			}
+			exprSteps = 0
+			expOrder = int(ins.A)
+			if limit := vm.limits.GetStackDepth(); int(ins.B) > limit {
+				return results, pc, &limits.QuotaError{Kind: limits.QuotaStackDepth, Limit: limit}
+			}
+			if vm.profiler != nil {
+				exprStart = time.Now()
+			}
+
+		case chk.REG_LOAD:
+			env.GetValues(ev, program.Vars[ins.A:ins.A+ins.B], regs[ins.A:ins.A+ins.B])
+
+		case chk.REG_MOVE:
+			set(ins.A, regs[ins.B])
+
+		case chk.REG_ADD, chk.REG_SUBTRACT, chk.REG_MULTIPLY, chk.REG_DIVIDE, chk.REG_MODE, chk.REG_POWER,
+			chk.REG_GREATER, chk.REG_GREATER_EQUAL, chk.REG_LESS, chk.REG_LESS_EQUAL, chk.REG_EQUAL_EQUAL, chk.REG_BANG_EQUAL:
+			result, err := values.BinaryOperate(regs[ins.B], regs[ins.C], regTokens[ins.Op])
+			if err != nil {
+				return results, pc, err
+			}
+			if err := vm.limits.CheckValue(result); err != nil {
+				return results, pc, err
+			}
+			set(ins.A, result)
+
+		case chk.REG_NOT, chk.REG_NEGATE:
+			result, err := values.PreUnaryOperate(regs[ins.B], regTokens[ins.Op])
+			if err != nil {
+				return results, pc, err
+			}
+			set(ins.A, result)
+
+		case chk.REG_JUMP:
+			pc = int(ins.A) - 1
+
+		case chk.REG_JUMP_IF_FALSE:
+			if vm.coverage != nil {
+				vm.recordBranch(program, pc, regs[ins.A].IsTruthy())
+			}
+			if !regs[ins.A].IsTruthy() {
+				pc = int(ins.B) - 1
+			}
+
+		case chk.REG_JUMP_IF_TRUE:
+			if vm.coverage != nil {
+				vm.recordBranch(program, pc, regs[ins.A].IsTruthy())
+			}
+			if regs[ins.A].IsTruthy() {
+				pc = int(ins.B) - 1
+			}
+
+		case chk.REG_CALL:
+			fn := program.Funcs[ins.B]
+			args := make([]values.Value, fn.Arity())
+			copy(args, regs[ins.C:])
+			var start time.Time
+			if vm.profiler != nil {
+				start = time.Now()
+			}
+			result, err := fn.Call(args)
+			if vm.profiler != nil {
+				vm.profiler.RecordCall(expOrder, fn.GetName(), time.Since(start))
+			}
+			if err != nil {
+				return results, pc, err
+			}
+			if err := vm.limits.CheckValue(result); err != nil {
+				return results, pc, err
+			}
+			set(ins.A, result)
+
+		case chk.REG_GET_PROPERTY:
+			name := regs[ins.C].AsString()
+			obj := regs[ins.B]
+			if !obj.IsInstance() {
+				return results, pc, fmt.Errorf("只有实例对象有属性: %s", name)
+			}
+			instance := obj.AsInstance()
+			prop, _ := instance.Get(name)
+			set(ins.A, prop)
+
+		case chk.REG_CHECK_INSTANCE:
+			if !regs[ins.A].IsInstance() {
+				return results, pc, fmt.Errorf("只有实例对象有属性: %s", regs[ins.B].AsString())
+			}
+
+		case chk.REG_SET_PROPERTY:
+			name := regs[ins.B].AsString()
+			obj := regs[ins.A]
+			if !obj.IsInstance() {
+				return results, pc, fmt.Errorf("只有实例对象有属性: %s", name)
+			}
+			instance := obj.AsInstance()
+			instance.Set(name, regs[ins.C])
+			if err := vm.limits.CheckValue(obj); err != nil {
+				return results, pc, err
+			}
+
+		case chk.REG_END:
+			val := regs[ins.A]
+			results = append(results, &ExResult{Value: &val, State: OK, Index: expOrder})
+			if vm.profiler != nil {
+				vm.profiler.RecordExpr(expOrder, time.Since(exprStart))
+			}
+
+		case chk.REG_EXIT:
+			return results, pc, nil
+
+		default:
+			return results, pc, fmt.Errorf("暂不支持的指令：%s", ins.Op)
+		}
+	}
+}
+
+// storeRegisters 将修改过的变量写回执行环境
+func storeRegisters(ev env.Environment, vars []string, regs []values.Value, dirty []bool) {
+	names := make([]string, 0)
+	vals := make([]values.Value, 0)
+	for i, d := range dirty {
+		if d {
+			names = append(names, vars[i])
+			vals = append(vals, regs[i])
+		}
+	}
+	if len(names) > 0 {
+		env.PutValues(ev, names, vals)
+	}
+}
+
+// regTokens 运算指令对应的运算符
+var regTokens = [...]values.TokenType{
+	chk.REG_ADD:           values.PLUS,
+	chk.REG_SUBTRACT:      values.MINUS,
+	chk.REG_MULTIPLY:      values.STAR,
+	chk.REG_DIVIDE:        values.SLASH,
+	chk.REG_MODE:          values.PERCENT,
+	chk.REG_POWER:         values.STARSTAR,
+	chk.REG_GREATER:       values.GREATER,
+	chk.REG_GREATER_EQUAL: values.GREATER_EQUAL,
+	chk.REG_LESS:          values.LESS,
+	chk.REG_LESS_EQUAL:    values.LESS_EQUAL,
+	chk.REG_EQUAL_EQUAL:   values.EQUAL_EQUAL,
+	chk.REG_BANG_EQUAL:    values.BANG_EQUAL,
+	chk.REG_NOT:           values.BANG,
+	chk.REG_NEGATE:        values.MINUS,
+}
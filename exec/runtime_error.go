@@ -0,0 +1,62 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/util"
+)
+
+// RuntimeError 虚拟机执行出错的位置，字节码包含调试信息时返回（见 chk.DebugInfo）。
+// 执行中的 panic（如整数除以零、函数内部出错）也转换为 RuntimeError，没有调试信息时只有表达式序号。
+// Err 为原始错误，可用 errors.Is/errors.As 判断
+type RuntimeError struct {
+	Index   int    // 表达式序号
+	Pos     int    // 出错指令的位置
+	Line    int    // 出错子表达式的起始行
+	Column  int    // 出错子表达式的起始列
+	Snippet string // 出错子表达式的源码
+	Source  string // 表达式源码，编译时未提供源码则为空
+	Err     error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Snippet == "" {
+		return fmt.Sprintf("表达式 %d 执行出错：%v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("表达式 %d 第 %d 行第 %d 列 %q 执行出错：%v", e.Index, e.Line, e.Column, e.Snippet, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// recovered 把主循环中 recover 得到的值转换为执行第 index 个表达式时的错误
+func recovered(r any, index, pos int) error {
+	return &RuntimeError{Index: index, Pos: pos, Err: util.PanicError(r)}
+}
+
+// locate 为执行错误附加调试信息中的出错位置，取消和没有调试信息时原样返回
+func locate(err error, debug *chk.DebugInfo, pos int) error {
+	if debug == nil || errors.Is(err, limits.ErrCanceled) {
+		return err
+	}
+	entry, ok := debug.Lookup(pos)
+	if !ok {
+		return err
+	}
+	if re, ok := err.(*RuntimeError); ok {
+		err = re.Err
+	}
+	return &RuntimeError{
+		Index:   entry.Index,
+		Pos:     pos,
+		Line:    entry.Line,
+		Column:  entry.Column,
+		Snippet: debug.Snippet(entry),
+		Source:  debug.Sources[entry.Index],
+		Err:     err,
+	}
+}
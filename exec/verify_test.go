@@ -0,0 +1,144 @@
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var verifyLines = []string{"x = a + 1", "x > 3 && b < 2 || c", "if(x > 10, abs(x), -x)"}
+
+// mutate 解码字节码后修改指令，重新编码为新的字节码块
+func mutate(t *testing.T, chunk *chk.Chunk, fn func([]*chk.Instruction) []*chk.Instruction) *chk.Chunk {
+	t.Helper()
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	return chk.NewChunkWithData(chk.EncodeInstructions(fn(instructions)), chunk.Constants, chunk.Vars)
+}
+
+func requireVerifyError(t *testing.T, chunk *chk.Chunk, pos int) {
+	t.Helper()
+	err := chk.Verify(chunk)
+	var verr *chk.VerifyError
+	require.True(t, errors.As(err, &verr), "%v", err)
+	assert.Equal(t, pos, verr.Pos, verr.Error())
+}
+
+func TestVerify_CompiledChunks(t *testing.T) {
+	for _, setup := range []func(*gop.GopRunner){
+		func(r *gop.GopRunner) {},
+		func(r *gop.GopRunner) { r.SetOptimize(true) },
+		func(r *gop.GopRunner) { r.SetSlotAccess(true) },
+	} {
+		runner := gop.NewGopRunner()
+		setup(runner)
+		chunk, err := runner.CompileSource(verifyLines)
+		require.NoError(t, err)
+		assert.NoError(t, chk.Verify(chunk))
+	}
+}
+
+func TestVerify_RejectInvalid(t *testing.T) {
+	chunk, err := gop.NewGopRunner().CompileSource(verifyLines)
+	require.NoError(t, err)
+
+	// 常量索引越界
+	var target *chk.Instruction
+	bad := mutate(t, chunk, func(ins []*chk.Instruction) []*chk.Instruction {
+		for _, in := range ins {
+			if in.Op == chk.OP_CONSTANT {
+				in.Operands[0] = 1000
+				target = in
+				break
+			}
+		}
+		return ins
+	})
+	requireVerifyError(t, bad, target.Pos)
+
+	// 删除加法的左操作数，栈下溢
+	bad = mutate(t, chunk, func(ins []*chk.Instruction) []*chk.Instruction {
+		for i, in := range ins {
+			if in.Op == chk.OP_ADD {
+				target = in
+				return append(ins[:i-2], ins[i-1:]...)
+			}
+		}
+		return ins
+	})
+	requireVerifyError(t, bad, target.Pos)
+
+	// 跳转到指令中间
+	bad = mutate(t, chunk, func(ins []*chk.Instruction) []*chk.Instruction {
+		for _, in := range ins {
+			if in.Op == chk.OP_JUMP_IF_FALSE {
+				target = in
+			}
+		}
+		return ins
+	})
+	codes := append([]byte(nil), bad.Codes...)
+	codes[target.Pos+4] += 2
+	requireVerifyError(t, chk.NewChunkWithData(codes, chunk.Constants, chunk.Vars), target.Pos)
+
+	// 缺少 OP_EXIT
+	codes = chunk.Codes[:len(chunk.Codes)-1]
+	requireVerifyError(t, chk.NewChunkWithData(codes, chunk.Constants, chunk.Vars), len(codes)-1)
+
+	// 未知操作码、操作数不完整
+	codes = append(append([]byte(nil), chunk.Codes[:5]...), 200)
+	requireVerifyError(t, chk.NewChunkWithData(codes, chunk.Constants, chunk.Vars), 5)
+	codes = append(append([]byte(nil), chunk.Codes[:5]...), byte(chk.OP_CONSTANT), 0)
+	requireVerifyError(t, chk.NewChunkWithData(codes, chunk.Constants, chunk.Vars), 5)
+
+	// 常量池损坏
+	requireVerifyError(t, chk.NewChunkWithData(chunk.Codes, []byte{0xff}, chunk.Vars), -1)
+
+	// 表达式序号为负数或不小于表达式个数
+	for _, order := range []int32{-1, int32(len(verifyLines))} {
+		bad = mutate(t, chunk, func(ins []*chk.Instruction) []*chk.Instruction {
+			for _, in := range ins {
+				if in.Op == chk.OP_BEGIN {
+					target = in
+				}
+			}
+			target.Operands[0] = order
+			return ins
+		})
+		requireVerifyError(t, bad, target.Pos)
+	}
+}
+
+func TestVerify_ReadChunkRejectsInvalid(t *testing.T) {
+	chunk, err := gop.NewGopRunner().CompileSource(verifyLines)
+	require.NoError(t, err)
+	bad := mutate(t, chunk, func(ins []*chk.Instruction) []*chk.Instruction {
+		return ins[:len(ins)-2]
+	})
+
+	restored, err := chk.NewChunkWithBytes(bad.ToBytes())
+	var verr *chk.VerifyError
+	assert.True(t, errors.As(err, &verr))
+	assert.Nil(t, restored)
+
+	_, err = chk.ReadChunk(bytes.NewReader(bad.ToBytes()))
+	assert.True(t, errors.As(err, &verr))
+
+	restored, err = chk.NewChunkWithBytes(chunk.ToBytes())
+	require.NoError(t, err)
+	ev := env.NewDefaultEnvironment()
+	ev.Put("a", values.NewIntValue(5))
+	ev.Put("b", values.NewIntValue(1))
+	ev.Put("c", values.NewBooleanValue(false))
+	result, err := gop.NewGopRunner().RunChunkContext(context.Background(), restored, ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{6, true, -6}, result)
+}
@@ -1,23 +1,38 @@
 package exec
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/cover"
 	"github.com/simonwater/gopression/env"
 	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/profile"
 	"github.com/simonwater/gopression/util"
 	"github.com/simonwater/gopression/values"
 )
 
-const STACK_MAX = 256
+const STACK_MAX = limits.DEFAULT_STACK_DEPTH
 
 type VM struct {
-	stack       [STACK_MAX]values.Value
+	stack       []values.Value
 	stackTop    int
+	limits      limits.Limits
 	chunkReader *chk.ChunkReader
+	opPos       int // 正在执行的指令位置
 	tracer      *util.Tracer
+	hook        DebugHook
+	profiler    *profile.Profiler
+	coverage    *cover.Collector
+
+	// 按槽位访问的变量，首次访问时一次性读入，执行结束时写回修改过的变量
+	slotNames []string
+	slots     []values.Value
+	dirty     []bool
 }
 
 func NewVM(tracer *util.Tracer) *VM {
@@ -26,14 +41,74 @@ func NewVM(tracer *util.Tracer) *VM {
 	}
 }
 
+func (vm *VM) GetLimits() limits.Limits {
+	return vm.limits
+}
+
+// SetLimits 设置资源限额，超出时返回 *QuotaError
+func (vm *VM) SetLimits(limits limits.Limits) {
+	vm.limits = limits
+}
+
+func (vm *VM) GetDebugHook() DebugHook {
+	return vm.hook
+}
+
+// SetDebugHook 设置调试钩子，为 nil 时不调用（默认）
+func (vm *VM) SetDebugHook(hook DebugHook) {
+	vm.hook = hook
+}
+
+func (vm *VM) GetProfiler() *profile.Profiler {
+	return vm.profiler
+}
+
+// SetProfiler 设置剖析器，记录表达式和函数调用的时间以及各操作码的执行次数，为 nil 时不记录（默认）
+func (vm *VM) SetProfiler(profiler *profile.Profiler) {
+	vm.profiler = profiler
+}
+
+func (vm *VM) GetCoverage() *cover.Collector {
+	return vm.coverage
+}
+
+// SetCoverage 设置覆盖率收集器，在条件跳转指令处记录 if 和逻辑运算的判定结果。
+// 按调试信息中的源码位置记录，字节码没有调试信息时不记录。为 nil 时不记录（默认）
+func (vm *VM) SetCoverage(coverage *cover.Collector) {
+	vm.coverage = coverage
+}
+
+// recordBranch 记录当前跳转指令处的判定结果
+func (vm *VM) recordBranch(truthy bool) {
+	debug := vm.chunkReader.GetDebugInfo()
+	if debug == nil {
+		return
+	}
+	if entry, ok := debug.Lookup(vm.opPos); ok {
+		vm.coverage.Record(entry.Index, entry.Start, entry.End, truthy)
+	}
+}
+
 func (vm *VM) reset() {
 	vm.stackTop = 0
 	vm.chunkReader = nil
+	vm.slotNames, vm.slots, vm.dirty = nil, nil, nil
+	if vm.stack == nil {
+		vm.stack = make([]values.Value, 0, min(STACK_MAX, vm.limits.GetStackDepth()))
+	}
 }
 
-func (vm *VM) push(value values.Value) {
-	vm.stack[vm.stackTop] = value
+func (vm *VM) push(value values.Value) error {
+	if limit := vm.limits.GetStackDepth(); vm.stackTop >= limit {
+		return &limits.QuotaError{Kind: limits.QuotaStackDepth, Limit: limit}
+	}
+	if vm.stackTop == len(vm.stack) {
+		vm.stack = append(vm.stack, value)
+	} else {
+		vm.stack[vm.stackTop] = value
+	}
 	vm.stackTop++
+	return nil
 }
 
 func (vm *VM) pop() values.Value {
@@ -50,38 +125,97 @@ func (vm *VM) peekAt(distance int) values.Value {
 }
 
 func (vm *VM) Execute(chunk *chk.Chunk, env env.Environment) ([]*ExResult, error) {
-	chunkReader := chk.NewChunkReader(chunk, vm.tracer)
-	return vm.ExecuteWithReader(chunkReader, env)
+	return vm.ExecuteContext(context.Background(), chunk, env)
 }
 
 func (vm *VM) ExecuteWithReader(chunkReader *chk.ChunkReader, env env.Environment) ([]*ExResult, error) {
+	return vm.ExecuteWithReaderContext(context.Background(), chunkReader, env)
+}
+
+// ExecuteContext 执行字节码，在表达式之间以及每执行一定数量的指令后检查 ctx 是否取消。
+// 取消时返回已完成表达式的结果和包装了 ErrCanceled 的错误
+func (vm *VM) ExecuteContext(ctx context.Context, chunk *chk.Chunk, env env.Environment) ([]*ExResult, error) {
+	chunkReader := chk.NewChunkReader(chunk, vm.tracer)
+	return vm.ExecuteWithReaderContext(ctx, chunkReader, env)
+}
+
+func (vm *VM) ExecuteWithReaderContext(ctx context.Context, chunkReader *chk.ChunkReader, env env.Environment) ([]*ExResult, error) {
 	vm.reset()
 	vm.chunkReader = chunkReader
-	return vm.run(env)
+	results, err := vm.run(ctx, env)
+	if err != nil {
+		err = locate(err, chunkReader.GetDebugInfo(), vm.opPos)
+	}
+	return results, err
 }
 
-// run 虚拟机主循环
-func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
+// run 虚拟机主循环，执行中的 panic 转换为错误返回
+func (vm *VM) run(ctx context.Context, env env.Environment) (results []*ExResult, err error) {
 	if vm.tracer != nil {
 		vm.tracer.StartTimerWithMsg("运行虚拟机")
 		defer vm.tracer.EndTimer("虚拟机运行结束")
 	}
 
-	results := []*ExResult{}
+	defer vm.storeSlots(env)
+
+	results = []*ExResult{}
 	expOrder := 0
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(r, expOrder, vm.opPos)
+		}
+	}()
+	cancelable := ctx.Done() != nil
+	steps := 0
+	exprSteps := 0 // 当前表达式已执行的指令数
+	var opCounts *[256]int64
+	var exprStart time.Time
+	if vm.profiler != nil {
+		opCounts = new([256]int64)
+		defer vm.profiler.RecordOps(opCounts)
+	}
 
 	for {
+		steps++
+		if cancelable && steps&cancelCheckMask == 0 {
+			if err := limits.CheckCanceled(ctx); err != nil {
+				return results, err
+			}
+		}
+		exprSteps++
+		if vm.limits.MaxInstructions > 0 && exprSteps > vm.limits.MaxInstructions {
+			return results, &limits.QuotaError{Kind: limits.QuotaInstructions, Limit: vm.limits.MaxInstructions}
+		}
+
+		vm.opPos = vm.chunkReader.Position()
 		op, err := vm.readCode()
 		if err != nil {
 			return results, errors.New("读取操作码失败: " + err.Error())
 		}
+		if vm.hook != nil {
+			if err := vm.callHook(op, expOrder, env); err != nil {
+				return results, err
+			}
+		}
+		if opCounts != nil {
+			opCounts[op]++
+		}
 
 		switch op {
 		case chk.OP_BEGIN:
+			if cancelable {
+				if err := limits.CheckCanceled(ctx); err != nil {
+					return results, err
+				}
+			}
+			exprSteps = 0
 			expOrder, err = vm.readInt()
 			if err != nil {
 				return results, errors.New("读取表达式顺序失败: " + err.Error())
 			}
+			if vm.profiler != nil {
+				exprStart = time.Now()
+			}
 
 		case chk.OP_END:
 			val := vm.pop()
@@ -91,20 +225,36 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 				Index: expOrder,
 			}
 			results = append(results, result)
+			if vm.profiler != nil {
+				vm.profiler.RecordExpr(expOrder, time.Since(exprStart))
+			}
 
 		case chk.OP_CONSTANT:
 			value, err := vm.readConstant()
 			if err != nil {
 				return results, err
 			}
-			vm.push(value)
+			if err := vm.push(value); err != nil {
+				return results, err
+			}
 
 		case chk.OP_POP:
 			vm.pop()
 
 		case chk.OP_NULL:
-			val := values.NewNullValue()
-			vm.push(val)
+			if err := vm.push(values.NewNullValue()); err != nil {
+				return results, err
+			}
+
+		case chk.OP_TRUE:
+			if err := vm.push(values.NewBooleanValue(true)); err != nil {
+				return results, err
+			}
+
+		case chk.OP_FALSE:
+			if err := vm.push(values.NewBooleanValue(false)); err != nil {
+				return results, err
+			}
 
 		case chk.OP_GET_GLOBAL:
 			name, err := vm.readString()
@@ -112,7 +262,9 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 				return results, err
 			}
 			val := env.GetOrDefault(name, values.NewNullValue())
-			vm.push(val)
+			if err := vm.push(val); err != nil {
+				return results, err
+			}
 
 		case chk.OP_SET_GLOBAL:
 			name, err := vm.readString()
@@ -121,6 +273,23 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 			}
 			env.Put(name, vm.peek())
 
+		case chk.OP_GET_LOCAL:
+			slot, err := vm.readSlot(env)
+			if err != nil {
+				return results, err
+			}
+			if err := vm.push(vm.slots[slot]); err != nil {
+				return results, err
+			}
+
+		case chk.OP_SET_LOCAL:
+			slot, err := vm.readSlot(env)
+			if err != nil {
+				return results, err
+			}
+			vm.slots[slot] = vm.peek()
+			vm.dirty[slot] = true
+
 		case chk.OP_GET_PROPERTY:
 			name, err := vm.readString()
 			if err != nil {
@@ -132,7 +301,9 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 			}
 			instance := obj.AsInstance()
 			prop, _ := instance.Get(name)
-			vm.push(prop)
+			if err := vm.push(prop); err != nil {
+				return results, err
+			}
 
 		case chk.OP_SET_PROPERTY:
 			name, err := vm.readString()
@@ -146,6 +317,9 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 			value := vm.peek()
 			instance := obj.AsInstance()
 			instance.Set(name, value)
+			if err := vm.limits.CheckValue(obj); err != nil {
+				return results, err
+			}
 
 		case chk.OP_ADD:
 			if err := vm.binaryOp(values.PLUS); err != nil {
@@ -167,6 +341,41 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 				return results, err
 			}
 
+		case chk.OP_ADD_INT:
+			if err := vm.intOp(values.PLUS); err != nil {
+				return results, err
+			}
+
+		case chk.OP_SUBTRACT_INT:
+			if err := vm.intOp(values.MINUS); err != nil {
+				return results, err
+			}
+
+		case chk.OP_MULTIPLY_INT:
+			if err := vm.intOp(values.STAR); err != nil {
+				return results, err
+			}
+
+		case chk.OP_ADD_DOUBLE:
+			if err := vm.doubleOp(values.PLUS); err != nil {
+				return results, err
+			}
+
+		case chk.OP_SUBTRACT_DOUBLE:
+			if err := vm.doubleOp(values.MINUS); err != nil {
+				return results, err
+			}
+
+		case chk.OP_MULTIPLY_DOUBLE:
+			if err := vm.doubleOp(values.STAR); err != nil {
+				return results, err
+			}
+
+		case chk.OP_DIVIDE_DOUBLE:
+			if err := vm.doubleOp(values.SLASH); err != nil {
+				return results, err
+			}
+
 		case chk.OP_MODE:
 			if err := vm.binaryOp(values.PERCENT); err != nil {
 				return results, err
@@ -222,7 +431,16 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 			if err != nil {
 				return results, err
 			}
-			if err := vm.callFunction(name); err != nil {
+			if vm.profiler == nil {
+				if err := vm.callFunction(name); err != nil {
+					return results, err
+				}
+				break
+			}
+			start := time.Now()
+			err = vm.callFunction(name)
+			vm.profiler.RecordCall(expOrder, name, time.Since(start))
+			if err != nil {
 				return results, err
 			}
 
@@ -231,6 +449,9 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 			if err != nil {
 				return results, err
 			}
+			if vm.coverage != nil {
+				vm.recordBranch(vm.peek().IsTruthy())
+			}
 			if !vm.peek().IsTruthy() {
 				if err := vm.gotoOffset(offset); err != nil {
 					return results, err
@@ -246,6 +467,53 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 				return results, err
 			}
 
+		case chk.OP_GET_GLOBAL_ADD_CONST:
+			name, err := vm.readString()
+			if err != nil {
+				return results, err
+			}
+			value, err := vm.readConstant()
+			if err != nil {
+				return results, err
+			}
+			if err := vm.push(env.GetOrDefault(name, values.NewNullValue())); err != nil {
+				return results, err
+			}
+			if err := vm.push(value); err != nil {
+				return results, err
+			}
+			if err := vm.binaryOp(values.PLUS); err != nil {
+				return results, err
+			}
+
+		case chk.OP_CONST_COMPARE_JUMP:
+			cmp, err := vm.readByte()
+			if err != nil {
+				return results, err
+			}
+			value, err := vm.readConstant()
+			if err != nil {
+				return results, err
+			}
+			offset, err := vm.readInt()
+			if err != nil {
+				return results, err
+			}
+			if err := vm.push(value); err != nil {
+				return results, err
+			}
+			if err := vm.binaryOp(compareTokens[chk.OpCode(cmp)]); err != nil {
+				return results, err
+			}
+			if vm.coverage != nil {
+				vm.recordBranch(vm.peek().IsTruthy())
+			}
+			if !vm.peek().IsTruthy() {
+				if err := vm.gotoOffset(offset); err != nil {
+					return results, err
+				}
+			}
+
 		case chk.OP_RETURN:
 			// 暂时不处理
 
@@ -261,6 +529,50 @@ func (vm *VM) run(env env.Environment) ([]*ExResult, error) {
 	}
 }
 
+// readSlot 读取槽位操作数，首次访问时通过批量接口读入全部变量
+func (vm *VM) readSlot(ev env.Environment) (int, error) {
+	slot, err := vm.readInt()
+	if err != nil {
+		return 0, err
+	}
+	if vm.slots == nil {
+		vm.slotNames = vm.chunkReader.GetVariables()
+		vm.slots = make([]values.Value, len(vm.slotNames))
+		vm.dirty = make([]bool, len(vm.slotNames))
+		env.GetValues(ev, vm.slotNames, vm.slots)
+	}
+	if slot < 0 || slot >= len(vm.slots) {
+		return 0, fmt.Errorf("变量槽位越界：%d", slot)
+	}
+	return slot, nil
+}
+
+// storeSlots 将修改过的变量写回执行环境
+func (vm *VM) storeSlots(ev env.Environment) {
+	names := make([]string, 0)
+	vals := make([]values.Value, 0)
+	for i, dirty := range vm.dirty {
+		if dirty {
+			names = append(names, vm.slotNames[i])
+			vals = append(vals, vm.slots[i])
+			vm.dirty[i] = false
+		}
+	}
+	if len(names) > 0 {
+		env.PutValues(ev, names, vals)
+	}
+}
+
+// compareTokens OP_CONST_COMPARE_JUMP 中比较操作码对应的运算符
+var compareTokens = map[chk.OpCode]values.TokenType{
+	chk.OP_GREATER:       values.GREATER,
+	chk.OP_GREATER_EQUAL: values.GREATER_EQUAL,
+	chk.OP_LESS:          values.LESS,
+	chk.OP_LESS_EQUAL:    values.LESS_EQUAL,
+	chk.OP_EQUAL_EQUAL:   values.EQUAL_EQUAL,
+	chk.OP_BANG_EQUAL:    values.BANG_EQUAL,
+}
+
 func (vm *VM) callFunction(name string) error {
 	funcObj := funmgr.GetFunctionManager().GetFunction(name)
 	cnt := funcObj.Arity()
@@ -272,8 +584,10 @@ func (vm *VM) callFunction(name string) error {
 	if err != nil {
 		return err
 	}
-	vm.push(result)
-	return nil
+	if err := vm.limits.CheckValue(result); err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) binaryOp(tokenType values.TokenType) error {
@@ -283,8 +597,50 @@ func (vm *VM) binaryOp(tokenType values.TokenType) error {
 	if err != nil {
 		return err
 	}
-	vm.push(result)
-	return nil
+	if err := vm.limits.CheckValue(result); err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+// intOp 整数特化指令：两个操作数都是整数时直接计算，否则按通用运算处理
+func (vm *VM) intOp(tokenType values.TokenType) error {
+	a, b := vm.peekAt(1), vm.peekAt(0)
+	if !a.IsInteger() || !b.IsInteger() {
+		return vm.binaryOp(tokenType)
+	}
+	vm.stackTop -= 2
+	x, y := a.AsInteger(), b.AsInteger()
+	switch tokenType {
+	case values.PLUS:
+		return vm.push(values.NewIntValue(x + y))
+	case values.MINUS:
+		return vm.push(values.NewIntValue(x - y))
+	default:
+		return vm.push(values.NewIntValue(x * y))
+	}
+}
+
+// doubleOp 浮点数特化指令：两个操作数都是数值且至少一个是浮点数时直接计算，否则按通用运算处理。
+// 除数为整数 0 时由通用运算报错
+func (vm *VM) doubleOp(tokenType values.TokenType) error {
+	a, b := vm.peekAt(1), vm.peekAt(0)
+	if !a.IsNumber() || !b.IsNumber() || (!a.IsDouble() && !b.IsDouble()) ||
+		(tokenType == values.SLASH && b.IsInteger() && b.AsInteger() == 0) {
+		return vm.binaryOp(tokenType)
+	}
+	vm.stackTop -= 2
+	x, y := a.AsDouble(), b.AsDouble()
+	switch tokenType {
+	case values.PLUS:
+		return vm.push(values.NewDoubleValue(x + y))
+	case values.MINUS:
+		return vm.push(values.NewDoubleValue(x - y))
+	case values.STAR:
+		return vm.push(values.NewDoubleValue(x * y))
+	default:
+		return vm.push(values.NewDoubleValue(x / y))
+	}
 }
 
 func (vm *VM) preUnaryOp(tokenType values.TokenType) error {
@@ -293,8 +649,7 @@ func (vm *VM) preUnaryOp(tokenType values.TokenType) error {
 	if err != nil {
 		return err
 	}
-	vm.push(result)
-	return nil
+	return vm.push(result)
 }
 
 func (vm *VM) readString() (string, error) {
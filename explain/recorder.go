@@ -0,0 +1,64 @@
+package explain
+
+import (
+	"sync"
+
+	"github.com/simonwater/gopression/values"
+)
+
+type spanKey struct {
+	start int
+	end   int
+}
+
+// Recorder 记录表达式执行时各子表达式的值，按源码位置区分子表达式。并发安全。
+// 语法树方式由 visitors.Evaluator 记录（见 SetExplain），字节码方式由 gop 为虚拟机设置的调试钩子按调试信息记录。
+// 每个表达式只保留最近一次执行的值，结果见 Report
+type Recorder struct {
+	mu      sync.Mutex
+	values  map[int]map[spanKey]values.Value
+	sources map[int]string
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		values:  make(map[int]map[spanKey]values.Value),
+		sources: make(map[int]string),
+	}
+}
+
+// Reset 清空已记录的值和源码
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = make(map[int]map[spanKey]values.Value)
+	r.sources = make(map[int]string)
+}
+
+// SetSources 记录各表达式的源码，生成报告时据此还原表达式的结构，expressions 按表达式序号排列
+func (r *Recorder) SetSources(expressions []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, src := range expressions {
+		r.sources[i] = src
+	}
+}
+
+// Begin 开始执行序号为 index 的表达式，清空其上一次执行记录的值
+func (r *Recorder) Begin(index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[index] = make(map[spanKey]values.Value)
+}
+
+// Record 记录序号为 index 的表达式中源码范围为 [start, end) 的子表达式的值
+func (r *Recorder) Record(index, start, end int, v values.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.values[index]
+	if !ok {
+		m = make(map[spanKey]values.Value)
+		r.values[index] = m
+	}
+	m[spanKey{start: start, end: end}] = v
+}
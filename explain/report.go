@@ -0,0 +1,270 @@
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/values"
+)
+
+// Node 子表达式及其值，子节点按求值顺序排列
+type Node struct {
+	Kind      string // binary、logic、unary、literal、variable、assign、call、if、get、set
+	Text      string // 源码
+	Line      int
+	Column    int
+	Operator  string // 运算符，只有运算表达式有
+	Name      string // 变量、函数或属性名
+	Value     values.Value
+	Evaluated bool // 是否执行过，未执行的分支为 false
+	Children  []*Node
+}
+
+// Access 一次变量读写
+type Access struct {
+	Name  string
+	Value values.Value
+	Write bool
+}
+
+// Explanation 一个表达式最近一次执行的解释
+type Explanation struct {
+	Index    int      `json:"index"`
+	Source   string   `json:"source"`
+	Root     *Node    `json:"root,omitempty"`
+	Accesses []Access `json:"accesses,omitempty"` // 按求值顺序排列的变量读写
+	Error    string   `json:"error,omitempty"`    // 没有源码或源码解析出错时的说明
+}
+
+// Report 解释报告，表达式按序号排列
+type Report struct {
+	Exprs []*Explanation
+}
+
+// Report 生成报告。解析各表达式的源码还原其结构，为每个子表达式填上记录的值。
+// 虚拟机不单独记录 if 和逻辑运算的值，取执行过的分支的值
+func (r *Recorder) Report() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	indexes := make([]int, 0, len(r.sources))
+	for index := range r.sources {
+		indexes = append(indexes, index)
+	}
+	for index := range r.values {
+		if _, ok := r.sources[index]; !ok {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+
+	report := &Report{}
+	for _, index := range indexes {
+		e := &Explanation{Index: index, Source: r.sources[index]}
+		report.Exprs = append(report.Exprs, e)
+		if e.Source == "" {
+			e.Error = "没有源码"
+			continue
+		}
+		expr, err := parser.NewParser(e.Source).Parse()
+		if err != nil {
+			e.Error = "源码解析出错：" + err.Error()
+			continue
+		}
+		e.Root = buildNode(expr, e.Source, r.values[index])
+		e.Accesses = collectAccesses(e.Root, nil)
+	}
+	return report
+}
+
+func buildNode(expr exprs.Expr, source string, recorded map[spanKey]values.Value) *Node {
+	span := exprs.SpanOf(expr)
+	n := &Node{Text: span.Text(source), Line: span.Line, Column: span.Column}
+	build := func(children ...exprs.Expr) {
+		for _, child := range children {
+			if child != nil {
+				n.Children = append(n.Children, buildNode(child, source, recorded))
+			}
+		}
+	}
+	switch e := expr.(type) {
+	case *exprs.LiteralExpr:
+		n.Kind = "literal"
+		n.Value = *e.Value
+	case *exprs.IdExpr:
+		n.Kind = "variable"
+		n.Name = e.Id
+	case *exprs.BinaryExpr:
+		n.Kind, n.Operator = "binary", e.Operator.Lexeme
+		build(e.Left, e.Right)
+	case *exprs.LogicExpr:
+		n.Kind, n.Operator = "logic", e.Operator.Lexeme
+		build(e.Left, e.Right)
+	case *exprs.UnaryExpr:
+		n.Kind, n.Operator = "unary", e.Operator.Lexeme
+		build(e.Right)
+	case *exprs.AssignExpr:
+		n.Kind = "assign"
+		if id, ok := e.Left.(*exprs.IdExpr); ok {
+			n.Name = id.Id
+			build(e.Right)
+		} else {
+			build(e.Left, e.Right)
+		}
+	case *exprs.CallExpr:
+		n.Kind = "call"
+		if id, ok := e.Callee.(*exprs.IdExpr); ok {
+			n.Name = id.Id
+		}
+		build(e.Args...)
+	case *exprs.IfExpr:
+		n.Kind = "if"
+		build(e.Condition, e.ThenBranch, e.ElseBranch)
+	case *exprs.GetExpr:
+		n.Kind, n.Name = "get", e.Name.Lexeme
+		build(e.Object)
+	case *exprs.SetExpr:
+		n.Kind, n.Name = "set", e.Name.Lexeme
+		build(e.Object, e.Value)
+	}
+
+	if v, ok := recorded[spanKey{start: span.Start, end: span.End}]; ok && span.IsValid() {
+		n.Value, n.Evaluated = v, true
+		return n
+	}
+	switch n.Kind {
+	case "if":
+		for _, branch := range n.Children[1:] {
+			if branch.Evaluated {
+				n.Value, n.Evaluated = branch.Value, true
+			}
+		}
+	case "logic":
+		// 与虚拟机一致：短路时为左侧的值，否则为右侧的值
+		for _, side := range n.Children {
+			if side.Evaluated {
+				n.Value, n.Evaluated = side.Value, true
+			}
+		}
+	}
+	return n
+}
+
+// collectAccesses 按求值顺序收集执行过的变量读写
+func collectAccesses(n *Node, accesses []Access) []Access {
+	if !n.Evaluated {
+		return accesses
+	}
+	for _, child := range n.Children {
+		accesses = collectAccesses(child, accesses)
+	}
+	switch n.Kind {
+	case "variable":
+		accesses = append(accesses, Access{Name: n.Name, Value: n.Value})
+	case "assign":
+		if n.Name != "" {
+			accesses = append(accesses, Access{Name: n.Name, Value: n.Value, Write: true})
+		}
+	}
+	return accesses
+}
+
+// WriteTree 以缩进的树形输出：每个表达式先输出源码，再逐层输出子表达式及其值，最后列出变量读写
+func (r *Report) WriteTree(w io.Writer) error {
+	var sb strings.Builder
+	for _, e := range r.Exprs {
+		fmt.Fprintf(&sb, "表达式 %d：%s\n", e.Index, e.Source)
+		if e.Error != "" {
+			fmt.Fprintf(&sb, "  %s\n", e.Error)
+			continue
+		}
+		writeNode(&sb, e.Root, 1)
+		if len(e.Accesses) > 0 {
+			sb.WriteString("  变量：\n")
+			for _, a := range e.Accesses {
+				action := "读"
+				if a.Write {
+					action = "写"
+				}
+				fmt.Fprintf(&sb, "    %s %s = %s\n", action, a.Name, formatValue(a.Value))
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeNode(sb *strings.Builder, n *Node, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Text)
+	if n.Evaluated {
+		sb.WriteString(" → " + formatValue(n.Value))
+	} else {
+		sb.WriteString("（未执行）")
+	}
+	sb.WriteByte('\n')
+	for _, child := range n.Children {
+		writeNode(sb, child, depth+1)
+	}
+}
+
+func formatValue(v values.Value) string {
+	if v.IsString() {
+		return strconv.Quote(v.AsString())
+	}
+	return v.String()
+}
+
+// WriteJSON 以 JSON 数组输出，每个元素为一个表达式的解释
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	exprs := r.Exprs
+	if exprs == nil {
+		exprs = []*Explanation{}
+	}
+	return enc.Encode(exprs)
+}
+
+// MarshalJSON 值输出为对应的 JSON 类型，未执行的子表达式不输出值
+func (n *Node) MarshalJSON() ([]byte, error) {
+	type jsonNode struct {
+		Kind      string  `json:"kind"`
+		Text      string  `json:"text"`
+		Line      int     `json:"line"`
+		Column    int     `json:"column"`
+		Operator  string  `json:"operator,omitempty"`
+		Name      string  `json:"name,omitempty"`
+		Value     any     `json:"value,omitempty"`
+		Evaluated bool    `json:"evaluated"`
+		Children  []*Node `json:"children,omitempty"`
+	}
+	jn := jsonNode{
+		Kind:      n.Kind,
+		Text:      n.Text,
+		Line:      n.Line,
+		Column:    n.Column,
+		Operator:  n.Operator,
+		Name:      n.Name,
+		Evaluated: n.Evaluated,
+		Children:  n.Children,
+	}
+	if n.Evaluated {
+		jn.Value = n.Value.GetValue()
+	}
+	return json.Marshal(jn)
+}
+
+func (a Access) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name  string `json:"name"`
+		Value any    `json:"value"`
+		Write bool   `json:"write"`
+	}{a.Name, a.Value.GetValue(), a.Write})
+}
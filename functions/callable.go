@@ -14,3 +14,14 @@ type CallableFunction interface {
 	GetTitle() string
 	GetGroup() string
 }
+
+// PureFunction 可选接口。纯函数的结果只由参数决定且没有副作用，参数均为常量时可在编译期求值
+type PureFunction interface {
+	IsPure() bool
+}
+
+// TypedFunction 可选接口，提供函数的类型签名，用于静态类型检查（见 ir.TypeChecker）
+type TypedFunction interface {
+	// ResultType 根据参数类型推断结果类型。参数类型为 0 表示未知；参数类型确定不合法时返回错误，结果类型无法确定时返回 0
+	ResultType(args []values.ValueType) (values.ValueType, error)
+}
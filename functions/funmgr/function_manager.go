@@ -1,7 +1,11 @@
 package funmgr
 
 import (
+	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/simonwater/gopression/functions"
 	"github.com/simonwater/gopression/functions/impl/numfunc"
@@ -11,6 +15,7 @@ import (
 type FunctionManager struct {
 	functions map[string]functions.CallableFunction
 	mu        sync.RWMutex
+	version   atomic.Uint64
 }
 
 var (
@@ -40,10 +45,36 @@ func (fm *FunctionManager) RegistFunction(fn functions.CallableFunction) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 	fm.functions[fn.GetName()] = fn
+	fm.version.Add(1)
 }
 
 func (fm *FunctionManager) RemoveFunction(name string) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 	delete(fm.functions, name)
+	fm.version.Add(1)
+}
+
+// Version 注册表版本，每次注册或移除函数时加一。
+// 编译时绑定了函数对象的结果（如寄存器程序和闭包）在版本变化后可能不再适用
+func (fm *FunctionManager) Version() uint64 {
+	return fm.version.Load()
+}
+
+// Fingerprint 已注册函数的指纹，由函数名和参数个数计算。
+// 字节码按函数名调用并依赖参数个数，指纹不同时缓存的字节码可能不再适用
+func (fm *FunctionManager) Fingerprint() uint32 {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	names := make([]string, 0, len(fm.functions))
+	for name := range fm.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New32a()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s/%d;", name, fm.functions[name].Arity())
+	}
+	return h.Sum32()
 }
@@ -2,6 +2,7 @@ package numfunc
 
 import (
 	"errors"
+	"fmt"
 	"math"
 
 	"github.com/simonwater/gopression/functions"
@@ -22,6 +23,22 @@ func (a *Abs) Arity() int {
 	return 1
 }
 
+func (a *Abs) IsPure() bool {
+	return true
+}
+
+// ResultType 结果与参数的类型相同
+func (a *Abs) ResultType(args []values.ValueType) (values.ValueType, error) {
+	if len(args) != 1 {
+		return 0, nil
+	}
+	switch args[0] {
+	case 0, values.Vt_Integer, values.Vt_Double:
+		return args[0], nil
+	}
+	return 0, fmt.Errorf("参数必须是数值，实际为 %s", args[0])
+}
+
 func (a *Abs) Call(arguments []values.Value) (values.Value, error) {
 	if len(arguments) != 1 || !arguments[0].IsNumber() {
 		panic(errors.New("参数不合法！"))
@@ -22,6 +22,11 @@ func (c *Clock) Arity() int {
 	return 0
 }
 
+// ResultType 结果为毫秒数的字符串形式
+func (c *Clock) ResultType(args []values.ValueType) (values.ValueType, error) {
+	return values.Vt_String, nil
+}
+
 func (c *Clock) Call(arguments []values.Value) (values.Value, error) {
 	t := time.Now().UnixNano() / int64(time.Millisecond)
 	return values.NewStringValue(strconv.FormatInt(t, 10)), nil
@@ -0,0 +1,97 @@
+package gop_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+const bench_formulaBatches = 1000
+
+// BenchmarkExecuteModes 比较各执行模式执行已编译好的表达式，不含解析、分析和编译。
+// 虚拟机模式同时报告每次执行的指令数
+func BenchmarkExecuteModes(b *testing.B) {
+	runner := gop.NewGopRunner()
+	exprs, err := runner.Parse(testdata.GetExpressions(bench_formulaBatches))
+	require.NoError(b, err)
+	exprInfos, err := runner.Analyze(exprs)
+	require.NoError(b, err)
+
+	b.Run("SyntaxTree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			ev := testdata.GetEnv(bench_formulaBatches)
+			b.StartTimer()
+			runner.RunIR(exprInfos, ev)
+		}
+	})
+
+	b.Run("ChunkVM", func(b *testing.B) {
+		chunk := runner.CompileIR(exprInfos)
+		instructions, err := chk.DecodeInstructions(chunk.Codes)
+		require.NoError(b, err)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			ev := testdata.GetEnv(bench_formulaBatches)
+			b.StartTimer()
+			runner.RunChunk(chunk, ev)
+		}
+		b.ReportMetric(float64(len(instructions)), "instructions/op")
+	})
+
+	b.Run("RegisterVM", func(b *testing.B) {
+		program, err := runner.CompileRegister(exprInfos)
+		require.NoError(b, err)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			ev := testdata.GetEnv(bench_formulaBatches)
+			b.StartTimer()
+			_, err := runner.RunRegisterContext(b.Context(), program, ev)
+			require.NoError(b, err)
+		}
+		b.ReportMetric(float64(len(program.Code)), "instructions/op")
+	})
+
+	b.Run("Closure", func(b *testing.B) {
+		program := runner.CompileClosure(exprInfos)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			ev := testdata.GetEnv(bench_formulaBatches)
+			b.StartTimer()
+			_, err := runner.RunClosureContext(b.Context(), program, ev)
+			require.NoError(b, err)
+		}
+	})
+}
+
+// BenchmarkExecuteBatch 比较各执行模式从源码开始的批量执行
+func BenchmarkExecuteBatch(b *testing.B) {
+	lines := testdata.GetExpressions(bench_formulaBatches)
+	for _, mode := range []struct {
+		name string
+		mode gop.ExecuteMode
+	}{
+		{"SyntaxTree", gop.SyntaxTree},
+		{"ChunkVM", gop.ChunkVM},
+		{"RegisterVM", gop.RegisterVM},
+		{"Closure", gop.Closure},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode.mode)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				ev := testdata.GetEnv(bench_formulaBatches)
+				b.StartTimer()
+				_, err := runner.ExecuteBatch(lines, ev)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package gop_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelEnv 读取 trigger 变量时取消执行
+type cancelEnv struct {
+	*env.DefaultEnvironment
+	trigger string
+	cancel  context.CancelFunc
+}
+
+func newCancelEnv(trigger string, cancel context.CancelFunc) *cancelEnv {
+	ev := &cancelEnv{
+		DefaultEnvironment: env.NewDefaultEnvironment(),
+		trigger:            trigger,
+		cancel:             cancel,
+	}
+	ev.BaseEnvironment = env.NewBaseEnvironment(ev)
+	return ev
+}
+
+func (ce *cancelEnv) GetOrDefault(id string, defValue values.Value) values.Value {
+	if id == ce.trigger {
+		ce.cancel()
+	}
+	return ce.DefaultEnvironment.GetOrDefault(id, defValue)
+}
+
+func (ce *cancelEnv) GetValues(ids []string, dest []values.Value) {
+	for _, id := range ids {
+		if id == ce.trigger {
+			ce.cancel()
+		}
+	}
+	ce.DefaultEnvironment.GetValues(ids, dest)
+}
+
+func TestExecuteContext_CanceledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		_, err := runner.ExecuteContext(ctx, "1 + 2")
+		require.ErrorIs(t, err, limits.ErrCanceled)
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err := gop.NewGopRunner().ExecuteContext(ctx, "1 + 2")
+	require.ErrorIs(t, err, limits.ErrCanceled)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestExecuteContext_ReturnsPartialResults(t *testing.T) {
+	lines := []string{"x = 1", "y = t + 1", "z = y + 1"}
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		for _, parallelism := range []int{1, 4} {
+			ctx, cancel := context.WithCancel(context.Background())
+			ev := newCancelEnv("t", cancel)
+			ev.PutInt("t", 5)
+
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			runner.SetParallelism(parallelism)
+			result, err := runner.ExecuteBatchContext(ctx, lines, ev)
+			require.ErrorIs(t, err, limits.ErrCanceled, "模式 %d 并发 %d", mode, parallelism)
+			require.Len(t, result, 3)
+			assert.Equal(t, 1, result[0])
+			assert.Nil(t, result[2], "取消后的表达式不应执行")
+			assert.True(t, ev.Get("z").IsNull())
+			cancel()
+		}
+	}
+}
+
+func TestExecuteContext_InterruptsLongExpression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ev := newCancelEnv("t", cancel)
+	ev.PutInt("t", 1)
+
+	// 单个表达式的指令数超过虚拟机的检查间隔
+	long := "t" + strings.Repeat(" + t", 2000)
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	result, err := runner.ExecuteBatchContext(ctx, []string{"x = 1", long}, ev)
+	require.ErrorIs(t, err, limits.ErrCanceled)
+	assert.Equal(t, []any{1, nil}, result)
+
+	// 未取消时结果不受影响
+	ev = newCancelEnv("", nil)
+	ev.PutInt("t", 1)
+	result, err = runner.ExecuteBatchContext(context.Background(), []string{long}, ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{2001}, result)
+}
@@ -0,0 +1,120 @@
+package gop_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClosure_ResultsMatchSyntaxTree(t *testing.T) {
+	lines := append(testdata.GetExpressions(20),
+		"1000 + 100.0 * 99 - (600 - 3 * 15) / (((68 - 9) - 3) * 2 - 100) + 10000 % 7 * 71",
+		"-a + -d",
+		"!f && a <= b && a == 3 && !(d != 2.5) && !(a > b) && a >= 3",
+		"s + a + d",
+		"if(a > b, a, b) * if(!f, 2)",
+		"if(f, 1)",
+		"a || b",
+		"f || s",
+		"a && s",
+		"0 && a",
+		"p = q = a * 2 + b",
+		"r = k + (k = 5)",
+		"o.x = a + 1",
+		"o.x * 2",
+		"o.y",
+		"abs(b - a * 2) + abs(-d)",
+	)
+	runner := gop.NewGopRunner()
+	expected, err := runner.ExecuteBatch(lines, newRegisterEnv())
+	require.NoError(t, err)
+
+	for _, optimize := range []bool{false, true} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(gop.Closure)
+		runner.SetOptimize(optimize)
+		ev := newRegisterEnv()
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err)
+		assert.Equal(t, expected, result, "优化 %v", optimize)
+		testdata.CheckValues(t, ev, 20)
+		assert.Equal(t, 10, ev.Get("p").GetValue())
+		assert.Equal(t, 8, ev.Get("r").GetValue())
+	}
+}
+
+func TestClosure_ProgramReusedAcrossEnvironments(t *testing.T) {
+	runner := gop.NewGopRunner()
+	exprs, err := runner.Parse([]string{"y = x * 2 + 1", "z = y > 10"})
+	require.NoError(t, err)
+	exprInfos, err := runner.Analyze(exprs)
+	require.NoError(t, err)
+	program := runner.CompileClosure(exprInfos)
+	assert.Equal(t, 2, program.Len())
+	assert.Equal(t, []string{"x", "y", "z"}, program.GetVariables())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ev := newCountingEnv()
+			ev.PutInt("x", int32(i))
+			result, err := runner.RunClosureContext(context.Background(), program, ev)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []any{i*2 + 1, i*2+1 > 10}, result)
+				assert.Equal(t, i*2+1, ev.Get("y").GetValue())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClosure_ErrorsAndLimits(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.Closure)
+	ev := newRegisterEnv()
+	result, err := runner.ExecuteBatch([]string{"x = 1", "y = b * (s - 1)", "z = 3"}, ev)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "表达式 1 执行出错")
+	assert.Equal(t, []any{1, nil, nil}, result)
+	assert.Equal(t, 1, ev.Get("x").GetValue())
+	assert.True(t, ev.Get("z").IsNull(), "出错后的表达式不应执行")
+
+	_, err = runner.Execute("nofunc(1)")
+	assert.ErrorContains(t, err, "function not found: nofunc")
+
+	// 整数对零取模在执行中 panic，与语法树方式一样转换为带序号的错误
+	ev = newRegisterEnv()
+	result, err = runner.ExecuteBatch([]string{"x = 1", "y = b % 0", "z = 3"}, ev)
+	assert.EqualError(t, err, "表达式 1 执行出错：runtime error: integer divide by zero")
+	assert.Equal(t, []any{1, nil, nil}, result)
+	assert.True(t, ev.Get("z").IsNull(), "出错后的表达式不应执行")
+
+	deep := strings.Repeat("1 + (", 100) + "1" + strings.Repeat(")", 100)
+	runner.SetLimits(limits.Limits{MaxRecursion: 50})
+	_, err = runner.Execute(deep)
+	requireQuota(t, err, limits.QuotaRecursion)
+	runner.SetLimits(limits.Limits{MaxInstructions: 10})
+	_, err = runner.Execute(deep)
+	requireQuota(t, err, limits.QuotaInstructions)
+	runner.SetLimits(limits.Limits{MaxRecursion: 500})
+	result1, err := runner.Execute(deep)
+	require.NoError(t, err)
+	assert.Equal(t, 101, result1)
+
+	runner.SetLimits(limits.Limits{})
+	ev = newRegisterEnv()
+	ev.Put("n", values.NewIntValue(2))
+	result1, err = runner.Execute("n * 3", ev)
+	require.NoError(t, err)
+	assert.Equal(t, 6, result1)
+}
@@ -0,0 +1,276 @@
+package gop
+
+import (
+	"compress/flate"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/schema"
+)
+
+// CompileCache 编译缓存，按表达式列表和影响编译结果的运行器选项的哈希缓存编译好的程序（见 Program），
+// 超出容量时淘汰最久未使用的程序。并发安全，可以在多个运行器之间共享。
+// 函数注册表变化（见 funmgr.FunctionManager.Version）后清空内存中的程序
+type CompileCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // 最近使用的在前
+	version  uint64     // 缓存的程序编译时的函数注册表版本
+	dir      string
+	stats    CacheStats
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits          uint64 // 内存命中次数
+	DiskHits      uint64 // 内存未命中、从磁盘读取的次数
+	Misses        uint64 // 未命中次数
+	Evictions     uint64 // 因超出容量淘汰的程序数
+	Invalidations uint64 // 因函数注册表变化清空缓存的次数
+	DiskErrors    uint64 // 磁盘读写失败的次数，损坏或不兼容的文件会被删除
+	Size          int    // 内存中的程序数
+}
+
+type cacheItem struct {
+	key     string
+	program *Program
+}
+
+// NewCompileCache 创建最多保存 capacity 个程序的缓存，capacity 小于 1 时按 1 处理
+func NewCompileCache(capacity int) *CompileCache {
+	return &CompileCache{
+		capacity: max(capacity, 1),
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		version:  funmgr.GetFunctionManager().Version(),
+	}
+}
+
+func (c *CompileCache) GetCapacity() int {
+	return c.capacity
+}
+
+func (c *CompileCache) GetDir() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dir
+}
+
+// SetDir 设置磁盘存储目录，目录不存在时创建，为空时不使用磁盘。
+// 只有 ChunkVM 模式的程序写入磁盘，每个程序一个文件，以压缩的字节码格式（见 chk.Chunk.WriteCompressed）保存
+func (c *CompileCache) SetDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir = dir
+	return nil
+}
+
+// GetStats 返回当前的统计
+func (c *CompileCache) GetStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// Len 内存中的程序数
+func (c *CompileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Clear 清空内存中的程序，不影响磁盘上的文件和统计
+func (c *CompileCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+func (c *CompileCache) clear() {
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// checkVersion 函数注册表变化时清空内存中的程序，调用方持有锁
+func (c *CompileCache) checkVersion() {
+	version := funmgr.GetFunctionManager().Version()
+	if version == c.version {
+		return
+	}
+	if c.order.Len() > 0 {
+		c.stats.Invalidations++
+		c.clear()
+	}
+	c.version = version
+}
+
+// get 查找程序，内存中没有时从磁盘读取 ChunkVM 程序，读取的程序使用 limits，执行前按 s 校验输入
+func (c *CompileCache) get(key string, mode ExecuteMode, limits limits.Limits, s *schema.Schema) (*Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkVersion()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		c.stats.Hits++
+		return elem.Value.(*cacheItem).program, true
+	}
+
+	if c.dir != "" && mode == ChunkVM {
+		program, err := c.load(key, limits, s)
+		if err == nil {
+			c.stats.DiskHits++
+			c.add(key, program)
+			return program, true
+		}
+		if !os.IsNotExist(err) {
+			c.stats.DiskErrors++
+			os.Remove(c.path(key))
+		}
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// put 保存程序，设置了磁盘目录时同时写入 ChunkVM 程序
+func (c *CompileCache) put(key string, program *Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkVersion()
+	c.add(key, program)
+	if c.dir != "" && program.chunk != nil {
+		if err := c.store(key, program.chunk); err != nil {
+			c.stats.DiskErrors++
+		}
+	}
+}
+
+func (c *CompileCache) add(key string, program *Program) {
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheItem).program = program
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, program: program})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *CompileCache) path(key string) string {
+	return filepath.Join(c.dir, key+".chk")
+}
+
+// store 先写入临时文件再改名，避免其他进程读到不完整的文件
+func (c *CompileCache) store(key string, chunk *chk.Chunk) error {
+	file, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := chunk.WriteCompressed(file, flate.BestSpeed); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), c.path(key))
+}
+
+func (c *CompileCache) load(key string, limits limits.Limits, s *schema.Schema) (*Program, error) {
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	chunk, err := chk.ReadChunk(file)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkProgram(chunk, limits, s)
+}
+
+// newChunkProgram 由字节码创建 ChunkVM 程序，从字节码中还原变量、函数和表达式个数。
+// 字节码中不保存变量声明，由调用方传入编译时的 s
+func newChunkProgram(chunk *chk.Chunk, limits limits.Limits, s *schema.Schema) (*Program, error) {
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	if err != nil {
+		return nil, err
+	}
+	program := &Program{mode: ChunkVM, limits: limits, schema: s}
+	program.setChunk(chunk)
+	reader := program.reader
+	program.variables = slices.Clone(reader.GetVariables())
+	sort.Strings(program.variables)
+	regions := 0
+	for _, ins := range instructions {
+		if ins.Op == chk.OP_BEGIN {
+			regions++
+		}
+	}
+	called := make(map[string]bool)
+	for _, ins := range instructions {
+		switch ins.Op {
+		case chk.OP_BEGIN:
+			order := int(ins.Operands[0])
+			if order < 0 || order >= regions {
+				return nil, fmt.Errorf("表达式序号 %d 越界，共 %d 个表达式", order, regions)
+			}
+			program.size = max(program.size, order+1)
+		case chk.OP_CALL:
+			name, err := reader.ReadConst(int(ins.Operands[0]))
+			if err != nil {
+				return nil, err
+			}
+			if funmgr.GetFunctionManager().GetFunction(name.AsString()) == nil {
+				return nil, fmt.Errorf("未定义的函数: %s", name.AsString())
+			}
+			called[name.AsString()] = true
+		}
+	}
+	for name := range called {
+		program.functions = append(program.functions, name)
+	}
+	sort.Strings(program.functions)
+	return program, nil
+}
+
+// cacheKey 由表达式列表和影响编译结果的选项计算缓存的键。并发数不影响编译结果，不计入
+func (r *GopRunner) cacheKey(expressions []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "mode=%d;sort=%v;optimize=%v;slot=%v;debug=%v;assign=%d;limits=%+v;funcs=%08x;opcodes=%d;typecheck=%v;",
+		r.executeMode, r.needSort, r.isOptimizing(), r.slotAccess, r.emitDebugInfo(), r.GetAssignPolicy(), r.limits,
+		funmgr.GetFunctionManager().Fingerprint(), chk.OPCODE_SET_VERSION, r.isTypeChecking())
+	if r.schema != nil {
+		fmt.Fprintf(h, "schema=%016x;", r.schema.Fingerprint())
+	}
+	size := make([]byte, 8)
+	for _, src := range expressions {
+		binary.BigEndian.PutUint64(size, uint64(len(src)))
+		h.Write(size)
+		h.Write([]byte(src))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,173 @@
+package gop_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileCache_HitsAndEviction(t *testing.T) {
+	cache := gop.NewCompileCache(2)
+	lines := testdata.GetExpressions(10)
+	expected, err := gop.NewGopRunner().ExecuteBatch(lines, testdata.GetEnv(10))
+	require.NoError(t, err)
+
+	runner := gop.NewGopRunner()
+	runner.SetCache(cache)
+	for i := 0; i < 3; i++ {
+		ev := testdata.GetEnv(10)
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err)
+		assert.Equal(t, expected, result)
+		testdata.CheckValues(t, ev, 10)
+	}
+	assert.Equal(t, gop.CacheStats{Hits: 2, Misses: 1, Size: 1}, cache.GetStats())
+
+	// 影响编译结果的选项不同时不能共用
+	program1, err := runner.Compile(lines)
+	require.NoError(t, err)
+	runner.SetExecuteMode(gop.ChunkVM)
+	program2, err := runner.Compile(lines)
+	require.NoError(t, err)
+	assert.NotSame(t, program1, program2)
+	assert.Equal(t, gop.ChunkVM, program2.GetExecuteMode())
+
+	// 容量为 2，最久未使用的 SyntaxTree 程序被淘汰
+	runner.SetExecuteMode(gop.Closure)
+	_, err = runner.Compile(lines)
+	require.NoError(t, err)
+	runner.SetExecuteMode(gop.SyntaxTree)
+	program3, err := runner.Compile(lines)
+	require.NoError(t, err)
+	assert.NotSame(t, program1, program3)
+
+	stats := cache.GetStats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(4), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestCompileCache_InvalidatedByFunctionRegistry(t *testing.T) {
+	cache := gop.NewCompileCache(10)
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	runner.SetCache(cache)
+	lines := []string{"y = abs(x) + 1"}
+	_, err := runner.Compile(lines)
+	require.NoError(t, err)
+
+	fm := funmgr.GetFunctionManager()
+	version := fm.Version()
+	fm.RegistFunction(newTestFunc("cache_test_fn"))
+	defer fm.RemoveFunction("cache_test_fn")
+	assert.Greater(t, fm.Version(), version)
+
+	program, err := runner.Compile(append(lines, "z = cache_test_fn()"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abs", "cache_test_fn"}, program.GetFunctions())
+	stats := cache.GetStats()
+	assert.Equal(t, uint64(1), stats.Invalidations)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+
+	// 未注册的函数不会放入缓存
+	_, err = runner.Compile([]string{"nofunc(1)"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestCompileCache_DiskStore(t *testing.T) {
+	dir := t.TempDir()
+	lines := testdata.GetExpressions(10)
+	newRunner := func() (*gop.GopRunner, *gop.CompileCache) {
+		cache := gop.NewCompileCache(10)
+		require.NoError(t, cache.SetDir(dir))
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(gop.ChunkVM)
+		runner.SetCache(cache)
+		return runner, cache
+	}
+
+	runner, cache := newRunner()
+	expected, err := runner.Compile(lines)
+	require.NoError(t, err)
+	files, err := filepath.Glob(filepath.Join(dir, "*.chk"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, dir, cache.GetDir())
+
+	// 新的缓存从磁盘读取
+	runner, cache = newRunner()
+	program, err := runner.Compile(lines)
+	require.NoError(t, err)
+	assert.Equal(t, gop.CacheStats{DiskHits: 1, Size: 1}, cache.GetStats())
+	assert.Equal(t, expected.GetChunk(), program.GetChunk())
+	assert.Equal(t, expected.GetVariables(), program.GetVariables())
+	assert.Equal(t, expected.Len(), program.Len())
+	ev := testdata.GetEnv(10)
+	_, err = program.Run(context.Background(), ev)
+	require.NoError(t, err)
+	testdata.CheckValues(t, ev, 10)
+
+	// 损坏的文件被删除后重新编译
+	require.NoError(t, os.WriteFile(files[0], []byte("broken"), 0o644))
+	runner, cache = newRunner()
+	_, err = runner.Compile(lines)
+	require.NoError(t, err)
+	assert.Equal(t, gop.CacheStats{Misses: 1, DiskErrors: 1, Size: 1}, cache.GetStats())
+	runner, cache = newRunner()
+	_, err = runner.Compile(lines)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), cache.GetStats().DiskHits)
+}
+
+func TestCompileCache_DiskHitValidatesInput(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"total = price * 2"}
+	newRunner := func() (*gop.GopRunner, *gop.CompileCache) {
+		cache := gop.NewCompileCache(10)
+		require.NoError(t, cache.SetDir(dir))
+		s := schema.NewSchema()
+		s.Declare("price", values.Vt_Integer).SetRange(0, 100)
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(gop.ChunkVM)
+		runner.SetSchema(s)
+		runner.SetCache(cache)
+		return runner, cache
+	}
+
+	runner, _ := newRunner()
+	_, err := runner.Compile(lines)
+	require.NoError(t, err)
+
+	// 从磁盘读取的程序同样按变量声明校验输入
+	runner, cache := newRunner()
+	program, err := runner.Compile(lines)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), cache.GetStats().DiskHits)
+	require.NotNil(t, program.GetSchema())
+
+	ev := env.NewDefaultEnvironment()
+	ev.Put("price", values.NewIntValue(500))
+	_, err = program.Run(context.Background(), ev)
+	var errs schema.ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	assert.True(t, ev.Get("total").IsNull(), "校验失败时不执行")
+
+	ev = env.NewDefaultEnvironment()
+	ev.Put("price", values.NewIntValue(50))
+	result, err := program.Run(context.Background(), ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{100}, result)
+}
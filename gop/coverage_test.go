@@ -0,0 +1,94 @@
+package gop_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/cover"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverage_AllModes(t *testing.T) {
+	lines := []string{
+		"x = if(a > 1, a * 2, 0)",
+		"y = a > 0 && b > 0",
+		"z = a > 5 || b > 5",
+	}
+	ifPoint := cover.Point{Index: 0, Start: 4, End: 23}
+	andPoint := cover.Point{Index: 1, Start: 4, End: 18}
+	orPoint := cover.Point{Index: 2, Start: 4, End: 18}
+	inputs := [][2]int32{{2, 1}, {0, 3}, {3, 0}}
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		for _, optimize := range []bool{false, true} {
+			coverage := cover.NewCollector()
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			runner.SetOptimize(optimize)
+			runner.SetCoverage(coverage)
+			for _, in := range inputs {
+				ev := env.NewDefaultEnvironment()
+				ev.Put("a", values.NewIntValue(in[0]))
+				ev.Put("b", values.NewIntValue(in[1]))
+				_, err := runner.ExecuteBatch(lines, ev)
+				require.NoError(t, err)
+			}
+
+			report := coverage.Report()
+			require.Len(t, report.Formulas, 3, "模式 %d 优化 %v", mode, optimize)
+			branches := make(map[cover.Point]cover.Branch)
+			for _, f := range report.Formulas {
+				for _, b := range f.Branches {
+					branches[b.Point] = b
+				}
+			}
+			require.Len(t, branches, 3, "模式 %d 优化 %v", mode, optimize)
+			assert.Equal(t, cover.Counts{True: 2, False: 1}, branches[ifPoint].Counts, "模式 %d 优化 %v", mode, optimize)
+			assert.Equal(t, cover.BranchIf, branches[ifPoint].Kind)
+			assert.Equal(t, cover.Counts{True: 2, False: 1}, branches[andPoint].Counts, "模式 %d 优化 %v", mode, optimize)
+			assert.Equal(t, cover.BranchAnd, branches[andPoint].Kind)
+			assert.Equal(t, cover.Counts{True: 0, False: 3}, branches[orPoint].Counts, "模式 %d 优化 %v", mode, optimize)
+			assert.Equal(t, cover.BranchOr, branches[orPoint].Kind)
+
+			covered, total := report.Coverage()
+			assert.Equal(t, 5, covered)
+			assert.Equal(t, 6, total)
+		}
+	}
+}
+
+func TestCoverage_Report(t *testing.T) {
+	coverage := cover.NewCollector()
+	coverage.SetSources([]string{"if(a, b && c, d)", "e + 1"})
+	coverage.Record(0, 0, 16, true)
+	coverage.Record(0, 0, 16, false)
+	coverage.Record(0, 6, 12, false)
+	coverage.Record(3, 0, 1, true)
+
+	report := coverage.Report()
+	require.Len(t, report.Formulas, 3)
+	f := report.Formulas[0]
+	require.Len(t, f.Branches, 2, "未执行的分支也列出")
+	assert.Equal(t, "if(a, b && c, d)", f.Branches[0].Text)
+	assert.True(t, f.Branches[0].IsCovered())
+	assert.Equal(t, "b && c", f.Branches[1].Text)
+	assert.Equal(t, [2]cover.Arm{{Name: "右侧", Hits: 0}, {Name: "短路", Hits: 1}}, f.Branches[1].Arms())
+	assert.Empty(t, report.Formulas[1].Branches)
+	require.Len(t, report.Formulas[2].Branches, 1, "没有源码的覆盖点")
+	assert.Equal(t, cover.BranchUnknown, report.Formulas[2].Branches[0].Kind)
+
+	var text strings.Builder
+	require.NoError(t, report.WriteText(&text))
+	out := text.String()
+	assert.Contains(t, out, "表达式 0：if(a, b && c, d)")
+	assert.Contains(t, out, "then 1")
+	assert.Contains(t, out, "右侧 0 !")
+	assert.Contains(t, out, "分支覆盖率：4/6（66.7%）")
+
+	coverage.Reset()
+	assert.Empty(t, coverage.Report().Formulas)
+}
@@ -0,0 +1,85 @@
+package gop_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDebugRunner(optimize bool) *gop.GopRunner {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetDebugInfo(true)
+	runner.SetOptimize(optimize)
+	return runner
+}
+
+func TestDebugInfo_RuntimeErrorLocation(t *testing.T) {
+	lines := []string{"y = 2", "z = b * (a - 1)", "w = y +\n  (a * 2)"}
+	for _, optimize := range []bool{false, true} {
+		ev := env.NewDefaultEnvironment()
+		ev.Put("a", values.NewStringValue("s"))
+		ev.Put("b", values.NewIntValue(3))
+
+		_, err := newDebugRunner(optimize).ExecuteBatch(lines, ev)
+		var rerr *exec.RuntimeError
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		assert.Equal(t, 1, rerr.Index)
+		assert.Equal(t, "(a - 1)", rerr.Snippet)
+		assert.Equal(t, 1, rerr.Line)
+		assert.Equal(t, 9, rerr.Column)
+		assert.Equal(t, lines[1], rerr.Source)
+		assert.Contains(t, err.Error(), `表达式 1 第 1 行第 9 列 "(a - 1)"`)
+
+		ev.Put("b", values.NewStringValue("t"))
+		_, err = newDebugRunner(optimize).ExecuteBatch([]string{lines[2], lines[0]}, ev)
+		require.True(t, errors.As(err, &rerr), "%v", err)
+		assert.Equal(t, 0, rerr.Index)
+		assert.Equal(t, "(a * 2)", rerr.Snippet)
+		assert.Equal(t, 2, rerr.Line)
+		assert.Equal(t, 3, rerr.Column)
+	}
+}
+
+func TestDebugInfo_Disabled(t *testing.T) {
+	ev := env.NewDefaultEnvironment()
+	ev.Put("a", values.NewStringValue("s"))
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	_, err := runner.Execute("a - 1", ev)
+	require.Error(t, err)
+	var rerr *exec.RuntimeError
+	assert.False(t, errors.As(err, &rerr))
+
+	chunk, err := runner.CompileSource([]string{"a - 1"})
+	require.NoError(t, err)
+	assert.False(t, chunk.HasDebugInfo())
+}
+
+func TestDebugInfo_SerializeAndDisassemble(t *testing.T) {
+	chunk, err := newDebugRunner(false).CompileSource([]string{"x = a + 1", "if(x > 1, abs(x), 0)"})
+	require.NoError(t, err)
+	require.True(t, chunk.HasDebugInfo())
+
+	restored, err := chk.NewChunkWithBytes(chunk.ToBytes())
+	require.NoError(t, err)
+	assert.Equal(t, chunk.Debug, restored.Debug)
+	require.NoError(t, chk.Verify(restored))
+
+	var output []string
+	require.NoError(t, exec.NewDisassembler(func(msg string) {
+		output = append(output, strings.TrimSpace(msg))
+	}).Execute(restored))
+	assert.Contains(t, output, "; 表达式 0：x = a + 1")
+	assert.Contains(t, output, ";   1:5 a + 1")
+	assert.Contains(t, output, "; 表达式 1：if(x > 1, abs(x), 0)")
+	assert.Contains(t, output, ";   1:11 abs(x)")
+}
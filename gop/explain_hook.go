@@ -0,0 +1,51 @@
+package gop
+
+import (
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/explain"
+)
+
+// explainHook 字节码虚拟机记录解释结果的调试钩子：产生值的指令执行后，下一条指令执行前的栈顶即为该指令所属子表达式的值
+type explainHook struct {
+	recorder *explain.Recorder
+	pending  bool
+	entry    chk.DebugEntry
+}
+
+// newExplainHook 创建记录子表达式值的调试钩子（见 exec.VM.SetDebugHook），每次执行使用新的钩子。
+// 按调试信息中指令的源码位置记录，字节码没有调试信息时不记录。
+// 窥孔优化融合的指令只记录融合后的结果，需要完整记录时关闭优化
+func newExplainHook(recorder *explain.Recorder) exec.DebugHook {
+	return &explainHook{recorder: recorder}
+}
+
+func (h *explainHook) BeforeInstruction(s *exec.DebugState) error {
+	if h.pending && len(s.Stack) > 0 {
+		h.recorder.Record(h.entry.Index, h.entry.Start, h.entry.End, s.Stack[len(s.Stack)-1])
+	}
+	h.pending = false
+	if s.Op == chk.OP_BEGIN {
+		h.recorder.Begin(s.Order)
+		return nil
+	}
+	if !producesValue(s.Op) {
+		return nil
+	}
+	debug := s.GetDebugInfo()
+	if debug == nil {
+		return nil
+	}
+	h.entry, h.pending = debug.Lookup(s.Pos)
+	return nil
+}
+
+// producesValue 指令执行后栈顶是否为所属子表达式的值
+func producesValue(op chk.OpCode) bool {
+	switch op {
+	case chk.OP_POP, chk.OP_JUMP, chk.OP_JUMP_IF_FALSE, chk.OP_BEGIN, chk.OP_END,
+		chk.OP_RETURN, chk.OP_EXIT, chk.OP_DEFINE_GLOBAL:
+		return false
+	}
+	return true
+}
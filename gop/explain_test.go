@@ -0,0 +1,106 @@
+package gop_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/explain"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runExplain(t *testing.T, mode gop.ExecuteMode, lines []string) *explain.Report {
+	recorder := explain.NewRecorder()
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(mode)
+	runner.SetOptimize(true)
+	runner.SetExplain(recorder)
+	ev := env.NewDefaultEnvironment()
+	ev.Put("B0", values.NewIntValue(840))
+	ev.Put("C0", values.NewIntValue(6))
+	_, err := runner.ExecuteBatch(lines, ev)
+	require.NoError(t, err)
+	return recorder.Report()
+}
+
+func TestExplain_Tree(t *testing.T) {
+	lines := []string{
+		"A0 = B0 * 2 + C0",
+		"D0 = if(A0 > 1000, A0 - 1, 0)",
+		"E0 = C0 > 10 && abs(C0) > 1",
+	}
+	expected := `表达式 0：A0 = B0 * 2 + C0
+  A0 = B0 * 2 + C0 → 1686
+    B0 * 2 + C0 → 1686
+      B0 * 2 → 1680
+        B0 → 840
+        2 → 2
+      C0 → 6
+  变量：
+    读 B0 = 840
+    读 C0 = 6
+    写 A0 = 1686
+表达式 1：D0 = if(A0 > 1000, A0 - 1, 0)
+  D0 = if(A0 > 1000, A0 - 1, 0) → 1685
+    if(A0 > 1000, A0 - 1, 0) → 1685
+      A0 > 1000 → true
+        A0 → 1686
+        1000 → 1000
+      A0 - 1 → 1685
+        A0 → 1686
+        1 → 1
+      0（未执行）
+  变量：
+    读 A0 = 1686
+    读 A0 = 1686
+    写 D0 = 1685
+表达式 2：E0 = C0 > 10 && abs(C0) > 1
+  E0 = C0 > 10 && abs(C0) > 1 → false
+    C0 > 10 && abs(C0) > 1 → false
+      C0 > 10 → false
+        C0 → 6
+        10 → 10
+      abs(C0) > 1（未执行）
+        abs(C0)（未执行）
+          C0（未执行）
+        1（未执行）
+  变量：
+    读 C0 = 6
+    写 E0 = false
+`
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM} {
+		report := runExplain(t, mode, lines)
+		var text strings.Builder
+		require.NoError(t, report.WriteTree(&text))
+		assert.Equal(t, expected, text.String(), "模式 %s", mode)
+	}
+}
+
+func TestExplain_JSONAndErrors(t *testing.T) {
+	report := runExplain(t, gop.ChunkVM, []string{"A0 = B0 + 1"})
+	var buf strings.Builder
+	require.NoError(t, report.WriteJSON(&buf))
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &decoded))
+	require.Len(t, decoded, 1)
+	root := decoded[0]["root"].(map[string]any)
+	assert.Equal(t, "assign", root["kind"])
+	assert.Equal(t, "A0", root["name"])
+	assert.Equal(t, float64(841), root["value"])
+	add := root["children"].([]any)[0].(map[string]any)
+	assert.Equal(t, "+", add["operator"])
+	assert.Equal(t, []any{
+		map[string]any{"name": "B0", "value": float64(840), "write": false},
+		map[string]any{"name": "A0", "value": float64(841), "write": true},
+	}, decoded[0]["accesses"])
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	runner.SetExplain(explain.NewRecorder())
+	_, err := runner.ExecuteBatch([]string{"1 + 1"})
+	assert.ErrorContains(t, err, "解释模式只支持")
+}
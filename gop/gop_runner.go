@@ -1,13 +1,24 @@
 package gop
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/cover"
 	"github.com/simonwater/gopression/env"
 	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/explain"
 	"github.com/simonwater/gopression/ir"
 	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/limits"
 	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/profile"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/trace"
 	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
 	"github.com/simonwater/gopression/visitors"
 )
 
@@ -16,11 +27,42 @@ type ExecuteMode int
 const (
 	SyntaxTree ExecuteMode = iota
 	ChunkVM
+	// RegisterVM 编译为三地址指令，由寄存器虚拟机执行（见 exec.RegisterVM）。
+	// 赋值在每批表达式执行结束（包括出错和取消）时才写回执行环境
+	RegisterVM
+	// Closure 编译为 Go 闭包组成的树后执行（见 visitors.ClosureProgram），执行语义与 SyntaxTree 相同
+	Closure
 )
 
+func (m ExecuteMode) String() string {
+	switch m {
+	case SyntaxTree:
+		return "SyntaxTree"
+	case ChunkVM:
+		return "ChunkVM"
+	case RegisterVM:
+		return "RegisterVM"
+	case Closure:
+		return "Closure"
+	}
+	return fmt.Sprintf("ExecuteMode(%d)", int(m))
+}
+
 type GopRunner struct {
 	needSort    bool
+	optimize    bool
+	slotAccess  bool
+	debugInfo   bool
+	typeCheck   bool
 	executeMode ExecuteMode
+	parallelism int
+	limits      limits.Limits
+	cache       *CompileCache
+	profiler    *profile.Profiler
+	coverage    *cover.Collector
+	explain     *explain.Recorder
+	tracer      *trace.Tracer
+	schema      *schema.Schema
 	context     *ir.GopContext
 }
 
@@ -28,6 +70,7 @@ func NewGopRunner() *GopRunner {
 	return &GopRunner{
 		needSort:    true,
 		executeMode: SyntaxTree,
+		parallelism: 1,
 		context:     ir.NewGopContext(),
 	}
 }
@@ -40,6 +83,116 @@ func (r *GopRunner) SetNeedSort(needSort bool) {
 	r.needSort = needSort
 }
 
+func (r *GopRunner) IsOptimize() bool {
+	return r.optimize
+}
+
+// SetOptimize 设置是否优化，默认关闭。开启后分析前对表达式做常量折叠和代数化简（见 visitors.ConstantFolder），
+// 编译后对字节码做窥孔优化（见 chk.Optimize）
+func (r *GopRunner) SetOptimize(optimize bool) {
+	r.optimize = optimize
+}
+
+func (r *GopRunner) IsSlotAccess() bool {
+	return r.slotAccess
+}
+
+// SetSlotAccess 设置字节码是否按槽位访问变量（见 visitors.OpCodeCompiler.SetSlotAccess），默认关闭。
+// 开启后赋值在每批字节码执行结束（包括出错和取消）时才写回执行环境
+func (r *GopRunner) SetSlotAccess(slotAccess bool) {
+	r.slotAccess = slotAccess
+}
+
+func (r *GopRunner) IsDebugInfo() bool {
+	return r.debugInfo
+}
+
+// SetDebugInfo 设置编译时是否生成调试信息，默认关闭。开启后虚拟机执行出错时返回带有表达式序号和源码位置的
+// *exec.RuntimeError，反汇编时穿插显示源码
+func (r *GopRunner) SetDebugInfo(debugInfo bool) {
+	r.debugInfo = debugInfo
+}
+
+func (r *GopRunner) IsTypeCheck() bool {
+	return r.typeCheck
+}
+
+// SetTypeCheck 执行前是否做静态类型检查（见 ir.TypeChecker），默认不检查。设置了变量声明（见 SetSchema）时总是检查。
+// 检查出类型错误时不执行任何表达式，返回 ir.TypeErrors；字节码按推断出的类型生成特化的算术指令
+func (r *GopRunner) SetTypeCheck(typeCheck bool) {
+	r.typeCheck = typeCheck
+}
+
+func (r *GopRunner) GetSchema() *schema.Schema {
+	return r.schema
+}
+
+// SetSchema 设置输入变量的声明，为 nil 时不声明（默认）。设置后执行前检查表达式读取的变量都已声明
+// （见 schema.Schema.CheckVariables），按声明的类型做静态类型检查，并在执行环境初始化（BeforeExecute）后
+// 校验输入（见 schema.Schema.Validate），有错误时不执行任何表达式
+func (r *GopRunner) SetSchema(s *schema.Schema) {
+	r.schema = s
+}
+
+// isTypeChecking 是否做静态类型检查：开启了类型检查或设置了变量声明
+func (r *GopRunner) isTypeChecking() bool {
+	return r.typeCheck || r.schema != nil
+}
+
+// checkExprs 执行前的静态检查：检查函数调用的嵌套层数，设置了变量声明时检查未声明的变量，
+// 再按执行顺序做类型检查，并在节点上记录推断出的类型
+func (r *GopRunner) checkExprs(exprInfos []*ir.ExprInfo) error {
+	if err := checkCallDepth(exprInfos, r.limits); err != nil {
+		return err
+	}
+	var declared ir.VarTypes
+	if r.schema != nil {
+		exprList := make([]exprs.Expr, len(exprInfos))
+		for i, info := range exprInfos {
+			exprList[i] = info.GetExpr()
+		}
+		vs, err := ir.NewVarsQuery().ExecuteAll(exprList)
+		if err != nil {
+			return err
+		}
+		if err := r.schema.CheckVariables(vs); err != nil {
+			return err
+		}
+		declared = r.schema
+	}
+	if !r.isTypeChecking() {
+		return nil
+	}
+	return ir.NewTypeChecker(declared).Check(exprInfos)
+}
+
+// checkCallDepth 按限额检查各表达式中函数调用的嵌套层数，各执行模式都在执行前检查
+func checkCallDepth(exprInfos []*ir.ExprInfo, l limits.Limits) error {
+	if l.MaxCallDepth <= 0 {
+		return nil
+	}
+	query := ir.NewCallDepthQuery()
+	for _, info := range exprInfos {
+		if err := l.CheckCallDepth(query.Execute(info.GetExpr())); err != nil {
+			return fmt.Errorf("表达式 %d 编译出错：%w", info.GetIndex(), err)
+		}
+	}
+	return nil
+}
+
+// beforeExecute 初始化执行环境，设置了变量声明 s 时再校验输入。返回 false 时不执行
+func beforeExecute(ev env.Environment, fields []*util.Field, s *schema.Schema) (bool, error) {
+	if !ev.BeforeExecute(fields) {
+		return false, nil
+	}
+	if s != nil {
+		if err := s.Validate(ev); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 func (r *GopRunner) IsTrace() bool {
 	return r.context.GetTracer().IsEnable()
 }
@@ -48,6 +201,15 @@ func (r *GopRunner) SetTrace(isTrace bool) {
 	r.context.GetTracer().SetEnable(isTrace)
 }
 
+func (r *GopRunner) GetAssignPolicy() ir.AssignPolicy {
+	return r.context.GetAssignPolicy()
+}
+
+// SetAssignPolicy 设置同一变量被多个表达式赋值时的处理策略，默认 ir.AssignError
+func (r *GopRunner) SetAssignPolicy(policy ir.AssignPolicy) {
+	r.context.SetAssignPolicy(policy)
+}
+
 func (r *GopRunner) GetExecuteMode() ExecuteMode {
 	return r.executeMode
 }
@@ -56,93 +218,325 @@ func (r *GopRunner) SetExecuteMode(executeMode ExecuteMode) {
 	r.executeMode = executeMode
 }
 
-func (r *GopRunner) Execute(expression string, ev ...env.Environment) (any, error) {
-	var e env.Environment
-	if len(ev) == 0 || ev[0] == nil {
-		e = env.NewDefaultEnvironment()
-	} else {
-		e = ev[0]
+func (r *GopRunner) GetParallelism() int {
+	return r.parallelism
+}
+
+// SetParallelism 设置并发执行的工作协程数，小于等于 1 时顺序执行。
+// 并发执行时表达式按依赖关系分层（见 ir.Levelize），层内并发、层间顺序执行，结果与顺序执行一致
+func (r *GopRunner) SetParallelism(parallelism int) {
+	r.parallelism = parallelism
+}
+
+func (r *GopRunner) GetLimits() limits.Limits {
+	return r.limits
+}
+
+// SetLimits 设置执行资源限额，各执行模式下超出限额时都返回 *limits.QuotaError
+func (r *GopRunner) SetLimits(limits limits.Limits) {
+	r.limits = limits
+}
+
+func (r *GopRunner) GetCache() *CompileCache {
+	return r.cache
+}
+
+// SetCache 设置编译缓存，为 nil 时不使用缓存（默认）。设置后 Compile 先查找缓存，
+// 顺序执行的 ExecuteBatch 也经由 Compile 编译后执行，表达式调用了未注册的函数时在执行前返回错误
+func (r *GopRunner) SetCache(cache *CompileCache) {
+	r.cache = cache
+}
+
+func (r *GopRunner) GetProfiler() *profile.Profiler {
+	return r.profiler
+}
+
+// SetProfiler 设置剖析器，为 nil 时不剖析（默认）。设置后各执行模式下都记录各表达式的执行时间和函数调用，
+// ChunkVM 模式还记录各操作码的执行次数，结果见 profile.Profiler.Report 和 profile.Profiler.WritePprof
+func (r *GopRunner) SetProfiler(profiler *profile.Profiler) {
+	r.profiler = profiler
+}
+
+func (r *GopRunner) GetCoverage() *cover.Collector {
+	return r.coverage
+}
+
+// SetCoverage 设置覆盖率收集器，为 nil 时不收集（默认）。设置后各执行模式下都记录 if 和逻辑运算的判定结果，
+// 虚拟机模式按调试信息定位，因此编译时总是生成调试信息（见 SetDebugInfo）。结果见 cover.Collector.Report
+func (r *GopRunner) SetCoverage(coverage *cover.Collector) {
+	r.coverage = coverage
+}
+
+func (r *GopRunner) GetTracer() *trace.Tracer {
+	return r.tracer
+}
+
+// SetTracer 设置结构化跟踪器，为 nil 时使用 ctx 中的跟踪器（见 trace.ContextWithTracer），都没有时不跟踪（默认）。
+// 设置后 ExecuteBatchContext 等为解析、分析、编译和执行各阶段创建区间，ctx 中有区间时作为其子区间
+func (r *GopRunner) SetTracer(tracer *trace.Tracer) {
+	r.tracer = tracer
+}
+
+// startSpan 开始一个阶段的跟踪区间，没有跟踪器时返回原 ctx 和 nil 区间
+func (r *GopRunner) startSpan(ctx context.Context, phase string, count int) (context.Context, *trace.Span) {
+	tracer := r.tracer
+	if tracer == nil {
+		tracer = trace.TracerFromContext(ctx)
+	}
+	return tracer.Start(ctx, phase, count)
+}
+
+func (r *GopRunner) GetExplain() *explain.Recorder {
+	return r.explain
+}
+
+// SetExplain 设置解释记录器，为 nil 时不记录（默认）。设置后记录每个表达式中各子表达式的值和变量读写，
+// 结果见 explain.Recorder.Report。只支持 SyntaxTree 和 ChunkVM 模式，其他模式下 ExecuteBatchContext 返回错误。
+// 字节码按调试信息定位，因此编译时总是生成调试信息；为了记录每个子表达式，设置后不做优化（见 SetOptimize）
+func (r *GopRunner) SetExplain(recorder *explain.Recorder) {
+	r.explain = recorder
+}
+
+// instruments 执行时记录剖析、覆盖率和解释结果的工具，都可以为 nil
+type instruments struct {
+	profiler *profile.Profiler
+	coverage *cover.Collector
+	explain  *explain.Recorder
+}
+
+func (r *GopRunner) instruments() instruments {
+	return instruments{profiler: r.profiler, coverage: r.coverage, explain: r.explain}
+}
+
+// setupVM 为字节码虚拟机设置各工具
+func (inst instruments) setupVM(vm *exec.VM) {
+	vm.SetProfiler(inst.profiler)
+	vm.SetCoverage(inst.coverage)
+	if inst.explain != nil {
+		vm.SetDebugHook(newExplainHook(inst.explain))
 	}
-	result, err := r.executeBatch([]string{expression}, e)
-	if err != nil || len(result) == 0 {
+}
+
+// setupRegisterVM 为寄存器虚拟机设置各工具
+func (inst instruments) setupRegisterVM(vm *exec.RegisterVM) {
+	vm.SetProfiler(inst.profiler)
+	vm.SetCoverage(inst.coverage)
+}
+
+// runClosure 执行闭包程序，结果转换为虚拟机的执行结果
+func (inst instruments) runClosure(ctx context.Context, program *visitors.ClosureProgram, ev env.Environment) ([]*exec.ExResult, error) {
+	results, err := program.ExecuteInstrumented(ctx, ev, inst.profiler, inst.coverage)
+	exResults := make([]*exec.ExResult, len(results))
+	for i := range results {
+		exResults[i] = &exec.ExResult{Value: &results[i].Value, State: exec.OK, Index: results[i].Index}
+	}
+	return exResults, err
+}
+
+// emitDebugInfo 编译时是否生成调试信息：开启了调试信息或需要收集覆盖率、记录解释
+func (r *GopRunner) emitDebugInfo() bool {
+	return r.debugInfo || r.coverage != nil || r.explain != nil
+}
+
+// isOptimizing 编译时是否优化：开启了优化且没有记录解释
+func (r *GopRunner) isOptimizing() bool {
+	return r.optimize && r.explain == nil
+}
+
+func (r *GopRunner) Execute(expression string, ev ...env.Environment) (any, error) {
+	return r.ExecuteContext(context.Background(), expression, ev...)
+}
+
+// ExecuteContext 执行单个表达式，ctx 取消或超时时返回包装了 limits.ErrCanceled 的错误
+func (r *GopRunner) ExecuteContext(ctx context.Context, expression string, ev ...env.Environment) (any, error) {
+	result, err := r.ExecuteBatchContext(ctx, []string{expression}, ev...)
+	if len(result) == 0 {
 		return nil, err
 	}
-	return result[0], nil
+	return result[0], err
 }
 
 func (r *GopRunner) ExecuteBatch(expressions []string, ev ...env.Environment) ([]any, error) {
+	return r.ExecuteBatchContext(context.Background(), expressions, ev...)
+}
+
+// ExecuteBatchContext 批量执行表达式。各执行模式下都在表达式之间检查 ctx，虚拟机还会在执行过程中定期检查。
+// 取消或超时时返回包装了 limits.ErrCanceled 的错误，以及已完成表达式的结果（未执行的位置为 nil）
+func (r *GopRunner) ExecuteBatchContext(ctx context.Context, expressions []string, ev ...env.Environment) ([]any, error) {
 	var e env.Environment
 	if len(ev) == 0 || ev[0] == nil {
 		e = env.NewDefaultEnvironment()
 	} else {
 		e = ev[0]
 	}
-	return r.executeBatch(expressions, e)
+	return r.executeBatch(ctx, expressions, e)
 }
 
-func (r *GopRunner) executeBatch(expressions []string, env env.Environment) ([]any, error) {
+func (r *GopRunner) executeBatch(ctx context.Context, expressions []string, env env.Environment) (result []any, err error) {
+	ctx, span := r.startSpan(ctx, trace.PhaseBatch, len(expressions))
+	span.SetAttr("mode", r.executeMode.String())
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	tracer := r.context.GetTracer()
 	tracer.StartTimerWithMsg("开始。公式总数：%d", len(expressions))
+	defer tracer.EndTimer("结束。")
+	if r.profiler != nil {
+		r.profiler.SetSources(expressions)
+	}
+	if r.coverage != nil {
+		r.coverage.SetSources(expressions)
+	}
+	if r.explain != nil {
+		if r.executeMode != SyntaxTree && r.executeMode != ChunkVM {
+			return nil, fmt.Errorf("解释模式只支持 SyntaxTree 和 ChunkVM 执行模式，当前为 %s", r.executeMode)
+		}
+		r.explain.SetSources(expressions)
+	}
 
+	if r.cache != nil && r.parallelism <= 1 {
+		_, compileSpan := r.startSpan(ctx, trace.PhaseCompile, len(expressions))
+		program, err := r.Compile(expressions)
+		compileSpan.SetError(err)
+		compileSpan.End()
+		if err != nil {
+			return nil, err
+		}
+		if err := limits.CheckCanceled(ctx); err != nil {
+			return nil, err
+		}
+		execCtx, execSpan := r.startSpan(ctx, trace.PhaseExecute, program.Len())
+		defer execSpan.End()
+		result, err = program.run(execCtx, env, r.instruments())
+		execSpan.SetError(err)
+		return result, err
+	}
+
+	_, parseSpan := r.startSpan(ctx, trace.PhaseParse, len(expressions))
 	exprs, err := r.Parse(expressions)
+	parseSpan.SetError(err)
+	parseSpan.End()
 	if err != nil {
 		return nil, err
 	}
-	exprInfos := r.Analyze(exprs)
-
-	var result []any
-	if r.executeMode == ChunkVM {
-		chunk := r.CompileIR(exprInfos)
-		result = r.RunChunk(chunk, env)
-	} else {
-		result = r.RunIR(exprInfos, env)
+	_, analyzeSpan := r.startSpan(ctx, trace.PhaseAnalyze, len(exprs))
+	exprInfos, err := r.Analyze(exprs)
+	analyzeSpan.SetError(err)
+	analyzeSpan.End()
+	if err != nil {
+		return nil, err
+	}
+	setSources(exprInfos, expressions)
+	if err := r.checkExprs(exprInfos); err != nil {
+		return nil, err
+	}
+	if err := limits.CheckCanceled(ctx); err != nil {
+		return nil, err
 	}
 
-	tracer.EndTimer("结束。")
-	return result, nil
-}
-
-func (r *GopRunner) RunIR(exprInfos []*ir.ExprInfo, ev env.Environment) []any {
-	tracer := r.context.GetTracer()
-	tracer.StartTimer()
+	if r.executeMode != SyntaxTree && r.parallelism > 1 {
+		// 并发执行时按层编译，编译计入执行阶段
+		execCtx, execSpan := r.startSpan(ctx, trace.PhaseExecute, len(exprInfos))
+		defer execSpan.End()
+		execSpan.SetAttr("parallelism", r.parallelism)
+		result, err = r.runVMParallel(execCtx, exprInfos, env)
+		execSpan.SetError(err)
+		return result, err
+	} else if r.executeMode == SyntaxTree {
+		execCtx, execSpan := r.startSpan(ctx, trace.PhaseExecute, len(exprInfos))
+		defer execSpan.End()
+		result, err = r.RunIRContext(execCtx, exprInfos, env)
+		execSpan.SetError(err)
+		return result, err
+	}
 
-	variables := make(map[string]bool)
-	for _, info := range exprInfos {
-		for name := range info.GetPrecursors() {
-			variables[name] = true
+	_, compileSpan := r.startSpan(ctx, trace.PhaseCompile, len(exprInfos))
+	var run func(ctx context.Context) ([]any, error)
+	switch r.executeMode {
+	case RegisterVM:
+		program, err := r.CompileRegister(exprInfos)
+		if err != nil {
+			compileSpan.SetError(err)
+			compileSpan.End()
+			return nil, err
 		}
-		for name := range info.GetSuccessors() {
-			variables[name] = true
+		run = func(ctx context.Context) ([]any, error) { return r.RunRegisterContext(ctx, program, env) }
+	case Closure:
+		program := r.CompileClosure(exprInfos)
+		run = func(ctx context.Context) ([]any, error) { return r.RunClosureContext(ctx, program, env) }
+	default:
+		chunk := r.CompileIR(exprInfos)
+		if err := chk.Verify(chunk); err != nil {
+			compileSpan.SetError(err)
+			compileSpan.End()
+			return nil, err
 		}
+		run = func(ctx context.Context) ([]any, error) { return r.RunChunkContext(ctx, chunk, env) }
+	}
+	compileSpan.End()
+
+	execCtx, execSpan := r.startSpan(ctx, trace.PhaseExecute, len(exprInfos))
+	result, err = run(execCtx)
+	execSpan.SetError(err)
+	execSpan.End()
+	if err != nil && len(result) < len(exprInfos) {
+		// 被中断时只返回已完成的结果，补齐到表达式数量
+		result = append(result, make([]any, len(exprInfos)-len(result))...)
 	}
+	return result, err
+}
 
-	fields := make([]*util.Field, 0, len(variables))
-	for v := range variables {
-		fields = append(fields, util.NewField(v))
+// RunIR 以语法树方式执行，表达式执行出错时 panic
+func (r *GopRunner) RunIR(exprInfos []*ir.ExprInfo, ev env.Environment) []any {
+	result, err := r.RunIRContext(context.Background(), exprInfos, ev)
+	if err != nil {
+		panic(err)
 	}
+	return result
+}
 
-	flag := ev.BeforeExecute(fields)
+// RunIRContext 以语法树方式执行，每个表达式执行前检查 ctx。
+// 表达式执行出错或被取消时停止执行，返回已完成表达式的结果和错误
+func (r *GopRunner) RunIRContext(ctx context.Context, exprInfos []*ir.ExprInfo, ev env.Environment) ([]any, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimer()
+
+	flag, err := beforeExecute(ev, collectFields(exprInfos), r.schema)
 	tracer.EndTimer("完成执行环境初始化。")
 	if !flag {
-		return nil
+		return nil, err
 	}
 
 	tracer.StartTimerWithMsg("执行")
-	n := len(exprInfos)
-	result := make([]any, n)
+	defer tracer.EndTimer("执行完成。")
+	result := make([]any, len(exprInfos))
 
+	if r.parallelism > 1 {
+		return result, r.runIRParallel(ctx, exprInfos, ev, result)
+	}
 	for _, info := range exprInfos {
-		expr := info.GetExpr()
-		evaluator := visitors.NewEvaluator(ev)
-		v := evaluator.Execute(expr)
+		if err := limits.CheckCanceled(ctx); err != nil {
+			return result, err
+		}
+		v, err := evaluate(info, ev, r.limits, r.instruments())
+		if err != nil {
+			return result, err
+		}
 		result[info.GetIndex()] = v.GetValue()
 	}
+	return result, nil
+}
 
-	tracer.EndTimer("执行完成。")
+func (r *GopRunner) RunChunk(chunk *chk.Chunk, ev env.Environment) []any {
+	result, _ := r.RunChunkContext(context.Background(), chunk, ev)
 	return result
 }
 
-func (r *GopRunner) RunChunk(chunk *chk.Chunk, ev env.Environment) []any {
+// RunChunkContext 以虚拟机方式执行字节码，返回已完成表达式的结果和虚拟机执行错误（含取消）。
+// 字节码应已通过 chk.Verify 校验：编译、chk.ReadChunk 和 chk.NewChunkWithBytes 得到的字节码都已校验
+func (r *GopRunner) RunChunkContext(ctx context.Context, chunk *chk.Chunk, ev env.Environment) ([]any, error) {
 	tracer := r.context.GetTracer()
 	tracer.StartTimer()
 
@@ -154,23 +548,82 @@ func (r *GopRunner) RunChunk(chunk *chk.Chunk, ev env.Environment) []any {
 		fields = append(fields, util.NewField(v))
 	}
 
-	flag := ev.BeforeExecute(fields)
+	flag, err := beforeExecute(ev, fields, r.schema)
 	tracer.EndTimer("完成执行环境初始化。")
 	if !flag {
-		return nil
+		return nil, err
 	}
 
 	tracer.StartTimerWithMsg("执行")
+	defer tracer.EndTimer("执行完成。")
 	vm := exec.NewVM(tracer)
-	exResults, _ := vm.ExecuteWithReader(chunkReader, ev)
+	vm.SetLimits(r.limits)
+	r.instruments().setupVM(vm)
+	exResults, err := vm.ExecuteWithReaderContext(ctx, chunkReader, ev)
+	// 每个表达式至少占一条 OP_BEGIN 指令，序号不会超过字节码长度
+	return collectResults(exResults, len(chunk.Codes), err)
+}
+
+// RunRegisterContext 以寄存器虚拟机方式执行，返回已完成表达式的结果和执行错误（含取消）
+func (r *GopRunner) RunRegisterContext(ctx context.Context, program *chk.RegProgram, ev env.Environment) ([]any, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimer()
+	fields := make([]*util.Field, 0, len(program.Vars))
+	for _, v := range program.Vars {
+		fields = append(fields, util.NewField(v))
+	}
+	flag, err := beforeExecute(ev, fields, r.schema)
+	tracer.EndTimer("完成执行环境初始化。")
+	if !flag {
+		return nil, err
+	}
+
+	tracer.StartTimerWithMsg("执行")
+	defer tracer.EndTimer("执行完成。")
+	vm := exec.NewRegisterVM(tracer)
+	vm.SetLimits(r.limits)
+	r.instruments().setupRegisterVM(vm)
+	exResults, err := vm.ExecuteContext(ctx, program, ev)
+	return collectResults(exResults, len(program.Code), err)
+}
+
+// RunClosureContext 执行闭包编译的程序，每个表达式执行前检查 ctx。
+// 程序可以反复对不同的执行环境执行，出错或被取消时返回已完成表达式的结果和错误
+func (r *GopRunner) RunClosureContext(ctx context.Context, program *visitors.ClosureProgram, ev env.Environment) ([]any, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimer()
+	variables := program.GetVariables()
+	fields := make([]*util.Field, 0, len(variables))
+	for _, v := range variables {
+		fields = append(fields, util.NewField(v))
+	}
+	flag, err := beforeExecute(ev, fields, r.schema)
+	tracer.EndTimer("完成执行环境初始化。")
+	if !flag {
+		return nil, err
+	}
+
+	tracer.StartTimerWithMsg("执行")
+	defer tracer.EndTimer("执行完成。")
+	exResults, err := r.instruments().runClosure(ctx, program, ev)
+	return collectResults(exResults, program.Len(), err)
+}
 
-	result := make([]any, len(exResults))
+// collectResults 按表达式序号排列虚拟机的执行结果，err 为执行错误。
+// 序号须在 [0, limit) 内，否则不返回结果，返回序号越界的错误
+func collectResults(exResults []*exec.ExResult, limit int, err error) ([]any, error) {
+	n := 0
+	for _, res := range exResults {
+		if index := res.GetIndex(); index < 0 || index >= limit {
+			return nil, fmt.Errorf("表达式序号 %d 越界，上限为 %d", index, limit)
+		}
+		n = max(n, res.GetIndex()+1)
+	}
+	result := make([]any, n)
 	for _, res := range exResults {
 		result[res.GetIndex()] = res.GetResult().GetValue()
 	}
-
-	tracer.EndTimer("执行完成。")
-	return result
+	return result, err
 }
 
 func (r *GopRunner) Parse(expressions []string) ([]exprs.Expr, error) {
@@ -191,20 +644,27 @@ func (r *GopRunner) Parse(expressions []string) ([]exprs.Expr, error) {
 	return result, nil
 }
 
-func (r *GopRunner) Analyze(exprs []exprs.Expr) []*ir.ExprInfo {
+func (r *GopRunner) Analyze(exprs []exprs.Expr) ([]*ir.ExprInfo, error) {
 	tracer := r.context.GetTracer()
 	tracer.StartTimerWithMsg("分析")
+	defer tracer.EndTimer("完成表达式分析。")
 
+	var folder *visitors.ConstantFolder
+	if r.isOptimizing() {
+		folder = visitors.NewConstantFolder()
+	}
 	exprInfos := make([]*ir.ExprInfo, len(exprs))
 	for i, expr := range exprs {
+		if folder != nil {
+			expr = folder.Fold(expr)
+		}
 		exprInfos[i] = ir.NewExprInfo(expr, i)
 	}
 
-	r.context.PrepareExecute(exprInfos)
-	sortedInfos := r.sortExprs(exprInfos)
-
-	tracer.EndTimer("完成表达式分析。")
-	return sortedInfos
+	if err := r.context.PrepareExecute(exprInfos); err != nil {
+		return nil, err
+	}
+	return r.sortExprs(exprInfos)
 }
 
 func (r *GopRunner) CompileSource(expressions []string) (*chk.Chunk, error) {
@@ -215,8 +675,18 @@ func (r *GopRunner) CompileSource(expressions []string) (*chk.Chunk, error) {
 	if err != nil {
 		return nil, err
 	}
-	exprInfos := r.Analyze(exprs)
+	exprInfos, err := r.Analyze(exprs)
+	if err != nil {
+		return nil, err
+	}
+	setSources(exprInfos, expressions)
+	if err := r.checkExprs(exprInfos); err != nil {
+		return nil, err
+	}
 	chunk := r.CompileIR(exprInfos)
+	if err := chk.Verify(chunk); err != nil {
+		return nil, err
+	}
 
 	tracer.EndTimer("完成表达式编译。")
 	return chunk, nil
@@ -227,22 +697,104 @@ func (r *GopRunner) CompileIR(exprInfos []*ir.ExprInfo) *chk.Chunk {
 	tracer.StartTimerWithMsg("编译中间表示")
 
 	compiler := visitors.NewOpCodeCompiler(tracer, len(exprInfos))
+	compiler.SetSlotAccess(r.slotAccess)
+	compiler.SetDebugInfo(r.emitDebugInfo())
 	compiler.BeginCompile()
 
 	for _, info := range exprInfos {
 		compiler.Compile(info)
 	}
 
-	result := compiler.EndCompile()
+	result := r.optimizeChunk(compiler.EndCompile())
 	tracer.EndTimer("完成表达式编译。")
 	return result
 }
 
-func (r *GopRunner) sortExprs(exprInfos []*ir.ExprInfo) []*ir.ExprInfo {
+// CompileRegister 编译为寄存器虚拟机的程序
+func (r *GopRunner) CompileRegister(exprInfos []*ir.ExprInfo) (*chk.RegProgram, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimerWithMsg("编译寄存器指令")
+	defer tracer.EndTimer("完成寄存器指令编译。")
+
+	compiler := visitors.NewRegisterCompiler(tracer)
+	compiler.SetDebugInfo(r.emitDebugInfo())
+	compiler.BeginCompile()
+	for _, info := range exprInfos {
+		if err := compiler.Compile(info); err != nil {
+			return nil, err
+		}
+	}
+	return compiler.EndCompile(), nil
+}
+
+// CompileClosure 编译为闭包，使用当前的资源限额
+func (r *GopRunner) CompileClosure(exprInfos []*ir.ExprInfo) *visitors.ClosureProgram {
+	tracer := r.context.GetTracer()
+	tracer.StartTimerWithMsg("编译闭包")
+	defer tracer.EndTimer("完成闭包编译。")
+
+	compiler := visitors.NewClosureCompiler()
+	compiler.SetLimits(r.limits)
+	compiler.BeginCompile()
+	for _, info := range exprInfos {
+		compiler.Compile(info)
+	}
+	return compiler.EndCompile()
+}
+
+// setSources 记录各表达式的源码，用于生成调试信息
+func setSources(exprInfos []*ir.ExprInfo, expressions []string) {
+	for _, info := range exprInfos {
+		info.SetSource(expressions[info.GetIndex()])
+	}
+}
+
+// optimizeChunk 开启优化时对字节码做窥孔优化
+func (r *GopRunner) optimizeChunk(chunk *chk.Chunk) *chk.Chunk {
+	if !r.isOptimizing() {
+		return chunk
+	}
+	optimized, err := chk.Optimize(chunk)
+	if err != nil {
+		// 编译器生成的字节码总能解码，出错说明编译器有缺陷
+		panic(err)
+	}
+	return optimized
+}
+
+func (r *GopRunner) sortExprs(exprInfos []*ir.ExprInfo) ([]*ir.ExprInfo, error) {
 	if r.needSort && len(exprInfos) >= 1 && r.context.GetExecContext().HasAssign() {
 		sorter := ir.NewExprSorter(r.context)
-		sortedExprInfos, _ := sorter.Sort()
-		return sortedExprInfos
+		return sorter.Sort()
+	}
+	return exprInfos, nil
+}
+
+// evaluate 以语法树方式执行单个表达式，执行出错时返回错误而不是 panic。
+// inst.profiler 不为 nil 时记录执行时间，inst.coverage 不为 nil 时记录判定结果
+func evaluate(info *ir.ExprInfo, ev env.Environment, limits limits.Limits, inst instruments) (values.Value, error) {
+	evaluator := visitors.NewEvaluator(ev)
+	evaluator.SetLimits(limits)
+	if inst.coverage != nil {
+		evaluator.SetCoverage(inst.coverage, info.GetIndex())
+	}
+	if inst.explain != nil {
+		evaluator.SetExplain(inst.explain, info.GetIndex())
+	}
+	profiler := inst.profiler
+	var start time.Time
+	if profiler != nil {
+		evaluator.SetProfiler(profiler, info.GetIndex())
+		start = time.Now()
+	}
+	v, err := util.SafeExecute(func() values.Value {
+		return evaluator.Execute(info.GetExpr())
+	})
+	if err != nil {
+		return v, fmt.Errorf("表达式 %d 执行出错：%w", info.GetIndex(), err)
+	}
+	if profiler != nil {
+		profiler.RecordExpr(info.GetIndex(), time.Since(start))
 	}
-	return exprInfos
+	return v, nil
 }
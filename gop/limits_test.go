@@ -0,0 +1,118 @@
+package gop_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var limitModes = []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure}
+
+func requireQuota(t *testing.T, err error, kind limits.QuotaKind, msgAndArgs ...any) {
+	t.Helper()
+	var quota *limits.QuotaError
+	require.ErrorAs(t, err, &quota, msgAndArgs...)
+	assert.Equal(t, kind, quota.Kind, msgAndArgs...)
+}
+
+func TestLimits_DeepExpression(t *testing.T) {
+	// 右结合嵌套，虚拟机中每层都占用一个栈位置
+	deep := strings.Repeat("1 + (", 300) + "1" + strings.Repeat(")", 300)
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	_, err := runner.Execute(deep)
+	requireQuota(t, err, limits.QuotaStackDepth, "默认栈深度超出时应返回错误而不是崩溃")
+
+	runner.SetLimits(limits.Limits{MaxStackDepth: 1024})
+	result, err := runner.Execute(deep)
+	require.NoError(t, err)
+	assert.Equal(t, 301, result)
+
+	runner = gop.NewGopRunner()
+	result, err = runner.Execute(deep)
+	require.NoError(t, err)
+	assert.Equal(t, 301, result)
+	runner.SetLimits(limits.Limits{MaxRecursion: 100})
+	_, err = runner.Execute(deep)
+	requireQuota(t, err, limits.QuotaRecursion)
+}
+
+func TestLimits_Instructions(t *testing.T) {
+	long := "a" + strings.Repeat(" + a", 50)
+	for _, mode := range limitModes {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetLimits(limits.Limits{MaxInstructions: 50})
+
+		ev := env.NewDefaultEnvironment()
+		ev.PutInt("a", 1)
+		_, err := runner.Execute(long, ev)
+		requireQuota(t, err, limits.QuotaInstructions, "模式 %d", mode)
+
+		// 限额按单个表达式计算
+		lines := make([]string, 100)
+		for i := range lines {
+			lines[i] = "a + 1"
+		}
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err, "模式 %d", mode)
+		assert.Len(t, result, 100)
+	}
+}
+
+func TestLimits_ValueSize(t *testing.T) {
+	for _, mode := range limitModes {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetLimits(limits.Limits{MaxStringSize: 6, MaxCollectionSize: 1})
+
+		ev := env.NewDefaultEnvironment()
+		ev.PutString("s", "abcd")
+		ev.Put("t", values.NewInstanceValue(*values.NewInstance()))
+
+		result, err := runner.Execute("s + 1", ev)
+		require.NoError(t, err)
+		assert.Equal(t, "abcd1", result)
+		_, err = runner.Execute("s + s", ev)
+		requireQuota(t, err, limits.QuotaStringSize, "模式 %d", mode)
+
+		_, err = runner.ExecuteBatch([]string{"t.a = 1", "t.b = 2"}, ev)
+		requireQuota(t, err, limits.QuotaCollectionSize, "模式 %d", mode)
+	}
+}
+
+func TestLimits_CallDepth(t *testing.T) {
+	for _, mode := range limitModes {
+		for _, parallelism := range []int{1, 4} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			runner.SetParallelism(parallelism)
+			runner.SetLimits(limits.Limits{MaxCallDepth: 2})
+			result, err := runner.Execute("abs(abs(-1))")
+			require.NoError(t, err, "模式 %d", mode)
+			assert.Equal(t, 1, result)
+			_, err = runner.Execute("abs(abs(abs(-1)))")
+			requireQuota(t, err, limits.QuotaCallDepth, "模式 %d", mode)
+
+			// 按语法结构检查，不执行的分支同样计入
+			_, err = runner.ExecuteBatch([]string{"x = 1", "if(1 > 2, abs(abs(abs(-1))), 0)"})
+			requireQuota(t, err, limits.QuotaCallDepth, "模式 %d", mode)
+
+			_, err = runner.Compile([]string{"abs(abs(abs(-1)))"})
+			requireQuota(t, err, limits.QuotaCallDepth, "模式 %d", mode)
+		}
+	}
+
+	wb := gop.NewWorkbook(env.NewDefaultEnvironment())
+	wb.SetLimits(limits.Limits{MaxCallDepth: 2})
+	_, err := wb.AddFormulas([]string{"x = abs(abs(-1))", "y = abs(abs(abs(x)))"})
+	requireQuota(t, err, limits.QuotaCallDepth)
+	assert.Equal(t, 1, wb.GetResult(0))
+}
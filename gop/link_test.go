@@ -0,0 +1,112 @@
+package gop_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	moduleA = []string{"y = x * 2", "abs(y - 10)"}
+	moduleB = []string{"z = y + w", "x = 3", `s = "v" + w`}
+)
+
+func linkEnv() *env.DefaultEnvironment {
+	ev := env.NewDefaultEnvironment()
+	ev.Put("w", values.NewIntValue(5))
+	return ev
+}
+
+func TestLink_MergeAndResort(t *testing.T) {
+	setups := map[string]func(*gop.GopRunner){
+		"默认":   func(r *gop.GopRunner) {},
+		"槽位访问": func(r *gop.GopRunner) { r.SetSlotAccess(true) },
+		"优化":   func(r *gop.GopRunner) { r.SetOptimize(true) },
+		"调试信息": func(r *gop.GopRunner) { r.SetDebugInfo(true) },
+	}
+	for name, setup := range setups {
+		runner := gop.NewGopRunner()
+		setup(runner)
+		a, err := runner.CompileSource(moduleA)
+		require.NoError(t, err, name)
+		b, err := runner.CompileSource(moduleB)
+		require.NoError(t, err, name)
+
+		linked, err := chk.Link(a, b)
+		require.NoError(t, err, name)
+		require.NoError(t, chk.Verify(linked), name)
+
+		ev := linkEnv()
+		result := runner.RunChunk(linked, ev)
+		assert.Equal(t, []any{6, 4, 11, 3, "v5"}, result, name)
+		assert.Equal(t, 11, ev.Get("z").GetValue(), name)
+	}
+}
+
+func TestLink_PropertyDependency(t *testing.T) {
+	for _, slotAccess := range []bool{false, true} {
+		runner := gop.NewGopRunner()
+		runner.SetSlotAccess(slotAccess)
+		a, err := runner.CompileSource([]string{"y = o.x + 1"})
+		require.NoError(t, err)
+		b, err := runner.CompileSource([]string{"o.x = 5"})
+		require.NoError(t, err)
+
+		linked, err := chk.Link(a, b)
+		require.NoError(t, err)
+		ev := env.NewDefaultEnvironment()
+		ev.Put("o", values.NewInstanceValue(*values.NewInstance()))
+		result, err := runner.RunChunkContext(t.Context(), linked, ev)
+		require.NoError(t, err, "槽位访问 %v", slotAccess)
+		assert.Equal(t, []any{6, 5}, result)
+		assert.Equal(t, 6, ev.Get("y").GetValue())
+	}
+}
+
+func TestLink_Cycle(t *testing.T) {
+	runner := gop.NewGopRunner()
+	a, err := runner.CompileSource([]string{"a = b + 1"})
+	require.NoError(t, err)
+	b, err := runner.CompileSource([]string{"b = a + 1"})
+	require.NoError(t, err)
+	_, err = chk.Link(a, b)
+	assert.ErrorContains(t, err, "循环依赖")
+}
+
+func TestLink_Extract(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetDebugInfo(true)
+	a, err := runner.CompileSource(moduleA)
+	require.NoError(t, err)
+	b, err := runner.CompileSource(moduleB)
+	require.NoError(t, err)
+	linked, err := chk.Link(a, b)
+	require.NoError(t, err)
+
+	sub, err := chk.Extract(linked, 3, 0)
+	require.NoError(t, err)
+	require.NoError(t, chk.Verify(sub))
+	assert.Less(t, sub.GetByteSize(), linked.GetByteSize())
+	assert.Equal(t, []any{6, 3}, runner.RunChunk(sub, linkEnv()))
+
+	// 序号重新编号，源码随表达式一起保留。单独取出 z = y + w 时 y 未赋值，执行出错
+	sub, err = chk.Extract(linked, 2)
+	require.NoError(t, err)
+	_, err = runner.RunChunkContext(t.Context(), sub, linkEnv())
+	var rerr *exec.RuntimeError
+	require.True(t, errors.As(err, &rerr), "%v", err)
+	assert.Equal(t, 0, rerr.Index)
+	assert.Equal(t, moduleB[0], rerr.Source)
+
+	_, err = chk.Extract(linked, 9)
+	assert.Error(t, err)
+	_, err = chk.Extract(linked, 0, 9, 7)
+	assert.EqualError(t, err, "字节码块中没有序号为 9 的表达式")
+}
@@ -0,0 +1,85 @@
+package gop_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptimize_ResultsUnchanged(t *testing.T) {
+	lines := append(testdata.GetExpressions(10),
+		"10 ** 2 / 5 - (12 + 8)",
+		"(A0 - 1) * 1 + abs(-3)",
+		"if(true, A1, B1)",
+		"r = 3 > 2 && true",
+	)
+	expected, err := gop.NewGopRunner().ExecuteBatch(lines, testdata.GetEnv(10))
+	require.NoError(t, err)
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetOptimize(true)
+		ev := testdata.GetEnv(10)
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err)
+		assert.Equal(t, expected, result, "模式 %d", mode)
+		testdata.CheckValues(t, ev, 10)
+	}
+}
+
+func TestOptimize_PreserveDivisionByZero(t *testing.T) {
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetOptimize(true)
+		_, err := runner.Execute("x = 1 + 10 / (5 - 5)", env.NewDefaultEnvironment())
+		assert.ErrorContains(t, err, "division by zero", "模式 %d", mode)
+	}
+}
+
+func TestOptimize_FoldedBranchDropsAssignment(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetOptimize(true)
+	ev := env.NewDefaultEnvironment()
+	result, err := runner.ExecuteBatch([]string{"if(1 > 2, x = 1, y = 2)", "z = y + 1"}, ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{2, 3}, result)
+	assert.True(t, ev.Get("x").IsNull())
+}
+
+func TestOptimize_BytecodeMatchesUnoptimized(t *testing.T) {
+	lines := []string{
+		"a + 1 > 3",
+		"a > 1 || b > 1 || c > 1 || d > 1",
+		"a > 1 && b > 1 && c > 1 && d > 1",
+		"if(a > 1, if(b < 2, a + 1, b + 1), c + 10)",
+		"x = if(a == b, 1) || d + 1",
+	}
+	for _, vals := range [][4]int32{{0, 0, 0, 0}, {2, 0, 0, 0}, {0, 0, 2, 0}, {2, 2, 2, 2}, {1, 1, 3, 0}} {
+		newEnv := func() *env.DefaultEnvironment {
+			ev := env.NewDefaultEnvironment()
+			for i, name := range []string{"a", "b", "c", "d"} {
+				ev.Put(name, values.NewIntValue(vals[i]))
+			}
+			return ev
+		}
+		plain := gop.NewGopRunner()
+		plain.SetExecuteMode(gop.ChunkVM)
+		expected, err := plain.ExecuteBatch(lines, newEnv())
+		require.NoError(t, err)
+
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(gop.ChunkVM)
+		runner.SetOptimize(true)
+		result, err := runner.ExecuteBatch(lines, newEnv())
+		require.NoError(t, err)
+		assert.Equal(t, expected, result, "变量取值 %v", vals)
+	}
+}
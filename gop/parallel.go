@@ -0,0 +1,289 @@
+package gop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+	"github.com/simonwater/gopression/visitors"
+)
+
+// runIRParallel 按层并发执行语法树，每个表达式执行前检查 ctx。
+// 出错或被取消时执行完当前层后停止，结果与执行环境的写入按 commitLevel 的规则只保留到第一个出错的表达式为止
+func (r *GopRunner) runIRParallel(ctx context.Context, exprInfos []*ir.ExprInfo, ev env.Environment, result []any) error {
+	syncEnv := env.NewSyncEnvironment(ev)
+	for _, level := range ir.Levelize(exprInfos) {
+		envs := make([]*bufferedEnv, len(level))
+		exResults := make([][]*exec.ExResult, len(level))
+		errs := make([]error, len(level))
+		parallelFor(r.parallelism, len(level), func(i int) {
+			if errs[i] = limits.CheckCanceled(ctx); errs[i] != nil {
+				return
+			}
+			envs[i] = newBufferedEnv(syncEnv)
+			v, err := evaluate(level[i], envs[i], r.limits, r.instruments())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			exResults[i] = []*exec.ExResult{{Value: &v, State: exec.OK, Index: level[i].GetIndex()}}
+		})
+		if err := commitLevel(syncEnv, envs, exResults, errs, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runVMParallel 按层编译并发执行：每层按工作协程数切分为若干组，每组按执行模式编译为独立的字节码块、寄存器程序或闭包
+func (r *GopRunner) runVMParallel(ctx context.Context, exprInfos []*ir.ExprInfo, ev env.Environment) ([]any, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimer()
+	flag, err := beforeExecute(ev, collectFields(exprInfos), r.schema)
+	tracer.EndTimer("完成执行环境初始化。")
+	if !flag {
+		return nil, err
+	}
+
+	tracer.StartTimerWithMsg("并发执行")
+	defer tracer.EndTimer("执行完成。")
+
+	syncEnv := env.NewSyncEnvironment(ev)
+	result := make([]any, len(exprInfos))
+	for _, level := range ir.Levelize(exprInfos) {
+		if err := limits.CheckCanceled(ctx); err != nil {
+			return result, err
+		}
+		groups := splitGroups(level, r.parallelism)
+		envs := make([]*bufferedEnv, len(groups))
+		runs := make([]func() ([]*exec.ExResult, error), len(groups))
+		for i, group := range groups {
+			envs[i] = newBufferedEnv(syncEnv)
+			if runs[i], err = r.compileGroup(ctx, group, envs[i]); err != nil {
+				return result, err
+			}
+		}
+
+		errs := make([]error, len(runs))
+		exResults := make([][]*exec.ExResult, len(runs))
+		parallelFor(r.parallelism, len(runs), func(i int) {
+			// 虚拟机和闭包已把表达式执行中的 panic 转换为带序号的错误，这里只是兜底，
+			// 避免工作协程的 panic 使进程退出。此时无法确定出错的表达式，错误中不带序号
+			defer func() {
+				if p := recover(); p != nil {
+					errs[i] = fmt.Errorf("并发执行出错：%w", util.PanicError(p))
+				}
+			}()
+			exResults[i], errs[i] = runs[i]()
+		})
+		if err := commitLevel(syncEnv, envs, exResults, errs, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// commitLevel 一层执行完后按顺序提交各组（语法树方式每组一个表达式）的结果和执行环境的写入。
+// 各组是层内按执行顺序排列的连续表达式，组内出错时执行停在出错的表达式。
+// 与顺序执行一致，只提交到第一个出错的组为止并返回其错误，之后各组的结果和写入全部丢弃。
+// 之后的层不再执行，其中执行顺序在出错表达式之前的表达式没有结果，这一点与顺序执行不同
+func commitLevel(syncEnv *env.SyncEnvironment, envs []*bufferedEnv, exResults [][]*exec.ExResult, errs []error, result []any) error {
+	for i := range envs {
+		if envs[i] != nil {
+			envs[i].commit(syncEnv)
+		}
+		for _, res := range exResults[i] {
+			result[res.GetIndex()] = res.GetResult().GetValue()
+		}
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
+
+// compileGroup 按执行模式编译一组表达式，返回执行函数
+func (r *GopRunner) compileGroup(ctx context.Context, group []*ir.ExprInfo, ev env.Environment) (func() ([]*exec.ExResult, error), error) {
+	if r.executeMode == Closure {
+		compiler := visitors.NewClosureCompiler()
+		compiler.SetLimits(r.limits)
+		compiler.BeginCompile()
+		for _, info := range group {
+			compiler.Compile(info)
+		}
+		program := compiler.EndCompile()
+		return func() ([]*exec.ExResult, error) {
+			return r.instruments().runClosure(ctx, program, ev)
+		}, nil
+	}
+	if r.executeMode == RegisterVM {
+		compiler := visitors.NewRegisterCompiler(nil)
+		compiler.SetDebugInfo(r.emitDebugInfo())
+		compiler.BeginCompile()
+		for _, info := range group {
+			if err := compiler.Compile(info); err != nil {
+				return nil, err
+			}
+		}
+		program := compiler.EndCompile()
+		return func() ([]*exec.ExResult, error) {
+			vm := exec.NewRegisterVM(nil)
+			vm.SetLimits(r.limits)
+			r.instruments().setupRegisterVM(vm)
+			return vm.ExecuteContext(ctx, program, ev)
+		}, nil
+	}
+
+	compiler := visitors.NewOpCodeCompiler(nil, len(group))
+	compiler.SetSlotAccess(r.slotAccess)
+	compiler.SetDebugInfo(r.emitDebugInfo())
+	compiler.BeginCompile()
+	for _, info := range group {
+		compiler.Compile(info)
+	}
+	chunk := r.optimizeChunk(compiler.EndCompile())
+	return func() ([]*exec.ExResult, error) {
+		vm := exec.NewVM(nil)
+		vm.SetLimits(r.limits)
+		r.instruments().setupVM(vm)
+		return vm.ExecuteContext(ctx, chunk, ev)
+	}, nil
+}
+
+// bufferedEnv 暂存一组表达式对变量的写入，读取时先查暂存的写入，由 commit 统一写入内部环境。
+// 只在一个工作协程内使用，不加锁。属性赋值直接修改对象，不经过执行环境，不会暂存
+type bufferedEnv struct {
+	*env.BaseEnvironment
+	inner  env.Environment
+	names  []string
+	writes map[string]values.Value
+}
+
+func newBufferedEnv(inner env.Environment) *bufferedEnv {
+	be := &bufferedEnv{inner: inner, writes: make(map[string]values.Value)}
+	be.BaseEnvironment = env.NewBaseEnvironment(be)
+	return be
+}
+
+func (be *bufferedEnv) BeforeExecute(vars []*util.Field) bool {
+	return be.inner.BeforeExecute(vars)
+}
+
+func (be *bufferedEnv) Get(id string) values.Value {
+	if v, ok := be.writes[id]; ok {
+		return v
+	}
+	return be.inner.Get(id)
+}
+
+func (be *bufferedEnv) GetOrDefault(id string, defValue values.Value) values.Value {
+	if v, ok := be.writes[id]; ok {
+		return v
+	}
+	return be.inner.GetOrDefault(id, defValue)
+}
+
+func (be *bufferedEnv) Put(id string, value values.Value) {
+	if _, ok := be.writes[id]; !ok {
+		be.names = append(be.names, id)
+	}
+	be.writes[id] = value
+}
+
+// Size 内部环境的变量数加上暂存的新变量数，暂存的变量在内部环境中为空值时视为新变量
+func (be *bufferedEnv) Size() int {
+	n := be.inner.Size()
+	for _, id := range be.names {
+		if be.inner.Get(id).IsNull() {
+			n++
+		}
+	}
+	return n
+}
+
+// commit 按首次写入的顺序把暂存的写入一次写入 ev
+func (be *bufferedEnv) commit(ev env.Environment) {
+	if len(be.names) == 0 {
+		return
+	}
+	vals := make([]values.Value, len(be.names))
+	for i, id := range be.names {
+		vals[i] = be.writes[id]
+	}
+	env.PutValues(ev, be.names, vals)
+}
+
+// parallelFor 使用最多 workers 个协程执行 fn(0..n-1)，全部完成后返回
+func parallelFor(workers, n int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// splitGroups 将表达式列表切分为最多 n 个连续的组
+func splitGroups(infos []*ir.ExprInfo, n int) [][]*ir.ExprInfo {
+	if n > len(infos) {
+		n = len(infos)
+	}
+	groups := make([][]*ir.ExprInfo, 0, n)
+	size, rest := len(infos)/n, len(infos)%n
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + size
+		if i < rest {
+			end++
+		}
+		groups = append(groups, infos[start:end])
+		start = end
+	}
+	return groups
+}
+
+// collectFields 收集表达式读取和赋值的所有变量
+func collectFields(exprInfos []*ir.ExprInfo) []*util.Field {
+	variables := make(map[string]bool)
+	for _, info := range exprInfos {
+		for name := range info.GetPrecursors() {
+			variables[name] = true
+		}
+		for name := range info.GetSuccessors() {
+			variables[name] = true
+		}
+	}
+
+	fields := make([]*util.Field, 0, len(variables))
+	for v := range variables {
+		fields = append(fields, util.NewField(v))
+	}
+	return fields
+}
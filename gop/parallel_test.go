@@ -0,0 +1,118 @@
+package gop_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const par_formulaBatches = 200
+
+func TestParallel_ResultsMatchSequential(t *testing.T) {
+	lines := testdata.GetExpressions(par_formulaBatches)
+	lines = append(lines, "A0 + A1", "B0 * 2")
+
+	sequential := gop.NewGopRunner()
+	expected, err := sequential.ExecuteBatch(lines, testdata.GetEnv(par_formulaBatches))
+	require.NoError(t, err)
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetParallelism(4)
+		ev := testdata.GetEnv(par_formulaBatches)
+
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err)
+		assert.Equal(t, expected, result, "模式 %d 并发执行结果应与顺序执行一致", mode)
+		testdata.CheckValues(t, ev, par_formulaBatches)
+	}
+}
+
+func TestParallel_KeepsUnsortedOrderSemantics(t *testing.T) {
+	lines := []string{
+		"y = x + 1",
+		"x = 10",
+		"z = x + y",
+	}
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetNeedSort(false)
+		runner.SetExecuteMode(mode)
+		runner.SetParallelism(8)
+		ev := env.NewDefaultEnvironment()
+		ev.PutInt("x", 1)
+
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.NoError(t, err)
+		assert.Equal(t, []any{2, 10, 12}, result)
+	}
+}
+
+func TestParallel_RecoversPanics(t *testing.T) {
+	lines := make([]string, 0, 41)
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("a%d = %d + 1", i, i))
+	}
+	lines = append(lines, "b = 5 % 0")
+	for _, mode := range []gop.ExecuteMode{gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetParallelism(4)
+		_, err := runner.ExecuteBatch(lines)
+		require.Error(t, err, "模式 %s", mode)
+		assert.ErrorContains(t, err, "integer divide by zero", "模式 %s", mode)
+		assert.EqualError(t, err, "表达式 40 执行出错：runtime error: integer divide by zero", "模式 %s", mode)
+	}
+}
+
+func TestParallel_StopsAtFirstError(t *testing.T) {
+	lines := []string{"null + 1", "null == null", "z == null"}
+	sequential := gop.NewGopRunner()
+	expected, err := sequential.ExecuteBatch(lines)
+	require.Error(t, err)
+	require.Equal(t, []any{nil, nil, nil}, expected)
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetParallelism(4)
+		result, err := runner.ExecuteBatch(lines)
+		assert.EqualError(t, err, sequentialError(t, mode, lines), "模式 %s", mode)
+		assert.Equal(t, expected, result, "模式 %s 出错之后的表达式不应有结果", mode)
+	}
+
+	// 出错之后的表达式对执行环境的写入同样丢弃，之前的保留
+	lines = []string{"x = 1", "y = 2", "v = null + 1", "z = 3", "w = 4"}
+	expected, err = sequential.ExecuteBatch(lines)
+	require.Error(t, err)
+	require.Equal(t, []any{1, 2, nil, nil, nil}, expected)
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetParallelism(2)
+		ev := env.NewDefaultEnvironment()
+		result, err := runner.ExecuteBatch(lines, ev)
+		require.Error(t, err, "模式 %s", mode)
+		assert.Equal(t, expected, result, "模式 %s", mode)
+		assert.Equal(t, 1, ev.Get("x").GetValue(), "模式 %s", mode)
+		assert.Equal(t, 2, ev.Get("y").GetValue(), "模式 %s", mode)
+		assert.True(t, ev.Get("z").IsNull(), "模式 %s", mode)
+		assert.True(t, ev.Get("w").IsNull(), "模式 %s", mode)
+	}
+}
+
+// sequentialError 顺序执行 lines 返回的错误信息
+func sequentialError(t *testing.T, mode gop.ExecuteMode, lines []string) string {
+	t.Helper()
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(mode)
+	_, err := runner.ExecuteBatch(lines)
+	require.Error(t, err)
+	return err.Error()
+}
@@ -0,0 +1,88 @@
+package gop_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiler_AllModes(t *testing.T) {
+	lines := []string{"x = abs(-3) + abs(y)", "y = 2", "z = x * 2"}
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		profiler := profile.NewProfiler()
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetProfiler(profiler)
+		for i := 0; i < 2; i++ {
+			_, err := runner.ExecuteBatch(lines)
+			require.NoError(t, err)
+		}
+
+		report := profiler.Report()
+		report.Sort(profile.SortByName)
+		require.Len(t, report.Exprs, 3, "模式 %d", mode)
+		for i, e := range report.Exprs {
+			assert.Equal(t, i, e.Index)
+			assert.Equal(t, int64(2), e.Count)
+			assert.Equal(t, lines[i], e.Source)
+		}
+		require.Len(t, report.Funcs, 1, "模式 %d", mode)
+		assert.Equal(t, "abs", report.Funcs[0].Name)
+		assert.Equal(t, int64(4), report.Funcs[0].Calls)
+
+		if mode == gop.ChunkVM {
+			counts := make(map[chk.OpCode]int64)
+			for _, op := range report.Ops {
+				counts[op.Op] = op.Count
+			}
+			assert.Equal(t, int64(6), counts[chk.OP_BEGIN])
+			assert.Equal(t, int64(4), counts[chk.OP_CALL])
+		} else {
+			assert.Empty(t, report.Ops, "模式 %d", mode)
+		}
+	}
+}
+
+func TestProfiler_ReportAndPprof(t *testing.T) {
+	profiler := profile.NewProfiler()
+	profiler.SetSources([]string{"a + b", "abs(a)"})
+	profiler.RecordExpr(0, 100)
+	profiler.RecordExpr(1, 300)
+	profiler.RecordExpr(1, 300)
+	profiler.RecordCall(1, "abs", 200)
+	profiler.RecordExpr(0, 100)
+	profiler.RecordExpr(0, 100)
+
+	report := profiler.Report()
+	assert.Equal(t, []int{1, 0}, []int{report.Exprs[0].Index, report.Exprs[1].Index}, "默认按总时间降序")
+	report.Sort(profile.SortByCount)
+	assert.Equal(t, 0, report.Exprs[0].Index)
+	assert.Equal(t, int64(3), report.Exprs[0].Count)
+
+	var text strings.Builder
+	require.NoError(t, report.WriteText(&text, 1))
+	assert.Contains(t, text.String(), "a + b")
+	assert.NotContains(t, text.String(), "abs(a)", "只输出 1 项")
+	assert.Contains(t, text.String(), "abs ")
+
+	var buf bytes.Buffer
+	require.NoError(t, profiler.WritePprof(&buf))
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	for _, s := range []string{"samples", "nanoseconds", "表达式 0：a + b", "表达式 1：abs(a)", "abs"} {
+		assert.True(t, bytes.Contains(data, []byte(s)), "缺少字符串 %q", s)
+	}
+
+	profiler.Reset()
+	assert.Empty(t, profiler.Report().Exprs)
+}
@@ -0,0 +1,245 @@
+package gop
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/visitors"
+)
+
+// Program 编译好的表达式程序，由 GopRunner.Compile 创建。
+// 包含排好序的中间表示或按执行模式编译的结果、变量列表和调用的函数，创建后不可修改，可以在多个协程中反复执行
+type Program struct {
+	mode      ExecuteMode
+	limits    limits.Limits
+	size      int
+	variables []string
+	functions []string       // 调用的函数名，按名称排序
+	schema    *schema.Schema // 编译时的变量声明，执行前按其校验输入
+
+	exprInfos []*ir.ExprInfo           // SyntaxTree
+	chunk     *chk.Chunk               // ChunkVM
+	reader    *chk.ChunkReader         // ChunkVM：已解码常量池等数据的读取器，每次执行时 Fork
+	vms       sync.Pool                // ChunkVM：复用的虚拟机
+	register  *chk.RegProgram          // RegisterVM
+	closure   *visitors.ClosureProgram // Closure
+}
+
+// Compile 解析、分析并按当前的执行模式编译表达式，返回可以反复执行的程序。
+// 表达式调用了未注册的函数时返回错误。程序使用编译时的资源限额，按顺序执行，不受 SetParallelism 影响。
+// 设置了编译缓存时先查找缓存，编译成功后放入缓存
+func (r *GopRunner) Compile(expressions []string) (*Program, error) {
+	if r.cache == nil {
+		return r.compile(expressions)
+	}
+	key := r.cacheKey(expressions)
+	if program, ok := r.cache.get(key, r.executeMode, r.limits, r.schema); ok {
+		return program, nil
+	}
+	program, err := r.compile(expressions)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(key, program)
+	return program, nil
+}
+
+func (r *GopRunner) compile(expressions []string) (*Program, error) {
+	tracer := r.context.GetTracer()
+	tracer.StartTimerWithMsg("编译程序。公式总数：%d", len(expressions))
+	defer tracer.EndTimer("完成程序编译。")
+
+	exprs, err := r.Parse(expressions)
+	if err != nil {
+		return nil, err
+	}
+	exprInfos, err := r.Analyze(exprs)
+	if err != nil {
+		return nil, err
+	}
+	setSources(exprInfos, expressions)
+	if err := r.checkExprs(exprInfos); err != nil {
+		return nil, err
+	}
+
+	program := &Program{
+		mode:      r.executeMode,
+		limits:    r.limits,
+		schema:    r.schema,
+		size:      len(exprInfos),
+		variables: collectVariables(exprInfos),
+	}
+	if program.functions, err = checkFunctions(exprInfos); err != nil {
+		return nil, err
+	}
+
+	if r.executeMode == RegisterVM {
+		if program.register, err = r.CompileRegister(exprInfos); err != nil {
+			return nil, err
+		}
+	}
+	_, err = util.SafeExecute(func() any {
+		switch r.executeMode {
+		case ChunkVM:
+			program.setChunk(r.CompileIR(exprInfos))
+		case Closure:
+			program.closure = r.CompileClosure(exprInfos)
+		default:
+			program.exprInfos = exprInfos
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if program.chunk != nil {
+		if err := chk.Verify(program.chunk); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
+
+// checkFunctions 返回表达式调用的函数名，按名称排序。有未注册的函数时返回错误
+func checkFunctions(exprInfos []*ir.ExprInfo) ([]string, error) {
+	query := ir.NewFuncsQuery()
+	for _, info := range exprInfos {
+		query.Execute(info.GetExpr())
+	}
+	names := query.GetFunctions()
+	for _, name := range names {
+		if funmgr.GetFunctionManager().GetFunction(name) == nil {
+			return nil, fmt.Errorf("未定义的函数: %s", name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// setChunk 设置字节码，预先解码常量池、变量列表和调试信息，供各次执行共享
+func (p *Program) setChunk(chunk *chk.Chunk) {
+	p.chunk = chunk
+	p.reader = chk.NewChunkReader(chunk, nil)
+	p.reader.Preload()
+}
+
+// collectVariables 表达式读写的全部变量，按名称排序
+func collectVariables(exprInfos []*ir.ExprInfo) []string {
+	fields := collectFields(exprInfos)
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = field.GetName()
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (p *Program) GetExecuteMode() ExecuteMode {
+	return p.mode
+}
+
+func (p *Program) GetLimits() limits.Limits {
+	return p.limits
+}
+
+// GetSchema 编译时的变量声明，没有声明时为 nil
+func (p *Program) GetSchema() *schema.Schema {
+	return p.schema
+}
+
+// Len 表达式个数
+func (p *Program) Len() int {
+	return p.size
+}
+
+// GetVariables 表达式读写的全部变量，按名称排序
+func (p *Program) GetVariables() []string {
+	return slices.Clone(p.variables)
+}
+
+// GetFunctions 表达式调用的函数名，按名称排序
+func (p *Program) GetFunctions() []string {
+	return slices.Clone(p.functions)
+}
+
+// GetChunk 字节码，只有 ChunkVM 模式编译的程序有字节码，其他模式返回 nil。调用方不要修改
+func (p *Program) GetChunk() *chk.Chunk {
+	return p.chunk
+}
+
+// Run 在执行环境 ev 中执行程序，ev 为 nil 时使用新建的默认执行环境。可以并发调用，每次执行使用各自的状态。
+// 与 ExecuteBatchContext 一样，出错或被取消时返回错误以及已完成表达式的结果（未执行的位置为 nil）
+func (p *Program) Run(ctx context.Context, ev env.Environment) ([]any, error) {
+	return p.run(ctx, ev, instruments{})
+}
+
+// run 执行程序，inst 中不为 nil 的工具记录剖析、覆盖率和解释结果
+func (p *Program) run(ctx context.Context, ev env.Environment, inst instruments) ([]any, error) {
+	if ev == nil {
+		ev = env.NewDefaultEnvironment()
+	}
+	fields := make([]*util.Field, len(p.variables))
+	for i, name := range p.variables {
+		fields[i] = util.NewField(name)
+	}
+	if ok, err := beforeExecute(ev, fields, p.schema); !ok {
+		return nil, err
+	}
+
+	var exResults []*exec.ExResult
+	var err error
+	switch {
+	case p.chunk != nil:
+		vm, _ := p.vms.Get().(*exec.VM)
+		if vm == nil {
+			vm = exec.NewVM(nil)
+			vm.SetLimits(p.limits)
+		}
+		vm.SetDebugHook(nil)
+		inst.setupVM(vm)
+		exResults, err = vm.ExecuteWithReaderContext(ctx, p.reader.Fork(), ev)
+		p.vms.Put(vm)
+	case p.register != nil:
+		vm := exec.NewRegisterVM(nil)
+		vm.SetLimits(p.limits)
+		inst.setupRegisterVM(vm)
+		exResults, err = vm.ExecuteContext(ctx, p.register, ev)
+	case p.closure != nil:
+		exResults, err = inst.runClosure(ctx, p.closure, ev)
+	default:
+		return p.runIR(ctx, ev, inst)
+	}
+
+	result, err := collectResults(exResults, p.size, err)
+	if result != nil {
+		result = append(result, make([]any, p.size-len(result))...)
+	}
+	return result, err
+}
+
+// runIR 以语法树方式执行
+func (p *Program) runIR(ctx context.Context, ev env.Environment, inst instruments) ([]any, error) {
+	result := make([]any, p.size)
+	for _, info := range p.exprInfos {
+		if err := limits.CheckCanceled(ctx); err != nil {
+			return result, err
+		}
+		v, err := evaluate(info, ev, p.limits, inst)
+		if err != nil {
+			return result, err
+		}
+		result[info.GetIndex()] = v.GetValue()
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package gop_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/limits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var programModes = []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure}
+
+func TestProgram_MatchesExecuteBatch(t *testing.T) {
+	lines := testdata.GetExpressions(20)
+	expected, err := gop.NewGopRunner().ExecuteBatch(lines, testdata.GetEnv(20))
+	require.NoError(t, err)
+
+	for _, mode := range programModes {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		program, err := runner.Compile(lines)
+		require.NoError(t, err)
+		assert.Equal(t, mode, program.GetExecuteMode())
+		assert.Equal(t, len(lines), program.Len())
+		assert.Equal(t, mode == gop.ChunkVM, program.GetChunk() != nil)
+
+		for i := 0; i < 3; i++ {
+			ev := testdata.GetEnv(20)
+			result, err := program.Run(context.Background(), ev)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result, "模式 %d", mode)
+			testdata.CheckValues(t, ev, 20)
+		}
+	}
+}
+
+func TestProgram_ConcurrentRun(t *testing.T) {
+	for _, mode := range programModes {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		program, err := runner.Compile([]string{"z = y > 10", "y = abs(x) * 2 + 1"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"x", "y", "z"}, program.GetVariables())
+		assert.Equal(t, []string{"abs"}, program.GetFunctions())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int32) {
+				defer wg.Done()
+				ev := env.NewDefaultEnvironment()
+				ev.PutInt("x", -i)
+				result, err := program.Run(context.Background(), ev)
+				if assert.NoError(t, err) {
+					y := int(i)*2 + 1
+					assert.Equal(t, []any{y > 10, y}, result, "模式 %d", mode)
+				}
+			}(int32(i))
+		}
+		wg.Wait()
+	}
+}
+
+func TestProgram_Errors(t *testing.T) {
+	runner := gop.NewGopRunner()
+	_, err := runner.Compile([]string{"x = 1", "y = nofunc(x)"})
+	assert.ErrorContains(t, err, "未定义的函数: nofunc")
+	_, err = runner.Compile([]string{"x = (1"})
+	assert.Error(t, err)
+
+	for _, mode := range programModes {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetLimits(limits.Limits{MaxInstructions: 1000})
+		program, err := runner.Compile([]string{"x = 1", "y = x * (s - 1)", "z = y + 1"})
+		require.NoError(t, err)
+		assert.Equal(t, limits.Limits{MaxInstructions: 1000}, program.GetLimits())
+
+		ev := env.NewDefaultEnvironment()
+		ev.PutString("s", "abc")
+		result, err := program.Run(context.Background(), ev)
+		assert.Error(t, err, "模式 %d", mode)
+		assert.Equal(t, []any{1, nil, nil}, result, "模式 %d", mode)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = program.Run(ctx, nil)
+		assert.ErrorIs(t, err, limits.ErrCanceled, "模式 %d", mode)
+	}
+}
@@ -0,0 +1,179 @@
+package gop_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRegisterEnv 在 testdata 的执行环境中加入各种类型的变量
+func newRegisterEnv() env.Environment {
+	ev := testdata.GetEnv(20)
+	ev.Put("a", values.NewIntValue(3))
+	ev.Put("b", values.NewIntValue(4))
+	ev.Put("k", values.NewIntValue(3))
+	ev.Put("d", values.NewDoubleValue(2.5))
+	ev.Put("s", values.NewStringValue("abc"))
+	ev.Put("f", values.NewBooleanValue(false))
+	ev.Put("o", values.NewInstanceValue(*values.NewInstance()))
+	return ev
+}
+
+func TestRegisterVM_ResultsMatchOtherModes(t *testing.T) {
+	lines := append(testdata.GetExpressions(20),
+		"1000 + 100.0 * 99 - (600 - 3 * 15) / (((68 - 9) - 3) * 2 - 100) + 10000 % 7 * 71",
+		"-a + -d",
+		"!f && a <= b && a == 3 && !(d != 2.5) && !(a > b) && a >= 3",
+		"s + a + d",
+		"if(a > b, a, b) * if(!f, 2)",
+		"if(f, 1)",
+		"if(a < b && !f, abs(-a), 0)",
+		"a > 5 || b > 3",
+		"p = q = a * 2 + b",
+		"r = k + (k = 5)",
+		"u = (u = 1) + (u = 2)",
+		"o.x = a + 1",
+		"o.x * 2",
+		"o.y",
+		"abs(b - a * 2) + abs(-d)",
+	)
+	runner := gop.NewGopRunner()
+	expected, err := runner.ExecuteBatch(lines, newRegisterEnv())
+	require.NoError(t, err)
+
+	for _, mode := range []gop.ExecuteMode{gop.ChunkVM, gop.RegisterVM} {
+		for _, optimize := range []bool{false, true} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			runner.SetOptimize(optimize)
+			ev := newRegisterEnv()
+			result, err := runner.ExecuteBatch(lines, ev)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result, "模式 %d 优化 %v", mode, optimize)
+			testdata.CheckValues(t, ev, 20)
+			assert.Equal(t, 10, ev.Get("p").GetValue())
+			assert.Equal(t, 8, ev.Get("r").GetValue())
+			assert.Equal(t, 3, ev.Get("u").GetValue())
+		}
+	}
+}
+
+func TestRegisterVM_SetOnNonInstanceMatchesOtherModes(t *testing.T) {
+	// 先求对象并检查是否为实例，不是实例时不再求值：不执行其中的赋值，也不报告其中的错误
+	for _, line := range []string{"a.x = (c = 5)", "a.x = 1 / 0"} {
+		for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.RegisterVM, gop.Closure} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			ev := newRegisterEnv()
+			_, err := runner.ExecuteBatch([]string{line}, ev)
+			require.Error(t, err, "模式 %d：%s", mode, line)
+			assert.NotContains(t, err.Error(), "divide by zero", "模式 %d：%s", mode, line)
+			assert.Nil(t, ev.Get("c").GetValue(), "模式 %d：%s", mode, line)
+		}
+	}
+}
+
+func TestRegisterVM_LogicMatchesChunkVM(t *testing.T) {
+	// 逻辑运算的操作数不是布尔值时，两种虚拟机都返回决定结果的操作数
+	lines := []string{"a || b", "f || s", "a && s", "0 && a", "x = f || x", "y = a && y"}
+	chunkRunner := gop.NewGopRunner()
+	chunkRunner.SetExecuteMode(gop.ChunkVM)
+	ev := newRegisterEnv()
+	ev.Put("x", values.NewIntValue(7))
+	expected, err := chunkRunner.ExecuteBatch(lines, ev)
+	require.NoError(t, err)
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	ev = newRegisterEnv()
+	ev.Put("x", values.NewIntValue(7))
+	result, err := runner.ExecuteBatch(lines, ev)
+	require.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.Equal(t, 7, ev.Get("x").GetValue())
+	assert.True(t, ev.Get("y").IsNull())
+}
+
+func TestRegisterVM_FewerInstructions(t *testing.T) {
+	runner := gop.NewGopRunner()
+	exprs, err := runner.Parse(testdata.GetExpressions(10))
+	require.NoError(t, err)
+	exprInfos, err := runner.Analyze(exprs)
+	require.NoError(t, err)
+
+	instructions, err := chk.DecodeInstructions(runner.CompileIR(exprInfos).Codes)
+	require.NoError(t, err)
+	program, err := runner.CompileRegister(exprInfos)
+	require.NoError(t, err)
+	assert.Less(t, len(program.Code)*5, len(instructions)*3, "寄存器指令数应不到字节码的 60%%")
+}
+
+func TestRegisterVM_WritesBackAssignedVariablesOnly(t *testing.T) {
+	ev := newCountingEnv()
+	ev.PutInt("a", 1)
+	ev.puts = 0
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	result, err := runner.ExecuteBatch([]string{"if(a > 1, x = 1, y = 2)", "z = y + a", "a + 1"}, ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{2, 3, 2}, result)
+	assert.Zero(t, ev.puts)
+	assert.ElementsMatch(t, []string{"y", "z"}, ev.bulkPuts, "未执行的赋值不应写回")
+	assert.Equal(t, 3, ev.Size(), "x 不应写入执行环境")
+}
+
+func TestRegisterVM_ErrorsAndLimits(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	runner.SetDebugInfo(true)
+	ev := newRegisterEnv()
+	result, err := runner.ExecuteBatch([]string{"x = 1", "y = b * (s - 1)", "z = 3"}, ev)
+	var rerr *exec.RuntimeError
+	require.True(t, errors.As(err, &rerr), "%v", err)
+	assert.Equal(t, 1, rerr.Index)
+	assert.Equal(t, "(s - 1)", rerr.Snippet)
+	assert.Equal(t, []any{1, nil, nil}, result)
+	assert.Equal(t, 1, ev.Get("x").GetValue(), "出错前的赋值应写回")
+
+	deep := strings.Repeat("1 + (", 300) + "1" + strings.Repeat(")", 300)
+	runner = gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	_, err = runner.Execute(deep)
+	requireQuota(t, err, limits.QuotaStackDepth)
+	runner.SetLimits(limits.Limits{MaxStackDepth: 1024})
+	result1, err := runner.Execute(deep)
+	require.NoError(t, err)
+	assert.Equal(t, 301, result1)
+}
+
+func TestRegisterVM_CompileErrors(t *testing.T) {
+	for _, line := range []string{"abs(1, 2)", "foo(1)"} {
+		for _, parallelism := range []int{1, 4} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(gop.RegisterVM)
+			runner.SetParallelism(parallelism)
+			_, err := runner.ExecuteBatch([]string{"x = 1", line})
+			assert.Error(t, err, "%s 并发数 %d", line, parallelism)
+		}
+
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(gop.RegisterVM)
+		_, err := runner.Compile([]string{line})
+		assert.Error(t, err, line)
+	}
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.RegisterVM)
+	_, err := runner.ExecuteBatch([]string{"abs(1, 2)"})
+	assert.EqualError(t, err, "表达式 0 编译出错：参数数量不匹配")
+}
@@ -0,0 +1,81 @@
+package gop_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func orderSchema(t *testing.T) *schema.Schema {
+	s, err := schema.Parse([]byte(`{
+	  "coerce": true,
+	  "vars": [
+	    {"name": "price", "type": "Double", "required": true, "min": 0},
+	    {"name": "qty", "type": "Integer", "default": 1, "min": 1}
+	  ]
+	}`))
+	require.NoError(t, err)
+	return s
+}
+
+func TestSchema_ValidateBeforeExecute(t *testing.T) {
+	lines := []string{"total = price * qty", "count = qty + 1"}
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		for _, parallelism := range []int{1, 2} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(mode)
+			runner.SetParallelism(parallelism)
+			runner.SetSchema(orderSchema(t))
+
+			ev := env.NewDefaultEnvironment()
+			ev.Put("price", values.NewStringValue("2.5"))
+			result, err := runner.ExecuteBatch(lines, ev)
+			require.NoError(t, err, "模式 %s", mode)
+			assert.Equal(t, []any{2.5, 2}, result, "模式 %s", mode)
+
+			ev = env.NewDefaultEnvironment()
+			ev.Put("price", values.NewIntValue(-3))
+			ev.Put("qty", values.NewIntValue(0))
+			_, err = runner.ExecuteBatch(lines, ev)
+			var errs schema.ValidationErrors
+			require.ErrorAs(t, err, &errs, "模式 %s", mode)
+			assert.Len(t, errs, 2)
+			assert.True(t, ev.Get("total").IsNull(), "校验失败时不执行")
+		}
+	}
+}
+
+func TestSchema_UndeclaredAndProgram(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetSchema(orderSchema(t))
+	_, err := runner.ExecuteBatch([]string{"total = price * qty - discount", "x = total + tax"})
+	assert.EqualError(t, err, "未声明的输入变量：discount, tax")
+
+	runner.SetCache(gop.NewCompileCache(8))
+	program, err := runner.Compile([]string{"total = price * qty"})
+	require.NoError(t, err)
+	assert.Same(t, runner.GetSchema(), program.GetSchema())
+	_, err = program.Run(context.Background(), nil)
+	assert.EqualError(t, err, "输入变量 price：缺少必需的输入")
+
+	ev := env.NewDefaultEnvironment()
+	ev.Put("price", values.NewDoubleValue(4))
+	result, err := program.Run(context.Background(), ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{4.0}, result)
+
+	// 声明不同时不使用缓存中的程序
+	other := orderSchema(t)
+	other.GetVar("qty").SetDefault(values.NewIntValue(3))
+	runner.SetSchema(other)
+	again, err := runner.Compile([]string{"total = price * qty"})
+	require.NoError(t, err)
+	assert.NotSame(t, program, again)
+}
@@ -1,6 +1,9 @@
 package gop_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"fmt"
 	"os"
 	"testing"
@@ -8,10 +11,14 @@ import (
 
 	"github.com/simonwater/gopression/chk"
 	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/functions"
+	"github.com/simonwater/gopression/functions/funmgr"
 	"github.com/simonwater/gopression/gop"
 	"github.com/simonwater/gopression/gop/testdata"
 	"github.com/simonwater/gopression/ir"
 	fileutil "github.com/simonwater/gopression/util/files"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,7 +42,7 @@ func chunkSerializeTest(t *testing.T) {
 	start := time.Now()
 	exprs, err := runner.Parse(lines)
 	require.NoError(t, err, "解析失败")
-	exprInfos := runner.Analyze(exprs)
+	exprInfos, err := runner.Analyze(exprs)
 	require.NoError(t, err, "分析失败")
 	elapsed := time.Since(start)
 	fmt.Printf("中间结果生成完成。耗时: %s\n", elapsed)
@@ -95,16 +102,118 @@ func SyntaxTreeTest(t *testing.T, exprInfos []*ir.ExprInfo, ev env.Environment)
 }
 
 func writeChkFile(chunk *chk.Chunk, filePath string) error {
-	data := chunk.ToBytes()
 	fileutil.CreateParentIfNotExist(filePath)
-	return os.WriteFile(filePath, data, 0644)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if _, err := chunk.WriteTo(w); err != nil {
+		return err
+	}
+	return w.Flush()
 }
 
 func readChkFile(filePath string) (*chk.Chunk, error) {
-	bytes, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
-	chunk := chk.NewChunkWithBytes(bytes)
-	return chunk, nil
+	defer file.Close()
+	return chk.ReadChunk(file)
+}
+
+func TestChunkSerialization_RejectCorruptOrIncompatible(t *testing.T) {
+	chunk, err := gop.NewGopRunner().CompileSource([]string{"x = a + 1", "abs(x - 10)"})
+	require.NoError(t, err)
+	data := chunk.ToBytes()
+
+	restored, err := chk.NewChunkWithBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, chunk, restored)
+
+	// 截断
+	_, err = chk.NewChunkWithBytes(data[:len(data)-5])
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+	_, err = chk.NewChunkWithBytes(data[:8])
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+
+	// 内容被修改
+	damaged := append([]byte(nil), data...)
+	damaged[20] ^= 0xff
+	_, err = chk.NewChunkWithBytes(damaged)
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+
+	// 魔数不符
+	damaged = append([]byte(nil), data...)
+	damaged[0] = 'X'
+	_, err = chk.NewChunkWithBytes(damaged)
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+
+	// 函数注册表变化
+	fm := funmgr.GetFunctionManager()
+	fm.RegistFunction(newTestFunc("serialize_test_fn"))
+	defer fm.RemoveFunction("serialize_test_fn")
+	_, err = chk.NewChunkWithBytes(data)
+	require.ErrorIs(t, err, chk.ErrIncompatibleChunk)
+}
+
+func TestChunkSerialization_CompressedAndStreaming(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetDebugInfo(true)
+	chunk, err := runner.CompileSource(testdata.GetExpressions(200))
+	require.NoError(t, err)
+
+	var compressed bytes.Buffer
+	n, err := chunk.WriteCompressed(&compressed, flate.BestSpeed)
+	require.NoError(t, err)
+	assert.Equal(t, int64(compressed.Len()), n)
+	plain := chunk.ToBytes()
+	assert.Less(t, compressed.Len(), len(plain)/2)
+
+	restored, err := chk.NewChunkWithBytes(compressed.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, chunk, restored)
+
+	// 同一个流中连续读取多个字节码块
+	small, err := runner.CompileSource([]string{"a + 1"})
+	require.NoError(t, err)
+	var stream bytes.Buffer
+	_, err = chunk.WriteCompressed(&stream, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = small.WriteTo(&stream)
+	require.NoError(t, err)
+	_, err = small.WriteCompressed(&stream, flate.BestCompression)
+	require.NoError(t, err)
+	for _, expected := range []*chk.Chunk{chunk, small, small} {
+		actual, err := chk.ReadChunk(&stream)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+	assert.Zero(t, stream.Len())
+
+	// 压缩数据损坏或截断
+	damaged := append([]byte(nil), compressed.Bytes()...)
+	damaged[len(damaged)/2] ^= 0xff
+	_, err = chk.NewChunkWithBytes(damaged)
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+	_, err = chk.NewChunkWithBytes(compressed.Bytes()[:compressed.Len()-10])
+	require.ErrorIs(t, err, chk.ErrCorruptChunk)
+}
+
+type testFunc struct {
+	*functions.Function
+}
+
+func newTestFunc(name string) *testFunc {
+	return &testFunc{Function: functions.NewFunction(name, name, functions.SYSTEM_GROUP)}
+}
+
+func (f *testFunc) Arity() int {
+	return 0
+}
+
+func (f *testFunc) Call(arguments []values.Value) (values.Value, error) {
+	return values.NewNullValue(), nil
 }
@@ -0,0 +1,118 @@
+package gop_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/exec"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/gop/testdata"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingEnv 统计逐个访问和批量访问的次数
+type countingEnv struct {
+	*env.DefaultEnvironment
+	gets, puts int
+	bulkGets   int
+	bulkPuts   []string
+}
+
+func newCountingEnv() *countingEnv {
+	ev := &countingEnv{DefaultEnvironment: env.NewDefaultEnvironment()}
+	ev.BaseEnvironment = env.NewBaseEnvironment(ev)
+	return ev
+}
+
+func (ce *countingEnv) GetOrDefault(id string, defValue values.Value) values.Value {
+	ce.gets++
+	return ce.DefaultEnvironment.GetOrDefault(id, defValue)
+}
+
+func (ce *countingEnv) Put(id string, value values.Value) {
+	ce.puts++
+	ce.DefaultEnvironment.Put(id, value)
+}
+
+func (ce *countingEnv) GetValues(ids []string, dest []values.Value) {
+	ce.bulkGets++
+	ce.DefaultEnvironment.GetValues(ids, dest)
+}
+
+func (ce *countingEnv) PutValues(ids []string, vals []values.Value) {
+	ce.bulkPuts = append(ce.bulkPuts, ids...)
+	ce.DefaultEnvironment.PutValues(ids, vals)
+}
+
+func TestSlotAccess_ResultsMatchGlobalAccess(t *testing.T) {
+	const batches = 50
+	lines := testdata.GetExpressions(batches)
+	expected, err := gop.NewGopRunner().ExecuteBatch(lines, testdata.GetEnv(batches))
+	require.NoError(t, err)
+
+	for _, parallelism := range []int{1, 4} {
+		for _, optimize := range []bool{false, true} {
+			runner := gop.NewGopRunner()
+			runner.SetExecuteMode(gop.ChunkVM)
+			runner.SetSlotAccess(true)
+			runner.SetParallelism(parallelism)
+			runner.SetOptimize(optimize)
+			ev := testdata.GetEnv(batches)
+			result, err := runner.ExecuteBatch(lines, ev)
+			require.NoError(t, err)
+			assert.Equal(t, expected, result, "并发 %d 优化 %v", parallelism, optimize)
+			testdata.CheckValues(t, ev, batches)
+		}
+	}
+}
+
+func TestSlotAccess_BulkLoadAndDirtyWriteBack(t *testing.T) {
+	ev := newCountingEnv()
+	ev.PutInt("a", 1)
+	ev.PutInt("b", 2)
+	ev.puts = 0
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetSlotAccess(true)
+	result, err := runner.ExecuteBatch([]string{"x = a + b", "y = x * b", "a + b + x + y"}, ev)
+	require.NoError(t, err)
+	assert.Equal(t, []any{3, 6, 12}, result)
+
+	assert.Equal(t, 0, ev.gets, "按槽位访问时不应逐个读取变量")
+	assert.Equal(t, 0, ev.puts, "按槽位访问时不应逐个写入变量")
+	assert.Equal(t, 1, ev.bulkGets)
+	sort.Strings(ev.bulkPuts)
+	assert.Equal(t, []string{"x", "y"}, ev.bulkPuts, "只写回被赋值的变量")
+	assert.Equal(t, 6, ev.Get("y").GetValue())
+}
+
+func TestSlotAccess_WriteBackOnError(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetSlotAccess(true)
+	runner.SetNeedSort(false)
+	ev := env.NewDefaultEnvironment()
+	result, err := runner.ExecuteBatch([]string{"x = 1", "y = x / 0"}, ev)
+	require.Error(t, err)
+	assert.Equal(t, []any{1, nil}, result)
+	assert.Equal(t, 1, ev.Get("x").GetValue(), "出错前的赋值应写回")
+	assert.True(t, ev.Get("y").IsNull())
+}
+
+func TestSlotAccess_Disassemble(t *testing.T) {
+	runner := gop.NewGopRunner()
+	runner.SetSlotAccess(true)
+	chunk, err := runner.CompileSource([]string{"b = a + 1"})
+	require.NoError(t, err)
+
+	var output []string
+	require.NoError(t, exec.NewDisassembler(func(msg string) {
+		output = append(output, msg)
+	}).Execute(chunk))
+	assert.Regexp(t, `OP_GET_LOCAL\s+0\(a\)`, output[2])
+	assert.Regexp(t, `OP_SET_LOCAL\s+1\(b\)`, output[5])
+}
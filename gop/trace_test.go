@@ -0,0 +1,96 @@
+package gop_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/trace"
+	"github.com/simonwater/gopression/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace_Phases(t *testing.T) {
+	lines := []string{"x = 1 + 2", "y = x * 2"}
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		collector := trace.NewCollector()
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetTracer(trace.NewTracer(collector))
+		_, err := runner.ExecuteBatch(lines)
+		require.NoError(t, err)
+
+		var phases []string
+		for _, span := range collector.GetSpans("") {
+			phases = append(phases, span.Phase)
+		}
+		expected := []string{trace.PhaseParse, trace.PhaseAnalyze, trace.PhaseCompile, trace.PhaseExecute, trace.PhaseBatch}
+		if mode == gop.SyntaxTree {
+			expected = []string{trace.PhaseParse, trace.PhaseAnalyze, trace.PhaseExecute, trace.PhaseBatch}
+		}
+		assert.Equal(t, expected, phases, "模式 %s", mode)
+
+		batch := collector.GetSpans(trace.PhaseBatch)[0]
+		assert.Equal(t, 2, batch.Count)
+		assert.Equal(t, 1, batch.Depth)
+		modeAttr, _ := batch.GetAttr("mode")
+		assert.Equal(t, mode.String(), modeAttr)
+		for _, span := range collector.GetSpans(trace.PhaseExecute) {
+			assert.Equal(t, batch.SpanID, span.ParentID)
+		}
+	}
+}
+
+func TestTrace_ContextAndErrors(t *testing.T) {
+	collector := trace.NewCollector()
+	tracer := trace.NewTracer(collector)
+	ctx := trace.ContextWithTracer(context.Background(), tracer)
+	ctx, request := tracer.Start(ctx, "request", 0)
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetCache(gop.NewCompileCache(4))
+	_, err := runner.ExecuteBatchContext(ctx, []string{"x = 1 +"})
+	require.Error(t, err)
+	_, err = runner.ExecuteBatchContext(ctx, []string{"x = 1"})
+	require.NoError(t, err)
+	request.End()
+
+	batches := collector.GetSpans(trace.PhaseBatch)
+	require.Len(t, batches, 2, "使用 ctx 中的跟踪器")
+	assert.Equal(t, request.GetID(), batches[0].ParentID)
+	assert.Equal(t, 2, batches[0].Depth)
+	_, failed := batches[0].GetAttr("error")
+	assert.True(t, failed)
+	_, failed = batches[1].GetAttr("error")
+	assert.False(t, failed)
+	assert.Len(t, collector.GetSpans(trace.PhaseCompile), 2, "使用编译缓存时编译作为一个阶段")
+	assert.Len(t, collector.GetSpans(trace.PhaseExecute), 1)
+}
+
+func TestTrace_UtilTracerConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	tracer := util.NewTracerWithPrinter(func(message string) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	tracer.SetEnable(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tracer.StartTimerWithMsg("阶段 %d", j)
+				tracer.EndTimer("完成")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 8*100*2, count)
+}
@@ -0,0 +1,91 @@
+package gop_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeCheck_RejectsBeforeExecution(t *testing.T) {
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetTypeCheck(true)
+		ev := env.NewDefaultEnvironment()
+		_, err := runner.ExecuteBatch([]string{"x = 1", `y = "a" - x`}, ev)
+		var typeErrs ir.TypeErrors
+		require.ErrorAs(t, err, &typeErrs, "模式 %s", mode)
+		assert.Equal(t, `表达式 1 第 1 行第 5 列 "\"a\" - x" 类型错误：运算符 - 的操作数必须是数值，实际为 String`, err.Error())
+		assert.False(t, ev.Get("x").IsNumber(), "有类型错误时不执行任何表达式")
+	}
+
+	runner := gop.NewGopRunner()
+	runner.SetCache(gop.NewCompileCache(8))
+	runner.SetExecuteMode(gop.ChunkVM)
+	_, err := runner.ExecuteBatch([]string{`"a" - 1`})
+	assert.ErrorContains(t, err, "operands must be numbers")
+	runner.SetTypeCheck(true)
+	_, err = runner.ExecuteBatch([]string{`"a" - 1`})
+	assert.ErrorContains(t, err, "类型错误", "类型检查计入缓存的键")
+}
+
+func TestTypeCheck_SpecializedOpcodes(t *testing.T) {
+	s := schema.NewSchema()
+	s.Declare("price", values.Vt_Double)
+	s.Declare("qty", values.Vt_Integer)
+	lines := []string{
+		"total = price * qty",
+		"count = qty + qty * 2 - 1",
+		"avg = total / count",
+		"rest = qty / 2",
+	}
+
+	runner := gop.NewGopRunner()
+	runner.SetExecuteMode(gop.ChunkVM)
+	runner.SetSchema(s)
+	chunk, err := runner.CompileSource(lines)
+	require.NoError(t, err)
+	instructions, err := chk.DecodeInstructions(chunk.Codes)
+	require.NoError(t, err)
+	used := make(map[chk.OpCode]bool)
+	for _, ins := range instructions {
+		used[ins.Op] = true
+	}
+	for _, op := range []chk.OpCode{chk.OP_MULTIPLY_DOUBLE, chk.OP_ADD_INT, chk.OP_MULTIPLY_INT, chk.OP_SUBTRACT_INT, chk.OP_DIVIDE_DOUBLE, chk.OP_DIVIDE} {
+		assert.True(t, used[op], op.Title())
+	}
+	assert.False(t, used[chk.OP_MULTIPLY])
+
+	// 整数可以作为声明为 Double 的输入，执行时按通用运算处理，结果与不做类型检查时相同
+	for _, vals := range [][2]values.Value{
+		{values.NewDoubleValue(2.5), values.NewIntValue(4)},
+		{values.NewIntValue(3), values.NewIntValue(4)},
+		{values.NewIntValue(3), values.NewIntValue(0)},
+	} {
+		newEnv := func() *env.DefaultEnvironment {
+			ev := env.NewDefaultEnvironment()
+			ev.Put("price", vals[0])
+			ev.Put("qty", vals[1])
+			return ev
+		}
+		plain := gop.NewGopRunner()
+		plain.SetExecuteMode(gop.ChunkVM)
+		expected, expectedErr := plain.ExecuteBatch(lines, newEnv())
+		actual, err := runner.ExecuteBatch(lines, newEnv())
+		assert.Equal(t, expected, actual, "%v", vals)
+		assert.Equal(t, expectedErr, err, "%v", vals)
+	}
+
+	ev := env.NewDefaultEnvironment()
+	ev.Put("price", values.NewDoubleValue(1.5))
+	ev.Put("qty", values.NewDoubleValue(2.5))
+	_, err = runner.ExecuteBatch(lines, ev)
+	assert.EqualError(t, err, "输入变量 qty：类型应为 Integer，实际为 Double", "与声明不符的输入在执行前拒绝")
+}
@@ -0,0 +1,326 @@
+package gop
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+	"github.com/simonwater/gopression/visitors"
+)
+
+// Workbook 长期持有公式的依赖图。输入变量变化时沿依赖图只重算受影响的公式，
+// 支持单独增加、删除、修改公式而无需重建整张图。公式以语法树方式执行，同一变量只允许一个公式赋值。
+type Workbook struct {
+	env      env.Environment
+	formulas map[int]*ir.ExprInfo // 公式编号 -> 表达式信息，表达式信息的索引即公式编号
+	sources  map[int]string
+	results  map[int]any
+	nodeSet  *util.NodeSet[*ir.ExprInfo] // 变量节点，节点信息为对该变量赋值的公式
+	graph    *util.Digraph               // 变量依赖图：被读取的变量 -> 被赋值的变量
+	readers  map[string]map[int]bool     // 变量 -> 读取该变量的公式编号
+	limits   limits.Limits
+	nextId   int
+}
+
+func NewWorkbook(ev env.Environment) *Workbook {
+	if ev == nil {
+		ev = env.NewDefaultEnvironment()
+	}
+	return &Workbook{
+		env:      ev,
+		formulas: make(map[int]*ir.ExprInfo),
+		sources:  make(map[int]string),
+		results:  make(map[int]any),
+		nodeSet:  util.NewNodeSet[*ir.ExprInfo](),
+		graph:    util.NewDigraph(0),
+		readers:  make(map[string]map[int]bool),
+	}
+}
+
+func (wb *Workbook) GetEnv() env.Environment {
+	return wb.env
+}
+
+func (wb *Workbook) GetLimits() limits.Limits {
+	return wb.limits
+}
+
+// SetLimits 设置公式计算的资源限额
+func (wb *Workbook) SetLimits(limits limits.Limits) {
+	wb.limits = limits
+}
+
+// Size 公式数量
+func (wb *Workbook) Size() int {
+	return len(wb.formulas)
+}
+
+// GetIds 获取所有公式编号，升序
+func (wb *Workbook) GetIds() []int {
+	ids := make([]int, 0, len(wb.formulas))
+	for id := range wb.formulas {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (wb *Workbook) GetSource(id int) string {
+	return wb.sources[id]
+}
+
+// GetResult 获取公式最近一次的计算结果
+func (wb *Workbook) GetResult(id int) any {
+	return wb.results[id]
+}
+
+// AddFormula 添加公式并计算该公式及依赖它的公式，返回公式编号。
+// 公式已加入但计算出错时同时返回编号和错误，添加失败时编号为 -1
+func (wb *Workbook) AddFormula(src string) (int, error) {
+	ids, err := wb.AddFormulas([]string{src})
+	if len(ids) == 0 {
+		return -1, err
+	}
+	return ids[0], err
+}
+
+// AddFormulas 批量添加公式，全部加入依赖图后统一计算一次。
+// 解析失败、重复赋值或循环引用时不添加任何公式；计算出错时公式已加入，同时返回编号和错误
+func (wb *Workbook) AddFormulas(srcs []string) ([]int, error) {
+	infos := make([]*ir.ExprInfo, len(srcs))
+	for i, src := range srcs {
+		info, err := wb.analyze(src, wb.nextId+i)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = info
+	}
+
+	ids := make([]int, 0, len(infos))
+	for i, info := range infos {
+		if err := wb.link(info, srcs[i]); err != nil {
+			for _, id := range ids {
+				wb.unlink(id)
+			}
+			return nil, err
+		}
+		ids = append(ids, info.GetIndex())
+	}
+	wb.nextId += len(infos)
+
+	if _, err := wb.recalculate(ids, collectSuccessors(infos)); err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// EditFormula 修改公式，重算该公式及依赖它的公式。修改失败时公式保持不变
+func (wb *Workbook) EditFormula(id int, src string) error {
+	old, ok := wb.formulas[id]
+	if !ok {
+		return fmt.Errorf("公式不存在：%d", id)
+	}
+	info, err := wb.analyze(src, id)
+	if err != nil {
+		return err
+	}
+
+	oldSrc, oldResult := wb.sources[id], wb.results[id]
+	wb.unlink(id)
+	if err := wb.link(info, src); err != nil {
+		if linkErr := wb.link(old, oldSrc); linkErr != nil {
+			return errors.Join(err, linkErr)
+		}
+		wb.results[id] = oldResult
+		return err
+	}
+
+	_, err = wb.recalculate([]int{id}, collectSuccessors([]*ir.ExprInfo{info}))
+	return err
+}
+
+// RemoveFormula 删除公式。依赖该公式赋值变量的公式保留环境中的当前值，不会重算
+func (wb *Workbook) RemoveFormula(id int) error {
+	if _, ok := wb.formulas[id]; !ok {
+		return fmt.Errorf("公式不存在：%d", id)
+	}
+	wb.unlink(id)
+	return nil
+}
+
+// Update 输入变量发生变化后，沿依赖图重算受影响的公式，返回被重算公式的结果
+func (wb *Workbook) Update(changed ...string) (map[int]any, error) {
+	return wb.recalculate(nil, changed)
+}
+
+// Recalculate 按依赖顺序重算全部公式
+func (wb *Workbook) Recalculate() (map[int]any, error) {
+	return wb.recalculate(wb.GetIds(), nil)
+}
+
+// recalculate 重算指定公式以及所有（直接或间接）读取 changed 变量的公式
+func (wb *Workbook) recalculate(ids []int, changed []string) (map[int]any, error) {
+	affected := make(map[int]bool)
+	for _, id := range ids {
+		affected[id] = true
+	}
+
+	sources := make([]int, 0, len(changed))
+	for _, name := range changed {
+		if node := wb.nodeSet.GetNodeByName(name); node != nil {
+			sources = append(sources, node.Index)
+		}
+	}
+	if len(sources) > 0 {
+		marked := wb.graph.Reachable(sources...)
+		for v, reached := range marked {
+			if !reached {
+				continue
+			}
+			for id := range wb.readers[wb.nodeSet.GetNodeByIndex(v).Name] {
+				affected[id] = true
+			}
+		}
+	}
+
+	infos := make([]*ir.ExprInfo, 0, len(affected))
+	for _, id := range wb.GetIds() {
+		if affected[id] {
+			infos = append(infos, wb.formulas[id])
+		}
+	}
+	sorted, err := ir.SortExprInfos(infos, wb.writersOf)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]any, len(sorted))
+	for _, info := range sorted {
+		if err := wb.limits.CheckCallDepth(ir.NewCallDepthQuery().Execute(info.GetExpr())); err != nil {
+			return result, fmt.Errorf("公式 %d 计算出错：%w", info.GetIndex(), err)
+		}
+		evaluator := visitors.NewEvaluator(wb.env)
+		evaluator.SetLimits(wb.limits)
+		v, err := util.SafeExecute(func() values.Value {
+			return evaluator.Execute(info.GetExpr())
+		})
+		if err != nil {
+			return result, fmt.Errorf("公式 %d 计算出错：%w", info.GetIndex(), err)
+		}
+		wb.results[info.GetIndex()] = v.GetValue()
+		result[info.GetIndex()] = v.GetValue()
+	}
+	return result, nil
+}
+
+func (wb *Workbook) analyze(src string, id int) (*ir.ExprInfo, error) {
+	expr, err := parser.NewParser(src).Parse()
+	if err != nil {
+		return nil, err
+	}
+	info := ir.NewExprInfo(expr, id)
+	info.SetSource(src)
+	return info, nil
+}
+
+// link 将公式加入依赖图，存在重复赋值或循环引用时返回错误且不修改依赖图
+func (wb *Workbook) link(info *ir.ExprInfo, src string) error {
+	id := info.GetIndex()
+	for name := range info.GetSuccessors() {
+		if writers := wb.writersOf(name); len(writers) > 0 {
+			indexes := []int{writers[0].GetIndex(), id}
+			sort.Ints(indexes)
+			return &ir.AssignConflictError{Name: name, Indexes: indexes}
+		}
+		if info.GetPrecursors()[name] {
+			return fmt.Errorf("公式 %d 存在循环引用：%s", id, name)
+		}
+	}
+
+	// 被赋值变量能到达依赖的变量时，新增的边会形成环
+	succs := make([]int, 0)
+	for name := range info.GetSuccessors() {
+		if node := wb.nodeSet.GetNodeByName(name); node != nil {
+			succs = append(succs, node.Index)
+		}
+	}
+	if len(succs) > 0 {
+		marked := wb.graph.Reachable(succs...)
+		for name := range info.GetPrecursors() {
+			if node := wb.nodeSet.GetNodeByName(name); node != nil && marked[node.Index] {
+				return fmt.Errorf("公式 %d 存在循环引用：%s", id, name)
+			}
+		}
+	}
+
+	wb.formulas[id] = info
+	wb.sources[id] = src
+	for name := range info.GetPrecursors() {
+		wb.addNode(name)
+		if wb.readers[name] == nil {
+			wb.readers[name] = make(map[int]bool)
+		}
+		wb.readers[name][id] = true
+	}
+	for name := range info.GetSuccessors() {
+		wb.addNode(name).Info = info
+	}
+	for prec := range info.GetPrecursors() {
+		u := wb.nodeSet.GetNodeByName(prec).Index
+		for succ := range info.GetSuccessors() {
+			wb.graph.AddEdge(u, wb.nodeSet.GetNodeByName(succ).Index)
+		}
+	}
+	return nil
+}
+
+// unlink 将公式从依赖图中移除，变量节点保留
+func (wb *Workbook) unlink(id int) {
+	info := wb.formulas[id]
+	for prec := range info.GetPrecursors() {
+		u := wb.nodeSet.GetNodeByName(prec).Index
+		for succ := range info.GetSuccessors() {
+			wb.graph.RemoveEdge(u, wb.nodeSet.GetNodeByName(succ).Index)
+		}
+		delete(wb.readers[prec], id)
+	}
+	for succ := range info.GetSuccessors() {
+		wb.nodeSet.GetNodeByName(succ).Info = nil
+	}
+	delete(wb.formulas, id)
+	delete(wb.sources, id)
+	delete(wb.results, id)
+}
+
+func (wb *Workbook) addNode(name string) *util.Node[*ir.ExprInfo] {
+	if node := wb.nodeSet.GetNodeByName(name); node != nil {
+		return node
+	}
+	node := wb.nodeSet.AddNode(name)
+	wb.graph.AddVertex()
+	return node
+}
+
+func (wb *Workbook) writersOf(name string) []*ir.ExprInfo {
+	node := wb.nodeSet.GetNodeByName(name)
+	if node == nil || node.Info == nil {
+		return nil
+	}
+	return []*ir.ExprInfo{node.Info}
+}
+
+func collectSuccessors(infos []*ir.ExprInfo) []string {
+	names := make([]string, 0)
+	for _, info := range infos {
+		for name := range info.GetSuccessors() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
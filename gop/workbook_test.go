@@ -0,0 +1,97 @@
+package gop_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/gop"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkbook_IncrementalUpdate(t *testing.T) {
+	ev := env.NewDefaultEnvironment()
+	ev.PutInt("m", 2)
+	ev.PutInt("n", 4)
+	ev.PutInt("w", 6)
+	ev.PutInt("k", 1)
+
+	wb := gop.NewWorkbook(ev)
+	ids, err := wb.AddFormulas([]string{
+		"x = a + b * c",
+		"a = m + n",
+		"b = a * 2",
+		"c = n + w",
+		"z = k * 10",
+		"x + z",
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 6)
+	assert.Equal(t, 126, wb.GetResult(ids[0]))
+	assert.Equal(t, 136, wb.GetResult(ids[5]))
+
+	// m 只影响 a、b、x 及读取 x 的公式
+	ev.PutInt("m", 3)
+	changed, err := wb.Update("m")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]any{ids[0]: 147, ids[1]: 7, ids[2]: 14, ids[5]: 157}, changed)
+
+	// 未被任何公式读取的变量不触发计算
+	changed, err = wb.Update("unknown")
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	ev.PutInt("k", 2)
+	changed, err = wb.Update("k")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]any{ids[4]: 20, ids[5]: 167}, changed)
+}
+
+func TestWorkbook_EditAndRemove(t *testing.T) {
+	ev := env.NewDefaultEnvironment()
+	ev.PutInt("m", 2)
+	ev.PutInt("x", 0)
+
+	wb := gop.NewWorkbook(ev)
+	y, err := wb.AddFormula("y = x * 2")
+	require.NoError(t, err)
+	x, err := wb.AddFormula("x = m + 1")
+	require.NoError(t, err)
+	assert.Equal(t, 6, wb.GetResult(y), "添加 x 的赋值公式后应重算 y")
+
+	require.NoError(t, wb.EditFormula(x, "x = m * 10"))
+	assert.Equal(t, 20, wb.GetResult(x))
+	assert.Equal(t, 40, wb.GetResult(y))
+	assert.Equal(t, "x = m * 10", wb.GetSource(x))
+
+	// 修改后形成循环引用，公式保持不变
+	err = wb.EditFormula(x, "x = y + 1")
+	require.Error(t, err)
+	assert.Equal(t, "x = m * 10", wb.GetSource(x))
+	assert.Equal(t, 20, wb.GetResult(x))
+
+	// 重复赋值
+	_, err = wb.AddFormula("x = 1")
+	var conflict *ir.AssignConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "x", conflict.Name)
+
+	require.NoError(t, wb.RemoveFormula(x))
+	assert.Equal(t, 1, wb.Size())
+	ev.PutInt("m", 100)
+	changed, err := wb.Update("m")
+	require.NoError(t, err)
+	assert.Empty(t, changed, "删除公式后 m 不再影响 y")
+
+	ev.Put("x", values.NewIntValue(7))
+	changed, err = wb.Update("x")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]any{y: 14}, changed)
+
+	_, err = wb.AddFormula("x = y + 1")
+	require.Error(t, err, "循环引用应报错")
+	_, err = wb.AddFormula("q = q + 1")
+	require.Error(t, err, "自引用应报错")
+}
@@ -0,0 +1,66 @@
+package ir
+
+import "github.com/simonwater/gopression/ir/exprs"
+
+// CallDepthQuery 计算表达式中函数调用的最大嵌套层数，按语法结构计算，不论分支是否执行。
+// 不含调用的表达式为 0，abs(x) 为 1，abs(abs(x)) 为 2
+type CallDepthQuery struct {
+	*BaseVisitor[int]
+}
+
+func NewCallDepthQuery() *CallDepthQuery {
+	q := &CallDepthQuery{}
+	q.BaseVisitor = NewBaseVisitor(q)
+	return q
+}
+
+func (q *CallDepthQuery) Execute(expr exprs.Expr) int {
+	if expr == nil {
+		return 0
+	}
+	return q.Accept(expr)
+}
+
+func (q *CallDepthQuery) VisitBinary(expr *exprs.BinaryExpr) int {
+	return max(q.Execute(expr.Left), q.Execute(expr.Right))
+}
+
+func (q *CallDepthQuery) VisitLogic(expr *exprs.LogicExpr) int {
+	return max(q.Execute(expr.Left), q.Execute(expr.Right))
+}
+
+func (q *CallDepthQuery) VisitLiteral(expr *exprs.LiteralExpr) int {
+	return 0
+}
+
+func (q *CallDepthQuery) VisitUnary(expr *exprs.UnaryExpr) int {
+	return q.Execute(expr.Right)
+}
+
+func (q *CallDepthQuery) VisitId(expr *exprs.IdExpr) int {
+	return 0
+}
+
+func (q *CallDepthQuery) VisitAssign(expr *exprs.AssignExpr) int {
+	return max(q.Execute(expr.Left), q.Execute(expr.Right))
+}
+
+func (q *CallDepthQuery) VisitCall(expr *exprs.CallExpr) int {
+	depth := q.Execute(expr.Callee)
+	for _, arg := range expr.Args {
+		depth = max(depth, q.Execute(arg))
+	}
+	return depth + 1
+}
+
+func (q *CallDepthQuery) VisitIf(expr *exprs.IfExpr) int {
+	return max(q.Execute(expr.Condition), q.Execute(expr.ThenBranch), q.Execute(expr.ElseBranch))
+}
+
+func (q *CallDepthQuery) VisitGet(expr *exprs.GetExpr) int {
+	return q.Execute(expr.Object)
+}
+
+func (q *CallDepthQuery) VisitSet(expr *exprs.SetExpr) int {
+	return max(q.Execute(expr.Object), q.Execute(expr.Value))
+}
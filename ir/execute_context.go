@@ -1,16 +1,30 @@
 package ir
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/simonwater/gopression/util"
 )
 
+// AssignConflictError 多个表达式对同一变量赋值
+type AssignConflictError struct {
+	Name    string
+	Indexes []int
+}
+
+func (e *AssignConflictError) Error() string {
+	return fmt.Sprintf("变量 %s 被多个表达式赋值，表达式索引：%v", e.Name, e.Indexes)
+}
+
 type ExecuteContext struct {
 	exprInfos []*ExprInfo
 	nodeSet   *util.NodeSet[*ExprInfo]
 	graph     *util.Digraph
+	writers   map[string][]*ExprInfo // 变量 -> 对其赋值的表达式，按表达式索引升序
+	conflicts []*AssignConflictError
 	global    *GopContext
 }
 
@@ -36,11 +50,34 @@ func (ec *ExecuteContext) HasAssign() bool {
 	return ec.graph != nil && ec.graph.V > 0
 }
 
-func (ec *ExecuteContext) PreExecute(exprInfos []*ExprInfo) {
+// GetWriters 获取对变量赋值的所有表达式，按表达式索引升序
+func (ec *ExecuteContext) GetWriters(name string) []*ExprInfo {
+	return ec.writers[name]
+}
+
+// GetConflicts 获取被多个表达式赋值的变量冲突信息，按变量名排序
+func (ec *ExecuteContext) GetConflicts() []*AssignConflictError {
+	return ec.conflicts
+}
+
+// PreExecute 构造变量依赖图。赋值策略为 AssignError 且存在多个表达式对同一变量赋值时返回错误
+func (ec *ExecuteContext) PreExecute(exprInfos []*ExprInfo) error {
 	ec.nodeSet = util.NewNodeSet[*ExprInfo]()
+	ec.writers = make(map[string][]*ExprInfo)
+	ec.conflicts = nil
 	ec.exprInfos = exprInfos
 	ec.initNodes()
 	ec.initGraph()
+	ec.checkConflicts()
+
+	if len(ec.conflicts) > 0 && ec.global.GetAssignPolicy() == AssignError {
+		errs := make([]error, len(ec.conflicts))
+		for i, conflict := range ec.conflicts {
+			errs[i] = conflict
+		}
+		return errors.Join(errs...)
+	}
+	return nil
 }
 
 func (ec *ExecuteContext) initNodes() {
@@ -53,18 +90,15 @@ func (ec *ExecuteContext) initNodes() {
 		}
 
 		// 添加前置节点
-		for name := range exprInfo.GetPrecursors() {
+		for _, name := range sortedNames(exprInfo.GetPrecursors()) {
 			ec.nodeSet.AddNode(name)
 		}
 
-		// 添加后继节点并关联表达式信息
-		first := true
-		for name := range exprInfo.GetSuccessors() {
+		// 添加后继节点并关联表达式信息，多个表达式赋值同一变量时节点关联最后一个
+		for _, name := range sortedNames(exprInfo.GetSuccessors()) {
 			node := ec.nodeSet.AddNode(name)
-			if first {
-				node.Info = exprInfo
-				first = false
-			}
+			node.Info = exprInfo
+			ec.writers[name] = append(ec.writers[name], exprInfo)
 		}
 	}
 
@@ -103,6 +137,25 @@ func (ec *ExecuteContext) initGraph() {
 	tracer.EndTimer("完成图的构造。")
 }
 
+func (ec *ExecuteContext) checkConflicts() {
+	names := make([]string, 0)
+	for name, infos := range ec.writers {
+		if len(infos) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		infos := ec.writers[name]
+		indexes := make([]int, len(infos))
+		for i, info := range infos {
+			indexes[i] = info.GetIndex()
+		}
+		ec.conflicts = append(ec.conflicts, &AssignConflictError{Name: name, Indexes: indexes})
+	}
+}
+
 func (ec *ExecuteContext) PrintGraph() string {
 	if ec.graph == nil {
 		return "图未初始化\n"
@@ -130,3 +183,12 @@ func (ec *ExecuteContext) PrintGraph() string {
 
 	return builder.String()
 }
+
+func sortedNames(names map[string]bool) []string {
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
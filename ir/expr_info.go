@@ -7,6 +7,7 @@ type ExprInfo struct {
 	successors map[string]bool // 被赋值的变量 write
 	expr       exprs.Expr
 	index      int
+	source     string // 表达式源码，编译调试信息时使用，可以为空
 }
 
 func NewExprInfo(e exprs.Expr, idx int) *ExprInfo {
@@ -29,7 +30,11 @@ func (ei *ExprInfo) initVariables() {
 	}
 }
 
+// IsAssign 表达式是否对变量赋值，包括嵌套在子表达式中的赋值，如 if(c, x = 1)
 func (ei *ExprInfo) IsAssign() bool {
+	if len(ei.successors) > 0 {
+		return true
+	}
 	_, isAssign := ei.expr.(*exprs.AssignExpr)
 	_, isSet := ei.expr.(*exprs.SetExpr)
 	return isAssign || isSet
@@ -60,6 +65,14 @@ func (ei *ExprInfo) SetExpr(e exprs.Expr) {
 	ei.expr = e
 }
 
+func (ei *ExprInfo) GetSource() string {
+	return ei.source
+}
+
+func (ei *ExprInfo) SetSource(source string) {
+	ei.source = source
+}
+
 func (ei *ExprInfo) GetIndex() int {
 	return ei.index
 }
@@ -0,0 +1,60 @@
+package ir
+
+import "strings"
+
+// Levelize 将表达式列表划分为若干层，同一层内的表达式互不依赖，可以并发执行。
+// 逐层执行的结果与按列表顺序依次执行一致：读取变量的表达式排在之前对它赋值的表达式之后，
+// 对变量赋值的表达式排在之前读取或赋值该变量的表达式之后。层内表达式保持在列表中的相对顺序。
+// 属性路径（如 t1.x）按根对象名（t1）判断冲突，因为同一对象的属性共享同一个实例。
+func Levelize(infos []*ExprInfo) [][]*ExprInfo {
+	lastWrite := make(map[string]int) // 变量 -> 最近一次赋值所在层
+	lastRead := make(map[string]int)  // 变量 -> 最近一次赋值之后读取它的最高层
+	levels := make([][]*ExprInfo, 0)
+
+	for _, info := range infos {
+		reads := rootNames(info.GetPrecursors())
+		writes := rootNames(info.GetSuccessors())
+
+		level := 0
+		for name := range reads {
+			if l, ok := lastWrite[name]; ok && l+1 > level {
+				level = l + 1
+			}
+		}
+		for name := range writes {
+			if l, ok := lastWrite[name]; ok && l+1 > level {
+				level = l + 1
+			}
+			if l, ok := lastRead[name]; ok && l+1 > level {
+				level = l + 1
+			}
+		}
+
+		for name := range reads {
+			if l, ok := lastRead[name]; !ok || level > l {
+				lastRead[name] = level
+			}
+		}
+		for name := range writes {
+			lastWrite[name] = level
+			delete(lastRead, name)
+		}
+
+		if level == len(levels) {
+			levels = append(levels, make([]*ExprInfo, 0))
+		}
+		levels[level] = append(levels[level], info)
+	}
+	return levels
+}
+
+func rootNames(names map[string]bool) map[string]bool {
+	result := make(map[string]bool, len(names))
+	for name := range names {
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[:i]
+		}
+		result[name] = true
+	}
+	return result
+}
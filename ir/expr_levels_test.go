@@ -0,0 +1,39 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelize(t *testing.T) {
+	srcs := []string{
+		"a = m + n",
+		"c = n + w",
+		"b = a * 2",
+		"x = a + b * c",
+		"m + n",
+		"n = 1",
+		"t.x = 1",
+		"t.y = 2",
+	}
+
+	context := ir.NewGopContext()
+	exprs := parse(srcs, context)
+	infos := make([]*ir.ExprInfo, len(exprs))
+	for i, expr := range exprs {
+		infos[i] = ir.NewExprInfo(expr, i)
+	}
+
+	levels := ir.Levelize(infos)
+	indexes := make([][]int, len(levels))
+	for i, level := range levels {
+		for _, info := range level {
+			indexes[i] = append(indexes[i], info.GetIndex())
+		}
+	}
+
+	// n = 1 必须在读取 n 的表达式之后；同一对象的属性赋值不能并发
+	assert.Equal(t, [][]int{{0, 1, 4, 6}, {2, 5, 7}, {3}}, indexes)
+}
@@ -2,50 +2,47 @@ package ir
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/simonwater/gopression/util"
 )
 
 type ExprSorter struct {
-	nodeSet *util.NodeSet[*ExprInfo]
-	graph   *util.Digraph
 	context *GopContext
 }
 
 func NewExprSorter(context *GopContext) *ExprSorter {
-	execContext := context.GetExecContext()
-	return &ExprSorter{
-		context: context,
-		nodeSet: execContext.GetNodeSet(),
-		graph:   execContext.GetGraph(),
-	}
+	return &ExprSorter{context: context}
 }
 
+// Sort 按变量依赖关系对表达式排序，赋值表达式在前，其余表达式保持原顺序排在最后。
+// 多目标赋值（a = b = 1）排在所有读取 a 或 b 的表达式之前；
+// 同一变量有多个赋值表达式时（AssignLastWins），赋值表达式按索引顺序执行，读取该变量的表达式排在它们之后。
 func (es *ExprSorter) Sort() ([]*ExprInfo, error) {
-	if es.graph == nil || es.graph.V == 0 {
+	execContext := es.context.GetExecContext()
+	if !execContext.HasAssign() {
 		return nil, nil
 	}
 
 	tracer := es.context.GetTracer()
 	tracer.StartTimer()
 
-	topSorter := util.NewTopologicalSort(es.graph)
-
-	if !topSorter.Sort() {
-		return nil, errors.New("公式列表存在循环引用！")
+	origInfos := execContext.GetExprInfos()
+	assigns := make([]*ExprInfo, 0, len(origInfos))
+	for _, info := range origInfos {
+		if info.IsAssign() {
+			assigns = append(assigns, info)
+		}
 	}
 
-	nodeOrders := topSorter.GetOrders()
-	result := make([]*ExprInfo, 0, len(nodeOrders))
-
-	for _, nodeIndex := range nodeOrders {
-		node := es.nodeSet.GetNodeByIndex(nodeIndex)
-		if node.Info != nil {
-			result = append(result, node.Info)
-		}
+	sortedAssigns, err := SortExprInfos(assigns, execContext.GetWriters)
+	if err != nil {
+		tracer.EndTimer("拓扑排序失败。")
+		return nil, err
 	}
 
-	origInfos := es.context.GetExecContext().GetExprInfos()
+	result := make([]*ExprInfo, 0, len(origInfos))
+	result = append(result, sortedAssigns...)
 	for _, expr := range origInfos {
 		if !expr.IsAssign() {
 			result = append(result, expr)
@@ -56,6 +53,63 @@ func (es *ExprSorter) Sort() ([]*ExprInfo, error) {
 	return result, nil
 }
 
+// SortExprInfos 对一组表达式按依赖关系做拓扑排序，无依赖关系的表达式保持原有顺序。
+// writersOf 返回对变量赋值的表达式（按执行先后顺序），不在 infos 中的表达式被忽略。
+func SortExprInfos(infos []*ExprInfo, writersOf func(name string) []*ExprInfo) ([]*ExprInfo, error) {
+	positions := make(map[*ExprInfo]int, len(infos))
+	for i, info := range infos {
+		positions[info] = i
+	}
+
+	graph := util.NewDigraph(len(infos))
+	for v, info := range infos {
+		preds := make(map[int]bool)
+		// 依赖的变量的所有赋值表达式都要先执行
+		for name := range info.GetPrecursors() {
+			for _, writer := range writersOf(name) {
+				if u, ok := positions[writer]; ok && u != v {
+					preds[u] = true
+				}
+			}
+		}
+		// 同一变量的多个赋值表达式按顺序执行，保证最后一个生效
+		for name := range info.GetSuccessors() {
+			var prev *ExprInfo
+			for _, writer := range writersOf(name) {
+				if writer == info {
+					break
+				}
+				if _, ok := positions[writer]; ok {
+					prev = writer
+				}
+			}
+			if prev != nil {
+				preds[positions[prev]] = true
+			}
+		}
+
+		us := make([]int, 0, len(preds))
+		for u := range preds {
+			us = append(us, u)
+		}
+		sort.Ints(us)
+		for _, u := range us {
+			graph.AddEdge(u, v)
+		}
+	}
+
+	topSorter := util.NewTopologicalSort(graph)
+	if !topSorter.Sort() {
+		return nil, errors.New("公式列表存在循环引用！")
+	}
+
+	result := make([]*ExprInfo, 0, len(infos))
+	for _, v := range topSorter.GetOrders() {
+		result = append(result, infos[v])
+	}
+	return result, nil
+}
+
 // 可选：打印循环依赖的方法
 func (es *ExprSorter) PrintCircle() {
 	// 实现循环依赖检测和打印逻辑
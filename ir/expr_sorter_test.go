@@ -93,6 +93,106 @@ func TestExprSorter_ShouldSortMixedFormulas(t *testing.T) {
 	assert.Equal(t, 22, result[5])
 }
 
+func TestExprSorter_ShouldSortMultiTargetAssign(t *testing.T) {
+	srcs := []string{
+		"d = b + c",
+		"c = a * 2",
+		"a = b = m + 1",
+	}
+
+	context := ir.NewGopContext()
+	exprs := parse(srcs, context)
+	sortedExprInfos, err := analyze(exprs, context)
+	require.NoError(t, err, "排序不应出错")
+	require.Len(t, sortedExprInfos, 3, "应有3个表达式")
+
+	assert.Equal(t, "a = b = m + 1", srcs[sortedExprInfos[0].GetIndex()])
+	assert.Equal(t, "c = a * 2", srcs[sortedExprInfos[1].GetIndex()])
+	assert.Equal(t, "d = b + c", srcs[sortedExprInfos[2].GetIndex()])
+
+	runner := gop.NewGopRunner()
+	environment := env.NewDefaultEnvironment()
+	environment.PutInt("m", 2)
+	result, err := runner.ExecuteBatch(srcs, environment)
+	require.NoError(t, err)
+	assert.Equal(t, []any{9, 6, 3}, result)
+}
+
+func TestExprSorter_ShouldSortNestedAssign(t *testing.T) {
+	srcs := []string{
+		"y = x + 1",
+		"if(m > 0, x = m, x = 0)",
+	}
+
+	runner := gop.NewGopRunner()
+	environment := env.NewDefaultEnvironment()
+	environment.PutInt("m", 5)
+	result, err := runner.ExecuteBatch(srcs, environment)
+	require.NoError(t, err)
+	assert.Equal(t, []any{6, 5}, result)
+}
+
+func TestExprSorter_ShouldRejectConflictingAssign(t *testing.T) {
+	srcs := []string{
+		"x = 1",
+		"y = x + 1",
+		"x = 2",
+		"z = 3",
+		"z = 4",
+	}
+
+	context := ir.NewGopContext()
+	exprs := parse(srcs, context)
+	_, err := analyze(exprs, context)
+	require.Error(t, err, "重复赋值应报错")
+
+	var conflict *ir.AssignConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "x", conflict.Name)
+	assert.Equal(t, []int{0, 2}, conflict.Indexes)
+
+	conflicts := context.GetExecContext().GetConflicts()
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "z", conflicts[1].Name)
+	assert.Equal(t, []int{3, 4}, conflicts[1].Indexes)
+
+	runner := gop.NewGopRunner()
+	_, err = runner.ExecuteBatch(srcs, env.NewDefaultEnvironment())
+	require.ErrorAs(t, err, &conflict)
+}
+
+func TestExprSorter_ShouldApplyLastWins(t *testing.T) {
+	srcs := []string{
+		"y = x + 1",
+		"x = a + 1",
+		"a = 10",
+		"x = 2",
+	}
+
+	context := ir.NewGopContext()
+	context.SetAssignPolicy(ir.AssignLastWins)
+	exprs := parse(srcs, context)
+	sortedExprInfos, err := analyze(exprs, context)
+	require.NoError(t, err, "后者生效策略不应报错")
+	require.Len(t, sortedExprInfos, 4)
+
+	assert.Equal(t, "a = 10", srcs[sortedExprInfos[0].GetIndex()])
+	assert.Equal(t, "x = a + 1", srcs[sortedExprInfos[1].GetIndex()])
+	assert.Equal(t, "x = 2", srcs[sortedExprInfos[2].GetIndex()])
+	assert.Equal(t, "y = x + 1", srcs[sortedExprInfos[3].GetIndex()])
+
+	for _, mode := range []gop.ExecuteMode{gop.SyntaxTree, gop.ChunkVM, gop.RegisterVM, gop.Closure} {
+		runner := gop.NewGopRunner()
+		runner.SetExecuteMode(mode)
+		runner.SetAssignPolicy(ir.AssignLastWins)
+		environment := env.NewDefaultEnvironment()
+		result, err := runner.ExecuteBatch(srcs, environment)
+		require.NoError(t, err)
+		assert.Equal(t, []any{3, 11, 10, 2}, result)
+		assert.Equal(t, 2, environment.Get("x").GetValue())
+	}
+}
+
 func parse(srcs []string, context *ir.GopContext) []exprs.Expr {
 	tracer := context.GetTracer()
 	tracer.StartTimerWithMsg("解析")
@@ -118,7 +218,10 @@ func analyze(exprs []exprs.Expr, context *ir.GopContext) ([]*ir.ExprInfo, error)
 		exprInfos[i] = ir.NewExprInfo(expr, i)
 	}
 
-	context.PrepareExecute(exprInfos)
+	if err := context.PrepareExecute(exprInfos); err != nil {
+		tracer.EndTimer("表达式分析失败。")
+		return nil, err
+	}
 	sorter := ir.NewExprSorter(context)
 	sortedInfos, err := sorter.Sort()
 
@@ -6,6 +6,7 @@ import (
 
 // AssignExpr 赋值表达式
 type AssignExpr struct {
+	Node
 	Left     Expr
 	Operator *values.Token
 	Right    Expr
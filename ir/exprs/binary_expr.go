@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // BinaryExpr 二元表达式
 type BinaryExpr struct {
+	Node
 	Left     Expr
 	Operator *values.Token
 	Right    Expr
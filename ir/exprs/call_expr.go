@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // CallExpr 函数调用表达式
 type CallExpr struct {
+	Node
 	Callee Expr
 	Args   []Expr
 	RParen *values.Token
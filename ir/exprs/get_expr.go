@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // GetExpr 属性获取表达式
 type GetExpr struct {
+	Node
 	Object Expr
 	Name   *values.Token
 }
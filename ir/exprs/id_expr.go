@@ -2,6 +2,7 @@ package exprs
 
 // IdExpr 标识符表达式
 type IdExpr struct {
+	Node
 	Id string
 }
 
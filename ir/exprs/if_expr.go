@@ -2,6 +2,7 @@ package exprs
 
 // IfExpr 条件表达式
 type IfExpr struct {
+	Node
 	Condition  Expr
 	ThenBranch Expr
 	ElseBranch Expr
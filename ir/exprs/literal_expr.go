@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // LiteralExpr 字面量表达式
 type LiteralExpr struct {
+	Node
 	Value *values.Value
 }
 
@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // LogicExpr 逻辑表达式
 type LogicExpr struct {
+	Node
 	Left     Expr
 	Operator *values.Token
 	Right    Expr
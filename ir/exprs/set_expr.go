@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // SetExpr 属性设置表达式
 type SetExpr struct {
+	Node
 	Object Expr
 	Name   *values.Token
 	Value  Expr
@@ -0,0 +1,61 @@
+package exprs
+
+import "github.com/simonwater/gopression/values"
+
+// Span 表达式在源码中的范围。Start、End 为字符偏移（左闭右开），Line、Column 为起始位置的行列号，从 1 开始。
+// 零值表示位置未知，如手工构造的表达式
+type Span struct {
+	Start  int
+	End    int
+	Line   int
+	Column int
+}
+
+// IsValid 是否记录了源码位置
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
+
+// Text 从源码中截取范围内的文本，范围无效或越界时返回空串
+func (s Span) Text(source string) string {
+	runes := []rune(source)
+	if !s.IsValid() || s.Start < 0 || s.End > len(runes) || s.Start > s.End {
+		return ""
+	}
+	return string(runes[s.Start:s.End])
+}
+
+// Node 嵌入到各表达式结构中，记录源码位置和静态类型检查推断的类型
+type Node struct {
+	span Span
+	vt   values.ValueType
+}
+
+func (n *Node) GetSpan() Span {
+	return n.span
+}
+
+func (n *Node) SetSpan(span Span) {
+	n.span = span
+}
+
+// Positioned 带有源码位置的表达式
+type Positioned interface {
+	GetSpan() Span
+	SetSpan(span Span)
+}
+
+// SpanOf 获取表达式的源码位置，没有记录时返回零值
+func SpanOf(e Expr) Span {
+	if p, ok := e.(Positioned); ok {
+		return p.GetSpan()
+	}
+	return Span{}
+}
+
+// SetSpan 设置表达式的源码位置，表达式不支持时忽略
+func SetSpan(e Expr, span Span) {
+	if p, ok := e.(Positioned); ok {
+		p.SetSpan(span)
+	}
+}
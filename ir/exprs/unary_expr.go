@@ -4,6 +4,7 @@ import "github.com/simonwater/gopression/values"
 
 // UnaryExpr 一元表达式
 type UnaryExpr struct {
+	Node
 	Operator *values.Token
 	Right    Expr
 }
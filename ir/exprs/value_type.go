@@ -0,0 +1,33 @@
+package exprs
+
+import "github.com/simonwater/gopression/values"
+
+// GetValueType 静态类型检查推断的类型，未检查或无法确定时为 0
+func (n *Node) GetValueType() values.ValueType {
+	return n.vt
+}
+
+func (n *Node) SetValueType(vt values.ValueType) {
+	n.vt = vt
+}
+
+// Typed 带有推断类型的表达式
+type Typed interface {
+	GetValueType() values.ValueType
+	SetValueType(vt values.ValueType)
+}
+
+// TypeOf 获取表达式推断的类型，没有记录时返回 0
+func TypeOf(e Expr) values.ValueType {
+	if t, ok := e.(Typed); ok {
+		return t.GetValueType()
+	}
+	return 0
+}
+
+// SetType 设置表达式推断的类型，表达式不支持时忽略
+func SetType(e Expr, vt values.ValueType) {
+	if t, ok := e.(Typed); ok {
+		t.SetValueType(vt)
+	}
+}
@@ -0,0 +1,95 @@
+package ir
+
+import (
+	"sort"
+
+	"github.com/simonwater/gopression/ir/exprs"
+)
+
+// FuncsQuery 查询表达式调用的函数名，被调用者不是标识符的调用不计入
+type FuncsQuery struct {
+	*BaseVisitor[any]
+	names map[string]bool
+}
+
+func NewFuncsQuery() *FuncsQuery {
+	fq := &FuncsQuery{names: make(map[string]bool)}
+	fq.BaseVisitor = NewBaseVisitor(fq)
+	return fq
+}
+
+// Execute 收集表达式调用的函数名，可以对多个表达式依次调用后用 GetFunctions 取得全部结果
+func (fq *FuncsQuery) Execute(expr exprs.Expr) {
+	if expr != nil {
+		fq.Accept(expr)
+	}
+}
+
+// GetFunctions 已收集的函数名，按名称排序
+func (fq *FuncsQuery) GetFunctions() []string {
+	result := make([]string, 0, len(fq.names))
+	for name := range fq.names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (fq *FuncsQuery) VisitBinary(expr *exprs.BinaryExpr) any {
+	fq.Execute(expr.Left)
+	fq.Execute(expr.Right)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitLogic(expr *exprs.LogicExpr) any {
+	fq.Execute(expr.Left)
+	fq.Execute(expr.Right)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitLiteral(expr *exprs.LiteralExpr) any {
+	return nil
+}
+
+func (fq *FuncsQuery) VisitUnary(expr *exprs.UnaryExpr) any {
+	fq.Execute(expr.Right)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitId(expr *exprs.IdExpr) any {
+	return nil
+}
+
+func (fq *FuncsQuery) VisitAssign(expr *exprs.AssignExpr) any {
+	fq.Execute(expr.Left)
+	fq.Execute(expr.Right)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitCall(expr *exprs.CallExpr) any {
+	if idExpr, ok := expr.Callee.(*exprs.IdExpr); ok {
+		fq.names[idExpr.Id] = true
+	}
+	for _, arg := range expr.Args {
+		fq.Execute(arg)
+	}
+	return nil
+}
+
+func (fq *FuncsQuery) VisitIf(expr *exprs.IfExpr) any {
+	fq.Execute(expr.Condition)
+	fq.Execute(expr.ThenBranch)
+	fq.Execute(expr.ElseBranch)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitGet(expr *exprs.GetExpr) any {
+	fq.Execute(expr.Object)
+	return nil
+}
+
+func (fq *FuncsQuery) VisitSet(expr *exprs.SetExpr) any {
+	fq.Execute(expr.Object)
+	fq.Execute(expr.Value)
+	return nil
+}
@@ -2,14 +2,27 @@ package ir
 
 import "github.com/simonwater/gopression/util"
 
+// AssignPolicy 同一变量被多个表达式赋值时的处理策略
+type AssignPolicy int
+
+const (
+	// AssignError 拒绝执行，返回 AssignConflictError，其中包含所有冲突表达式的索引
+	AssignError AssignPolicy = iota
+	// AssignLastWins 所有赋值表达式按索引顺序依次执行，索引最大的表达式的赋值最终生效，
+	// 依赖该变量的表达式排在所有赋值表达式之后
+	AssignLastWins
+)
+
 type GopContext struct {
-	tracer      *util.Tracer
-	execContext *ExecuteContext
+	tracer       *util.Tracer
+	execContext  *ExecuteContext
+	assignPolicy AssignPolicy
 }
 
 func NewGopContext() *GopContext {
 	ctx := GopContext{
-		tracer: util.NewTracer(),
+		tracer:       util.NewTracer(),
+		assignPolicy: AssignError,
 	}
 	execCtx := NewExecuteContext(&ctx)
 	ctx.execContext = execCtx
@@ -28,6 +41,14 @@ func (ctx *GopContext) SetExecContext(execCtx *ExecuteContext) {
 	ctx.execContext = execCtx
 }
 
-func (ctx *GopContext) PrepareExecute(exprInfos []*ExprInfo) {
-	ctx.execContext.PreExecute(exprInfos)
+func (ctx *GopContext) GetAssignPolicy() AssignPolicy {
+	return ctx.assignPolicy
+}
+
+func (ctx *GopContext) SetAssignPolicy(policy AssignPolicy) {
+	ctx.assignPolicy = policy
+}
+
+func (ctx *GopContext) PrepareExecute(exprInfos []*ExprInfo) error {
+	return ctx.execContext.PreExecute(exprInfos)
 }
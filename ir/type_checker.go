@@ -0,0 +1,296 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/simonwater/gopression/functions"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/values"
+)
+
+// VarTypes 变量的声明类型（见 schema.Schema）
+type VarTypes interface {
+	// GetVarType 变量的声明类型，未声明时返回 false
+	GetVarType(name string) (values.ValueType, bool)
+}
+
+// TypeError 静态类型检查发现的类型错误，即无论变量取何值执行时都必然出错的运算
+type TypeError struct {
+	Index   int    // 表达式序号
+	Line    int    // 出错子表达式的起始行，没有源码位置时为 0
+	Column  int    // 出错子表达式的起始列
+	Snippet string // 出错子表达式的源码，表达式没有源码时为空
+	Msg     string
+}
+
+func (e *TypeError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("表达式 %d 类型错误：%s", e.Index, e.Msg)
+	}
+	if e.Snippet == "" {
+		return fmt.Sprintf("表达式 %d 第 %d 行第 %d 列类型错误：%s", e.Index, e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("表达式 %d 第 %d 行第 %d 列 %q 类型错误：%s", e.Index, e.Line, e.Column, e.Snippet, e.Msg)
+}
+
+// TypeErrors 一批表达式中的全部类型错误，按发现顺序排列
+type TypeErrors []*TypeError
+
+func (errs TypeErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// TypeChecker 静态类型检查：根据字面量、变量的声明类型、前面表达式的赋值和函数签名（见 functions.TypedFunction）
+// 推断每个节点的类型并记录到节点上（见 exprs.TypeOf），供编译器生成按类型特化的指令。
+// 类型为 0 表示无法确定，只有确定的类型才参与检查，因此不会误报
+type TypeChecker struct {
+	*BaseVisitor[values.ValueType]
+	declared VarTypes
+	assigned map[string]values.ValueType // 前面的表达式赋值的类型，多次赋值类型不同时为 0
+	nested   map[string]bool             // 在子表达式中赋值的变量，可能不执行，类型无法确定
+	info     *ExprInfo
+	errors   TypeErrors
+}
+
+// NewTypeChecker declared 为 nil 时只根据字面量、赋值和函数签名推断
+func NewTypeChecker(declared VarTypes) *TypeChecker {
+	tc := &TypeChecker{
+		declared: declared,
+		assigned: make(map[string]values.ValueType),
+		nested:   make(map[string]bool),
+	}
+	tc.BaseVisitor = NewBaseVisitor(tc)
+	return tc
+}
+
+// Check 按给定顺序检查各表达式，应为排序后的执行顺序，以便变量的类型从赋值处传到使用处。
+// 有类型错误时返回 TypeErrors
+func (tc *TypeChecker) Check(exprInfos []*ExprInfo) error {
+	for _, info := range exprInfos {
+		tc.info = info
+		tc.infer(info.GetExpr())
+	}
+	tc.info = nil
+	if len(tc.errors) > 0 {
+		return tc.errors
+	}
+	return nil
+}
+
+// GetErrors 已发现的类型错误
+func (tc *TypeChecker) GetErrors() TypeErrors {
+	return tc.errors
+}
+
+// GetVarType 变量的类型：声明的类型，没有声明时为已检查的表达式在顶层赋值的类型，无法确定时为 0
+func (tc *TypeChecker) GetVarType(name string) values.ValueType {
+	if tc.declared != nil {
+		if vt, ok := tc.declared.GetVarType(name); ok {
+			return vt
+		}
+	}
+	if tc.nested[name] {
+		return 0
+	}
+	return tc.assigned[name]
+}
+
+func (tc *TypeChecker) infer(expr exprs.Expr) values.ValueType {
+	if expr == nil {
+		return 0
+	}
+	vt := tc.Accept(expr)
+	exprs.SetType(expr, vt)
+	return vt
+}
+
+func (tc *TypeChecker) errorf(expr exprs.Expr, format string, args ...any) {
+	err := &TypeError{Msg: fmt.Sprintf(format, args...)}
+	if tc.info != nil {
+		err.Index = tc.info.GetIndex()
+	}
+	if span := exprs.SpanOf(expr); span.IsValid() {
+		err.Line, err.Column = span.Line, span.Column
+		if tc.info != nil {
+			err.Snippet = span.Text(tc.info.GetSource())
+		}
+	}
+	tc.errors = append(tc.errors, err)
+}
+
+func isNumberType(vt values.ValueType) bool {
+	return vt == values.Vt_Integer || vt == values.Vt_Double
+}
+
+// numberResult 两个数值运算的结果类型：都是整数时为整数，有一个是浮点数时为浮点数
+func numberResult(left, right values.ValueType) values.ValueType {
+	if left == values.Vt_Double || right == values.Vt_Double {
+		return values.Vt_Double
+	}
+	if left == values.Vt_Integer && right == values.Vt_Integer {
+		return values.Vt_Integer
+	}
+	return 0
+}
+
+func (tc *TypeChecker) VisitBinary(expr *exprs.BinaryExpr) values.ValueType {
+	left, right := tc.infer(expr.Left), tc.infer(expr.Right)
+	op := expr.Operator.Lexeme
+	switch expr.Operator.Type {
+	case values.PLUS:
+		for _, vt := range []values.ValueType{left, right} {
+			if vt != 0 && !isNumberType(vt) && vt != values.Vt_String {
+				tc.errorf(expr, "运算符 %s 的操作数必须是数值或字符串，实际为 %s", op, vt)
+				return 0
+			}
+		}
+		if left == values.Vt_String || right == values.Vt_String {
+			return values.Vt_String
+		}
+		return numberResult(left, right)
+	case values.MINUS, values.STAR, values.SLASH, values.PERCENT, values.STARSTAR:
+		if !tc.checkNumbers(expr, op, left, right) {
+			return 0
+		}
+		if expr.Operator.Type == values.STARSTAR {
+			return values.Vt_Double
+		}
+		return numberResult(left, right)
+	case values.GREATER, values.GREATER_EQUAL, values.LESS, values.LESS_EQUAL:
+		tc.checkNumbers(expr, op, left, right)
+		return values.Vt_Boolean
+	case values.EQUAL_EQUAL, values.BANG_EQUAL:
+		return values.Vt_Boolean
+	}
+	return 0
+}
+
+// checkNumbers 检查两个操作数都可能是数值
+func (tc *TypeChecker) checkNumbers(expr exprs.Expr, op string, left, right values.ValueType) bool {
+	for _, vt := range []values.ValueType{left, right} {
+		if vt != 0 && !isNumberType(vt) {
+			tc.errorf(expr, "运算符 %s 的操作数必须是数值，实际为 %s", op, vt)
+			return false
+		}
+	}
+	return true
+}
+
+// VisitLogic 语法树短路时结果为布尔值，虚拟机为左侧的值，两侧都是布尔值时才能确定
+func (tc *TypeChecker) VisitLogic(expr *exprs.LogicExpr) values.ValueType {
+	left, right := tc.infer(expr.Left), tc.infer(expr.Right)
+	if left == values.Vt_Boolean && right == values.Vt_Boolean {
+		return values.Vt_Boolean
+	}
+	return 0
+}
+
+func (tc *TypeChecker) VisitLiteral(expr *exprs.LiteralExpr) values.ValueType {
+	return expr.Value.GetValueType()
+}
+
+func (tc *TypeChecker) VisitUnary(expr *exprs.UnaryExpr) values.ValueType {
+	right := tc.infer(expr.Right)
+	switch expr.Operator.Type {
+	case values.BANG:
+		return values.Vt_Boolean
+	case values.MINUS:
+		if right != 0 && !isNumberType(right) {
+			tc.errorf(expr, "运算符 - 的操作数必须是数值，实际为 %s", right)
+			return 0
+		}
+		return right
+	}
+	return 0
+}
+
+func (tc *TypeChecker) VisitId(expr *exprs.IdExpr) values.ValueType {
+	return tc.GetVarType(expr.Id)
+}
+
+func (tc *TypeChecker) VisitAssign(expr *exprs.AssignExpr) values.ValueType {
+	right := tc.infer(expr.Right)
+	idExpr, ok := expr.Left.(*exprs.IdExpr)
+	if !ok {
+		tc.infer(expr.Left)
+		return right
+	}
+	exprs.SetType(idExpr, right)
+	if tc.declared != nil && right != 0 {
+		// 整数和浮点数之间不报错，与执行时的数值运算一致
+		if declared, ok := tc.declared.GetVarType(idExpr.Id); ok && declared != right &&
+			!(isNumberType(declared) && isNumberType(right)) {
+			tc.errorf(expr, "变量 %s 声明为 %s，赋值为 %s", idExpr.Id, declared, right)
+		}
+	}
+	if tc.info == nil || tc.info.GetExpr() != exprs.Expr(expr) {
+		tc.nested[idExpr.Id] = true
+	} else if prev, ok := tc.assigned[idExpr.Id]; ok && prev != right {
+		tc.assigned[idExpr.Id] = 0
+	} else {
+		tc.assigned[idExpr.Id] = right
+	}
+	return right
+}
+
+func (tc *TypeChecker) VisitCall(expr *exprs.CallExpr) values.ValueType {
+	args := make([]values.ValueType, len(expr.Args))
+	for i, arg := range expr.Args {
+		args[i] = tc.infer(arg)
+	}
+	idExpr, ok := expr.Callee.(*exprs.IdExpr)
+	if !ok {
+		return 0
+	}
+	fn := funmgr.GetFunctionManager().GetFunction(idExpr.Id)
+	if fn == nil {
+		return 0
+	}
+	if fn.Arity() != len(args) {
+		tc.errorf(expr, "函数 %s 需要 %d 个参数，实际为 %d 个", idExpr.Id, fn.Arity(), len(args))
+		return 0
+	}
+	typed, ok := fn.(functions.TypedFunction)
+	if !ok {
+		return 0
+	}
+	vt, err := typed.ResultType(args)
+	if err != nil {
+		tc.errorf(expr, "函数 %s：%v", idExpr.Id, err)
+		return 0
+	}
+	return vt
+}
+
+// VisitIf 两个分支类型相同时才能确定，没有 else 分支时条件为假结果为 null
+func (tc *TypeChecker) VisitIf(expr *exprs.IfExpr) values.ValueType {
+	tc.infer(expr.Condition)
+	then := tc.infer(expr.ThenBranch)
+	if expr.ElseBranch == nil {
+		return 0
+	}
+	if otherwise := tc.infer(expr.ElseBranch); otherwise == then {
+		return then
+	}
+	return 0
+}
+
+func (tc *TypeChecker) VisitGet(expr *exprs.GetExpr) values.ValueType {
+	if object := tc.infer(expr.Object); object != 0 && object != values.Vt_Instance {
+		tc.errorf(expr, "只有实例才有属性，实际为 %s", object)
+	}
+	return 0
+}
+
+func (tc *TypeChecker) VisitSet(expr *exprs.SetExpr) values.ValueType {
+	if object := tc.infer(expr.Object); object != 0 && object != values.Vt_Instance {
+		tc.errorf(expr, "只有实例才有属性，实际为 %s", object)
+	}
+	return tc.infer(expr.Value)
+}
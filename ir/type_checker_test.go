@@ -0,0 +1,71 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type varTypes map[string]values.ValueType
+
+func (v varTypes) GetVarType(name string) (values.ValueType, bool) {
+	vt, ok := v[name]
+	return vt, ok
+}
+
+func checkTypes(t *testing.T, declared VarTypes, lines ...string) ([]*ExprInfo, error) {
+	infos := make([]*ExprInfo, len(lines))
+	for i, src := range lines {
+		expr, err := parser.NewParser(src).Parse()
+		require.NoError(t, err)
+		infos[i] = NewExprInfo(expr, i)
+		infos[i].SetSource(src)
+	}
+	return infos, NewTypeChecker(declared).Check(infos)
+}
+
+func TestTypeChecker_Errors(t *testing.T) {
+	_, err := checkTypes(t, nil,
+		`x = 1 +
+  ("a" - 1)`,
+		"y = abs(true)",
+		"z = clock(1)",
+		"w = 1 + 2",
+	)
+	var typeErrs TypeErrors
+	require.ErrorAs(t, err, &typeErrs)
+	require.Len(t, typeErrs, 3)
+	assert.Equal(t, &TypeError{Index: 0, Line: 2, Column: 3, Snippet: `("a" - 1)`, Msg: "运算符 - 的操作数必须是数值，实际为 String"}, typeErrs[0])
+	assert.Equal(t, `表达式 1 第 1 行第 5 列 "abs(true)" 类型错误：函数 abs：参数必须是数值，实际为 Boolean`, typeErrs[1].Error())
+	assert.Equal(t, "函数 clock 需要 0 个参数，实际为 1 个", typeErrs[2].Msg)
+}
+
+func TestTypeChecker_Inference(t *testing.T) {
+	declared := varTypes{"price": values.Vt_Double, "qty": values.Vt_Integer, "name": values.Vt_String}
+	infos, err := checkTypes(t, declared,
+		"total = price * qty",
+		"count = qty + 1",
+		`label = name + count`,
+		"flag = if(a > 1, true, false)",
+		"big = if(flag, total, count)",
+		"if(flag, tmp = 1, 0)",
+		"tmp - 1",
+		"a - 1",
+	)
+	require.NoError(t, err)
+	typeOf := func(i int) values.ValueType { return exprs.TypeOf(infos[i].GetExpr()) }
+	assert.Equal(t, values.Vt_Double, typeOf(0))
+	assert.Equal(t, values.Vt_Integer, typeOf(1))
+	assert.Equal(t, values.Vt_String, typeOf(2))
+	assert.Equal(t, values.Vt_Boolean, typeOf(3))
+	assert.Equal(t, values.ValueType(0), typeOf(4), "两个分支类型不同")
+	assert.Equal(t, values.ValueType(0), typeOf(6), "条件分支中的赋值不一定执行")
+	assert.Equal(t, values.ValueType(0), typeOf(7), "未声明的变量类型未知")
+
+	_, err = checkTypes(t, declared, `qty = "ten"`, "price = 1")
+	assert.EqualError(t, err, `表达式 0 第 1 行第 1 列 "qty = \"ten\"" 类型错误：变量 qty 声明为 Integer，赋值为 String`)
+}
@@ -0,0 +1,21 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCanceled 执行被取消或超时。返回的错误同时包装了 ctx.Err()，
+// 可用 errors.Is 区分 context.Canceled 和 context.DeadlineExceeded
+var ErrCanceled = errors.New("执行已取消")
+
+// CheckCanceled ctx 已结束时返回取消错误，否则返回 nil
+func CheckCanceled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrCanceled, ctx.Err())
+	default:
+		return nil
+	}
+}
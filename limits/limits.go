@@ -0,0 +1,87 @@
+package limits
+
+import (
+	"fmt"
+
+	"github.com/simonwater/gopression/values"
+)
+
+// DEFAULT_STACK_DEPTH 未设置 MaxStackDepth 时操作数栈的最大深度
+const DEFAULT_STACK_DEPTH = 256
+
+// Limits 执行资源限额，用于安全地执行不受信任的公式。各项为 0 表示不限制（MaxStackDepth 为 0 时取 DEFAULT_STACK_DEPTH）。
+// 指令数按单个表达式计算：虚拟机为执行的指令条数，语法树为求值的节点个数
+type Limits struct {
+	MaxInstructions   int // 单个表达式最多执行的指令数
+	MaxStackDepth     int // 虚拟机操作数栈的最大深度
+	MaxStringSize     int // 运算产生的字符串最大长度（字节）
+	MaxCollectionSize int // 实例对象的最大属性数
+	MaxRecursion      int // 语法树求值的最大嵌套深度
+	// MaxCallDepth 函数调用的最大嵌套层数，如 abs(abs(x)) 为 2。执行前按表达式的语法结构检查，不论分支是否执行，
+	// 各执行模式结果相同（虚拟机在调用前已求出全部参数，执行时调用不会嵌套，无法在执行中检查）
+	MaxCallDepth int
+}
+
+// GetStackDepth 获取操作数栈的最大深度
+func (l Limits) GetStackDepth() int {
+	if l.MaxStackDepth <= 0 {
+		return DEFAULT_STACK_DEPTH
+	}
+	return l.MaxStackDepth
+}
+
+// CheckCallDepth 检查表达式中函数调用的嵌套层数 depth 是否超出限额
+func (l Limits) CheckCallDepth(depth int) error {
+	if l.MaxCallDepth > 0 && depth > l.MaxCallDepth {
+		return &QuotaError{Kind: QuotaCallDepth, Limit: l.MaxCallDepth}
+	}
+	return nil
+}
+
+// CheckValue 检查运算产生的值是否超出大小限额
+func (l Limits) CheckValue(v values.Value) error {
+	if l.MaxStringSize > 0 && v.IsString() && len(v.AsString()) > l.MaxStringSize {
+		return &QuotaError{Kind: QuotaStringSize, Limit: l.MaxStringSize}
+	}
+	if l.MaxCollectionSize > 0 && v.IsInstance() && len(v.AsInstance().Fields) > l.MaxCollectionSize {
+		return &QuotaError{Kind: QuotaCollectionSize, Limit: l.MaxCollectionSize}
+	}
+	return nil
+}
+
+type QuotaKind int
+
+const (
+	QuotaInstructions QuotaKind = iota
+	QuotaStackDepth
+	QuotaStringSize
+	QuotaCollectionSize
+	QuotaRecursion
+	QuotaCallDepth
+)
+
+var quotaNames = [...]string{
+	QuotaInstructions:   "指令数",
+	QuotaStackDepth:     "栈深度",
+	QuotaStringSize:     "字符串长度",
+	QuotaCollectionSize: "对象属性数",
+	QuotaRecursion:      "嵌套深度",
+	QuotaCallDepth:      "函数调用深度",
+}
+
+func (k QuotaKind) String() string {
+	if k >= 0 && int(k) < len(quotaNames) {
+		return quotaNames[k]
+	}
+	return fmt.Sprintf("QuotaKind(%d)", int(k))
+}
+
+// QuotaError 超出资源限额，可用 errors.As 判断
+type QuotaError struct {
+	Kind  QuotaKind
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("超出资源限额：%s上限为 %d", e.Kind, e.Limit)
+}
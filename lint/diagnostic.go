@@ -0,0 +1,79 @@
+package lint
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Diagnostic 检查发现的一个问题
+type Diagnostic struct {
+	Rule     Rule
+	Severity Severity
+	Index    int    // 表达式序号
+	Line     int    // 问题所在子表达式的起始行，没有源码位置时为 0
+	Column   int    // 问题所在子表达式的起始列
+	Snippet  string // 问题所在子表达式的源码，表达式没有源码时为空
+	Msg      string
+}
+
+func (d *Diagnostic) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "表达式 %d ", d.Index)
+	if d.Line > 0 {
+		fmt.Fprintf(&sb, "第 %d 行第 %d 列 ", d.Line, d.Column)
+	}
+	if d.Snippet != "" {
+		fmt.Fprintf(&sb, "%q ", d.Snippet)
+	}
+	fmt.Fprintf(&sb, "%s[%s]：%s", d.Severity, d.Rule, d.Msg)
+	return sb.String()
+}
+
+// Diagnostics 检查结果，按表达式序号、位置和规则排序
+type Diagnostics []*Diagnostic
+
+// HasErrors 是否有严重程度为错误的问题
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity >= SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter 严重程度不低于 min 的问题
+func (ds Diagnostics) Filter(min Severity) Diagnostics {
+	var result Diagnostics
+	for _, d := range ds {
+		if d.Severity >= min {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// WriteText 每行输出一个问题
+func (ds Diagnostics) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	for _, d := range ds {
+		sb.WriteString(d.String())
+		sb.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (ds Diagnostics) sort() {
+	slices.SortStableFunc(ds, func(a, b *Diagnostic) int {
+		return cmp.Or(
+			cmp.Compare(a.Index, b.Index),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Column, b.Column),
+			cmp.Compare(ruleOrder(a.Rule), ruleOrder(b.Rule)),
+		)
+	})
+}
@@ -0,0 +1,366 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+)
+
+// Linter 公式检查器：分析一组表达式，按规则（见 Rules）报告可疑的写法。
+// 变量的读写按给定的表达式顺序分析，应为执行顺序（如 GopRunner.Analyze 的结果）
+type Linter struct {
+	config   map[Rule]RuleConfig
+	supplied map[string]bool
+	outputs  map[string]bool
+	schema   *schema.Schema
+}
+
+// NewLinter 使用默认配置（见 DefaultConfig）创建检查器。未设置输入时不知道哪些变量由外部提供，
+// 不检查 RuleUndefinedVar，需要检查时用 SetSupplied 或 SetSchema 设置输入
+func NewLinter() *Linter {
+	return &Linter{config: DefaultConfig()}
+}
+
+func (l *Linter) IsEnabled(rule Rule) bool {
+	return l.config[rule].Enabled
+}
+
+// SetEnabled 开启或关闭规则
+func (l *Linter) SetEnabled(rule Rule, enabled bool) {
+	c := l.config[rule]
+	c.Enabled = enabled
+	l.config[rule] = c
+}
+
+func (l *Linter) GetSeverity(rule Rule) Severity {
+	return l.config[rule].Severity
+}
+
+// SetSeverity 设置规则报告的问题的严重程度
+func (l *Linter) SetSeverity(rule Rule, severity Severity) {
+	c := l.config[rule]
+	c.Severity = severity
+	l.config[rule] = c
+}
+
+// SetSupplied 设置执行时作为输入提供的变量，提供了实例时其属性也视为已提供。
+// 不带参数调用表示没有输入，所有没有被赋值的读取都报告 RuleUndefinedVar
+func (l *Linter) SetSupplied(names ...string) {
+	l.supplied = make(map[string]bool, len(names))
+	for _, name := range names {
+		l.supplied[name] = true
+	}
+}
+
+// SetOutputs 设置公式集的结果变量，只赋值不读取时不报告 RuleUnusedAssign
+func (l *Linter) SetOutputs(names ...string) {
+	l.outputs = make(map[string]bool, len(names))
+	for _, name := range names {
+		l.outputs[name] = true
+	}
+}
+
+// SetSchema 设置输入变量的声明，声明的变量视为已提供，声明的类型用于推断比较两侧的类型
+func (l *Linter) SetSchema(s *schema.Schema) {
+	l.schema = s
+}
+
+// LintSource 解析并按顺序检查各表达式
+func (l *Linter) LintSource(expressions []string) (Diagnostics, error) {
+	exprInfos := make([]*ir.ExprInfo, len(expressions))
+	for i, src := range expressions {
+		expr, err := parser.NewParser(src).Parse()
+		if err != nil {
+			return nil, fmt.Errorf("表达式 %d 解析出错：%w", i, err)
+		}
+		exprInfos[i] = ir.NewExprInfo(expr, i)
+		exprInfos[i].SetSource(src)
+	}
+	return l.Lint(exprInfos), nil
+}
+
+// LintExprs 按顺序检查解析后的表达式，没有源码，问题只带行列位置
+func (l *Linter) LintExprs(exprList []exprs.Expr) Diagnostics {
+	exprInfos := make([]*ir.ExprInfo, len(exprList))
+	for i, expr := range exprList {
+		exprInfos[i] = ir.NewExprInfo(expr, i)
+	}
+	return l.Lint(exprInfos)
+}
+
+// Lint 按给定顺序检查各表达式。会对表达式做类型推断（见 ir.TypeChecker），在节点上记录推断出的类型
+func (l *Linter) Lint(exprInfos []*ir.ExprInfo) Diagnostics {
+	var declared ir.VarTypes
+	if l.schema != nil {
+		declared = l.schema
+	}
+	// 类型错误由类型检查报告，这里只用推断出的类型
+	ir.NewTypeChecker(declared).Check(exprInfos)
+
+	p := &pass{
+		linter:   l,
+		assigned: make(map[string]*site),
+		read:     make(map[string]bool),
+		pending:  make(map[string]*site),
+	}
+	for _, info := range exprInfos {
+		p.info = info
+		p.firstReads = make(map[string]bool)
+		p.walk(info.GetExpr(), false)
+	}
+	p.checkVariables()
+	p.diags.sort()
+	return p.diags
+}
+
+// site 子表达式在表达式集中的位置
+type site struct {
+	info *ir.ExprInfo
+	expr exprs.Expr
+}
+
+type readSite struct {
+	site
+	name string
+}
+
+// pass 一次检查的状态
+type pass struct {
+	linter     *Linter
+	info       *ir.ExprInfo
+	diags      Diagnostics
+	assigned   map[string]*site // 被赋值的变量及其第一次赋值的位置
+	assignSeq  []string         // 被赋值的变量，按第一次赋值的顺序
+	read       map[string]bool  // 被读取的变量
+	reads      []readSite       // 每个表达式中各变量第一次读取的位置
+	firstReads map[string]bool  // 当前表达式中已读取的变量
+	pending    map[string]*site // 赋值后还没有被读取的变量及其赋值的位置
+}
+
+func (p *pass) report(rule Rule, at site, format string, args ...any) {
+	config := p.linter.config[rule]
+	if !config.Enabled {
+		return
+	}
+	d := &Diagnostic{Rule: rule, Severity: config.Severity, Index: at.info.GetIndex(), Msg: fmt.Sprintf(format, args...)}
+	if span := exprs.SpanOf(at.expr); span.IsValid() {
+		d.Line, d.Column = span.Line, span.Column
+		d.Snippet = span.Text(at.info.GetSource())
+	}
+	p.diags = append(p.diags, d)
+}
+
+func (p *pass) at(expr exprs.Expr) site {
+	return site{info: p.info, expr: expr}
+}
+
+// walk 按求值顺序遍历，conditional 表示子表达式不一定执行（if 的分支、逻辑运算的右侧）
+func (p *pass) walk(expr exprs.Expr, conditional bool) {
+	switch e := expr.(type) {
+	case *exprs.IdExpr:
+		p.onRead(e.Id, e)
+	case *exprs.GetExpr:
+		if path := pathOf(e); path != "" {
+			p.onRead(path, e)
+		} else {
+			p.walk(e.Object, conditional)
+		}
+	case *exprs.SetExpr:
+		path := pathOf(exprs.NewGetExpr(e.Object, e.Name))
+		if path == "" {
+			p.walk(e.Object, conditional)
+		}
+		if pathOf(e.Value) == path && path != "" {
+			p.report(RuleSelfAssign, p.at(e), "变量 %s 赋值给自身", path)
+		}
+		p.walk(e.Value, conditional)
+		if path != "" {
+			p.onAssign(path, e, conditional)
+		}
+	case *exprs.AssignExpr:
+		path := pathOf(e.Left)
+		if path != "" && pathOf(e.Right) == path {
+			p.report(RuleSelfAssign, p.at(e), "变量 %s 赋值给自身", path)
+		}
+		p.walk(e.Right, conditional)
+		if path != "" {
+			p.onAssign(path, e, conditional)
+		}
+	case *exprs.BinaryExpr:
+		p.walk(e.Left, conditional)
+		p.walk(e.Right, conditional)
+		p.checkCompare(e)
+	case *exprs.LogicExpr:
+		p.walk(e.Left, conditional)
+		p.walk(e.Right, true)
+	case *exprs.UnaryExpr:
+		p.walk(e.Right, conditional)
+	case *exprs.CallExpr:
+		for _, arg := range e.Args {
+			p.walk(arg, conditional)
+		}
+	case *exprs.IfExpr:
+		p.checkCondition(e.Condition)
+		p.walk(e.Condition, conditional)
+		p.walk(e.ThenBranch, true)
+		if e.ElseBranch != nil {
+			p.walk(e.ElseBranch, true)
+		}
+	}
+}
+
+func (p *pass) onRead(name string, expr exprs.Expr) {
+	p.read[name] = true
+	if !p.firstReads[name] {
+		p.firstReads[name] = true
+		p.reads = append(p.reads, readSite{site: p.at(expr), name: name})
+	}
+	for assigned := range p.pending {
+		if related(assigned, name) {
+			delete(p.pending, assigned)
+		}
+	}
+}
+
+func (p *pass) onAssign(name string, expr exprs.Expr, conditional bool) {
+	at := p.at(expr)
+	if _, ok := p.assigned[name]; !ok {
+		p.assigned[name] = &at
+		p.assignSeq = append(p.assignSeq, name)
+	}
+	if prev, ok := p.pending[name]; ok && !conditional {
+		where := "同一表达式中"
+		if prev.info != p.info {
+			where = fmt.Sprintf("表达式 %d 中", p.info.GetIndex())
+		}
+		if span := exprs.SpanOf(expr); span.IsValid() {
+			where += fmt.Sprintf("第 %d 行第 %d 列", span.Line, span.Column)
+		}
+		p.report(RuleOverwrittenAssign, *prev, "赋值给 %s 的值没有被读取，就被%s的赋值覆盖", name, where)
+	}
+	p.pending[name] = &at
+}
+
+// checkVariables 检查没有被读取的赋值和没有来源的读取
+func (p *pass) checkVariables() {
+	for _, name := range p.assignSeq {
+		if covers(p.linter.outputs, name) {
+			continue
+		}
+		used := false
+		for read := range p.read {
+			if related(name, read) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			p.report(RuleUnusedAssign, *p.assigned[name], "变量 %s 被赋值但没有被读取", name)
+		}
+	}
+
+	if p.linter.supplied == nil && p.linter.schema == nil {
+		// 未设置输入，无法区分输入和未定义的变量
+		return
+	}
+	for _, r := range p.reads {
+		if covers(p.assigned, r.name) || covers(p.linter.supplied, r.name) ||
+			(p.linter.schema != nil && p.linter.schema.IsDeclared(r.name)) {
+			continue
+		}
+		p.report(RuleUndefinedVar, r.site, "变量 %s 既没有被赋值，也不是输入", r.name)
+	}
+}
+
+// checkCondition 条件只由字面量和运算组成时总是执行同一分支
+func (p *pass) checkCondition(cond exprs.Expr) {
+	if !isConstant(cond) {
+		return
+	}
+	if lit, ok := cond.(*exprs.LiteralExpr); ok {
+		p.report(RuleConstantCondition, p.at(cond), "if 的条件总是为 %v", lit.Value.IsTruthy())
+		return
+	}
+	p.report(RuleConstantCondition, p.at(cond), "if 的条件是常量表达式，总是执行同一分支")
+}
+
+func (p *pass) checkCompare(e *exprs.BinaryExpr) {
+	op := e.Operator.Type
+	isEquality := op == values.EQUAL_EQUAL || op == values.BANG_EQUAL
+	if !isEquality && op != values.GREATER && op != values.GREATER_EQUAL && op != values.LESS && op != values.LESS_EQUAL {
+		return
+	}
+	left, right := exprs.TypeOf(e.Left), exprs.TypeOf(e.Right)
+	_, leftLit := e.Left.(*exprs.LiteralExpr)
+	_, rightLit := e.Right.(*exprs.LiteralExpr)
+	if (leftLit || rightLit) && left != 0 && right != 0 && left != right &&
+		left != values.Vt_Null && right != values.Vt_Null && !(isNumber(left) && isNumber(right)) {
+		switch op {
+		case values.EQUAL_EQUAL:
+			p.report(RuleMixedTypeCompare, p.at(e), "%s 与 %s 比较，结果总是为 false", left, right)
+		case values.BANG_EQUAL:
+			p.report(RuleMixedTypeCompare, p.at(e), "%s 与 %s 比较，结果总是为 true", left, right)
+		default:
+			p.report(RuleMixedTypeCompare, p.at(e), "%s 与 %s 比较大小，执行时出错", left, right)
+		}
+	}
+	if isEquality && (left == values.Vt_Double || right == values.Vt_Double) {
+		p.report(RuleDoubleEquality, p.at(e), "浮点数用 %s 比较可能受舍入误差影响，应比较差的绝对值是否小于允许的误差", e.Operator.Lexeme)
+	}
+}
+
+func isNumber(vt values.ValueType) bool {
+	return vt == values.Vt_Integer || vt == values.Vt_Double
+}
+
+// isConstant 是否只由字面量和运算组成
+func isConstant(expr exprs.Expr) bool {
+	switch e := expr.(type) {
+	case *exprs.LiteralExpr:
+		return true
+	case *exprs.UnaryExpr:
+		return isConstant(e.Right)
+	case *exprs.BinaryExpr:
+		return isConstant(e.Left) && isConstant(e.Right)
+	case *exprs.LogicExpr:
+		return isConstant(e.Left) && isConstant(e.Right)
+	}
+	return false
+}
+
+// pathOf 变量或属性的路径，如 a、a.b.c，不是变量时为空
+func pathOf(expr exprs.Expr) string {
+	switch e := expr.(type) {
+	case *exprs.IdExpr:
+		return e.Id
+	case *exprs.GetExpr:
+		if owner := pathOf(e.Object); owner != "" {
+			return owner + "." + e.Name.Lexeme
+		}
+	}
+	return ""
+}
+
+// related 两个路径是否相同或一个是另一个的属性，读取其中一个可能读到另一个的值
+func related(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// covers 路径本身或其所属的实例在 names 中
+func covers[V any](names map[string]V, path string) bool {
+	for {
+		if _, ok := names[path]; ok {
+			return true
+		}
+		dot := strings.LastIndexByte(path, '.')
+		if dot < 0 {
+			return false
+		}
+		path = path[:dot]
+	}
+}
@@ -0,0 +1,100 @@
+package lint_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/lint"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var lintLines = []string{
+	"total = price * qty",
+	"x = x",
+	"y = if(1 > 2, total, 0) + if(true, 1, 2)",
+	`z = if(price == "3", rate == 0.5, order.a = order.a)`,
+	"w = (t = 1) + (t = 2) + t + discount",
+	"rate = 0.5",
+	"u = 1",
+	"u = 2",
+}
+
+func TestLinter_Rules(t *testing.T) {
+	s := schema.NewSchema()
+	s.Declare("price", values.Vt_Double)
+	s.Declare("qty", values.Vt_Integer)
+	s.Declare("order", values.Vt_Instance)
+	l := lint.NewLinter()
+	l.SetSchema(s)
+	l.SetEnabled(lint.RuleUnusedAssign, true)
+	l.SetOutputs("y", "z", "w")
+	ds, err := l.LintSource(lintLines)
+	require.NoError(t, err)
+
+	var text strings.Builder
+	require.NoError(t, ds.WriteText(&text))
+	assert.Equal(t, `表达式 1 第 1 行第 1 列 "x = x" 警告[self-assign]：变量 x 赋值给自身
+表达式 2 第 1 行第 8 列 "1 > 2" 警告[constant-condition]：if 的条件是常量表达式，总是执行同一分支
+表达式 2 第 1 行第 30 列 "true" 警告[constant-condition]：if 的条件总是为 true
+表达式 3 第 1 行第 8 列 "price == \"3\"" 警告[mixed-type-compare]：Double 与 String 比较，结果总是为 false
+表达式 3 第 1 行第 8 列 "price == \"3\"" 警告[double-equality]：浮点数用 == 比较可能受舍入误差影响，应比较差的绝对值是否小于允许的误差
+表达式 3 第 1 行第 22 列 "rate == 0.5" 警告[double-equality]：浮点数用 == 比较可能受舍入误差影响，应比较差的绝对值是否小于允许的误差
+表达式 3 第 1 行第 35 列 "order.a = order.a" 警告[self-assign]：变量 order.a 赋值给自身
+表达式 4 第 1 行第 5 列 "(t = 1)" 警告[overwritten-assign]：赋值给 t 的值没有被读取，就被同一表达式中第 1 行第 15 列的赋值覆盖
+表达式 4 第 1 行第 29 列 "discount" 错误[undefined-var]：变量 discount 既没有被赋值，也不是输入
+表达式 6 第 1 行第 1 列 "u = 1" 提示[unused-assign]：变量 u 被赋值但没有被读取
+表达式 6 第 1 行第 1 列 "u = 1" 警告[overwritten-assign]：赋值给 u 的值没有被读取，就被表达式 7 中第 1 行第 1 列的赋值覆盖
+`, text.String())
+	assert.True(t, ds.HasErrors())
+	assert.Len(t, ds.Filter(lint.SeverityError), 1)
+}
+
+func TestLinter_Config(t *testing.T) {
+	expr, err := parser.NewParser("if(a == 1.5, b = b, 0)").Parse()
+	require.NoError(t, err)
+	l := lint.NewLinter()
+	assert.False(t, l.IsEnabled(lint.RuleUnusedAssign))
+	ds := l.LintExprs([]exprs.Expr{expr})
+	require.Len(t, ds, 2)
+	assert.Equal(t, "表达式 0 第 1 行第 4 列 警告[double-equality]：浮点数用 == 比较可能受舍入误差影响，应比较差的绝对值是否小于允许的误差", ds[0].String())
+	assert.Equal(t, lint.RuleSelfAssign, ds[1].Rule)
+
+	l.SetEnabled(lint.RuleDoubleEquality, false)
+	l.SetSeverity(lint.RuleSelfAssign, lint.SeverityError)
+	l.SetSupplied("a")
+	ds = l.LintExprs([]exprs.Expr{expr})
+	require.Len(t, ds, 1)
+	assert.Equal(t, lint.SeverityError, ds[0].Severity)
+	assert.Equal(t, 1, ds[0].Line)
+	assert.Equal(t, 14, ds[0].Column)
+
+	_, err = l.LintSource([]string{"1 +"})
+	assert.ErrorContains(t, err, "表达式 0 解析出错")
+}
+
+func TestLinter_UndefinedVarNeedsInputs(t *testing.T) {
+	lines := []string{"a = 1", "b = a + c"}
+
+	// 默认不知道哪些变量是输入，不报告未定义的读取
+	ds, err := lint.NewLinter().LintSource(lines)
+	require.NoError(t, err)
+	assert.Empty(t, ds)
+
+	l := lint.NewLinter()
+	l.SetSupplied()
+	ds, err = l.LintSource(lines)
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	assert.Equal(t, lint.RuleUndefinedVar, ds[0].Rule)
+	assert.Equal(t, `表达式 1 第 1 行第 9 列 "c" 错误[undefined-var]：变量 c 既没有被赋值，也不是输入`, ds[0].String())
+
+	l.SetSupplied("c")
+	ds, err = l.LintSource(lines)
+	require.NoError(t, err)
+	assert.Empty(t, ds)
+}
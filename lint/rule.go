@@ -0,0 +1,85 @@
+package lint
+
+import "fmt"
+
+// Severity 问题的严重程度
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "提示"
+	case SeverityWarning:
+		return "警告"
+	case SeverityError:
+		return "错误"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Rule 检查规则的名称
+type Rule string
+
+const (
+	// RuleUnusedAssign 被赋值但没有任何表达式读取的变量。公式集的结果通常只赋值不读取，默认关闭，
+	// 开启时用 Linter.SetOutputs 排除结果变量
+	RuleUnusedAssign Rule = "unused-assign"
+	// RuleUndefinedVar 被读取但既没有被赋值也没有作为输入提供的变量，设置了输入（见 Linter.SetSupplied、Linter.SetSchema）时才检查
+	RuleUndefinedVar Rule = "undefined-var"
+	// RuleSelfAssign 把变量赋值给自身，如 x = x
+	RuleSelfAssign Rule = "self-assign"
+	// RuleConstantCondition if 的条件是常量，总是执行同一分支
+	RuleConstantCondition Rule = "constant-condition"
+	// RuleMixedTypeCompare 字面量与类型不同的值比较，如 x == "1"，其中 x 是数值
+	RuleMixedTypeCompare Rule = "mixed-type-compare"
+	// RuleDoubleEquality 用 == 或 != 比较浮点数
+	RuleDoubleEquality Rule = "double-equality"
+	// RuleOverwrittenAssign 赋值的结果在被读取之前又被赋值覆盖
+	RuleOverwrittenAssign Rule = "overwritten-assign"
+)
+
+// Rules 全部规则，按报告时的顺序排列
+var Rules = []Rule{
+	RuleUnusedAssign,
+	RuleUndefinedVar,
+	RuleSelfAssign,
+	RuleConstantCondition,
+	RuleMixedTypeCompare,
+	RuleDoubleEquality,
+	RuleOverwrittenAssign,
+}
+
+// RuleConfig 规则的配置
+type RuleConfig struct {
+	Enabled  bool
+	Severity Severity
+}
+
+// DefaultConfig 各规则的默认配置
+func DefaultConfig() map[Rule]RuleConfig {
+	return map[Rule]RuleConfig{
+		RuleUnusedAssign:      {Enabled: false, Severity: SeverityInfo},
+		RuleUndefinedVar:      {Enabled: true, Severity: SeverityError},
+		RuleSelfAssign:        {Enabled: true, Severity: SeverityWarning},
+		RuleConstantCondition: {Enabled: true, Severity: SeverityWarning},
+		RuleMixedTypeCompare:  {Enabled: true, Severity: SeverityWarning},
+		RuleDoubleEquality:    {Enabled: true, Severity: SeverityWarning},
+		RuleOverwrittenAssign: {Enabled: true, Severity: SeverityWarning},
+	}
+}
+
+// ruleOrder 规则在 Rules 中的序号，用于排序
+func ruleOrder(rule Rule) int {
+	for i, r := range Rules {
+		if r == rule {
+			return i
+		}
+	}
+	return len(Rules)
+}
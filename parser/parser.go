@@ -11,6 +11,9 @@ import (
 var prefixParselets = map[values.TokenType]parselet.PrefixParselet{
 	values.NUMBER:     parselet.NewLiteralParselet(),
 	values.STRING:     parselet.NewLiteralParselet(),
+	values.TRUE:       parselet.NewLiteralParselet(),
+	values.FALSE:      parselet.NewLiteralParselet(),
+	values.NULL:       parselet.NewLiteralParselet(),
 	values.IDENTIFIER: parselet.NewIdParselet(),
 	values.LEFT_PAREN: parselet.NewGroupParselet(),
 	values.MINUS:      parselet.NewPreUnaryParselet(PREC_UNARY),
@@ -77,7 +80,9 @@ func (p *Parser) ExpressionPrec(minPrec int) exprs.Expr {
 		panic(parselet.NewLoxParseError(token, "unknown token: "+token.Lexeme))
 	}
 
+	start := token
 	lhs := prefixParselet.Parse(p, token)
+	p.markSpan(lhs, start)
 
 	for !p.IsAtEnd() {
 		next := p.Peek()
@@ -93,11 +98,24 @@ func (p *Parser) ExpressionPrec(minPrec int) exprs.Expr {
 
 		token = p.Advance()
 		lhs = infixParselet.Parse(p, lhs, token)
+		p.markSpan(lhs, start)
 	}
 
 	return lhs
 }
 
+// markSpan 记录表达式的源码范围：从 start 到刚消费的 token。
+// 括号表达式返回内部表达式，其范围会被外层扩展为包含括号
+func (p *Parser) markSpan(expr exprs.Expr, start values.Token) {
+	end := p.Previous()
+	exprs.SetSpan(expr, exprs.Span{
+		Start:  start.Offset,
+		End:    end.Offset + len([]rune(end.Lexeme)),
+		Line:   start.Line,
+		Column: start.Column,
+	})
+}
+
 // Match 检查当前token是否匹配任意给定的类型
 func (p *Parser) Match(types ...values.TokenType) bool {
 	for _, t := range types {
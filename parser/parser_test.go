@@ -31,6 +31,14 @@ func TestParseStringLiteral(t *testing.T) {
 	assert.Equal(t, "abc", literalExpr.String())
 }
 
+func TestParseKeywordLiteral(t *testing.T) {
+	for src, expected := range map[string]any{"true": true, "false": false, "null": nil} {
+		literalExpr, ok := parseExpr(src).(*exprs.LiteralExpr)
+		assert.True(t, ok, src)
+		assert.Equal(t, expected, literalExpr.Value.GetValue(), src)
+	}
+}
+
 func TestParseIdentifier(t *testing.T) {
 	expr := parseExpr("foo")
 	idExpr, ok := expr.(*exprs.IdExpr)
@@ -84,3 +92,17 @@ func TestParseInvalidInput(t *testing.T) {
 		parseExpr("a * b + c)")
 	})
 }
+
+func TestParseSpan(t *testing.T) {
+	src := "x = foo *\n  (bar + 1)"
+	assign := parseExpr(src).(*exprs.AssignExpr)
+	assert.Equal(t, exprs.Span{Start: 0, End: 21, Line: 1, Column: 1}, assign.GetSpan())
+	assert.Equal(t, "foo *\n  (bar + 1)", exprs.SpanOf(assign.Right).Text(src))
+
+	group := assign.Right.(*exprs.BinaryExpr).Right
+	assert.Equal(t, "(bar + 1)", exprs.SpanOf(group).Text(src))
+	assert.Equal(t, 2, exprs.SpanOf(group).Line)
+	assert.Equal(t, 3, exprs.SpanOf(group).Column)
+	bar := group.(*exprs.BinaryExpr).Left
+	assert.Equal(t, exprs.Span{Start: 13, End: 16, Line: 2, Column: 4}, exprs.SpanOf(bar))
+}
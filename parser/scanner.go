@@ -14,6 +14,8 @@ type Scanner struct {
 	start   int
 	current int
 	line    int
+	lineAt  int // 当前行起始字符的偏移
+	column  int // 当前 token 起始列
 	runes   []rune
 }
 
@@ -48,10 +50,11 @@ func NewScanner(source string) *Scanner {
 
 func (s *Scanner) ScanTokens() []values.Token {
 	for !s.isEnd() {
-		s.start = s.current
+		s.markStart()
 		s.scanToken()
 	}
-	s.tokens = append(s.tokens, *values.NewToken(values.EOF, "", values.NewNullValue(), s.line))
+	s.markStart()
+	s.addToken(values.EOF, values.NewNullValue())
 	return s.tokens
 }
 
@@ -131,7 +134,7 @@ func (s *Scanner) scanToken() {
 	case ' ', '\t', '\r':
 		// ignore whitespace
 	case '\n':
-		s.line++
+		s.newLine()
 	case '"':
 		s.stringToken()
 	default:
@@ -147,10 +150,9 @@ func (s *Scanner) scanToken() {
 
 func (s *Scanner) stringToken() {
 	for s.peek() != '"' && !s.isEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		if s.advance() == '\n' {
+			s.newLine()
 		}
-		s.advance()
 	}
 	if s.isEnd() {
 		panic(fmt.Sprintf("line %d: Unterminated string.", s.line))
@@ -193,7 +195,14 @@ func (s *Scanner) identity() {
 	if !ok {
 		typ = values.IDENTIFIER
 	}
-	s.addToken(typ, values.NewNullValue())
+	literal := values.NewNullValue()
+	switch typ {
+	case values.TRUE:
+		literal = values.NewBooleanValue(true)
+	case values.FALSE:
+		literal = values.NewBooleanValue(false)
+	}
+	s.addToken(typ, literal)
 }
 
 func (s *Scanner) isEnd() bool {
@@ -233,7 +242,22 @@ func (s *Scanner) peekNext() rune {
 
 func (s *Scanner) addToken(typ values.TokenType, literal values.Value) {
 	text := string(s.runes[s.start:s.current])
-	s.tokens = append(s.tokens, *values.NewToken(typ, text, literal, s.line))
+	token := values.NewToken(typ, text, literal, s.line)
+	token.Offset = s.start
+	token.Column = s.column
+	s.tokens = append(s.tokens, *token)
+}
+
+// markStart 开始读取新的 token
+func (s *Scanner) markStart() {
+	s.start = s.current
+	s.column = s.current - s.lineAt + 1
+}
+
+// newLine 刚读过换行符
+func (s *Scanner) newLine() {
+	s.line++
+	s.lineAt = s.current
 }
 
 // 工具函数
@@ -0,0 +1,183 @@
+package profile
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WritePprof 以 pprof 格式（gzip 压缩的 protobuf，见 github.com/google/pprof/proto/profile.proto）输出剖析结果，
+// 可用 go tool pprof 查看。每个表达式和函数各对应一个 pprof 函数，函数调用的调用栈为 [函数, 表达式]。
+// 样本值为次数和时间（纳秒），表达式的时间不含其中的函数调用
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	b := newPprofBuilder()
+	exprLocs := make(map[int]uint64)
+	exprSelf := make(map[int]int64)
+	indexes := make([]int, 0, len(p.exprs))
+	for index, e := range p.exprs {
+		indexes = append(indexes, index)
+		exprSelf[index] = int64(e.Total)
+	}
+	slices.Sort(indexes)
+	for _, index := range indexes {
+		name := fmt.Sprintf("表达式 %d", index)
+		if src := p.sources[index]; src != "" {
+			name += "：" + strings.Join(strings.Fields(src), " ")
+		}
+		exprLocs[index] = b.location(name)
+	}
+
+	keys := make([]callKey, 0, len(p.calls))
+	for key := range p.calls {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b callKey) int {
+		if a.index != b.index {
+			return a.index - b.index
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	for _, key := range keys {
+		f := p.calls[key]
+		exprLoc, ok := exprLocs[key.index]
+		if !ok {
+			// 表达式出错时没有记录表达式本身
+			exprLoc = b.location(fmt.Sprintf("表达式 %d", key.index))
+			exprLocs[key.index] = exprLoc
+		}
+		b.sample([]uint64{b.location(key.name), exprLoc}, f.Calls, int64(f.Total))
+		exprSelf[key.index] -= int64(f.Total)
+	}
+	for _, index := range indexes {
+		b.sample([]uint64{exprLocs[index]}, p.exprs[index].Count, max(exprSelf[index], 0))
+	}
+	start := p.start
+	p.mu.Unlock()
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.build(start)); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// pprofBuilder 生成 pprof 的 Profile 消息，只使用需要的字段
+type pprofBuilder struct {
+	strings   []string
+	stringIdx map[string]int64
+	locations map[string]uint64
+	functions []byte // 已编码的 Function 和 Location 消息
+	samples   []byte
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:   []string{""},
+		stringIdx: map[string]int64{"": 0},
+		locations: make(map[string]uint64),
+	}
+}
+
+func (b *pprofBuilder) str(s string) int64 {
+	if i, ok := b.stringIdx[s]; ok {
+		return i
+	}
+	i := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIdx[s] = i
+	return i
+}
+
+// location 返回名为 name 的函数所在位置的编号，函数和位置使用相同的编号
+func (b *pprofBuilder) location(name string) uint64 {
+	if id, ok := b.locations[name]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[name] = id
+
+	var fn protoBuf
+	fn.varint(1, id)
+	fn.varint(2, uint64(b.str(name)))
+	fn.varint(3, uint64(b.str(name)))
+	b.functions = appendMessage(b.functions, 5, fn)
+
+	var line protoBuf
+	line.varint(1, id)
+	var loc protoBuf
+	loc.varint(1, id)
+	loc.message(4, line)
+	b.functions = appendMessage(b.functions, 4, loc)
+	return id
+}
+
+func (b *pprofBuilder) sample(locations []uint64, count, nanos int64) {
+	var s protoBuf
+	s.packed(1, locations)
+	s.packed(2, []uint64{uint64(count), uint64(nanos)})
+	b.samples = appendMessage(b.samples, 2, s)
+}
+
+func (b *pprofBuilder) build(start time.Time) []byte {
+	var profile protoBuf
+	valueType := func(typ, unit string) protoBuf {
+		var vt protoBuf
+		vt.varint(1, uint64(b.str(typ)))
+		vt.varint(2, uint64(b.str(unit)))
+		return vt
+	}
+	profile.message(1, valueType("samples", "count"))
+	profile.message(1, valueType("time", "nanoseconds"))
+	period := valueType("time", "nanoseconds")
+	profile = append(profile, b.samples...)
+	profile = append(profile, b.functions...)
+	profile.varint(9, uint64(start.UnixNano()))
+	profile.varint(10, uint64(time.Since(start)))
+	profile.message(11, period)
+	profile.varint(12, 1)
+	for _, s := range b.strings {
+		profile.bytes(6, []byte(s))
+	}
+	return profile
+}
+
+// protoBuf protobuf 编码
+type protoBuf []byte
+
+func (p *protoBuf) tag(field int, wireType int) {
+	*p = binary.AppendUvarint(*p, uint64(field<<3|wireType))
+}
+
+func (p *protoBuf) varint(field int, v uint64) {
+	p.tag(field, 0)
+	*p = binary.AppendUvarint(*p, v)
+}
+
+func (p *protoBuf) bytes(field int, data []byte) {
+	p.tag(field, 2)
+	*p = binary.AppendUvarint(*p, uint64(len(data)))
+	*p = append(*p, data...)
+}
+
+func (p *protoBuf) message(field int, msg protoBuf) {
+	p.bytes(field, msg)
+}
+
+func (p *protoBuf) packed(field int, vs []uint64) {
+	var data []byte
+	for _, v := range vs {
+		data = binary.AppendUvarint(data, v)
+	}
+	p.bytes(field, data)
+}
+
+func appendMessage(dst []byte, field int, msg protoBuf) []byte {
+	var p protoBuf = dst
+	p.message(field, msg)
+	return p
+}
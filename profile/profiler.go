@@ -0,0 +1,235 @@
+package profile
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/simonwater/gopression/chk"
+)
+
+// Profiler 执行剖析器，记录各表达式的执行次数和时间、各函数的调用次数和时间，以及虚拟机各操作码的执行次数。
+// 表达式按序号累计，多次执行同一批表达式时结果相加。并发安全
+type Profiler struct {
+	mu      sync.Mutex
+	start   time.Time
+	sources map[int]string
+	exprs   map[int]*ExprProfile
+	calls   map[callKey]*FuncProfile // 按表达式和函数分别累计，用于生成 pprof 格式
+	opcodes [256]int64
+}
+
+type callKey struct {
+	index int
+	name  string
+}
+
+// ExprProfile 表达式的剖析结果。Total 包含其中函数调用的时间
+type ExprProfile struct {
+	Index  int
+	Source string
+	Count  int64
+	Total  time.Duration
+}
+
+// FuncProfile 函数的剖析结果，只统计函数本身的执行时间，不包括参数求值
+type FuncProfile struct {
+	Name  string
+	Calls int64
+	Total time.Duration
+}
+
+// OpProfile 操作码的执行次数
+type OpProfile struct {
+	Op    chk.OpCode
+	Count int64
+}
+
+func NewProfiler() *Profiler {
+	p := &Profiler{}
+	p.Reset()
+	return p
+}
+
+// Reset 清空已记录的结果
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start = time.Now()
+	p.sources = make(map[int]string)
+	p.exprs = make(map[int]*ExprProfile)
+	p.calls = make(map[callKey]*FuncProfile)
+	p.opcodes = [256]int64{}
+}
+
+// SetSources 记录各表达式的源码，用于报告中显示，expressions 按表达式序号排列
+func (p *Profiler) SetSources(expressions []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, src := range expressions {
+		p.sources[i] = src
+	}
+}
+
+// RecordExpr 记录序号为 index 的表达式执行一次，耗时 d
+func (p *Profiler) RecordExpr(index int, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.exprs[index]
+	if !ok {
+		e = &ExprProfile{Index: index}
+		p.exprs[index] = e
+	}
+	e.Count++
+	e.Total += d
+}
+
+// RecordCall 记录序号为 index 的表达式中调用一次函数 name，耗时 d
+func (p *Profiler) RecordCall(index int, name string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := callKey{index: index, name: name}
+	f, ok := p.calls[key]
+	if !ok {
+		f = &FuncProfile{Name: name}
+		p.calls[key] = f
+	}
+	f.Calls++
+	f.Total += d
+}
+
+// RecordOps 累加各操作码的执行次数，counts 以操作码为下标
+func (p *Profiler) RecordOps(counts *[256]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for op, n := range counts {
+		p.opcodes[op] += n
+	}
+}
+
+// ProfileSortKey 报告的排序方式
+type ProfileSortKey int
+
+const (
+	SortByTotal ProfileSortKey = iota // 按总时间（操作码按次数）降序
+	SortByCount                       // 按次数降序
+	SortByName                        // 按表达式序号、函数名或操作码升序
+)
+
+// ProfileReport 剖析报告
+type ProfileReport struct {
+	Duration time.Duration // 从创建或 Reset 到生成报告的时间
+	Exprs    []ExprProfile
+	Funcs    []FuncProfile
+	Ops      []OpProfile
+}
+
+// Report 生成报告，默认按总时间降序排列
+func (p *Profiler) Report() *ProfileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := &ProfileReport{Duration: time.Since(p.start)}
+	for _, e := range p.exprs {
+		profile := *e
+		profile.Source = p.sources[e.Index]
+		report.Exprs = append(report.Exprs, profile)
+	}
+	funcs := make(map[string]*FuncProfile)
+	for key, f := range p.calls {
+		total, ok := funcs[key.name]
+		if !ok {
+			total = &FuncProfile{Name: key.name}
+			funcs[key.name] = total
+		}
+		total.Calls += f.Calls
+		total.Total += f.Total
+	}
+	for _, f := range funcs {
+		report.Funcs = append(report.Funcs, *f)
+	}
+	for op, n := range p.opcodes {
+		if n > 0 {
+			report.Ops = append(report.Ops, OpProfile{Op: chk.OpCode(op), Count: n})
+		}
+	}
+	report.Sort(SortByTotal)
+	return report
+}
+
+// Sort 按 key 重新排列报告中的各项，次数或时间相同时按序号或名称排列
+func (r *ProfileReport) Sort(key ProfileSortKey) {
+	slices.SortFunc(r.Exprs, func(a, b ExprProfile) int {
+		switch key {
+		case SortByTotal:
+			if c := cmp.Compare(b.Total, a.Total); c != 0 {
+				return c
+			}
+		case SortByCount:
+			if c := cmp.Compare(b.Count, a.Count); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.Index, b.Index)
+	})
+	slices.SortFunc(r.Funcs, func(a, b FuncProfile) int {
+		switch key {
+		case SortByTotal:
+			if c := cmp.Compare(b.Total, a.Total); c != 0 {
+				return c
+			}
+		case SortByCount:
+			if c := cmp.Compare(b.Calls, a.Calls); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(r.Ops, func(a, b OpProfile) int {
+		if key != SortByName {
+			if c := cmp.Compare(b.Count, a.Count); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.Op, b.Op)
+	})
+}
+
+// WriteText 以表格形式输出报告，limit 大于 0 时每部分最多输出 limit 项
+func (r *ProfileReport) WriteText(w io.Writer, limit int) error {
+	take := func(n int) int {
+		if limit > 0 {
+			return min(n, limit)
+		}
+		return n
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "总时间：%v\n\n", r.Duration)
+	fmt.Fprintln(tw, "表达式\t次数\t总时间\t平均\t源码")
+	for _, e := range r.Exprs[:take(len(r.Exprs))] {
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%v\t%s\n", e.Index, e.Count, e.Total, average(e.Total, e.Count), e.Source)
+	}
+	if len(r.Funcs) > 0 {
+		fmt.Fprintln(tw, "\n函数\t调用次数\t总时间\t平均\t")
+		for _, f := range r.Funcs[:take(len(r.Funcs))] {
+			fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t\n", f.Name, f.Calls, f.Total, average(f.Total, f.Calls))
+		}
+	}
+	if len(r.Ops) > 0 {
+		fmt.Fprintln(tw, "\n操作码\t次数\t\t\t")
+		for _, op := range r.Ops[:take(len(r.Ops))] {
+			fmt.Fprintf(tw, "%s\t%d\t\t\t\n", op.Op.Title(), op.Count)
+		}
+	}
+	return tw.Flush()
+}
+
+func average(total time.Duration, count int64) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	return total / time.Duration(count)
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/simonwater/gopression/ir"
+)
+
+// UndeclaredError 表达式读取了未声明、也没有被赋值的变量
+type UndeclaredError struct {
+	Names []string // 按名称排序
+}
+
+func (e *UndeclaredError) Error() string {
+	return fmt.Sprintf("未声明的输入变量：%s", strings.Join(e.Names, ", "))
+}
+
+// CheckVariables 检查变量查询的结果（见 ir.VarsQuery）：读取的变量除了被表达式赋值的之外都必须声明，
+// 声明了实例时其属性视为已声明。有未声明的变量时返回 *UndeclaredError
+func (s *Schema) CheckVariables(vs *ir.VariableSet) error {
+	if vs == nil {
+		return nil
+	}
+	var undeclared []string
+	for name := range vs.GetDepends() {
+		if !vs.GetAssigns()[name] && !s.IsDeclared(name) {
+			undeclared = append(undeclared, name)
+		}
+	}
+	if len(undeclared) == 0 {
+		return nil
+	}
+	slices.Sort(undeclared)
+	return &UndeclaredError{Names: undeclared}
+}
+
+// IsDeclared 变量或其所属的实例已声明
+func (s *Schema) IsDeclared(name string) bool {
+	for {
+		if _, ok := s.vars[name]; ok {
+			return true
+		}
+		dot := strings.LastIndexByte(name, '.')
+		if dot < 0 {
+			return false
+		}
+		name = name[:dot]
+	}
+}
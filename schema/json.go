@@ -0,0 +1,157 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/simonwater/gopression/values"
+)
+
+// jsonVar 变量声明的 JSON 格式，类型用 values.ValueType 的名称表示，不区分大小写，省略或为 any 时不限类型
+type jsonVar struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Default  any      `json:"default,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Enum     []any    `json:"enum,omitempty"`
+}
+
+type jsonSchema struct {
+	Coerce bool       `json:"coerce,omitempty"`
+	Vars   []*jsonVar `json:"vars"`
+}
+
+// Load 从 JSON 读取变量声明，格式如：
+//
+//	{
+//	  "coerce": true,
+//	  "vars": [
+//	    {"name": "price", "type": "Double", "required": true, "min": 0},
+//	    {"name": "level", "type": "String", "default": "low", "enum": ["low", "high"]}
+//	  ]
+//	}
+func Load(r io.Reader) (*Schema, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	var js jsonSchema
+	if err := dec.Decode(&js); err != nil {
+		return nil, fmt.Errorf("变量声明格式错误：%w", err)
+	}
+	s := NewSchema()
+	s.SetCoerce(js.Coerce)
+	for i, jv := range js.Vars {
+		if jv.Name == "" {
+			return nil, fmt.Errorf("第 %d 个变量声明缺少变量名", i+1)
+		}
+		if s.GetVar(jv.Name) != nil {
+			return nil, fmt.Errorf("变量 %s 重复声明", jv.Name)
+		}
+		typ, err := parseType(jv.Type)
+		if err != nil {
+			return nil, fmt.Errorf("变量 %s：%w", jv.Name, err)
+		}
+		v := s.Declare(jv.Name, typ).SetRequired(jv.Required)
+		v.Min, v.Max = jv.Min, jv.Max
+		if v.Min != nil && v.Max != nil && *v.Min > *v.Max {
+			return nil, fmt.Errorf("变量 %s：下限 %g 大于上限 %g", jv.Name, *v.Min, *v.Max)
+		}
+		if jv.Default != nil {
+			def, err := fromJSON(jv.Default, typ)
+			if err != nil {
+				return nil, fmt.Errorf("变量 %s 的默认值：%w", jv.Name, err)
+			}
+			v.SetDefault(def)
+		}
+		for _, raw := range jv.Enum {
+			value, err := fromJSON(raw, typ)
+			if err != nil {
+				return nil, fmt.Errorf("变量 %s 的取值 %v：%w", jv.Name, raw, err)
+			}
+			v.Enum = append(v.Enum, value)
+		}
+	}
+	return s, nil
+}
+
+// Parse 从 JSON 文本读取变量声明，格式见 Load
+func Parse(data []byte) (*Schema, error) {
+	return Load(bytes.NewReader(data))
+}
+
+// MarshalJSON 按 Load 的格式输出，变量按声明顺序排列
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	js := jsonSchema{Coerce: s.coerce, Vars: make([]*jsonVar, 0, len(s.names))}
+	for _, v := range s.GetVars() {
+		js.Vars = append(js.Vars, v.toJSON())
+	}
+	return json.Marshal(js)
+}
+
+func (v *Var) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.toJSON())
+}
+
+func (v *Var) toJSON() *jsonVar {
+	jv := &jsonVar{Name: v.Name, Type: typeName(v.Type), Required: v.Required, Min: v.Min, Max: v.Max}
+	if v.Type == 0 {
+		jv.Type = ""
+	}
+	if v.Default != nil {
+		jv.Default = v.Default.GetValue()
+	}
+	for _, value := range v.Enum {
+		jv.Enum = append(jv.Enum, value.GetValue())
+	}
+	return jv
+}
+
+// parseType 按名称查找类型，只支持执行时会出现的类型
+func parseType(name string) (values.ValueType, error) {
+	if name == "" || strings.EqualFold(name, "any") {
+		return 0, nil
+	}
+	for _, vt := range []values.ValueType{values.Vt_Integer, values.Vt_Double, values.Vt_String, values.Vt_Boolean, values.Vt_Instance} {
+		if strings.EqualFold(name, vt.String()) {
+			return vt, nil
+		}
+	}
+	return 0, fmt.Errorf("未知的类型 %s", name)
+}
+
+func typeName(vt values.ValueType) string {
+	if vt == 0 {
+		return "Any"
+	}
+	return vt.String()
+}
+
+// fromJSON 把 JSON 值转换为声明类型的值，不限类型时整数转换为 Integer，其他数值转换为 Double
+func fromJSON(raw any, typ values.ValueType) (values.Value, error) {
+	var value values.Value
+	switch r := raw.(type) {
+	case json.Number:
+		if i, err := r.Int64(); err == nil && typ != values.Vt_Double && int64(int32(i)) == i {
+			value = values.NewIntValue(int32(i))
+		} else if f, err := r.Float64(); err == nil {
+			value = values.NewDoubleValue(f)
+		} else {
+			return value, err
+		}
+	case string:
+		value = values.NewStringValue(r)
+	case bool:
+		value = values.NewBooleanValue(r)
+	default:
+		return value, fmt.Errorf("不支持的 JSON 值 %v", raw)
+	}
+	if typ != 0 && value.GetValueType() != typ {
+		return value, fmt.Errorf("类型应为 %s，实际为 %s", typeName(typ), value.GetValueType())
+	}
+	return value, nil
+}
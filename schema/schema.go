@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+
+	"github.com/simonwater/gopression/values"
+)
+
+// Var 输入变量的声明
+type Var struct {
+	Name     string           // 变量名，实例的属性用 . 分隔的路径表示，如 order.price
+	Type     values.ValueType // 0 表示不限类型
+	Required bool             // 执行时是否必须提供
+	Default  *values.Value    // 没有提供时使用的默认值，为 nil 时没有默认值
+	Min      *float64         // 数值的下限（含），为 nil 时不限
+	Max      *float64         // 数值的上限（含），为 nil 时不限
+	Enum     []values.Value   // 允许的取值，为空时不限
+}
+
+// SetRequired 设置是否必须提供，返回 v 以便连续设置
+func (v *Var) SetRequired(required bool) *Var {
+	v.Required = required
+	return v
+}
+
+// SetDefault 设置默认值
+func (v *Var) SetDefault(value values.Value) *Var {
+	v.Default = &value
+	return v
+}
+
+// SetRange 设置数值的取值范围（含两端）
+func (v *Var) SetRange(min, max float64) *Var {
+	v.Min, v.Max = &min, &max
+	return v
+}
+
+// SetEnum 设置允许的取值
+func (v *Var) SetEnum(enum ...values.Value) *Var {
+	v.Enum = enum
+	return v
+}
+
+// Schema 一组表达式的输入变量声明。创建后只在单个协程中修改，之后可以并发读取
+type Schema struct {
+	vars   map[string]*Var
+	names  []string // 声明顺序
+	coerce bool
+}
+
+func NewSchema() *Schema {
+	return &Schema{vars: make(map[string]*Var)}
+}
+
+// Declare 声明变量，已声明时替换原来的声明
+func (s *Schema) Declare(name string, typ values.ValueType) *Var {
+	v := &Var{Name: name, Type: typ}
+	if _, ok := s.vars[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.vars[name] = v
+	return v
+}
+
+// GetVar 按名称查找变量声明，未声明时返回 nil
+func (s *Schema) GetVar(name string) *Var {
+	return s.vars[name]
+}
+
+// GetVars 按声明顺序返回全部变量声明
+func (s *Schema) GetVars() []*Var {
+	result := make([]*Var, len(s.names))
+	for i, name := range s.names {
+		result[i] = s.vars[name]
+	}
+	return result
+}
+
+func (s *Schema) Len() int {
+	return len(s.names)
+}
+
+func (s *Schema) IsCoerce() bool {
+	return s.coerce
+}
+
+// SetCoerce 校验输入时是否转换类型不符的值（见 Validate），默认不转换，类型不符即报错
+func (s *Schema) SetCoerce(coerce bool) {
+	s.coerce = coerce
+}
+
+// GetVarType 变量的声明类型，未声明或不限类型时返回 false，用于静态类型检查（见 ir.TypeChecker）
+func (s *Schema) GetVarType(name string) (values.ValueType, bool) {
+	v, ok := s.vars[name]
+	if !ok || v.Type == 0 {
+		return 0, false
+	}
+	return v.Type, true
+}
+
+// Fingerprint 声明内容的指纹，与声明顺序无关，用于编译缓存的键
+func (s *Schema) Fingerprint() uint64 {
+	sorted := slices.Clone(s.names)
+	slices.Sort(sorted)
+	h := fnv.New64a()
+	enc := json.NewEncoder(h)
+	enc.Encode(s.coerce)
+	for _, name := range sorted {
+		enc.Encode(s.vars[name])
+	}
+	return h.Sum64()
+}
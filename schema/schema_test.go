@@ -0,0 +1,108 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/schema"
+	"github.com/simonwater/gopression/values"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const orderSchema = `{
+  "coerce": true,
+  "vars": [
+    {"name": "price", "type": "Double", "required": true, "min": 0},
+    {"name": "qty", "type": "integer", "default": 1, "min": 1, "max": 100},
+    {"name": "level", "type": "String", "default": "low", "enum": ["low", "high"]},
+    {"name": "customer"}
+  ]
+}`
+
+func TestSchema_Load(t *testing.T) {
+	s, err := schema.Parse([]byte(orderSchema))
+	require.NoError(t, err)
+	require.Equal(t, 4, s.Len())
+	assert.True(t, s.IsCoerce())
+	qty := s.GetVar("qty")
+	assert.Equal(t, values.Vt_Integer, qty.Type)
+	assert.Equal(t, values.NewIntValue(1), *qty.Default)
+	assert.Equal(t, 100.0, *qty.Max)
+	assert.Equal(t, []values.Value{values.NewStringValue("low"), values.NewStringValue("high")}, s.GetVar("level").Enum)
+	_, ok := s.GetVarType("customer")
+	assert.False(t, ok, "不限类型")
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	reloaded, err := schema.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, s.Fingerprint(), reloaded.Fingerprint())
+	reloaded.GetVar("qty").SetRange(1, 50)
+	assert.NotEqual(t, s.Fingerprint(), reloaded.Fingerprint())
+
+	for src, msg := range map[string]string{
+		`{"vars": [{"name": "a", "type": "Date"}]}`:                    "变量 a：未知的类型 Date",
+		`{"vars": [{"name": "a", "type": "Integer", "default": 1.5}]}`: "变量 a 的默认值：类型应为 Integer，实际为 Double",
+		`{"vars": [{"name": "a"}, {"name": "a"}]}`:                     "变量 a 重复声明",
+		`{"vars": [{"name": "a", "min": 2, "max": 1}]}`:                "变量 a：下限 2 大于上限 1",
+		`{"vars": [{"type": "Double"}]}`:                               "第 1 个变量声明缺少变量名",
+	} {
+		_, err := schema.Parse([]byte(src))
+		assert.EqualError(t, err, msg, src)
+	}
+	_, err = schema.Parse([]byte(`{"vars": [{"name": "a", "size": 1}]}`))
+	assert.ErrorContains(t, err, "变量声明格式错误")
+}
+
+func TestSchema_Validate(t *testing.T) {
+	s, err := schema.Parse([]byte(orderSchema))
+	require.NoError(t, err)
+
+	ev := env.NewDefaultEnvironment()
+	ev.Put("price", values.NewStringValue(" 12.5 "))
+	ev.Put("qty", values.NewDoubleValue(3))
+	require.NoError(t, s.Validate(ev))
+	assert.Equal(t, values.NewDoubleValue(12.5), ev.Get("price"), "按声明转换")
+	assert.Equal(t, values.NewIntValue(3), ev.Get("qty"))
+	assert.Equal(t, values.NewStringValue("low"), ev.Get("level"), "使用默认值")
+	assert.True(t, ev.Get("customer").IsNull(), "可选且没有默认值")
+
+	ev = env.NewDefaultEnvironment()
+	ev.Put("qty", values.NewDoubleValue(2.5))
+	ev.Put("level", values.NewStringValue("mid"))
+	err = s.Validate(ev)
+	var errs schema.ValidationErrors
+	require.ErrorAs(t, err, &errs)
+	assert.Equal(t, "输入变量 price：缺少必需的输入\n"+
+		"输入变量 qty：无法将 2.5 转换为 Integer\n"+
+		`输入变量 level：值 "mid" 不是允许的取值 ["low", "high"]`, err.Error())
+
+	s.SetCoerce(false)
+	ev = env.NewDefaultEnvironment()
+	ev.Put("price", values.NewIntValue(-1))
+	ev.Put("qty", values.NewStringValue("3"))
+	assert.EqualError(t, s.Validate(ev), "输入变量 price：值 -1 小于下限 0\n输入变量 qty：类型应为 Integer，实际为 String")
+
+	nested := schema.NewSchema()
+	nested.Declare("order.total", values.Vt_Double).SetDefault(values.NewDoubleValue(0))
+	ev = env.NewDefaultEnvironment()
+	ev.PutInstance("order", values.NewInstance())
+	require.NoError(t, nested.Validate(ev))
+	assert.Equal(t, values.NewDoubleValue(0), ev.Get("order").AsInstance().Fields["total"])
+}
+
+func TestSchema_CheckVariables(t *testing.T) {
+	s := schema.NewSchema()
+	s.Declare("price", values.Vt_Double)
+	s.Declare("order", values.Vt_Instance)
+	vs, err := ir.NewVarsQuery().ExecuteSrc("total = price * qty + order.fee + discount + total2")
+	require.NoError(t, err)
+	assert.EqualError(t, s.CheckVariables(vs), "未声明的输入变量：discount, qty, total2")
+
+	vs, err = ir.NewVarsQuery().ExecuteSrc("total = price * 2 + order.fee")
+	require.NoError(t, err)
+	assert.NoError(t, s.CheckVariables(vs))
+}
@@ -0,0 +1,182 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/values"
+)
+
+// ValidationError 一个输入变量的校验错误
+type ValidationError struct {
+	Name string
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("输入变量 %s：%s", e.Name, e.Msg)
+}
+
+// ValidationErrors 全部输入变量的校验错误，按声明顺序排列
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate 按声明校验执行环境中的输入：没有提供的变量使用默认值，必需的变量没有提供时报错；
+// 类型不符时开启了转换（见 SetCoerce）则转换后写回执行环境，否则报错，整数总是可以作为 Double；
+// 再检查取值范围和允许的取值。有错误时返回 ValidationErrors，此时执行环境中可能已写入部分默认值和转换结果
+func (s *Schema) Validate(ev env.Environment) error {
+	var errs ValidationErrors
+	for _, v := range s.GetVars() {
+		if msg := s.validateVar(ev, v); msg != "" {
+			errs = append(errs, &ValidationError{Name: v.Name, Msg: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *Schema) validateVar(ev env.Environment, v *Var) string {
+	value := getVar(ev, v.Name)
+	if value.IsNull() || value.GetValueType() == 0 {
+		switch {
+		case v.Default != nil:
+			return putVar(ev, v.Name, *v.Default)
+		case v.Required:
+			return "缺少必需的输入"
+		}
+		return ""
+	}
+
+	if v.Type != 0 && value.GetValueType() != v.Type &&
+		!(v.Type == values.Vt_Double && value.IsInteger()) {
+		if !s.coerce {
+			return fmt.Sprintf("类型应为 %s，实际为 %s", typeName(v.Type), value.GetValueType())
+		}
+		coerced, ok := coerce(value, v.Type)
+		if !ok {
+			return fmt.Sprintf("无法将 %s 转换为 %s", formatValue(value), typeName(v.Type))
+		}
+		if msg := putVar(ev, v.Name, coerced); msg != "" {
+			return msg
+		}
+		value = coerced
+	}
+
+	if value.IsNumber() {
+		n := value.AsDouble()
+		switch {
+		case v.Min != nil && v.Max != nil && (n < *v.Min || n > *v.Max):
+			return fmt.Sprintf("值 %s 超出范围 [%g, %g]", formatValue(value), *v.Min, *v.Max)
+		case v.Min != nil && n < *v.Min:
+			return fmt.Sprintf("值 %s 小于下限 %g", formatValue(value), *v.Min)
+		case v.Max != nil && n > *v.Max:
+			return fmt.Sprintf("值 %s 大于上限 %g", formatValue(value), *v.Max)
+		}
+	}
+	if len(v.Enum) > 0 && !containsValue(v.Enum, value) {
+		allowed := make([]string, len(v.Enum))
+		for i, e := range v.Enum {
+			allowed[i] = formatValue(e)
+		}
+		return fmt.Sprintf("值 %s 不是允许的取值 [%s]", formatValue(value), strings.Join(allowed, ", "))
+	}
+	return ""
+}
+
+// containsValue 整数和浮点数按数值比较
+func containsValue(enum []values.Value, value values.Value) bool {
+	for _, e := range enum {
+		if e.Equals(value) || (e.IsNumber() && value.IsNumber() && e.AsDouble() == value.AsDouble()) {
+			return true
+		}
+	}
+	return false
+}
+
+// coerce 把值转换为目标类型：数值之间在不丢失精度时转换，字符串按字面解析，数值和布尔值可以转换为字符串
+func coerce(value values.Value, typ values.ValueType) (values.Value, bool) {
+	switch typ {
+	case values.Vt_Integer:
+		switch {
+		case value.IsDouble():
+			f := value.AsDouble()
+			if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+				return values.NewIntValue(int32(f)), true
+			}
+		case value.IsString():
+			if i, err := strconv.ParseInt(strings.TrimSpace(value.AsString()), 10, 32); err == nil {
+				return values.NewIntValue(int32(i)), true
+			}
+		}
+	case values.Vt_Double:
+		if value.IsString() {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(value.AsString()), 64); err == nil {
+				return values.NewDoubleValue(f), true
+			}
+		}
+	case values.Vt_String:
+		if value.IsNumber() || value.IsBoolean() {
+			return values.NewStringValue(value.String()), true
+		}
+	case values.Vt_Boolean:
+		if value.IsString() {
+			if b, err := strconv.ParseBool(strings.TrimSpace(value.AsString())); err == nil {
+				return values.NewBooleanValue(b), true
+			}
+		}
+	}
+	return value, false
+}
+
+func formatValue(v values.Value) string {
+	if v.IsString() {
+		return strconv.Quote(v.AsString())
+	}
+	return v.String()
+}
+
+// getVar 读取变量，. 分隔的路径逐级读取实例的属性，不存在时为 null
+func getVar(ev env.Environment, name string) values.Value {
+	parts := strings.Split(name, ".")
+	value := ev.Get(parts[0])
+	for _, field := range parts[1:] {
+		if !value.IsInstance() {
+			return values.NewNullValue()
+		}
+		instance := value.AsInstance()
+		v, ok := instance.Get(field)
+		if !ok {
+			return values.NewNullValue()
+		}
+		value = v
+	}
+	return value
+}
+
+// putVar 写入变量，路径所属的实例不存在时返回错误说明
+func putVar(ev env.Environment, name string, value values.Value) string {
+	dot := strings.LastIndexByte(name, '.')
+	if dot < 0 {
+		ev.Put(name, value)
+		return ""
+	}
+	owner := getVar(ev, name[:dot])
+	if !owner.IsInstance() {
+		return fmt.Sprintf("%s 不是实例，无法写入属性", name[:dot])
+	}
+	instance := owner.AsInstance()
+	instance.Set(name[dot+1:], value)
+	return ""
+}
@@ -0,0 +1,91 @@
+package trace
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 执行过程中各阶段的名称
+const (
+	PhaseBatch   = "batch"   // 批量执行，包含以下各阶段
+	PhaseParse   = "parse"   // 解析源码
+	PhaseAnalyze = "analyze" // 常量折叠、依赖分析和排序
+	PhaseCompile = "compile" // 编译为字节码、寄存器程序或闭包
+	PhaseExecute = "execute" // 执行
+)
+
+// EventKind 事件类型
+type EventKind int
+
+const (
+	SpanStart EventKind = iota // 区间开始
+	SpanEnd                    // 区间结束
+)
+
+func (k EventKind) String() string {
+	if k == SpanStart {
+		return "start"
+	}
+	return "end"
+}
+
+// Attr 区间的属性
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Event 跟踪事件，区间开始和结束时各产生一个
+type Event struct {
+	Kind     EventKind
+	SpanID   uint64
+	ParentID uint64 // 父区间的编号，没有父区间时为 0
+	Depth    int    // 嵌套深度，没有父区间时为 1
+	Phase    string
+	Count    int           // 涉及的表达式数量
+	Time     time.Time     // 事件发生的时间
+	Duration time.Duration // 区间的持续时间，只有结束事件有
+	Attrs    []Attr
+}
+
+// GetAttr 按名称查找属性
+func (e Event) GetAttr(key string) (any, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+// MarshalJSON 属性输出为对象，持续时间以纳秒为单位
+func (e Event) MarshalJSON() ([]byte, error) {
+	type jsonEvent struct {
+		Kind       string         `json:"kind"`
+		SpanID     uint64         `json:"span"`
+		ParentID   uint64         `json:"parent,omitempty"`
+		Depth      int            `json:"depth"`
+		Phase      string         `json:"phase"`
+		Count      int            `json:"count"`
+		Time       time.Time      `json:"time"`
+		DurationNs int64          `json:"duration_ns,omitempty"`
+		Attrs      map[string]any `json:"attrs,omitempty"`
+	}
+	je := jsonEvent{
+		Kind:       e.Kind.String(),
+		SpanID:     e.SpanID,
+		ParentID:   e.ParentID,
+		Depth:      e.Depth,
+		Phase:      e.Phase,
+		Count:      e.Count,
+		Time:       e.Time,
+		DurationNs: int64(e.Duration),
+	}
+	if len(e.Attrs) > 0 {
+		je.Attrs = make(map[string]any, len(e.Attrs))
+		for _, attr := range e.Attrs {
+			je.Attrs[attr.Key] = attr.Value
+		}
+	}
+	return json.Marshal(je)
+}
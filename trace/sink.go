@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Sink 接收跟踪事件，可能被多个协程同时调用
+type Sink interface {
+	Emit(event Event)
+}
+
+// SinkFunc 函数形式的 Sink
+type SinkFunc func(event Event)
+
+func (f SinkFunc) Emit(event Event) {
+	f(event)
+}
+
+// multiSink 把事件依次发给多个 Sink
+type multiSink []Sink
+
+func (m multiSink) Emit(event Event) {
+	for _, sink := range m {
+		sink.Emit(event)
+	}
+}
+
+// MultiSink 把事件依次发给 sinks 中的每一个
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+// Printer 以 util.Tracer 的格式输出事件：按深度缩进，开始时输出阶段、表达式数量和属性，结束时输出耗时（毫秒）
+type Printer struct {
+	mu      sync.Mutex
+	printer func(message string)
+}
+
+// NewPrinter printer 为 nil 时输出到标准输出
+func NewPrinter(printer func(message string)) *Printer {
+	if printer == nil {
+		printer = func(message string) { fmt.Println(message) }
+	}
+	return &Printer{printer: printer}
+}
+
+func (p *Printer) Emit(event Event) {
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(" ", max(event.Depth-1, 0)))
+	if event.Kind == SpanStart {
+		fmt.Fprintf(&sb, "[trace%d]start %s", event.Depth, event.Phase)
+		if event.Count > 0 {
+			fmt.Fprintf(&sb, " 表达式数：%d", event.Count)
+		}
+	} else {
+		fmt.Fprintf(&sb, "[trace%d]end:%dms %s", event.Depth, event.Duration.Milliseconds(), event.Phase)
+	}
+	for _, attr := range event.Attrs {
+		fmt.Fprintf(&sb, " %s=%v", attr.Key, attr.Value)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.printer(sb.String())
+}
+
+// JSONWriter 每个事件输出为一行 JSON（格式见 Event.MarshalJSON）。写入出错后不再输出，错误由 GetErr 返回
+type JSONWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *JSONWriter) Emit(event Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err == nil {
+		j.err = j.enc.Encode(event)
+	}
+}
+
+// GetErr 第一次写入出错时的错误
+func (j *JSONWriter) GetErr() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Collector 在内存中保存全部事件，用于测试
+type Collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Emit(event Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+// GetEvents 按发生顺序返回已收到的事件
+func (c *Collector) GetEvents() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+// GetSpans 按结束顺序返回阶段为 phase 的区间的结束事件，phase 为空时返回全部区间
+func (c *Collector) GetSpans(phase string) []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []Event
+	for _, event := range c.events {
+		if event.Kind == SpanEnd && (phase == "" || event.Phase == phase) {
+			spans = append(spans, event)
+		}
+	}
+	return spans
+}
+
+// Reset 清空已收到的事件
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = nil
+}
@@ -0,0 +1,164 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer 创建跟踪区间，区间开始和结束时向 Sink 发送事件。并发安全
+type Tracer struct {
+	sink   Sink
+	nextID atomic.Uint64
+}
+
+func NewTracer(sink Sink) *Tracer {
+	return &Tracer{sink: sink}
+}
+
+func (t *Tracer) GetSink() Sink {
+	return t.sink
+}
+
+// Start 开始一个区间，ctx 中有区间（见 ContextWithSpan）时作为其子区间。count 为涉及的表达式数量。
+// 返回带有新区间的 ctx，在其下开始的区间都是新区间的子区间。t 为 nil 时返回原 ctx 和 nil 区间
+func (t *Tracer) Start(ctx context.Context, phase string, count int, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: t,
+		id:     t.nextID.Add(1),
+		depth:  1,
+		phase:  phase,
+		count:  count,
+		attrs:  attrs,
+		start:  time.Now(),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.parentID = parent.id
+		span.depth = parent.depth + 1
+	}
+	t.sink.Emit(span.event(SpanStart, span.start))
+	return ContextWithSpan(ctx, span), span
+}
+
+// Span 跟踪区间。nil 区间的方法都不做任何事，调用方不必判断是否开启了跟踪
+type Span struct {
+	tracer   *Tracer
+	id       uint64
+	parentID uint64
+	depth    int
+	phase    string
+	start    time.Time
+
+	mu    sync.Mutex
+	count int
+	attrs []Attr
+	ended bool
+}
+
+func (s *Span) GetID() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.id
+}
+
+func (s *Span) GetPhase() string {
+	if s == nil {
+		return ""
+	}
+	return s.phase
+}
+
+// SetCount 设置涉及的表达式数量，在结束事件中输出
+func (s *Span) SetCount(count int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count = count
+}
+
+// SetAttr 设置属性，已有同名属性时替换，在结束事件中输出
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].Key == key {
+			s.attrs[i].Value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, Attr{Key: key, Value: value})
+}
+
+// SetError err 不为 nil 时记录为属性 error
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetAttr("error", err.Error())
+	}
+}
+
+// End 结束区间并发送结束事件，重复调用时只有第一次有效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	event := s.event(SpanEnd, now)
+	s.mu.Unlock()
+	event.Duration = now.Sub(s.start)
+	s.tracer.sink.Emit(event)
+}
+
+func (s *Span) event(kind EventKind, at time.Time) Event {
+	return Event{
+		Kind:     kind,
+		SpanID:   s.id,
+		ParentID: s.parentID,
+		Depth:    s.depth,
+		Phase:    s.phase,
+		Count:    s.count,
+		Time:     at,
+		Attrs:    append([]Attr(nil), s.attrs...),
+	}
+}
+
+type spanKey struct{}
+
+type tracerKey struct{}
+
+// ContextWithSpan 返回带有区间 span 的 ctx，之后以其开始的区间都是 span 的子区间
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext ctx 中的区间，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithTracer 返回带有跟踪器 t 的 ctx，用于在调用链中传递跟踪器（见 gop.GopRunner.SetTracer）
+func ContextWithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// TracerFromContext ctx 中的跟踪器，没有时返回 nil
+func TracerFromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return t
+}
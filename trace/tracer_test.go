@@ -0,0 +1,99 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simonwater/gopression/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer_NestedSpans(t *testing.T) {
+	collector := trace.NewCollector()
+	tracer := trace.NewTracer(collector)
+
+	ctx, outer := tracer.Start(context.Background(), "request", 0, trace.Attr{Key: "user", Value: "u1"})
+	assert.Same(t, outer, trace.SpanFromContext(ctx))
+	_, inner := tracer.Start(ctx, trace.PhaseParse, 3)
+	inner.SetAttr("mode", "ChunkVM")
+	inner.SetError(errors.New("语法错误"))
+	inner.End()
+	inner.End()
+	outer.End()
+
+	events := collector.GetEvents()
+	require.Len(t, events, 4, "重复调用 End 只发送一次结束事件")
+	assert.Equal(t, trace.SpanStart, events[0].Kind)
+	assert.Equal(t, 1, events[0].Depth)
+
+	spans := collector.GetSpans(trace.PhaseParse)
+	require.Len(t, spans, 1)
+	parse := spans[0]
+	assert.Equal(t, outer.GetID(), parse.ParentID)
+	assert.Equal(t, 2, parse.Depth)
+	assert.Equal(t, 3, parse.Count)
+	assert.GreaterOrEqual(t, int64(parse.Duration), int64(0))
+	mode, ok := parse.GetAttr("mode")
+	assert.True(t, ok)
+	assert.Equal(t, "ChunkVM", mode)
+	msg, _ := parse.GetAttr("error")
+	assert.Equal(t, "语法错误", msg)
+
+	collector.Reset()
+	assert.Empty(t, collector.GetEvents())
+}
+
+func TestTracer_NilSafe(t *testing.T) {
+	var tracer *trace.Tracer
+	ctx, span := tracer.Start(context.Background(), trace.PhaseBatch, 1)
+	assert.Nil(t, span)
+	assert.Nil(t, trace.SpanFromContext(ctx))
+	span.SetCount(2)
+	span.SetAttr("k", 1)
+	span.SetError(errors.New("x"))
+	span.End()
+	assert.Zero(t, span.GetID())
+
+	tracer = trace.NewTracer(trace.NewCollector())
+	ctx = trace.ContextWithTracer(context.Background(), tracer)
+	assert.Same(t, tracer, trace.TracerFromContext(ctx))
+	assert.Nil(t, trace.TracerFromContext(context.Background()))
+}
+
+func TestTracer_Sinks(t *testing.T) {
+	var lines []string
+	var buf bytes.Buffer
+	jsonWriter := trace.NewJSONWriter(&buf)
+	tracer := trace.NewTracer(trace.MultiSink(
+		trace.NewPrinter(func(message string) { lines = append(lines, message) }),
+		jsonWriter,
+	))
+
+	ctx, batch := tracer.Start(context.Background(), trace.PhaseBatch, 2)
+	_, exec := tracer.Start(ctx, trace.PhaseExecute, 2, trace.Attr{Key: "mode", Value: "Closure"})
+	exec.End()
+	batch.End()
+
+	require.Len(t, lines, 4)
+	assert.Equal(t, "[trace1]start batch 表达式数：2", lines[0])
+	assert.Equal(t, " [trace2]start execute 表达式数：2 mode=Closure", lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], " [trace2]end:"), lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "[trace1]end:"), lines[3])
+
+	require.NoError(t, jsonWriter.GetErr())
+	records := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, records, 4)
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(records[2]), &record))
+	assert.Equal(t, "end", record["kind"])
+	assert.Equal(t, "execute", record["phase"])
+	assert.Equal(t, float64(batch.GetID()), record["parent"])
+	assert.Equal(t, float64(2), record["count"])
+	assert.Contains(t, record, "duration_ns")
+	assert.Equal(t, map[string]any{"mode": "Closure"}, record["attrs"])
+}
@@ -29,6 +29,14 @@ func NewBufferFromBytes(bytes []byte) *ByteBuffer {
 	return bb
 }
 
+// WrapBytes 直接包装已有字节，不复制，位置为 0。用于只读场景，写入会修改原字节
+func WrapBytes(bytes []byte) *ByteBuffer {
+	return &ByteBuffer{
+		buf:      bytes,
+		capacity: len(bytes),
+	}
+}
+
 // SetEndian 设置字节序（true=小端，false=大端，默认大端）
 func (bb *ByteBuffer) SetEndian(littleEndian bool) {
 	bb.littleEndian = littleEndian
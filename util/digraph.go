@@ -36,6 +36,65 @@ func (g *Digraph) AddEdge(v, w int) {
 	g.E++
 }
 
+// AddVertex 添加一个顶点，返回新顶点的编号
+func (g *Digraph) AddVertex() int {
+	g.adj = append(g.adj, make([]int, 0))
+	g.indegree = append(g.indegree, 0)
+	g.V++
+	return g.V - 1
+}
+
+// RemoveEdge 删除一条 v->w 的边，边不存在时返回 false
+func (g *Digraph) RemoveEdge(v, w int) bool {
+	g.validateVertex(v)
+	g.validateVertex(w)
+	for i, x := range g.adj[v] {
+		if x == w {
+			g.adj[v] = append(g.adj[v][:i], g.adj[v][i+1:]...)
+			g.indegree[w]--
+			g.E--
+			return true
+		}
+	}
+	return false
+}
+
+// HasEdge 是否存在 v->w 的边
+func (g *Digraph) HasEdge(v, w int) bool {
+	g.validateVertex(v)
+	g.validateVertex(w)
+	for _, x := range g.adj[v] {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// Reachable 从给定顶点出发广度优先遍历，返回各顶点是否可达（起点本身可达）
+func (g *Digraph) Reachable(sources ...int) []bool {
+	marked := make([]bool, g.V)
+	queue := make([]int, 0, len(sources))
+	for _, s := range sources {
+		g.validateVertex(s)
+		if !marked[s] {
+			marked[s] = true
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range g.adj[v] {
+			if !marked[w] {
+				marked[w] = true
+				queue = append(queue, w)
+			}
+		}
+	}
+	return marked
+}
+
 func (g *Digraph) Adj(v int) []int {
 	g.validateVertex(v)
 	return g.adj[v]
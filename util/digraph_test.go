@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestDigraphAddRemoveEdge(t *testing.T) {
+	g := NewDigraph(2)
+	v := g.AddVertex()
+	if v != 2 || g.V != 3 {
+		t.Errorf("expected new vertex 2 and 3 vertices, got %d and %d", v, g.V)
+	}
+	g.AddEdge(0, 1)
+	g.AddEdge(1, 2)
+	if !g.HasEdge(1, 2) || g.Indegree(2) != 1 || g.E != 2 {
+		t.Errorf("AddEdge failed")
+	}
+	if !g.RemoveEdge(1, 2) {
+		t.Errorf("RemoveEdge should succeed")
+	}
+	if g.HasEdge(1, 2) || g.Indegree(2) != 0 || g.E != 1 {
+		t.Errorf("RemoveEdge failed")
+	}
+	if g.RemoveEdge(1, 2) {
+		t.Errorf("RemoveEdge of missing edge should return false")
+	}
+}
+
+func TestDigraphReachable(t *testing.T) {
+	g := NewDigraph(5)
+	g.AddEdge(0, 1)
+	g.AddEdge(1, 2)
+	g.AddEdge(3, 4)
+	marked := g.Reachable(1)
+	expected := []bool{false, true, true, false, false}
+	for i := range expected {
+		if marked[i] != expected[i] {
+			t.Errorf("vertex %d: expected %v, got %v", i, expected[i], marked[i])
+		}
+	}
+	marked = g.Reachable(0, 3)
+	for i := 0; i < g.V; i++ {
+		if !marked[i] {
+			t.Errorf("vertex %d should be reachable", i)
+		}
+	}
+}
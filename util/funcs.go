@@ -8,17 +8,22 @@ import (
 func SafeExecute[T any](fn func() T) (result T, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			switch v := r.(type) {
-			case error:
-				err = v
-			case string:
-				err = errors.New(v)
-			default:
-				err = fmt.Errorf("panic: %v", r)
-			}
+			err = PanicError(r)
 		}
 	}()
 
 	result = fn()
 	return
 }
+
+// PanicError 把 recover 得到的值转换为错误
+func PanicError(r any) error {
+	switch v := r.(type) {
+	case error:
+		return v
+	case string:
+		return errors.New(v)
+	default:
+		return fmt.Errorf("panic: %v", r)
+	}
+}
@@ -3,16 +3,22 @@ package util
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Entry struct {
 	TimeMillis int64
+	Start      time.Time // 开始时间，用于计算耗时
 	ID         int
 }
 
+// Tracer 以文本输出各阶段的耗时，并发安全。多个协程同时计时时嵌套关系会交错，
+// 需要按协程区分的结构化跟踪见 trace 包
 type Tracer struct {
-	enable  bool
+	mu      sync.Mutex
+	enable  atomic.Bool
 	stack   []*Entry
 	printer func(message string)
 }
@@ -27,24 +33,25 @@ func NewTracer() *Tracer {
 
 func NewTracerWithPrinter(printer func(message string)) *Tracer {
 	t := &Tracer{
-		enable:  false,
 		printer: printer,
 		stack:   []*Entry{},
 	}
-	t.stack = append(t.stack, &Entry{ID: 0, TimeMillis: time.Now().UnixMilli()})
+	t.stack = append(t.stack, newEntry(0))
 	return t
 }
 
 func (t *Tracer) IsEnable() bool {
-	return t.enable
+	return t.enable.Load()
 }
 
 func (t *Tracer) SetEnable(isTrace bool) {
-	t.enable = isTrace
+	t.enable.Store(isTrace)
 }
 
 func (t *Tracer) Println(message string, args ...interface{}) {
 	message = "[trace] " + message
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.printTrace(message)
 }
 
@@ -53,9 +60,11 @@ func (t *Tracer) StartTimer() {
 }
 
 func (t *Tracer) StartTimerWithMsg(message string, args ...interface{}) {
-	if !t.enable {
+	if !t.IsEnable() {
 		return
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	entry := t.newTimeEntry()
 	t.stack = append(t.stack, entry)
 	msg := t.makeBlank(entry.ID-1) + fmt.Sprintf("[trace%d]start ", entry.ID)
@@ -66,15 +75,17 @@ func (t *Tracer) StartTimerWithMsg(message string, args ...interface{}) {
 }
 
 func (t *Tracer) EndTimer(message string, args ...interface{}) {
-	if !t.enable {
+	if !t.IsEnable() {
 		return
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if len(t.stack) == 0 {
 		return
 	}
 	entry := t.stack[len(t.stack)-1]
 	t.stack = t.stack[:len(t.stack)-1]
-	timeUsed := time.Now().UnixMilli() - entry.TimeMillis
+	timeUsed := time.Since(entry.Start).Milliseconds()
 	msg := t.makeBlank(entry.ID-1) + fmt.Sprintf("[trace%d]end:%dms ", entry.ID, timeUsed)
 	if message != "" {
 		msg += fmt.Sprintf(message, args...)
@@ -82,13 +93,17 @@ func (t *Tracer) EndTimer(message string, args ...interface{}) {
 	t.printTrace(msg)
 
 	if len(t.stack) == 0 {
-		t.stack = append(t.stack, &Entry{ID: 0, TimeMillis: time.Now().UnixMilli()})
+		t.stack = append(t.stack, newEntry(0))
 	}
 }
 
+func newEntry(id int) *Entry {
+	now := time.Now()
+	return &Entry{ID: id, TimeMillis: now.UnixMilli(), Start: now}
+}
+
 func (t *Tracer) newTimeEntry() *Entry {
-	lastId := t.stack[len(t.stack)-1].ID
-	return &Entry{ID: lastId + 1, TimeMillis: time.Now().UnixMilli()}
+	return newEntry(t.stack[len(t.stack)-1].ID + 1)
 }
 
 func (t *Tracer) printTrace(message string) {
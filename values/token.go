@@ -9,6 +9,8 @@ type Token struct {
 	Lexeme  string
 	Literal Value // 或 *values.Value，取决于你的Value定义
 	Line    int
+	Column  int // 所在列，从 1 开始，按字符计
+	Offset  int // 在源码中的字符偏移
 }
 
 func NewToken(tokenType TokenType, lexeme string, literal Value, line int) *Token {
@@ -87,6 +87,40 @@ func GetFrom(buf *util.ByteBuffer) (Value, error) {
 	}
 }
 
+// SkipFrom 跳过一个序列化的值，不解码。类型未知或数据不完整时返回错误
+func SkipFrom(buf *util.ByteBuffer) error {
+	tag, err := buf.Get()
+	if err != nil {
+		return err
+	}
+	vt, ok := ValueOf(tag)
+	if !ok {
+		return fmt.Errorf("未知类型: %d", tag)
+	}
+	size := 0
+	switch vt {
+	case Vt_Integer:
+		size = 4
+	case Vt_Double:
+		size = 8
+	case Vt_String:
+		slen, err := buf.GetShort()
+		if err != nil {
+			return err
+		}
+		if slen < 0 {
+			return errors.New("字符串长度无效")
+		}
+		size = int(slen)
+	default:
+		return errors.New("暂不支持的类型")
+	}
+	if buf.Remaining() < size {
+		return errors.New("buffer underflow")
+	}
+	return buf.SetPosition(buf.Position() + size)
+}
+
 // 计算序列化字节长度
 func (val Value) GetByteSize() (int16, error) {
 	switch val.vt {
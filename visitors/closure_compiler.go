@@ -0,0 +1,326 @@
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/simonwater/gopression/cover"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/profile"
+	"github.com/simonwater/gopression/values"
+)
+
+// closure 编译后的表达式节点，对 frame 中的执行环境求值
+type closure func(f *closureFrame) (values.Value, error)
+
+// closureFrame 单次执行的状态
+type closureFrame struct {
+	env    env.Environment
+	limits limits.Limits
+	depth  int // 当前求值嵌套深度
+	steps  int // 当前表达式已求值的节点数
+
+	profiler *profile.Profiler
+	coverage *cover.Collector
+	index    int // 当前表达式序号
+}
+
+// recordBranch 记录 span 处的判定结果，span 无效时不记录
+func (f *closureFrame) recordBranch(span exprs.Span, truthy bool) {
+	if f.coverage != nil && span.IsValid() {
+		f.coverage.Record(f.index, span.Start, span.End, truthy)
+	}
+}
+
+// ClosureCompiler 闭包编译器，将表达式编译为 Go 闭包组成的树（见 ClosureProgram）。
+// 运算符、函数和字面量在编译时确定，执行时不再经过访问者分派。执行语义与 Evaluator 相同
+type ClosureCompiler struct {
+	*ir.BaseVisitor[closure]
+	limits  limits.Limits
+	program *ClosureProgram
+}
+
+func NewClosureCompiler() *ClosureCompiler {
+	c := &ClosureCompiler{}
+	c.BaseVisitor = ir.NewBaseVisitor(c)
+	return c
+}
+
+func (c *ClosureCompiler) GetLimits() limits.Limits {
+	return c.limits
+}
+
+// SetLimits 设置编译出的程序执行时的资源限额，超出时返回 *limits.QuotaError。没有限额时不生成检查代码
+func (c *ClosureCompiler) SetLimits(limits limits.Limits) {
+	c.limits = limits
+}
+
+// BeginCompile 开始编译
+func (c *ClosureCompiler) BeginCompile() {
+	c.program = &ClosureProgram{limits: c.limits, variables: make(map[string]bool)}
+}
+
+// Compile 编译表达式信息
+func (c *ClosureCompiler) Compile(exprInfo *ir.ExprInfo) {
+	c.program.exprs = append(c.program.exprs, closureExpr{
+		index: exprInfo.GetIndex(),
+		eval:  c.compile(exprInfo.GetExpr()),
+	})
+	for name := range exprInfo.GetPrecursors() {
+		c.program.variables[name] = true
+	}
+	for name := range exprInfo.GetSuccessors() {
+		c.program.variables[name] = true
+	}
+}
+
+// EndCompile 结束编译并返回程序
+func (c *ClosureCompiler) EndCompile() *ClosureProgram {
+	program := c.program
+	c.program = nil
+	return program
+}
+
+// compile 编译子表达式，设置了限额时包装节点数和嵌套深度的检查
+func (c *ClosureCompiler) compile(expr exprs.Expr) closure {
+	if expr == nil {
+		return constClosure(values.NewNullValue())
+	}
+	fn := c.Accept(expr)
+	if c.limits == (limits.Limits{}) {
+		return fn
+	}
+	return func(f *closureFrame) (values.Value, error) {
+		f.depth++
+		defer func() { f.depth-- }()
+		f.steps++
+		if f.limits.MaxInstructions > 0 && f.steps > f.limits.MaxInstructions {
+			return values.NewNullValue(), &limits.QuotaError{Kind: limits.QuotaInstructions, Limit: f.limits.MaxInstructions}
+		}
+		if f.limits.MaxRecursion > 0 && f.depth > f.limits.MaxRecursion {
+			return values.NewNullValue(), &limits.QuotaError{Kind: limits.QuotaRecursion, Limit: f.limits.MaxRecursion}
+		}
+		return fn(f)
+	}
+}
+
+func constClosure(v values.Value) closure {
+	return func(f *closureFrame) (values.Value, error) {
+		return v, nil
+	}
+}
+
+func (c *ClosureCompiler) VisitBinary(expr *exprs.BinaryExpr) closure {
+	left, right := c.compile(expr.Left), c.compile(expr.Right)
+	typ := expr.Operator.Type
+	intOp := intOps[typ]
+	return func(f *closureFrame) (values.Value, error) {
+		a, err := left(f)
+		if err != nil {
+			return a, err
+		}
+		b, err := right(f)
+		if err != nil {
+			return b, err
+		}
+		if intOp != nil && a.IsInteger() && b.IsInteger() {
+			return intOp(a.AsInteger(), b.AsInteger()), nil
+		}
+		r, err := values.BinaryOperate(a, b, typ)
+		if err != nil {
+			return r, fmt.Errorf("error evaluating binary expression: %w", err)
+		}
+		return r, f.limits.CheckValue(r)
+	}
+}
+
+// intOps 两个整数运算的快速路径，结果与 values.BinaryOperate 相同
+var intOps = map[values.TokenType]func(a, b int32) values.Value{
+	values.PLUS:          func(a, b int32) values.Value { return values.NewIntValue(a + b) },
+	values.MINUS:         func(a, b int32) values.Value { return values.NewIntValue(a - b) },
+	values.STAR:          func(a, b int32) values.Value { return values.NewIntValue(a * b) },
+	values.GREATER:       func(a, b int32) values.Value { return values.NewBooleanValue(a > b) },
+	values.GREATER_EQUAL: func(a, b int32) values.Value { return values.NewBooleanValue(a >= b) },
+	values.LESS:          func(a, b int32) values.Value { return values.NewBooleanValue(a < b) },
+	values.LESS_EQUAL:    func(a, b int32) values.Value { return values.NewBooleanValue(a <= b) },
+	values.EQUAL_EQUAL:   func(a, b int32) values.Value { return values.NewBooleanValue(a == b) },
+	values.BANG_EQUAL:    func(a, b int32) values.Value { return values.NewBooleanValue(a != b) },
+}
+
+func (c *ClosureCompiler) VisitLogic(expr *exprs.LogicExpr) closure {
+	left, right := c.compile(expr.Left), c.compile(expr.Right)
+	// 短路时的结果：OR 为 true，AND 为 false
+	shortCircuit := expr.Operator.Type == values.OR
+	span := exprs.SpanOf(expr)
+	return func(f *closureFrame) (values.Value, error) {
+		v, err := left(f)
+		if err != nil {
+			return v, err
+		}
+		f.recordBranch(span, v.IsTruthy())
+		if v.IsTruthy() == shortCircuit {
+			return values.NewBooleanValue(shortCircuit), nil
+		}
+		return right(f)
+	}
+}
+
+func (c *ClosureCompiler) VisitLiteral(expr *exprs.LiteralExpr) closure {
+	return constClosure(*expr.Value)
+}
+
+func (c *ClosureCompiler) VisitUnary(expr *exprs.UnaryExpr) closure {
+	right := c.compile(expr.Right)
+	typ := expr.Operator.Type
+	return func(f *closureFrame) (values.Value, error) {
+		v, err := right(f)
+		if err != nil {
+			return v, err
+		}
+		r, err := values.PreUnaryOperate(v, typ)
+		if err != nil {
+			return r, fmt.Errorf("error evaluating unary expression: %w", err)
+		}
+		return r, nil
+	}
+}
+
+func (c *ClosureCompiler) VisitId(expr *exprs.IdExpr) closure {
+	id := expr.Id
+	return func(f *closureFrame) (values.Value, error) {
+		return f.env.GetOrDefault(id, values.NewNullValue()), nil
+	}
+}
+
+func (c *ClosureCompiler) VisitAssign(expr *exprs.AssignExpr) closure {
+	right := c.compile(expr.Right)
+	idExpr, ok := expr.Left.(*exprs.IdExpr)
+	return func(f *closureFrame) (values.Value, error) {
+		v, err := right(f)
+		if err != nil {
+			return v, err
+		}
+		if !ok {
+			return v, errors.New("invalid assignment target")
+		}
+		f.env.Put(idExpr.Id, v)
+		return v, nil
+	}
+}
+
+func (c *ClosureCompiler) VisitCall(expr *exprs.CallExpr) closure {
+	idExpr, ok := expr.Callee.(*exprs.IdExpr)
+	if !ok {
+		return errorClosure(errors.New("can only call named functions"))
+	}
+	name := idExpr.Id
+	fn := funmgr.GetFunctionManager().GetFunction(name)
+	if fn == nil {
+		return errorClosure(fmt.Errorf("function not found: %s", name))
+	}
+
+	args := make([]closure, len(expr.Args))
+	for i, arg := range expr.Args {
+		args[i] = c.compile(arg)
+	}
+	return func(f *closureFrame) (values.Value, error) {
+		argValues := make([]values.Value, len(args))
+		for i, arg := range args {
+			v, err := arg(f)
+			if err != nil {
+				return v, err
+			}
+			argValues[i] = v
+		}
+		if len(argValues) != fn.Arity() {
+			return values.NewNullValue(), fmt.Errorf("expected %d arguments but got %d", fn.Arity(), len(argValues))
+		}
+
+		var start time.Time
+		if f.profiler != nil {
+			start = time.Now()
+		}
+		r, err := fn.Call(argValues)
+		if f.profiler != nil {
+			f.profiler.RecordCall(f.index, name, time.Since(start))
+		}
+		if err != nil {
+			return r, fmt.Errorf("error calling function %s: %w", name, err)
+		}
+		return r, f.limits.CheckValue(r)
+	}
+}
+
+// errorClosure 执行时返回编译期发现的错误，与 Evaluator 一样只在执行到该节点时出错
+func errorClosure(err error) closure {
+	return func(f *closureFrame) (values.Value, error) {
+		return values.NewNullValue(), err
+	}
+}
+
+func (c *ClosureCompiler) VisitIf(expr *exprs.IfExpr) closure {
+	cond, then := c.compile(expr.Condition), c.compile(expr.ThenBranch)
+	var otherwise closure
+	if expr.ElseBranch != nil {
+		otherwise = c.compile(expr.ElseBranch)
+	}
+	span := exprs.SpanOf(expr)
+	return func(f *closureFrame) (values.Value, error) {
+		v, err := cond(f)
+		if err != nil {
+			return v, err
+		}
+		f.recordBranch(span, v.IsTruthy())
+		if v.IsTruthy() {
+			return then(f)
+		} else if otherwise != nil {
+			return otherwise(f)
+		}
+		return values.NewNullValue(), nil
+	}
+}
+
+func (c *ClosureCompiler) VisitGet(expr *exprs.GetExpr) closure {
+	object := c.compile(expr.Object)
+	name := expr.Name.Lexeme
+	return func(f *closureFrame) (values.Value, error) {
+		v, err := object(f)
+		if err != nil {
+			return v, err
+		}
+		if !v.IsInstance() {
+			return values.NewNullValue(), errors.New("only instances have properties")
+		}
+		obj := v.AsInstance()
+		if r, ok := obj.Get(name); ok {
+			return r, nil
+		}
+		return values.NewNullValue(), nil
+	}
+}
+
+func (c *ClosureCompiler) VisitSet(expr *exprs.SetExpr) closure {
+	object, value := c.compile(expr.Object), c.compile(expr.Value)
+	name := expr.Name.Lexeme
+	return func(f *closureFrame) (values.Value, error) {
+		o, err := object(f)
+		if err != nil {
+			return o, err
+		}
+		if !o.IsInstance() {
+			return values.NewNullValue(), errors.New("only instances have fields")
+		}
+		v, err := value(f)
+		if err != nil {
+			return v, err
+		}
+		obj := o.AsInstance()
+		obj.Set(name, v)
+		return v, f.limits.CheckValue(o)
+	}
+}
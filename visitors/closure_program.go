@@ -0,0 +1,103 @@
+package visitors
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/simonwater/gopression/cover"
+	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/limits"
+	"github.com/simonwater/gopression/profile"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// ClosureProgram 由 ClosureCompiler 编译出的程序。编译后不再修改，可以反复、并发地对不同的执行环境执行
+type ClosureProgram struct {
+	exprs     []closureExpr
+	variables map[string]bool
+	limits    limits.Limits
+}
+
+// ClosureResult 一个表达式的执行结果
+type ClosureResult struct {
+	Index int
+	Value values.Value
+}
+
+type closureExpr struct {
+	index int
+	eval  closure
+}
+
+// run 执行表达式，执行中的 panic（如整数除以零）转换为错误
+func (e closureExpr) run(f *closureFrame) (v values.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = util.PanicError(r)
+		}
+	}()
+	return e.eval(f)
+}
+
+// Len 表达式数量
+func (p *ClosureProgram) Len() int {
+	return len(p.exprs)
+}
+
+// GetVariables 表达式读取和赋值的全部变量，按名称排序
+func (p *ClosureProgram) GetVariables() []string {
+	names := make([]string, 0, len(p.variables))
+	for name := range p.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *ClosureProgram) GetLimits() limits.Limits {
+	return p.limits
+}
+
+func (p *ClosureProgram) Execute(ev env.Environment) ([]ClosureResult, error) {
+	return p.ExecuteContext(context.Background(), ev)
+}
+
+// ExecuteContext 按编译顺序执行各表达式，每个表达式执行前检查 ctx。
+// 出错或被取消时停止执行，返回已完成表达式的结果和错误
+func (p *ClosureProgram) ExecuteContext(ctx context.Context, ev env.Environment) ([]ClosureResult, error) {
+	return p.ExecuteProfiled(ctx, ev, nil)
+}
+
+// ExecuteProfiled 同 ExecuteContext，profiler 不为 nil 时记录表达式和函数调用的时间
+func (p *ClosureProgram) ExecuteProfiled(ctx context.Context, ev env.Environment, profiler *profile.Profiler) ([]ClosureResult, error) {
+	return p.ExecuteInstrumented(ctx, ev, profiler, nil)
+}
+
+// ExecuteInstrumented 同 ExecuteProfiled，coverage 不为 nil 时还记录 if 和逻辑运算的判定结果
+func (p *ClosureProgram) ExecuteInstrumented(ctx context.Context, ev env.Environment, profiler *profile.Profiler, coverage *cover.Collector) ([]ClosureResult, error) {
+	frame := &closureFrame{env: ev, limits: p.limits, profiler: profiler, coverage: coverage}
+	results := make([]ClosureResult, 0, len(p.exprs))
+	for _, e := range p.exprs {
+		if err := limits.CheckCanceled(ctx); err != nil {
+			return results, err
+		}
+		frame.steps = 0
+		frame.index = e.index
+		var start time.Time
+		if profiler != nil {
+			start = time.Now()
+		}
+		v, err := e.run(frame)
+		if err != nil {
+			return results, fmt.Errorf("表达式 %d 执行出错：%w", e.index, err)
+		}
+		if profiler != nil {
+			profiler.RecordExpr(e.index, time.Since(start))
+		}
+		results = append(results, ClosureResult{Index: e.index, Value: v})
+	}
+	return results, nil
+}
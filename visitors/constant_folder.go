@@ -0,0 +1,215 @@
+package visitors
+
+import (
+	"github.com/simonwater/gopression/functions"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// ConstantFolder 常量折叠与代数化简。
+// 计算常量子表达式和参数均为常量的纯函数调用（见 functions.PureFunction），
+// 化简 x * 1、1 * x、x + 0、0 + x、x - 0 以及条件为常量的 if。
+// 计算出错（如除以 0）的子表达式保持原样，以保留运行时的错误。
+// x + 0 等化简只在 x 可以确定为数值时进行：x 为字符串时 x + 0 是字符串拼接，x 为空值时会报错。
+// 折叠返回新的语法树，不修改原表达式
+type ConstantFolder struct {
+	*ir.BaseVisitor[exprs.Expr]
+}
+
+func NewConstantFolder() *ConstantFolder {
+	f := &ConstantFolder{}
+	f.BaseVisitor = ir.NewBaseVisitor(f)
+	return f
+}
+
+// Fold 折叠表达式，新节点沿用原表达式的源码位置
+func (f *ConstantFolder) Fold(expr exprs.Expr) exprs.Expr {
+	if expr == nil {
+		return nil
+	}
+	result := f.Accept(expr)
+	if !exprs.SpanOf(result).IsValid() {
+		exprs.SetSpan(result, exprs.SpanOf(expr))
+	}
+	return result
+}
+
+func (f *ConstantFolder) VisitBinary(expr *exprs.BinaryExpr) exprs.Expr {
+	left := f.Fold(expr.Left)
+	right := f.Fold(expr.Right)
+	if l, ok := literalOf(left); ok {
+		if r, ok := literalOf(right); ok {
+			v, err := util.SafeExecute(func() values.Value {
+				v, err := values.BinaryOperate(l, r, expr.Operator.Type)
+				if err != nil {
+					panic(err)
+				}
+				return v
+			})
+			if err == nil {
+				return newLiteral(v)
+			}
+		}
+	}
+
+	switch expr.Operator.Type {
+	case values.STAR:
+		if isIntLiteral(right, 1) && isNumeric(left) {
+			return left
+		}
+		if isIntLiteral(left, 1) && isNumeric(right) {
+			return right
+		}
+	case values.PLUS:
+		if isIntLiteral(right, 0) && isNumeric(left) {
+			return left
+		}
+		if isIntLiteral(left, 0) && isNumeric(right) {
+			return right
+		}
+	case values.MINUS:
+		if isIntLiteral(right, 0) && isNumeric(left) {
+			return left
+		}
+	}
+	return exprs.NewBinaryExpr(left, expr.Operator, right)
+}
+
+// VisitLogic 左侧为布尔常量时折叠。语法树与虚拟机对非布尔值的短路结果不同（布尔值或原值），这里不处理
+func (f *ConstantFolder) VisitLogic(expr *exprs.LogicExpr) exprs.Expr {
+	left := f.Fold(expr.Left)
+	right := f.Fold(expr.Right)
+	if l, ok := literalOf(left); ok && l.IsBoolean() {
+		if expr.Operator.Type == values.OR {
+			if l.AsBoolean() {
+				return newLiteral(values.NewBooleanValue(true))
+			}
+			return right
+		}
+		if !l.AsBoolean() {
+			return newLiteral(values.NewBooleanValue(false))
+		}
+		return right
+	}
+	return exprs.NewLogicExpr(left, expr.Operator, right)
+}
+
+func (f *ConstantFolder) VisitLiteral(expr *exprs.LiteralExpr) exprs.Expr {
+	return expr
+}
+
+func (f *ConstantFolder) VisitUnary(expr *exprs.UnaryExpr) exprs.Expr {
+	right := f.Fold(expr.Right)
+	if r, ok := literalOf(right); ok {
+		if v, err := values.PreUnaryOperate(r, expr.Operator.Type); err == nil {
+			return newLiteral(v)
+		}
+	}
+	return exprs.NewUnaryExpr(expr.Operator, right)
+}
+
+func (f *ConstantFolder) VisitId(expr *exprs.IdExpr) exprs.Expr {
+	return expr
+}
+
+func (f *ConstantFolder) VisitAssign(expr *exprs.AssignExpr) exprs.Expr {
+	return exprs.NewAssignExpr(expr.Left, expr.Operator, f.Fold(expr.Right))
+}
+
+func (f *ConstantFolder) VisitCall(expr *exprs.CallExpr) exprs.Expr {
+	args := make([]exprs.Expr, len(expr.Args))
+	constArgs := make([]values.Value, len(expr.Args))
+	allConst := true
+	for i, arg := range expr.Args {
+		args[i] = f.Fold(arg)
+		v, ok := literalOf(args[i])
+		constArgs[i] = v
+		allConst = allConst && ok
+	}
+	result := exprs.NewCallExpr(expr.Callee, args, expr.RParen)
+	if !allConst {
+		return result
+	}
+
+	idExpr, ok := expr.Callee.(*exprs.IdExpr)
+	if !ok {
+		return result
+	}
+	fn := funmgr.GetFunctionManager().GetFunction(idExpr.Id)
+	if fn == nil || fn.Arity() != len(args) {
+		return result
+	}
+	if pure, ok := fn.(functions.PureFunction); !ok || !pure.IsPure() {
+		return result
+	}
+	v, err := util.SafeExecute(func() values.Value {
+		v, err := fn.Call(constArgs)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	})
+	if err != nil {
+		return result
+	}
+	return newLiteral(v)
+}
+
+func (f *ConstantFolder) VisitIf(expr *exprs.IfExpr) exprs.Expr {
+	cond := f.Fold(expr.Condition)
+	if c, ok := literalOf(cond); ok {
+		if c.IsTruthy() {
+			return f.Fold(expr.ThenBranch)
+		}
+		if expr.ElseBranch == nil {
+			return newLiteral(values.NewNullValue())
+		}
+		return f.Fold(expr.ElseBranch)
+	}
+	return exprs.NewIfExpr(cond, f.Fold(expr.ThenBranch), f.Fold(expr.ElseBranch))
+}
+
+func (f *ConstantFolder) VisitGet(expr *exprs.GetExpr) exprs.Expr {
+	return exprs.NewGetExpr(f.Fold(expr.Object), expr.Name)
+}
+
+func (f *ConstantFolder) VisitSet(expr *exprs.SetExpr) exprs.Expr {
+	return exprs.NewSetExpr(f.Fold(expr.Object), expr.Name, f.Fold(expr.Value))
+}
+
+func literalOf(expr exprs.Expr) (values.Value, bool) {
+	if l, ok := expr.(*exprs.LiteralExpr); ok && l.Value != nil {
+		return *l.Value, true
+	}
+	return values.NewNullValue(), false
+}
+
+func newLiteral(v values.Value) *exprs.LiteralExpr {
+	return exprs.NewLiteralExpr(&v)
+}
+
+func isIntLiteral(expr exprs.Expr, i int32) bool {
+	v, ok := literalOf(expr)
+	return ok && v.IsInteger() && v.AsInteger() == i
+}
+
+// isNumeric 表达式的结果能否确定为数值（或者求值时报错）
+func isNumeric(expr exprs.Expr) bool {
+	switch e := expr.(type) {
+	case *exprs.LiteralExpr:
+		return e.Value != nil && e.Value.IsNumber()
+	case *exprs.UnaryExpr:
+		return e.Operator.Type == values.MINUS
+	case *exprs.BinaryExpr:
+		switch e.Operator.Type {
+		case values.MINUS, values.STAR, values.SLASH, values.PERCENT, values.STARSTAR:
+			return true
+		case values.PLUS:
+			return isNumeric(e.Left) && isNumeric(e.Right)
+		}
+	}
+	return false
+}
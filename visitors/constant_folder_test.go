@@ -0,0 +1,73 @@
+package visitors_test
+
+import (
+	"testing"
+
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/visitors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fold(t *testing.T, src string) exprs.Expr {
+	t.Helper()
+	expr, err := parser.NewParser(src).Parse()
+	require.NoError(t, err)
+	return visitors.NewConstantFolder().Fold(expr)
+}
+
+func requireLiteral(t *testing.T, expr exprs.Expr, expected any) {
+	t.Helper()
+	literal, ok := expr.(*exprs.LiteralExpr)
+	require.True(t, ok, "应折叠为常量：%#v", expr)
+	assert.Equal(t, expected, literal.Value.GetValue())
+}
+
+func TestConstantFolder_FoldConstants(t *testing.T) {
+	requireLiteral(t, fold(t, "10 ** 2 / 5 - (12 + 8)"), 0.0)
+	requireLiteral(t, fold(t, `"a" + 1 + 2`), "a12")
+	requireLiteral(t, fold(t, "abs(-3) * 2"), 6)
+	requireLiteral(t, fold(t, "!(1 > 2)"), true)
+	requireLiteral(t, fold(t, "true && 1 < 2"), true)
+	requireLiteral(t, fold(t, "false || null"), nil)
+
+	binary, ok := fold(t, "abs(-3) + x").(*exprs.BinaryExpr)
+	require.True(t, ok)
+	requireLiteral(t, binary.Left, 3)
+
+	// 非纯函数不折叠
+	_, ok = fold(t, "clock()").(*exprs.CallExpr)
+	assert.True(t, ok)
+}
+
+func TestConstantFolder_PreserveRuntimeErrors(t *testing.T) {
+	for _, src := range []string{"1 / 0", "1 % 0", "1 - \"a\"", "-\"a\"", "abs(\"a\")"} {
+		_, ok := fold(t, src).(*exprs.LiteralExpr)
+		assert.False(t, ok, "%s 出错时不应折叠", src)
+	}
+}
+
+func TestConstantFolder_Simplify(t *testing.T) {
+	for _, src := range []string{"(x - 1) * 1", "1 * (x - 1)", "(x - 1) + 0", "0 + (x - 1)", "(x - 1) - 0", "(x - 1) * (3 - 2)"} {
+		binary, ok := fold(t, src).(*exprs.BinaryExpr)
+		require.True(t, ok, src)
+		assert.Equal(t, "-", binary.Operator.Lexeme, src)
+		assert.Equal(t, "x", binary.Left.(*exprs.IdExpr).Id, src)
+	}
+
+	// 无法确定为数值时不化简：x 可能是字符串
+	for _, src := range []string{"x * 1", "x + 0", "(x + 1) + 0", "x * 1.0"} {
+		binary, ok := fold(t, src).(*exprs.BinaryExpr)
+		require.True(t, ok, src)
+		assert.Contains(t, []string{"*", "+"}, binary.Operator.Lexeme, src)
+	}
+
+	id, ok := fold(t, "if(true, a, b)").(*exprs.IdExpr)
+	require.True(t, ok)
+	assert.Equal(t, "a", id.Id)
+	id, ok = fold(t, "if(1 > 2, a, b)").(*exprs.IdExpr)
+	require.True(t, ok)
+	assert.Equal(t, "b", id.Id)
+	requireLiteral(t, fold(t, "if(1 > 2, a)"), nil)
+}
@@ -3,12 +3,17 @@ package visitors
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/simonwater/gopression/cover"
 	"github.com/simonwater/gopression/env"
+	"github.com/simonwater/gopression/explain"
 	"github.com/simonwater/gopression/functions/funmgr"
 	"github.com/simonwater/gopression/ir"
 	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/limits"
 	"github.com/simonwater/gopression/parser"
+	"github.com/simonwater/gopression/profile"
 	"github.com/simonwater/gopression/util"
 	"github.com/simonwater/gopression/values"
 )
@@ -16,7 +21,15 @@ import (
 // Evaluator 表达式求值器
 type Evaluator struct {
 	*ir.BaseVisitor[values.Value]
-	env env.Environment
+	env    env.Environment
+	limits limits.Limits
+	depth  int // 当前求值嵌套深度
+	steps  int // 当前表达式已求值的节点数
+
+	profiler *profile.Profiler
+	coverage *cover.Collector
+	explain  *explain.Recorder
+	index    int // 剖析、覆盖率和解释记录时计入的表达式序号
 }
 
 func NewEvaluator(ev env.Environment) *Evaluator {
@@ -25,6 +38,59 @@ func NewEvaluator(ev env.Environment) *Evaluator {
 	return e
 }
 
+func (e *Evaluator) GetLimits() limits.Limits {
+	return e.limits
+}
+
+// SetLimits 设置资源限额，超出时以 *limits.QuotaError panic
+func (e *Evaluator) SetLimits(limits limits.Limits) {
+	e.limits = limits
+}
+
+func (e *Evaluator) GetProfiler() *profile.Profiler {
+	return e.profiler
+}
+
+// SetProfiler 设置剖析器，记录函数调用的时间，计入序号为 index 的表达式。为 nil 时不记录（默认）
+func (e *Evaluator) SetProfiler(profiler *profile.Profiler, index int) {
+	e.profiler = profiler
+	e.index = index
+}
+
+func (e *Evaluator) GetCoverage() *cover.Collector {
+	return e.coverage
+}
+
+// SetCoverage 设置覆盖率收集器，记录 if 和逻辑运算的判定结果，计入序号为 index 的表达式。为 nil 时不记录（默认）
+func (e *Evaluator) SetCoverage(coverage *cover.Collector, index int) {
+	e.coverage = coverage
+	e.index = index
+}
+
+func (e *Evaluator) GetExplain() *explain.Recorder {
+	return e.explain
+}
+
+// SetExplain 设置解释记录器，记录序号为 index 的表达式中各子表达式的值，设置时清空该表达式之前记录的值。
+// 为 nil 时不记录（默认）
+func (e *Evaluator) SetExplain(recorder *explain.Recorder, index int) {
+	e.explain = recorder
+	e.index = index
+	if recorder != nil {
+		recorder.Begin(index)
+	}
+}
+
+// recordBranch 记录 expr 处的判定结果，表达式没有源码位置时不记录
+func (e *Evaluator) recordBranch(expr exprs.Expr, truthy bool) {
+	if e.coverage == nil {
+		return
+	}
+	if span := exprs.SpanOf(expr); span.IsValid() {
+		e.coverage.Record(e.index, span.Start, span.End, truthy)
+	}
+}
+
 func (e *Evaluator) ExecuteAll(exprs []exprs.Expr) ([]values.Value, error) {
 	if len(exprs) == 0 {
 		return nil, nil
@@ -59,9 +125,36 @@ func (e *Evaluator) ExecuteSrc(src string) (values.Value, error) {
 }
 
 func (e *Evaluator) Execute(expr exprs.Expr) values.Value {
+	if e.explain == nil {
+		return e.execute(expr)
+	}
+	v := e.execute(expr)
+	if span := exprs.SpanOf(expr); span.IsValid() {
+		e.explain.Record(e.index, span.Start, span.End, v)
+	}
+	return v
+}
+
+func (e *Evaluator) execute(expr exprs.Expr) values.Value {
 	if expr == nil {
 		return values.NewNullValue()
 	}
+	if e.limits == (limits.Limits{}) {
+		return e.Accept(expr)
+	}
+
+	if e.depth == 0 {
+		e.steps = 0
+	}
+	e.depth++
+	defer func() { e.depth-- }()
+	e.steps++
+	if e.limits.MaxInstructions > 0 && e.steps > e.limits.MaxInstructions {
+		panic(&limits.QuotaError{Kind: limits.QuotaInstructions, Limit: e.limits.MaxInstructions})
+	}
+	if e.limits.MaxRecursion > 0 && e.depth > e.limits.MaxRecursion {
+		panic(&limits.QuotaError{Kind: limits.QuotaRecursion, Limit: e.limits.MaxRecursion})
+	}
 	return e.Accept(expr)
 }
 
@@ -72,11 +165,13 @@ func (e *Evaluator) VisitBinary(expr *exprs.BinaryExpr) values.Value {
 	if err != nil {
 		panic(fmt.Errorf("error evaluating binary expression: %w", err))
 	}
+	e.checkValue(r)
 	return r
 }
 
 func (e *Evaluator) VisitLogic(expr *exprs.LogicExpr) values.Value {
 	left := e.Execute(expr.Left)
+	e.recordBranch(expr, left.IsTruthy())
 
 	if expr.Operator.Type == values.OR {
 		if left.IsTruthy() {
@@ -146,15 +241,24 @@ func (e *Evaluator) VisitCall(expr *exprs.CallExpr) values.Value {
 		))
 	}
 
+	var start time.Time
+	if e.profiler != nil {
+		start = time.Now()
+	}
 	r, err := fn.Call(args)
+	if e.profiler != nil {
+		e.profiler.RecordCall(e.index, funcName, time.Since(start))
+	}
 	if err != nil {
 		panic(fmt.Errorf("error calling function %s: %w", funcName, err))
 	}
+	e.checkValue(r)
 	return r
 }
 
 func (e *Evaluator) VisitIf(expr *exprs.IfExpr) values.Value {
 	cond := e.Execute(expr.Condition)
+	e.recordBranch(expr, cond.IsTruthy())
 	if cond.IsTruthy() {
 		return e.Execute(expr.ThenBranch)
 	} else if expr.ElseBranch != nil {
@@ -186,9 +290,16 @@ func (e *Evaluator) VisitSet(expr *exprs.SetExpr) values.Value {
 	value := e.Execute(expr.Value)
 	obj := object.AsInstance()
 	obj.Set(expr.Name.Lexeme, value)
+	e.checkValue(object)
 	return value
 }
 
 func (e *Evaluator) getVariableValue(id string) values.Value {
 	return e.env.GetOrDefault(id, values.NewNullValue())
 }
+
+func (e *Evaluator) checkValue(v values.Value) {
+	if err := e.limits.CheckValue(v); err != nil {
+		panic(err)
+	}
+}
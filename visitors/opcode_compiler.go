@@ -1,6 +1,8 @@
 package visitors
 
 import (
+	"sort"
+
 	"github.com/simonwater/gopression/chk"
 	"github.com/simonwater/gopression/functions/funmgr"
 	"github.com/simonwater/gopression/ir"
@@ -16,9 +18,21 @@ type OpCodeCompiler struct {
 	*ir.BaseVisitor[any]
 	chunkWriter *chk.ChunkWriter
 	varSet      map[string]bool
+	slotAccess  bool
+	slotRefs    []slotRef // 待回填槽位的操作数
+	debugInfo   bool
+	debug       *chk.DebugInfo
+	order       int        // 正在编译的表达式序号
+	span        exprs.Span // 正在编译的子表达式的源码位置
 	tracer      *util.Tracer
 }
 
+// slotRef 变量槽位操作数的位置，槽位在结束编译时才能确定
+type slotRef struct {
+	pos  int
+	name string
+}
+
 // NewOpCodeCompiler 创建新的编译器
 func NewOpCodeCompiler(tracer *util.Tracer, chunkCapacity ...int) *OpCodeCompiler {
 	c := &OpCodeCompiler{
@@ -36,16 +50,43 @@ func NewOpCodeCompiler(tracer *util.Tracer, chunkCapacity ...int) *OpCodeCompile
 	return c
 }
 
+func (c *OpCodeCompiler) IsSlotAccess() bool {
+	return c.slotAccess
+}
+
+// SetSlotAccess 设置是否按槽位访问变量。开启后变量读写编译为 OP_GET_LOCAL/OP_SET_LOCAL，
+// 槽位为变量在 Chunk.Vars 中的序号，虚拟机一次性读入全部变量，结束时写回修改过的变量
+func (c *OpCodeCompiler) SetSlotAccess(slotAccess bool) {
+	c.slotAccess = slotAccess
+}
+
+func (c *OpCodeCompiler) IsDebugInfo() bool {
+	return c.debugInfo
+}
+
+// SetDebugInfo 设置是否生成调试信息（见 chk.DebugInfo），记录每条指令所属的表达式和源码位置，以及表达式源码
+func (c *OpCodeCompiler) SetDebugInfo(debugInfo bool) {
+	c.debugInfo = debugInfo
+}
+
 // BeginCompile 开始编译
 func (c *OpCodeCompiler) BeginCompile() {
 	c.chunkWriter.Clear()
 	c.varSet = make(map[string]bool)
+	c.slotRefs = nil
+	c.debug = nil
+	if c.debugInfo {
+		c.debug = chk.NewDebugInfo()
+	}
 }
 
 // Compile 编译表达式信息
 func (c *OpCodeCompiler) Compile(exprInfo *ir.ExprInfo) {
 	expr := exprInfo.GetExpr()
 	order := exprInfo.GetIndex()
+	if c.debug != nil && exprInfo.GetSource() != "" {
+		c.debug.Sources[order] = exprInfo.GetSource()
+	}
 	c.CompileExpr(expr, order)
 
 	// 添加前置和后继变量到集合
@@ -59,6 +100,8 @@ func (c *OpCodeCompiler) Compile(exprInfo *ir.ExprInfo) {
 
 // CompileExpr 编译单个表达式
 func (c *OpCodeCompiler) CompileExpr(expr exprs.Expr, order int) {
+	c.order = order
+	c.span = exprs.SpanOf(expr)
 	c.emitOp(chk.OP_BEGIN, order)
 	c.execute(expr)
 	c.emitOp(chk.OP_END)
@@ -66,23 +109,71 @@ func (c *OpCodeCompiler) CompileExpr(expr exprs.Expr, order int) {
 
 // EndCompile 结束编译并返回块
 func (c *OpCodeCompiler) EndCompile() *chk.Chunk {
-	c.emitOp(chk.OP_EXIT)
+	c.chunkWriter.WriteCode(chk.OP_EXIT)
 
-	// 转换变量集合为切片
+	// 转换变量集合为切片，排序保证新增变量常量的顺序稳定
 	vars := make([]string, 0, len(c.varSet))
 	for name := range c.varSet {
 		vars = append(vars, name)
 	}
+	sort.Strings(vars)
 
 	c.chunkWriter.SetVariables(vars)
-	return c.chunkWriter.Flush()
+	if len(c.slotRefs) > 0 {
+		slots := c.chunkWriter.GetVarSlots()
+		for _, ref := range c.slotRefs {
+			c.chunkWriter.UpdateInt(ref.pos, int32(slots[ref.name]))
+		}
+	}
+	chunk := c.chunkWriter.Flush()
+	if c.debug != nil {
+		chunk.Debug = c.debug.ToBytes()
+	}
+	return chunk
 }
 
 func (c *OpCodeCompiler) execute(expr exprs.Expr) any {
 	if expr == nil {
 		return nil
 	}
-	return c.Accept(expr)
+	outer := c.span
+	if span := exprs.SpanOf(expr); span.IsValid() {
+		c.span = span
+	}
+	result := c.Accept(expr)
+	c.span = outer
+	return result
+}
+
+// typedArithOp 静态类型检查推断出两个操作数都是数值时（见 ir.TypeChecker）选用按类型特化的算术指令
+func typedArithOp(expr *exprs.BinaryExpr) (chk.OpCode, bool) {
+	left, right := exprs.TypeOf(expr.Left), exprs.TypeOf(expr.Right)
+	isNumber := func(vt values.ValueType) bool { return vt == values.Vt_Integer || vt == values.Vt_Double }
+	if !isNumber(left) || !isNumber(right) {
+		return 0, false
+	}
+	if left == values.Vt_Integer && right == values.Vt_Integer {
+		switch expr.Operator.Type {
+		case values.PLUS:
+			return chk.OP_ADD_INT, true
+		case values.MINUS:
+			return chk.OP_SUBTRACT_INT, true
+		case values.STAR:
+			return chk.OP_MULTIPLY_INT, true
+		}
+		return 0, false
+	}
+	switch expr.Operator.Type {
+	case values.PLUS:
+		return chk.OP_ADD_DOUBLE, true
+	case values.MINUS:
+		return chk.OP_SUBTRACT_DOUBLE, true
+	case values.STAR:
+		return chk.OP_MULTIPLY_DOUBLE, true
+	case values.SLASH:
+		return chk.OP_DIVIDE_DOUBLE, true
+	}
+	return 0, false
 }
 
 // 实现表达式访问者接口
@@ -90,6 +181,10 @@ func (c *OpCodeCompiler) VisitBinary(expr *exprs.BinaryExpr) any {
 	c.execute(expr.Left)
 	c.execute(expr.Right)
 
+	if op, ok := typedArithOp(expr); ok {
+		c.emitOp(op)
+		return nil
+	}
 	switch expr.Operator.Type {
 	case values.PLUS:
 		c.emitOp(chk.OP_ADD)
@@ -139,7 +234,17 @@ func (c *OpCodeCompiler) VisitLogic(expr *exprs.LogicExpr) any {
 }
 
 func (c *OpCodeCompiler) VisitLiteral(expr *exprs.LiteralExpr) any {
-	c.emitConstant(expr.Value)
+	// 布尔和空值不进常量池（常量折叠会产生这类字面量）
+	switch {
+	case expr.Value.IsNull():
+		c.emitOp(chk.OP_NULL)
+	case expr.Value.IsBoolean() && expr.Value.AsBoolean():
+		c.emitOp(chk.OP_TRUE)
+	case expr.Value.IsBoolean():
+		c.emitOp(chk.OP_FALSE)
+	default:
+		c.emitConstant(expr.Value)
+	}
 	return nil
 }
 
@@ -156,6 +261,10 @@ func (c *OpCodeCompiler) VisitUnary(expr *exprs.UnaryExpr) any {
 }
 
 func (c *OpCodeCompiler) VisitId(expr *exprs.IdExpr) any {
+	if c.slotAccess {
+		c.emitSlot(chk.OP_GET_LOCAL, expr.Id)
+		return nil
+	}
 	value := values.NewStringValue(expr.Id)
 	constIndex := c.makeConstant(&value)
 	c.emitOp(chk.OP_GET_GLOBAL, constIndex)
@@ -166,7 +275,9 @@ func (c *OpCodeCompiler) VisitAssign(expr *exprs.AssignExpr) any {
 	c.execute(expr.Right)
 
 	// 假设左侧是IdExpr
-	if idExpr, ok := expr.Left.(*exprs.IdExpr); ok {
+	if idExpr, ok := expr.Left.(*exprs.IdExpr); ok && c.slotAccess {
+		c.emitSlot(chk.OP_SET_LOCAL, idExpr.Id)
+	} else if ok {
 		value := values.NewStringValue(idExpr.Id)
 		constIndex := c.makeConstant(&value)
 		c.emitOp(chk.OP_SET_GLOBAL, constIndex)
@@ -253,14 +364,31 @@ func (c *OpCodeCompiler) patchJump(index int) {
 	c.chunkWriter.UpdateInt(index, int32(offset))
 }
 
-// emitOp 发出操作码
+// emitOp 发出操作码，生成调试信息时记录当前子表达式的位置
 func (c *OpCodeCompiler) emitOp(opCode chk.OpCode, args ...int) {
+	if c.debug != nil {
+		c.debug.AddEntry(chk.DebugEntry{
+			Pos:    c.chunkWriter.Position(),
+			Index:  c.order,
+			Start:  c.span.Start,
+			End:    c.span.End,
+			Line:   c.span.Line,
+			Column: c.span.Column,
+		})
+	}
 	c.chunkWriter.WriteCode(opCode)
 	if len(args) > 0 {
 		c.emitInt(args[0])
 	}
 }
 
+// emitSlot 发出按槽位访问变量的指令，槽位先占位，结束编译时回填
+func (c *OpCodeCompiler) emitSlot(opCode chk.OpCode, name string) {
+	c.varSet[name] = true
+	c.emitOp(opCode, 0)
+	c.slotRefs = append(c.slotRefs, slotRef{pos: c.chunkWriter.Position() - ADDRESS_SIZE, name: name})
+}
+
 // emitConstant 发出常量指令
 func (c *OpCodeCompiler) emitConstant(value *values.Value) {
 	index := c.makeConstant(value)
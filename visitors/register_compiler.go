@@ -0,0 +1,468 @@
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/simonwater/gopression/chk"
+	"github.com/simonwater/gopression/functions"
+	"github.com/simonwater/gopression/functions/funmgr"
+	"github.com/simonwater/gopression/ir"
+	"github.com/simonwater/gopression/ir/exprs"
+	"github.com/simonwater/gopression/util"
+	"github.com/simonwater/gopression/values"
+)
+
+// 编译期间常量和临时寄存器的编号带有标记，结束编译时才能确定变量和常量的数量，再统一换算为实际编号
+const (
+	constTag = 1 << 29
+	tempTag  = 1 << 30
+	tagMask  = constTag | tempTag
+)
+
+// RegisterCompiler 寄存器虚拟机的编译器，将表达式编译为三地址指令（见 chk.RegProgram）。
+// 变量和常量直接作为运算指令的操作数，不需要像字节码那样先压栈，赋值的结果直接写入变量所在的寄存器
+type RegisterCompiler struct {
+	*ir.BaseVisitor[any]
+	code      []chk.RegInstruction
+	vars      map[string]int32
+	varNames  []string
+	consts    map[values.Value]int32
+	constVals []values.Value
+	funcs     map[string]int32
+	funcList  []functions.CallableFunction
+	temps     int32 // 当前表达式已分配的临时寄存器数
+	maxTemps  int32 // 当前表达式最多同时使用的临时寄存器数
+	allTemps  int32 // 所有表达式最多同时使用的临时寄存器数
+	dest      int32 // 当前子表达式结果的目标寄存器
+	assigned  map[string]bool
+	debugInfo bool
+	debug     *chk.DebugInfo
+	order     int
+	span      exprs.Span
+	err       error // 当前表达式的第一个编译错误
+	tracer    *util.Tracer
+}
+
+func NewRegisterCompiler(tracer *util.Tracer) *RegisterCompiler {
+	c := &RegisterCompiler{tracer: tracer}
+	c.BaseVisitor = ir.NewBaseVisitor(c)
+	return c
+}
+
+func (c *RegisterCompiler) IsDebugInfo() bool {
+	return c.debugInfo
+}
+
+// SetDebugInfo 设置是否生成调试信息，记录每条指令所属的表达式和源码位置，以及表达式源码
+func (c *RegisterCompiler) SetDebugInfo(debugInfo bool) {
+	c.debugInfo = debugInfo
+}
+
+// BeginCompile 开始编译
+func (c *RegisterCompiler) BeginCompile() {
+	c.code = nil
+	c.vars = make(map[string]int32)
+	c.varNames = nil
+	c.consts = make(map[values.Value]int32)
+	c.constVals = nil
+	c.funcs = make(map[string]int32)
+	c.funcList = nil
+	c.allTemps = 0
+	c.debug = nil
+	if c.debugInfo {
+		c.debug = chk.NewDebugInfo()
+	}
+}
+
+// Compile 编译表达式信息。表达式首次用到的变量在表达式开始时一次读入。
+// 调用未定义的函数、参数个数不符或赋值目标无效时返回错误，出错后不应继续编译
+func (c *RegisterCompiler) Compile(exprInfo *ir.ExprInfo) error {
+	expr := exprInfo.GetExpr()
+	c.order = exprInfo.GetIndex()
+	c.span = exprs.SpanOf(expr)
+	c.assigned = exprInfo.GetSuccessors()
+	c.temps, c.maxTemps = 0, 0
+	c.err = nil
+	if c.debug != nil && exprInfo.GetSource() != "" {
+		c.debug.Sources[c.order] = exprInfo.GetSource()
+	}
+
+	begin := c.emit(chk.REG_BEGIN, int32(c.order), 0, 0)
+	first := len(c.varNames)
+	c.collectVars(expr)
+	if n := len(c.varNames) - first; n > 0 {
+		c.emit(chk.REG_LOAD, int32(first), int32(n), 0)
+	}
+	result := c.operand(expr)
+	c.emit(chk.REG_END, result, 0, 0)
+
+	c.code[begin].B = c.maxTemps
+	c.allTemps = max(c.allTemps, c.maxTemps)
+	if c.err != nil {
+		return fmt.Errorf("表达式 %d 编译出错：%w", c.order, c.err)
+	}
+	return nil
+}
+
+// fail 记录编译错误，只保留第一个
+func (c *RegisterCompiler) fail(format string, args ...any) {
+	if c.err == nil {
+		c.err = fmt.Errorf(format, args...)
+	}
+}
+
+// EndCompile 结束编译并返回程序
+func (c *RegisterCompiler) EndCompile() *chk.RegProgram {
+	c.emit(chk.REG_EXIT, 0, 0, 0)
+
+	numVars, numConsts := int32(len(c.varNames)), int32(len(c.constVals))
+	relocate := func(r int32) int32 {
+		switch r & tagMask {
+		case constTag:
+			return numVars + r&^tagMask
+		case tempTag:
+			return numVars + numConsts + r&^tagMask
+		}
+		return r
+	}
+	for i := range c.code {
+		ins := &c.code[i]
+		operands := chk.RegisterOperands(ins.Op)
+		for bit, field := range []*int32{&ins.A, &ins.B, &ins.C} {
+			if operands&(1<<bit) != 0 {
+				*field = relocate(*field)
+			}
+		}
+	}
+
+	program := &chk.RegProgram{
+		Code:    c.code,
+		Vars:    c.varNames,
+		Consts:  c.constVals,
+		Funcs:   c.funcList,
+		NumRegs: int(numVars + numConsts + c.allTemps),
+		Debug:   c.debug,
+	}
+	c.code = nil
+	return program
+}
+
+// exprTo 编译子表达式，结果写入 dest
+func (c *RegisterCompiler) exprTo(expr exprs.Expr, dest int32) {
+	outerSpan, outerDest := c.span, c.dest
+	if span := exprs.SpanOf(expr); span.IsValid() {
+		c.span = span
+	}
+	c.dest = dest
+	c.Accept(expr)
+	c.span, c.dest = outerSpan, outerDest
+}
+
+// operand 编译子表达式并返回结果所在的寄存器：变量和常量不生成指令，直接使用所在的寄存器，
+// 其他子表达式分配临时寄存器。later 为之后求值的兄弟表达式，其中对结果变量赋值时先复制到临时寄存器
+func (c *RegisterCompiler) operand(expr exprs.Expr, later ...exprs.Expr) int32 {
+	var reg int32
+	var name string
+	switch e := expr.(type) {
+	case *exprs.LiteralExpr:
+		return c.constReg(*e.Value)
+	case *exprs.IdExpr:
+		name = e.Id
+		reg = c.varReg(name)
+	case *exprs.AssignExpr:
+		id, ok := e.Left.(*exprs.IdExpr)
+		if !ok {
+			c.fail("无效的赋值目标")
+			return c.allocTemp()
+		}
+		name = id.Id
+		reg = c.varReg(name)
+		c.exprTo(expr, reg)
+	default:
+		reg = c.allocTemp()
+		c.exprTo(expr, reg)
+		return reg
+	}
+
+	if c.assignedIn(name, later) {
+		temp := c.allocTemp()
+		c.emit(chk.REG_MOVE, temp, reg, 0)
+		return temp
+	}
+	return reg
+}
+
+// assignedIn exprs 中是否对变量赋值
+func (c *RegisterCompiler) assignedIn(name string, exprList []exprs.Expr) bool {
+	if !c.assigned[name] {
+		return false
+	}
+	for _, e := range exprList {
+		if vars := ir.NewVarsQuery().Execute(e); vars != nil && vars.GetAssigns()[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// 实现表达式访问者接口
+func (c *RegisterCompiler) VisitBinary(expr *exprs.BinaryExpr) any {
+	dest, mark := c.dest, c.temps
+	var left int32
+	if _, ok := expr.Left.(*exprs.BinaryExpr); ok && isTemp(dest) {
+		// 临时寄存器只在本表达式内使用，右侧不会读取，左侧可以直接算到目标寄存器中，减少临时寄存器
+		c.exprTo(expr.Left, dest)
+		left = dest
+	} else {
+		left = c.operand(expr.Left, expr.Right)
+	}
+	right := c.operand(expr.Right)
+	c.emit(binaryOps[expr.Operator.Type], dest, left, right)
+	c.temps = mark
+	return nil
+}
+
+var binaryOps = map[values.TokenType]chk.RegOp{
+	values.PLUS:          chk.REG_ADD,
+	values.MINUS:         chk.REG_SUBTRACT,
+	values.STAR:          chk.REG_MULTIPLY,
+	values.SLASH:         chk.REG_DIVIDE,
+	values.PERCENT:       chk.REG_MODE,
+	values.STARSTAR:      chk.REG_POWER,
+	values.GREATER:       chk.REG_GREATER,
+	values.GREATER_EQUAL: chk.REG_GREATER_EQUAL,
+	values.LESS:          chk.REG_LESS,
+	values.LESS_EQUAL:    chk.REG_LESS_EQUAL,
+	values.EQUAL_EQUAL:   chk.REG_EQUAL_EQUAL,
+	values.BANG_EQUAL:    chk.REG_BANG_EQUAL,
+}
+
+// VisitLogic 结果与字节码一致：短路时为左侧的值，否则为右侧的值
+func (c *RegisterCompiler) VisitLogic(expr *exprs.LogicExpr) any {
+	dest, mark := c.dest, c.temps
+	// 左侧的值先写入目标寄存器，目标为变量时右侧可能读取该变量，改用临时寄存器
+	target := dest
+	if !isTemp(dest) {
+		target = c.allocTemp()
+	}
+
+	c.exprTo(expr.Left, target)
+	jumpOp := chk.REG_JUMP_IF_FALSE
+	if expr.Operator.Type == values.OR {
+		jumpOp = chk.REG_JUMP_IF_TRUE
+	}
+	jumper := c.emit(jumpOp, target, 0, 0)
+	c.exprTo(expr.Right, target)
+	c.code[jumper].B = int32(len(c.code))
+
+	if target != dest {
+		c.emit(chk.REG_MOVE, dest, target, 0)
+	}
+	c.temps = mark
+	return nil
+}
+
+func (c *RegisterCompiler) VisitLiteral(expr *exprs.LiteralExpr) any {
+	c.emit(chk.REG_MOVE, c.dest, c.constReg(*expr.Value), 0)
+	return nil
+}
+
+func (c *RegisterCompiler) VisitUnary(expr *exprs.UnaryExpr) any {
+	dest, mark := c.dest, c.temps
+	right := c.operand(expr.Right)
+	switch expr.Operator.Type {
+	case values.BANG:
+		c.emit(chk.REG_NOT, dest, right, 0)
+	case values.MINUS:
+		c.emit(chk.REG_NEGATE, dest, right, 0)
+	}
+	c.temps = mark
+	return nil
+}
+
+func (c *RegisterCompiler) VisitId(expr *exprs.IdExpr) any {
+	if reg := c.varReg(expr.Id); reg != c.dest {
+		c.emit(chk.REG_MOVE, c.dest, reg, 0)
+	}
+	return nil
+}
+
+func (c *RegisterCompiler) VisitAssign(expr *exprs.AssignExpr) any {
+	id, ok := expr.Left.(*exprs.IdExpr)
+	if !ok {
+		c.fail("无效的赋值目标")
+		return nil
+	}
+	dest := c.dest
+	reg := c.varReg(id.Id)
+	c.exprTo(expr.Right, reg)
+	if reg != dest {
+		c.emit(chk.REG_MOVE, dest, reg, 0)
+	}
+	return nil
+}
+
+func (c *RegisterCompiler) VisitCall(expr *exprs.CallExpr) any {
+	idExpr, ok := expr.Callee.(*exprs.IdExpr)
+	if !ok {
+		c.fail("不支持的调用表达式")
+		return nil
+	}
+	name := idExpr.Id
+	fn := funmgr.GetFunctionManager().GetFunction(name)
+	if fn == nil {
+		c.fail("未定义的函数: %s", name)
+		return nil
+	}
+	if len(expr.Args) != fn.Arity() {
+		c.fail("参数数量不匹配")
+		return nil
+	}
+
+	// 参数依次放在连续的临时寄存器中
+	dest, mark := c.dest, c.temps
+	base := c.temps | tempTag
+	args := make([]int32, len(expr.Args))
+	for i := range args {
+		args[i] = c.allocTemp()
+	}
+	for i, arg := range expr.Args {
+		c.exprTo(arg, args[i])
+	}
+	c.emit(chk.REG_CALL, dest, c.funcIndex(name, fn), base)
+	c.temps = mark
+	return nil
+}
+
+func (c *RegisterCompiler) VisitIf(expr *exprs.IfExpr) any {
+	dest, mark := c.dest, c.temps
+	cond := c.operand(expr.Condition)
+	elseJumper := c.emit(chk.REG_JUMP_IF_FALSE, cond, 0, 0)
+	c.temps = mark
+
+	c.exprTo(expr.ThenBranch, dest)
+	endJumper := c.emit(chk.REG_JUMP, 0, 0, 0)
+	c.code[elseJumper].B = int32(len(c.code))
+	if expr.ElseBranch != nil {
+		c.exprTo(expr.ElseBranch, dest)
+	} else {
+		c.emit(chk.REG_MOVE, dest, c.constReg(values.NewNullValue()), 0)
+	}
+	c.code[endJumper].A = int32(len(c.code))
+	return nil
+}
+
+func (c *RegisterCompiler) VisitGet(expr *exprs.GetExpr) any {
+	dest, mark := c.dest, c.temps
+	object := c.operand(expr.Object)
+	c.emit(chk.REG_GET_PROPERTY, dest, object, c.constReg(values.NewStringValue(expr.Name.Lexeme)))
+	c.temps = mark
+	return nil
+}
+
+// VisitSet 与语法树求值相同，先求对象并检查是否为实例，再求值
+func (c *RegisterCompiler) VisitSet(expr *exprs.SetExpr) any {
+	dest, mark := c.dest, c.temps
+	name := c.constReg(values.NewStringValue(expr.Name.Lexeme))
+	object := c.operand(expr.Object, expr.Value)
+	c.emit(chk.REG_CHECK_INSTANCE, object, name, 0)
+	value := c.operand(expr.Value)
+	c.emit(chk.REG_SET_PROPERTY, object, name, value)
+	if value != dest {
+		c.emit(chk.REG_MOVE, dest, value, 0)
+	}
+	c.temps = mark
+	return nil
+}
+
+// collectVars 按首次出现的顺序为表达式用到的变量分配寄存器
+func (c *RegisterCompiler) collectVars(expr exprs.Expr) {
+	switch e := expr.(type) {
+	case *exprs.IdExpr:
+		c.varReg(e.Id)
+	case *exprs.BinaryExpr:
+		c.collectVars(e.Left)
+		c.collectVars(e.Right)
+	case *exprs.LogicExpr:
+		c.collectVars(e.Left)
+		c.collectVars(e.Right)
+	case *exprs.UnaryExpr:
+		c.collectVars(e.Right)
+	case *exprs.AssignExpr:
+		c.collectVars(e.Left)
+		c.collectVars(e.Right)
+	case *exprs.CallExpr:
+		for _, arg := range e.Args {
+			c.collectVars(arg)
+		}
+	case *exprs.IfExpr:
+		c.collectVars(e.Condition)
+		c.collectVars(e.ThenBranch)
+		if e.ElseBranch != nil {
+			c.collectVars(e.ElseBranch)
+		}
+	case *exprs.GetExpr:
+		c.collectVars(e.Object)
+	case *exprs.SetExpr:
+		c.collectVars(e.Value)
+		c.collectVars(e.Object)
+	}
+}
+
+// emit 发出指令并返回指令序号，生成调试信息时记录当前子表达式的位置
+func (c *RegisterCompiler) emit(op chk.RegOp, a, b, cc int32) int {
+	pc := len(c.code)
+	if c.debug != nil {
+		c.debug.AddEntry(chk.DebugEntry{
+			Pos:    pc,
+			Index:  c.order,
+			Start:  c.span.Start,
+			End:    c.span.End,
+			Line:   c.span.Line,
+			Column: c.span.Column,
+		})
+	}
+	c.code = append(c.code, chk.RegInstruction{Op: op, A: a, B: b, C: cc})
+	return pc
+}
+
+func (c *RegisterCompiler) varReg(name string) int32 {
+	if reg, ok := c.vars[name]; ok {
+		return reg
+	}
+	reg := int32(len(c.varNames))
+	c.vars[name] = reg
+	c.varNames = append(c.varNames, name)
+	return reg
+}
+
+func (c *RegisterCompiler) constReg(value values.Value) int32 {
+	if index, ok := c.consts[value]; ok {
+		return index | constTag
+	}
+	index := int32(len(c.constVals))
+	c.consts[value] = index
+	c.constVals = append(c.constVals, value)
+	return index | constTag
+}
+
+func (c *RegisterCompiler) funcIndex(name string, fn functions.CallableFunction) int32 {
+	if index, ok := c.funcs[name]; ok {
+		return index
+	}
+	index := int32(len(c.funcList))
+	c.funcs[name] = index
+	c.funcList = append(c.funcList, fn)
+	return index
+}
+
+func (c *RegisterCompiler) allocTemp() int32 {
+	reg := c.temps | tempTag
+	c.temps++
+	c.maxTemps = max(c.maxTemps, c.temps)
+	return reg
+}
+
+func isTemp(reg int32) bool {
+	return reg&tagMask == tempTag
+}